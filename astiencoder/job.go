@@ -4,57 +4,349 @@ import "github.com/asticode/go-astitools/float"
 
 // Job represents a job
 type Job struct {
-	Inputs     map[string]JobInput     `json:"inputs"`
-	Operations map[string]JobOperation `json:"operations"`
-	Outputs    map[string]JobOutput    `json:"outputs"`
+	// Possible values are "auto" (default), "always" and "never"
+	// In "auto" mode, video streams are deinterlaced when their field order says they're interlaced
+	Deinterlace string `json:"deinterlace,omitempty"`
+	// In "auto" mode, number of frames idet analyzes to check whether frames flagged as interlaced really are when the
+	// stream's field order is unknown. 0 disables detection
+	DeinterlaceDetectionFrames int `json:"deinterlace_detection_frames,omitempty"`
+	// Possible values are "bwdif" and "yadif" (default)
+	Deinterlacer string              `json:"deinterlacer,omitempty"`
+	Inputs       map[string]JobInput `json:"inputs"`
+	// Audio operations are normalized to the target
+	LoudnessTarget *JobLoudnessTarget      `json:"loudness_target,omitempty"`
+	Operations     map[string]JobOperation `json:"operations"`
+	Outputs        map[string]JobOutput    `json:"outputs"`
 }
 
+// JobLoudnessTarget represents a job loudness target
+// When an audio operation has no custom filters and its input is a file, the input is measured entirely once the
+// workflow has started, before the first audio frame is filtered, and the audio is normalized linearly (two-pass).
+// Otherwise, or if the measurement fails, it's normalized dynamically (single-pass)
+type JobLoudnessTarget struct {
+	// Integrated loudness in LUFS. Defaults to -23
+	Integrated float64 `json:"integrated,omitempty"`
+	// Loudness range in LU. Defaults to 7
+	LRA float64 `json:"lra,omitempty"`
+	// True peak in dBTP. Defaults to -1
+	TruePeak float64 `json:"true_peak,omitempty"`
+}
+
+// Job deinterlace modes
+const (
+	JobDeinterlaceAlways = "always"
+	JobDeinterlaceAuto   = "auto"
+	JobDeinterlaceNever  = "never"
+)
+
+// Job deinterlacers
+const (
+	JobDeinterlacerBwdif = "bwdif"
+	JobDeinterlacerYadif = "yadif"
+)
+
+// Job input types
+const (
+	// Frames come from the highest-priority healthy source among other inputs
+	JobInputTypeFailover = "failover"
+	// Frames are generated by libavfilter sources
+	JobInputTypeGenerator = "generator"
+)
+
 // JobInput represents a job input
 type JobInput struct {
 	Dict        string `json:"dict"`
 	EmulateRate bool   `json:"emulate_rate"`
-	URL         string `json:"url"`
+	// Only used by "failover" inputs
+	Failover *JobInputFailover `json:"failover,omitempty"`
+	// Only used by "generator" inputs
+	Generator *JobInputGenerator `json:"generator,omitempty"`
+	// Possible values are "default", "failover" and "generator"
+	Type string `json:"type,omitempty"`
+	URL  string `json:"url"`
+}
+
+// Job input generator sources
+const (
+	JobInputGeneratorSourceBars        = "bars"
+	JobInputGeneratorSourceColor       = "color"
+	JobInputGeneratorSourceSilence     = "silence"
+	JobInputGeneratorSourceSine        = "sine"
+	JobInputGeneratorSourceTestPattern = "test_pattern"
+)
+
+// JobInputGenerator represents a job input generator
+// Operations using the generator input get frames from the generator matching their media type
+type JobInputGenerator struct {
+	Audio *JobInputGeneratorAudio `json:"audio,omitempty"`
+	// Duration in seconds. 0 means infinite
+	Duration float64 `json:"duration,omitempty"`
+	// If true, frames are generated in real time, otherwise they're generated as fast as possible
+	RealTime bool                    `json:"real_time,omitempty"`
+	Video    *JobInputGeneratorVideo `json:"video,omitempty"`
+}
+
+// JobInputGeneratorAudio represents a job input generator audio
+type JobInputGeneratorAudio struct {
+	Channels int `json:"channels,omitempty"`
+	// libavfilter source-only graph used instead of the source
+	Filters string `json:"filters,omitempty"`
+	// Only used by the "sine" source
+	Frequency  float64 `json:"frequency,omitempty"`
+	SampleRate int     `json:"sample_rate,omitempty"`
+	// Possible values are "silence" (default) and "sine"
+	Source string `json:"source,omitempty"`
+}
+
+// JobInputGeneratorVideo represents a job input generator video
+type JobInputGeneratorVideo struct {
+	// Only used by the "color" source
+	Color string `json:"color,omitempty"`
+	// libavfilter source-only graph used instead of the source
+	Filters     string              `json:"filters,omitempty"`
+	FrameRate   *astifloat.Rational `json:"frame_rate,omitempty"`
+	Height      int                 `json:"height,omitempty"`
+	PixelFormat string              `json:"pixel_format,omitempty"`
+	// Possible values are "bars", "color" and "test_pattern" (default)
+	Source string `json:"source,omitempty"`
+	Width  int    `json:"width,omitempty"`
+}
+
+// JobInputFailover represents a job input failover
+// A source is unhealthy when it stalls, when its decoding fails or when it reaches EOF
+type JobInputFailover struct {
+	// When provided, video frames are replaced with a slate once the sources have been unhealthy for the slate timeout
+	Slate   *JobInputFailoverSlate   `json:"slate,omitempty"`
+	Sources []JobInputFailoverSource `json:"sources"`
+	// Duration in seconds during which a source must stay healthy before switching back to it
+	StabilityWindow float64 `json:"stability_window,omitempty"`
+	// Duration in seconds without frames after which a source is considered stalled
+	StallTimeout float64 `json:"stall_timeout,omitempty"`
+}
+
+// JobInputFailoverSlate represents a job input failover slate
+// Sources are checked in this order: image path, pattern and color
+type JobInputFailoverSlate struct {
+	// Color name or hexadecimal value as libavfilter expects it. Defaults to "black"
+	Color     string `json:"color,omitempty"`
+	ImagePath string `json:"image_path,omitempty"`
+	// libavfilter video source name such as "testsrc2", "smptebars" or "smptehdbars"
+	Pattern string `json:"pattern,omitempty"`
+	// Duration in seconds during which the last frame is repeated before the slate is displayed
+	Timeout float64 `json:"timeout"`
+}
+
+// JobInputFailoverSource represents a job input failover source
+type JobInputFailoverSource struct {
+	// Name of a "default" input
+	Name string `json:"name"`
+	// The highest-priority healthy source is used
+	Priority int `json:"priority"`
 }
 
 // Job output types
 const (
+	// Decoded frames are dumped to the url without being encoded. The url is a template that can use "count",
+	// "input", "pts" and "stream_idx"
+	JobOutputTypeFrameDump = "frame_dump"
 	// The packet data is dumped directly to the url without any mux
 	JobOutputTypePktDump = "pkt_dump"
+	// Decoded frames are tiled into sprite sheets written to the url, and described in a WebVTT thumbnails track.
+	// The url is a template that can use "count", "input" and "stream_idx"
+	JobOutputTypeStoryboard = "storyboard"
 )
 
 // JobOutput represents a job output
 type JobOutput struct {
-	// Possible values are "default" and "pkt_dump"
+	FrameDump  *JobOutputFrameDump  `json:"frame_dump,omitempty"`
+	Storyboard *JobOutputStoryboard `json:"storyboard,omitempty"`
+	// Possible values are "default", "frame_dump", "pkt_dump" and "storyboard"
 	Type string `json:"type,omitempty"`
 	URL  string `json:"url"`
 }
 
+// JobOutputFrameDump represents a job output frame dump
+// When neither frame interval nor interval is provided, every frame is dumped
+type JobOutputFrameDump struct {
+	// Possible values are "jpeg", "png" (default) and "raw"
+	Format string `json:"format,omitempty"`
+	// A frame is dumped every n frames
+	FrameInterval int `json:"frame_interval,omitempty"`
+	// A frame is dumped every n seconds
+	Interval float64 `json:"interval,omitempty"`
+	// Between 1 and 100
+	JPEGQuality int `json:"jpeg_quality,omitempty"`
+}
+
+// JobOutputStoryboard represents a job output storyboard
+// Thumbnails have the operation's dimensions
+type JobOutputStoryboard struct {
+	// Number of thumbnails per row
+	Columns int `json:"columns,omitempty"`
+	// Possible values are "jpeg" (default) and "png"
+	Format string `json:"format,omitempty"`
+	// Duration in seconds between 2 thumbnails
+	Interval float64 `json:"interval,omitempty"`
+	// Between 1 and 100
+	JPEGQuality int `json:"jpeg_quality,omitempty"`
+	// Number of thumbnails per column
+	Rows int `json:"rows,omitempty"`
+	// Path the WebVTT thumbnails track is written to
+	VTT string `json:"vtt"`
+}
+
 // Job operation codecs
 const (
 	JobOperationCodecCopy = "copy"
 )
 
+// Job operation frame rate modes
+const (
+	// Blends consecutive frames (framerate)
+	JobOperationFrameRateModeBlend = "blend"
+	// Drops frames (fps), the output frame rate can't be greater than the input frame rate
+	JobOperationFrameRateModeDrop = "drop"
+	// Duplicates frames (fps), the output frame rate can't be lower than the input frame rate
+	// Drop and duplicate share the same filter which drops or duplicates frames depending on the frame rates, they only
+	// differ by the frame rates they accept
+	JobOperationFrameRateModeDuplicate = "duplicate"
+	// Uses motion interpolation, which is very expensive (minterpolate)
+	JobOperationFrameRateModeInterpolate = "interpolate"
+)
+
+// Job operation scale modes
+const (
+	// Scales to cover the provided dimensions while keeping the display aspect ratio, and crops what overflows
+	JobOperationScaleModeFill = "fill"
+	// Scales to fit in the provided dimensions while keeping the display aspect ratio, and pads what's missing
+	JobOperationScaleModeFit = "fit"
+	// Scales to the provided dimensions without keeping the display aspect ratio
+	JobOperationScaleModeStretch = "stretch"
+)
+
 // JobOperation represents a job operation
 // This can usually be compared to an encoding
 // Refrain from indicating all options in the dict and use other attributes instead
 type JobOperation struct {
 	BitRate *int `json:"bit_rate,omitempty"`
+	// VBV buffer size in bits, mandatory when max rate is provided
+	BufferSize *int `json:"buffer_size,omitempty"`
+	ClosedGOP  bool `json:"closed_gop,omitempty"`
 	// Possible values are "copy" and all libav codec names.
 	Codec string `json:"codec,omitempty"`
-	Dict  string `json:"dict,omitempty"`
+	// Only available for codecs exposing a "crf" option such as "libx264"
+	CRF *float64 `json:"crf,omitempty"`
+	// Detected intervals are exposed through the API
+	Detection *JobOperationDetection `json:"detection,omitempty"`
+	Dict      string                 `json:"dict,omitempty"`
+	// When provided, inputs must be empty and filters must be provided
+	FilterInputs []JobOperationFilterInput `json:"filter_inputs,omitempty"`
+	// libavfilter graph description applied before the automatic filters (frame rate, scale, etc.)
+	// With inputs, the graph is fed by the stream through its unlabeled input.
+	// With filter inputs, the graph references streams through their pad labels.
+	// Deinterlacing is applied to the reference input before the graph, and the other automatic filters are chained to
+	// the output of the graph's last filter chain which must either be unlabeled or labeled "out"
+	Filters string `json:"filters,omitempty"`
 	// Frame rate is a per-operation value since we may have different frame rate operations for a similar output
-	FrameRate   *astifloat.Rational  `json:"frame_rate,omitempty"`
-	GopSize     *int                 `json:"gop_size,omitempty"`
-	Height      *int                 `json:"height,omitempty"`
-	Inputs      []JobOperationInput  `json:"inputs"`
+	FrameRate *astifloat.Rational `json:"frame_rate,omitempty"`
+	// Possible values are "blend", "drop", "duplicate" and "interpolate"
+	// If empty, a cheap mode is picked based on the ratio between input and output frame rates
+	FrameRateMode string              `json:"frame_rate_mode,omitempty"`
+	GlobalQuality *int                `json:"global_quality,omitempty"`
+	GopSize       *int                `json:"gop_size,omitempty"`
+	Height        *int                `json:"height,omitempty"`
+	Inputs        []JobOperationInput `json:"inputs"`
+	// Only applies to video operations
+	KeyFrameAlignment *JobKeyFrameAlignment `json:"key_frame_alignment,omitempty"`
+	// Level as libav expects it (e.g. 31 for level 3.1 in h264)
+	Level       *int                 `json:"level,omitempty"`
+	MaxBFrames  *int                 `json:"max_b_frames,omitempty"`
+	MaxRate     *int                 `json:"max_rate,omitempty"`
+	MinRate     *int                 `json:"min_rate,omitempty"`
 	Outputs     []JobOperationOutput `json:"outputs"`
 	PixelFormat string               `json:"pixel_format,omitempty"`
-	ThreadCount *int                 `json:"thread_count,omitempty"`
+	// Possible values are the codec's profile names (e.g. "baseline", "main" or "high" for "libx264")
+	Profile string `json:"profile,omitempty"`
+	// Only applies to encoded video operations. Measured quality is exposed through the API
+	Quality *JobOperationQuality `json:"quality,omitempty"`
+	Refs    *int                 `json:"refs,omitempty"`
+	// Only used when both width and height are provided. Possible values are "fill", "fit" and "stretch" (default)
+	// When only one of width and height is provided, the other is computed to keep the display aspect ratio
+	ScaleMode   string `json:"scale_mode,omitempty"`
+	ThreadCount *int   `json:"thread_count,omitempty"`
 	// Since frame rate is a per-operation value, time base is as well
 	TimeBase *astifloat.Rational `json:"time_base,omitempty"`
 	Width    *int                `json:"width,omitempty"`
 }
 
+// Job key frame alignment modes
+const (
+	JobKeyFrameAlignmentModeInterval = "interval"
+	JobKeyFrameAlignmentModeSceneCut = "scene_cut"
+)
+
+// JobKeyFrameAlignment represents a job key frame alignment
+// Operations sharing the same group share key frame decisions and therefore have key frames at the same pts
+// Only "libx264" and "libx265" codecs are supported since the encoder must not place key frames on its own
+type JobKeyFrameAlignment struct {
+	// All operations of a group must have the same key frame alignment
+	Group string `json:"group,omitempty"`
+	// In "interval" mode, duration in seconds between key frames
+	// In "scene_cut" mode, max duration in seconds between key frames
+	// It's mandatory in both modes
+	Interval float64 `json:"interval,omitempty"`
+	// In "scene_cut" mode, min duration in seconds between key frames
+	MinInterval float64 `json:"min_interval,omitempty"`
+	// Possible values are "interval" and "scene_cut"
+	Mode string `json:"mode"`
+	// In "scene_cut" mode, scene score between 0 and 1 above which a key frame is forced
+	SceneThreshold float64 `json:"scene_threshold,omitempty"`
+}
+
+// JobOperationDetection represents a job operation detection
+type JobOperationDetection struct {
+	// Only applies to video operations
+	Black *JobDetectionBlack `json:"black,omitempty"`
+	// Only applies to audio operations
+	Silence *JobDetectionSilence `json:"silence,omitempty"`
+}
+
+// JobDetectionBlack represents a job black detection
+type JobDetectionBlack struct {
+	// Min duration in seconds of a black interval
+	MinDuration float64 `json:"min_duration,omitempty"`
+	// Ratio of black pixels (between 0 and 1) above which a frame is considered black
+	PictureThreshold float64 `json:"picture_threshold,omitempty"`
+	// Luma (between 0 and 1 of the luma range) under which a pixel is considered black
+	PixelThreshold float64 `json:"pixel_threshold,omitempty"`
+}
+
+// JobDetectionSilence represents a job silence detection
+// Clipping is detected as well
+type JobDetectionSilence struct {
+	// Min duration in seconds of a clipping interval
+	ClippingMinDuration float64 `json:"clipping_min_duration,omitempty"`
+	// Number of consecutive clipped samples after which a frame is considered clipped
+	ClippingMinSamples int `json:"clipping_min_samples,omitempty"`
+	// Level in dBFS above which a sample is considered clipped
+	ClippingThreshold float64 `json:"clipping_threshold,omitempty"`
+	// Min duration in seconds of a silence interval
+	MinDuration float64 `json:"min_duration,omitempty"`
+	// Level in dBFS under which audio is considered silent
+	Threshold float64 `json:"threshold,omitempty"`
+}
+
+// JobOperationQuality represents a job operation quality measurement
+// Encoded packets are decoded back and compared to the frames sent to the encoder
+type JobOperationQuality struct {
+	// Path of the JSON log libvmaf writes when the workflow is closed. Its per-frame scores are then added to the
+	// measured quality. If empty, VMAF is not computed.
+	// It's ignored when libav has been built without libvmaf
+	VMAFLog string `json:"vmaf_log,omitempty"`
+	// Number of frames rolling averages are computed on
+	Window int `json:"window,omitempty"`
+}
+
 // JobOperationInput represents a job operation input
 // TODO Add start, end and duration (use seek?)
 type JobOperationInput struct {
@@ -64,6 +356,13 @@ type JobOperationInput struct {
 	PID       *int   `json:"pid,omitempty"`
 }
 
+// JobOperationFilterInput represents a job operation filter input
+// The first matching stream of the input is fed to the filter graph through the pad
+type JobOperationFilterInput struct {
+	JobOperationInput
+	Pad string `json:"pad"`
+}
+
 // JobOperationOutput represents a job operation output
 type JobOperationOutput struct {
 	Name string `json:"name"`
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/asticode/go-astiencoder"
 	"github.com/asticode/go-astiencoder/libav"
@@ -38,8 +43,10 @@ func newBuilder() *builder {
 }
 
 type openedInput struct {
-	c JobInput
-	d *astilibav.Demuxer
+	c  JobInput
+	d  *astilibav.Demuxer
+	f  *astilibav.Failover
+	gs map[avcodec.MediaType]*astilibav.Generator
 }
 
 type openedOutput struct {
@@ -48,26 +55,53 @@ type openedOutput struct {
 }
 
 type buildData struct {
-	c        *astiencoder.Closer
-	decoders map[*astilibav.Demuxer]map[*avformat.Stream]*astilibav.Decoder
-	eh *astiencoder.EventHandler
-	inputs   map[string]openedInput
-	outputs  map[string]openedOutput
-	w        *astiencoder.Workflow
+	c                    *astiencoder.Closer
+	decoders             map[*astilibav.Demuxer]map[*avformat.Stream]*astilibav.Decoder
+	eh                   *astiencoder.EventHandler
+	failoverHandlers     map[*astilibav.Decoder]bool
+	inputs               map[string]openedInput
+	j                    Job
+	keyFrameAligners     map[string]keyFrameAligner
+	loudnessMeasurements map[string]*loudnessMeasurement
+	outputs              map[string]openedOutput
+	w                    *astiencoder.Workflow
 }
 
-func newBuildData(w *astiencoder.Workflow, eh *astiencoder.EventHandler, c *astiencoder.Closer) *buildData {
+type keyFrameAligner struct {
+	a astilibav.KeyFrameAligner
+	c JobKeyFrameAlignment
+}
+
+func newBuildData(j Job, w *astiencoder.Workflow, eh *astiencoder.EventHandler, c *astiencoder.Closer) *buildData {
 	return &buildData{
-		c:        c,
-		eh:       eh,
-		decoders: make(map[*astilibav.Demuxer]map[*avformat.Stream]*astilibav.Decoder),
-		w:        w,
+		c:                    c,
+		eh:                   eh,
+		decoders:             make(map[*astilibav.Demuxer]map[*avformat.Stream]*astilibav.Decoder),
+		failoverHandlers:     make(map[*astilibav.Decoder]bool),
+		j:                    j,
+		keyFrameAligners:     make(map[string]keyFrameAligner),
+		loudnessMeasurements: make(map[string]*loudnessMeasurement),
+		w:                    w,
 	}
 }
 
 func (b *builder) buildWorkflow(j Job, w *astiencoder.Workflow, eh *astiencoder.EventHandler, c *astiencoder.Closer) (err error) {
 	// Create build data
-	bd := newBuildData(w, eh, c)
+	bd := newBuildData(j, w, eh, c)
+
+	// Check deinterlace
+	switch j.Deinterlace {
+	case "", JobDeinterlaceAlways, JobDeinterlaceAuto, JobDeinterlaceNever:
+	default:
+		err = fmt.Errorf("main: invalid deinterlace %s", j.Deinterlace)
+		return
+	}
+	switch j.Deinterlacer {
+	case "", JobDeinterlacerBwdif, JobDeinterlacerYadif:
+	default:
+		err = fmt.Errorf("main: invalid deinterlacer %s", j.Deinterlacer)
+		return
+	}
 
 	// No inputs
 	if len(j.Inputs) == 0 {
@@ -114,21 +148,73 @@ func (b *builder) openInputs(j Job, bd *buildData) (is map[string]openedInput, e
 	// Loop through inputs
 	is = make(map[string]openedInput)
 	for n, cfg := range j.Inputs {
-		// Create demuxer
-		var d *astilibav.Demuxer
-		if d, err = astilibav.NewDemuxer(astilibav.DemuxerOptions{
-			Dict:        cfg.Dict,
-			EmulateRate: cfg.EmulateRate,
-			URL:         cfg.URL,
-		}, bd.eh, bd.c); err != nil {
-			err = errors.Wrap(err, "main: creating demuxer failed")
-			return
+		// Create input
+		oi := openedInput{
+			c: cfg,
+		}
+
+		// Switch on type
+		switch cfg.Type {
+		case JobInputTypeFailover:
+			// No failover
+			if cfg.Failover == nil || len(cfg.Failover.Sources) == 0 {
+				err = fmt.Errorf("main: no failover sources provided for input %s", n)
+				return
+			}
+
+			// Create failover
+			// Sources are added once all inputs have been opened
+			oi.f = astilibav.NewFailover(astilibav.FailoverOptions{
+				StabilityWindow: time.Duration(cfg.Failover.StabilityWindow * float64(time.Second)),
+				StallTimeout:    time.Duration(cfg.Failover.StallTimeout * float64(time.Second)),
+			}, bd.eh)
+		case JobInputTypeGenerator:
+			// Create generators
+			if oi.gs, err = b.createGenerators(bd, cfg); err != nil {
+				err = errors.Wrapf(err, "main: creating generators for input %s failed", n)
+				return
+			}
+		default:
+			// Create demuxer
+			if oi.d, err = astilibav.NewDemuxer(astilibav.DemuxerOptions{
+				Dict:        cfg.Dict,
+				EmulateRate: cfg.EmulateRate,
+				URL:         cfg.URL,
+			}, bd.eh, bd.c); err != nil {
+				err = errors.Wrap(err, "main: creating demuxer failed")
+				return
+			}
 		}
 
 		// Index
-		is[n] = openedInput{
-			c: cfg,
-			d: d,
+		is[n] = oi
+	}
+
+	// Loop through failovers
+	for n, i := range is {
+		// Not a failover
+		if i.f == nil {
+			continue
+		}
+
+		// Loop through sources
+		for _, s := range i.c.Failover.Sources {
+			// Retrieve source
+			si, ok := is[s.Name]
+			if !ok {
+				err = fmt.Errorf("main: source %s of failover input %s not found", s.Name, n)
+				return
+			} else if si.d == nil {
+				err = fmt.Errorf("main: source %s of failover input %s is not demuxed", s.Name, n)
+				return
+			}
+
+			// Add source
+			i.f.AddSource(astilibav.FailoverSource{
+				Name:     s.Name,
+				Node:     si.d,
+				Priority: s.Priority,
+			})
 		}
 	}
 	return
@@ -145,7 +231,7 @@ func (b *builder) openOutputs(j Job, bd *buildData) (os map[string]openedOutput,
 
 		// Switch on type
 		switch cfg.Type {
-		case JobOutputTypePktDump:
+		case JobOutputTypeFrameDump, JobOutputTypePktDump, JobOutputTypeStoryboard:
 			// This is a per-operation and per-input value since we may want to index the path by input name
 			// The writer is created afterwards
 		default:
@@ -163,8 +249,9 @@ func (b *builder) openOutputs(j Job, bd *buildData) (os map[string]openedOutput,
 }
 
 type operationInput struct {
-	c JobOperationInput
-	o openedInput
+	c   JobOperationInput
+	o   openedInput
+	pad string
 }
 
 type operationOutput struct {
@@ -172,6 +259,20 @@ type operationOutput struct {
 	o openedOutput
 }
 
+// encodingInput represents a node feeding an encoding with frames
+type encodingInput struct {
+	ctx       astilibav.Context
+	name      string
+	n         frameEmitter
+	pad       string
+	streamIdx int
+}
+
+type frameEmitter interface {
+	astiencoder.Node
+	astilibav.FrameHandlerConnector
+}
+
 func (b *builder) addOperationToWorkflow(name string, o JobOperation, bd *buildData) (err error) {
 	// Get operation inputs and outputs
 	var ois []operationInput
@@ -181,17 +282,44 @@ func (b *builder) addOperationToWorkflow(name string, o JobOperation, bd *buildD
 		return
 	}
 
+	// Operation is fed by filter inputs
+	if len(o.FilterInputs) > 0 {
+		if err = b.addFilterInputsOperationToWorkflow(o, ois, oos, bd); err != nil {
+			err = errors.Wrapf(err, "main: adding filter inputs operation %s failed", name)
+			return
+		}
+		return
+	}
+
 	// Loop through inputs
 	for _, i := range ois {
-		// Loop through streams
-		for _, is := range i.o.d.CtxFormat().Streams() {
-			// Only process a specific PID
-			if i.c.PID != nil && is.Id() != *i.c.PID {
-				continue
+		// Input is not demuxed
+		if i.o.d == nil {
+			// Copy is not supported
+			if o.Codec == JobOperationCodecCopy {
+				err = fmt.Errorf("main: copy is not supported with input %s since it's not demuxed", i.c.Name)
+				return
+			}
+
+			// Create encoding input
+			var ei encodingInput
+			if ei, err = b.nonDemuxedEncodingInput(bd, i); err != nil {
+				err = errors.Wrapf(err, "main: creating encoding input for input %s failed", i.c.Name)
+				return
 			}
 
-			// Only process a specific media type
-			if t := avutil.MediaTypeFromString(i.c.MediaType); t > -1 && is.CodecParameters().CodecType() != avcodec.MediaType(t) {
+			// Add encoding
+			if err = b.addEncoding(bd, o, []encodingInput{ei}, oos); err != nil {
+				err = errors.Wrapf(err, "main: adding encoding for input %s failed", i.c.Name)
+				return
+			}
+			continue
+		}
+
+		// Loop through streams
+		for _, is := range i.o.d.CtxFormat().Streams() {
+			// Stream doesn't match
+			if !streamMatchesOperationInput(is, i.c) {
 				continue
 			}
 
@@ -202,6 +330,12 @@ func (b *builder) addOperationToWorkflow(name string, o JobOperation, bd *buildD
 			if o.Codec == JobOperationCodecCopy {
 				// Loop through outputs
 				for _, o := range oos {
+					// Output needs frames
+					if !hasEncodedOutputs([]operationOutput{o}) {
+						err = fmt.Errorf("main: copy is not supported with output %s since it needs frames", o.c.Name)
+						return
+					}
+
 					// Clone stream
 					var os *avformat.Stream
 					if os, err = astilibav.CloneStream(is, o.o.m.CtxFormat()); err != nil {
@@ -225,239 +359,1485 @@ func (b *builder) addOperationToWorkflow(name string, o JobOperation, bd *buildD
 				return
 			}
 
-			// Create input ctx
-			inCtx := astilibav.NewContextFromStream(is)
-
-			// Create output ctx
-			outCtx := b.operationOutputCtx(o, inCtx, oos)
-
-			// Create filterer
-			var f *astilibav.Filterer
-			if f, err = b.createFilterer(bd, inCtx, outCtx, d); err != nil {
-				err = errors.Wrapf(err, "main: creating filterer for stream 0x%x(%d) of input %s failed", is.Id(), is.Id(), i.c.Name)
+			// Add encoding
+			if err = b.addEncoding(bd, o, []encodingInput{{
+				ctx:       astilibav.NewContextFromStream(is),
+				name:      i.c.Name,
+				n:         d,
+				pad:       "in",
+				streamIdx: is.Index(),
+			}}, oos); err != nil {
+				err = errors.Wrapf(err, "main: adding encoding for stream 0x%x(%d) of input %s failed", is.Id(), is.Id(), i.c.Name)
 				return
 			}
+		}
+	}
+	return
+}
 
-			// Create encoder
-			var e *astilibav.Encoder
-			if e, err = astilibav.NewEncoder(astilibav.EncoderOptions{Ctx: outCtx}, bd.eh, bd.c); err != nil {
-				err = errors.Wrapf(err, "main: creating encoder for stream 0x%x(%d) of input %s failed", is.Id(), is.Id(), i.c.Name)
+func (b *builder) addFilterInputsOperationToWorkflow(o JobOperation, ois []operationInput, oos []operationOutput, bd *buildData) (err error) {
+	// Loop through inputs
+	var eis []encodingInput
+	for _, i := range ois {
+		// Input is not demuxed
+		if i.o.d == nil {
+			// Create encoding input
+			var ei encodingInput
+			if ei, err = b.nonDemuxedEncodingInput(bd, i); err != nil {
+				err = errors.Wrapf(err, "main: creating encoding input for input %s failed", i.c.Name)
 				return
 			}
 
-			// Connect demuxer or filterer to encoder
-			if f != nil {
-				d.Connect(f)
-				f.Connect(e)
-			} else {
-				d.Connect(e)
-			}
-
-			// Loop through outputs
-			for _, o := range oos {
-				// Switch on type
-				var h astilibav.PktHandler
-				switch o.o.c.Type {
-				case JobOutputTypePktDump:
-					// Create pkt dumper
-					if h, err = astilibav.NewPktDumper(astilibav.PktDumperOptions{
-						Data:    map[string]interface{}{"input": i.c.Name},
-						Handler: astilibav.PktDumpFile,
-						Pattern: o.o.c.URL,
-					}, bd.eh); err != nil {
-						err = errors.Wrapf(err, "main: creating pkt dumper for output %s with conf %+v failed", o.c.Name, o.c)
-						return
-					}
-				default:
-					// Add stream
-					var os *avformat.Stream
-					if os, err = e.AddStream(o.o.m.CtxFormat()); err != nil {
-						err = errors.Wrapf(err, "main: adding stream for stream 0x%x(%d) of %s and output %s failed", is.Id(), is.Id(), i.c.Name, o.c.Name)
-						return
-					}
+			// Append encoding input
+			eis = append(eis, ei)
+			continue
+		}
 
-					// Create muxer handler
-					h = o.o.m.NewPktHandler(os)
-				}
+		// Get first matching stream
+		is := firstMatchingStream(i.o.d, i.c)
 
-				// Connect encoder to handler
-				e.Connect(h)
-			}
+		// No stream
+		if is == nil {
+			err = fmt.Errorf("main: no stream of input %s matches filter input %s", i.c.Name, i.pad)
+			return
 		}
-	}
-	return
-}
 
-func (b *builder) operationInputsOutputs(o JobOperation, bd *buildData) (is []operationInput, os []operationOutput, err error) {
-	// No inputs
-	if len(o.Inputs) == 0 {
-		err = errors.New("main: no operation inputs provided")
-		return
-	}
+		// Add demuxer as root node of the workflow
+		bd.w.AddChild(i.o.d)
 
-	// Loop through inputs
-	for _, pi := range o.Inputs {
-		// Retrieve opened input
-		i, ok := bd.inputs[pi.Name]
-		if !ok {
-			err = fmt.Errorf("main: opened input %s not found", pi.Name)
+		// Create decoder
+		var d *astilibav.Decoder
+		if d, err = b.createDecoder(bd, i, is); err != nil {
+			err = errors.Wrapf(err, "main: creating decoder for stream 0x%x(%d) of input %s failed", is.Id(), is.Id(), i.c.Name)
 			return
 		}
 
-		// Append input
-		is = append(is, operationInput{
-			c: pi,
-			o: i,
+		// Append encoding input
+		eis = append(eis, encodingInput{
+			ctx:       astilibav.NewContextFromStream(is),
+			name:      i.c.Name,
+			n:         d,
+			pad:       i.pad,
+			streamIdx: is.Index(),
 		})
 	}
 
-	// No outputs
-	if len(o.Outputs) == 0 {
-		err = errors.New("main: no operation outputs provided")
+	// Add encoding
+	if err = b.addEncoding(bd, o, eis, oos); err != nil {
+		err = errors.Wrap(err, "main: adding encoding failed")
 		return
 	}
+	return
+}
 
-	// Loop through outputs
-	for _, po := range o.Outputs {
-		// Retrieve opened output
-		o, ok := bd.outputs[po.Name]
-		if !ok {
-			err = fmt.Errorf("main: opened output %s not found", po.Name)
-			return
+func firstMatchingStream(d *astilibav.Demuxer, i JobOperationInput) *avformat.Stream {
+	for _, s := range d.CtxFormat().Streams() {
+		if streamMatchesOperationInput(s, i) {
+			return s
 		}
-
-		// Append output
-		os = append(os, operationOutput{
-			c: po,
-			o: o,
-		})
 	}
-	return
+	return nil
 }
 
-func (b *builder) operationOutputCtx(o JobOperation, inCtx astilibav.Context, oos []operationOutput) (outCtx astilibav.Context) {
-	// Default output ctx is input ctx
-	outCtx = inCtx
-
-	// Set codec name
-	outCtx.CodecName = o.Codec
-
-	// Set frame rate
-	if o.FrameRate != nil {
-		outCtx.FrameRate = avutil.NewRational(o.FrameRate.Num, o.FrameRate.Den)
+func (b *builder) nonDemuxedEncodingInput(bd *buildData, i operationInput) (ei encodingInput, err error) {
+	if i.o.f != nil {
+		return b.failoverEncodingInput(bd, i)
 	}
+	return b.generatorEncodingInput(bd, i)
+}
 
-	// Set time base
-	if o.TimeBase != nil {
-		outCtx.TimeBase = avutil.NewRational(o.TimeBase.Num, o.TimeBase.Den)
-	} else {
-		outCtx.TimeBase = avutil.NewRational(outCtx.FrameRate.Den(), outCtx.FrameRate.Num())
+func (b *builder) createGenerators(bd *buildData, cfg JobInput) (gs map[avcodec.MediaType]*astilibav.Generator, err error) {
+	// No generator
+	if cfg.Generator == nil || (cfg.Generator.Audio == nil && cfg.Generator.Video == nil) {
+		err = errors.New("main: no generator provided")
+		return
 	}
 
-	// Set pixel format
-	if len(o.PixelFormat) > 0 {
-		outCtx.PixelFormat = avutil.PixelFormatFromString(o.PixelFormat)
-	} else if o.Codec == "mjpeg" {
-		outCtx.PixelFormat = avutil.AV_PIX_FMT_YUVJ420P
+	// Create options
+	var os []astilibav.GeneratorOptions
+	if a := cfg.Generator.Audio; a != nil {
+		o := astilibav.GeneratorOptions{
+			Content: a.Filters,
+			Ctx: astilibav.Context{
+				Channels:   a.Channels,
+				CodecType:  avcodec.AVMEDIA_TYPE_AUDIO,
+				SampleRate: a.SampleRate,
+			},
+			Frequency: a.Frequency,
+		}
+		switch a.Source {
+		case "", JobInputGeneratorSourceSilence:
+			o.Source = astilibav.GeneratorSourceSilence
+		case JobInputGeneratorSourceSine:
+			o.Source = astilibav.GeneratorSourceSine
+		default:
+			err = fmt.Errorf("main: invalid audio generator source %s", a.Source)
+			return
+		}
+		os = append(os, o)
 	}
-
-	// Set height
-	if o.Height != nil {
-		outCtx.Height = *o.Height
+	if v := cfg.Generator.Video; v != nil {
+		o := astilibav.GeneratorOptions{
+			Color:   v.Color,
+			Content: v.Filters,
+			Ctx: astilibav.Context{
+				CodecType:   avcodec.AVMEDIA_TYPE_VIDEO,
+				Height:      v.Height,
+				PixelFormat: avutil.PixelFormatFromString(v.PixelFormat),
+				Width:       v.Width,
+			},
+		}
+		if len(v.PixelFormat) == 0 {
+			o.Ctx.PixelFormat = avutil.AV_PIX_FMT_YUV420P
+		}
+		if v.FrameRate != nil {
+			o.Ctx.FrameRate = avutil.NewRational(v.FrameRate.Num, v.FrameRate.Den)
+		}
+		switch v.Source {
+		case JobInputGeneratorSourceBars:
+			o.Source = astilibav.GeneratorSourceSMPTEBars
+		case JobInputGeneratorSourceColor:
+			o.Source = astilibav.GeneratorSourceColor
+		case "", JobInputGeneratorSourceTestPattern:
+			o.Source = astilibav.GeneratorSourceTestPattern
+		default:
+			err = fmt.Errorf("main: invalid video generator source %s", v.Source)
+			return
+		}
+		os = append(os, o)
 	}
 
-	// Set width
-	if o.Width != nil {
-		outCtx.Width = *o.Width
-	}
+	// Loop through options
+	gs = make(map[avcodec.MediaType]*astilibav.Generator)
+	for _, o := range os {
+		// Update options
+		o.Duration = time.Duration(cfg.Generator.Duration * float64(time.Second))
+		o.RealTime = cfg.Generator.RealTime
+
+		// Create generator
+		var g *astilibav.Generator
+		if g, err = astilibav.NewGenerator(o, bd.eh, bd.c); err != nil {
+			err = errors.Wrap(err, "main: creating generator failed")
+			return
+		}
 
-	// Set bit rate
-	if o.BitRate != nil {
-		outCtx.BitRate = *o.BitRate
+		// Index
+		gs[o.Ctx.CodecType] = g
 	}
+	return
+}
 
-	// Set gop size
-	if o.GopSize != nil {
-		outCtx.GopSize = *o.GopSize
+func (b *builder) generatorEncodingInput(bd *buildData, i operationInput) (ei encodingInput, err error) {
+	// Get generator
+	var g *astilibav.Generator
+	if t := avutil.MediaTypeFromString(i.c.MediaType); t > -1 {
+		g = i.o.gs[avcodec.MediaType(t)]
+	} else if len(i.o.gs) == 1 {
+		for _, v := range i.o.gs {
+			g = v
+		}
+	} else {
+		err = fmt.Errorf("main: media type is mandatory since generator input %s has several generators", i.c.Name)
+		return
 	}
 
-	// Set thread count
-	outCtx.ThreadCount = o.ThreadCount
-
-	// Set dict
-	outCtx.Dict = o.Dict
+	// No generator
+	if g == nil {
+		err = fmt.Errorf("main: no generator of input %s matches media type %s", i.c.Name, i.c.MediaType)
+		return
+	}
 
-	// TODO Add audio options
+	// Add generator as root node of the workflow
+	bd.w.AddChild(g)
 
-	// Set global header
-	if oos[0].o.m != nil {
-		outCtx.GlobalHeader = oos[0].o.m.CtxFormat().Oformat().Flags()&avformat.AVFMT_GLOBALHEADER > 0
+	// Create encoding input
+	ei = encodingInput{
+		ctx:  g.OutputCtx(),
+		name: i.c.Name,
+		n:    g,
+		pad:  i.pad,
 	}
 	return
 }
 
-func (b *builder) createDecoder(bd *buildData, i operationInput, is *avformat.Stream) (d *astilibav.Decoder, err error) {
-	// Get decoder
-	var okD, okS bool
-	if _, okD = bd.decoders[i.o.d]; okD {
-		d, okS = bd.decoders[i.o.d][is]
-	} else {
-		bd.decoders[i.o.d] = make(map[*avformat.Stream]*astilibav.Decoder)
-	}
+// failoverEncodingInput connects the first matching stream of every failover source to a rate enforcer switched by
+// the failover
+// The highest-priority source is used as reference: frames of other sources are restamped in its time base and,
+// for audio, resampled to its format
+func (b *builder) failoverEncodingInput(bd *buildData, i operationInput) (ei encodingInput, err error) {
+	// Sort sources
+	ss := make([]JobInputFailoverSource, len(i.o.c.Failover.Sources))
+	copy(ss, i.o.c.Failover.Sources)
+	sort.SliceStable(ss, func(a, b int) bool { return ss[a].Priority > ss[b].Priority })
+
+	// Loop through sources
+	var r *astilibav.RateEnforcer
+	for _, s := range ss {
+		// Get first matching stream
+		si := bd.inputs[s.Name]
+		is := firstMatchingStream(si.d, i.c)
+		if is == nil {
+			err = fmt.Errorf("main: no stream of source %s matches failover input %s", s.Name, i.c.Name)
+			return
+		}
+
+		// Add demuxer as root node of the workflow
+		bd.w.AddChild(si.d)
 
-	// Decoder doesn't exist
-	if !okD || !okS {
 		// Create decoder
-		if d, err = astilibav.NewDecoder(astilibav.DecoderOptions{CodecParams: is.CodecParameters()}, bd.eh, bd.c); err != nil {
-			err = errors.Wrapf(err, "main: creating decoder for stream 0x%x(%d) of %s failed", is.Id(), is.Id(), i.c.Name)
+		var d *astilibav.Decoder
+		if d, err = b.createDecoder(bd, operationInput{
+			c: i.c,
+			o: si,
+		}, is); err != nil {
+			err = errors.Wrapf(err, "main: creating decoder for stream 0x%x(%d) of source %s failed", is.Id(), is.Id(), s.Name)
 			return
 		}
 
-		// Connect demuxer
-		i.o.d.ConnectForStream(d, is)
+		// Create rate enforcer based on the reference source
+		if r == nil {
+			// Create encoding input
+			ei = encodingInput{
+				ctx:  astilibav.NewContextFromStream(is),
+				name: i.c.Name,
+				pad:  i.pad,
+			}
 
-		// Index decoder
-		bd.decoders[i.o.d][is] = d
+			// Create rate enforcer
+			if r, err = b.failoverRateEnforcer(bd, ei.ctx, *i.o.c.Failover); err != nil {
+				err = errors.Wrapf(err, "main: creating rate enforcer for failover input %s failed", i.c.Name)
+				return
+			}
+			ei.n = r
+
+			// Add switcher
+			i.o.f.AddSwitcher(r)
+		} else if t := is.CodecParameters().CodecType(); t != ei.ctx.CodecType {
+			err = fmt.Errorf("main: media type %d of source %s doesn't match media type %d of failover input %s", t, s.Name, ei.ctx.CodecType, i.c.Name)
+			return
+		}
+
+		// Connect decoder to failover
+		if _, ok := bd.failoverHandlers[d]; !ok {
+			d.Connect(i.o.f)
+			bd.failoverHandlers[d] = true
+		}
+
+		// Connect decoder to rate enforcer
+		if ei.ctx.CodecType == avutil.AVMEDIA_TYPE_AUDIO {
+			// Rate enforcer requires frames to have the same format
+			rs := astilibav.NewResampler(astilibav.ResamplerOptions{OutputCtx: ei.ctx}, bd.eh, bd.c)
+			d.Connect(rs)
+			rs.Connect(r)
+		} else {
+			d.Connect(r)
+		}
 	}
 	return
 }
 
-func (b *builder) createFilterer(bd *buildData, inCtx, outCtx astilibav.Context, n astiencoder.Node) (f *astilibav.Filterer, err error) {
-	// Create filters
-	var filters []string
+// Number of samples of frames dispatched by failover audio rate enforcers
+const failoverAudioFrameSize = 1024
 
+func (b *builder) failoverRateEnforcer(bd *buildData, ctx astilibav.Context, f JobInputFailover) (r *astilibav.RateEnforcer, err error) {
 	// Switch on media type
-	switch inCtx.CodecType {
+	switch ctx.CodecType {
+	case avutil.AVMEDIA_TYPE_AUDIO:
+		o := astilibav.RateEnforcerAudioOptions{
+			ChannelLayout: ctx.ChannelLayout,
+			FrameSize:     failoverAudioFrameSize,
+			SampleFmt:     ctx.SampleFmt,
+			SampleRate:    ctx.SampleRate,
+		}
+		r = astilibav.NewRateEnforcer(astilibav.RateEnforcerOptions{
+			Audio:     &o,
+			Restamper: astilibav.NewFrameRestamperWithFrameDuration(avutil.AvRescaleQ(int64(failoverAudioFrameSize), avutil.NewRational(1, ctx.SampleRate), ctx.TimeBase)),
+		}, bd.eh, bd.c)
 	case avutil.AVMEDIA_TYPE_VIDEO:
-		// Frame rate
-		// TODO Use select if inFramerate > outFramerate
-		if inCtx.FrameRate.Den() > 0 && outCtx.FrameRate.Den() > 0 && inCtx.FrameRate.Num()/inCtx.FrameRate.Den() != outCtx.FrameRate.Num()/outCtx.FrameRate.Den() {
-			filters = append(filters, fmt.Sprintf("minterpolate='fps=%d/%d'", outCtx.FrameRate.Num(), outCtx.FrameRate.Den()))
+		// No frame rate
+		if ctx.FrameRate.Num() <= 0 || ctx.FrameRate.Den() <= 0 {
+			err = errors.New("main: no frame rate")
+			return
 		}
 
-		// Scale
-		if inCtx.Height != outCtx.Height || inCtx.Width != outCtx.Width {
-			filters = append(filters, fmt.Sprintf("scale='w=%d:h=%d'", outCtx.Width, outCtx.Height))
+		// Create options
+		o := astilibav.RateEnforcerOptions{
+			FrameRate: ctx.FrameRate,
+			Restamper: astilibav.NewFrameRestamperWithFrameDuration(avutil.AvRescaleQ(1, avutil.NewRational(ctx.FrameRate.Den(), ctx.FrameRate.Num()), ctx.TimeBase)),
 		}
-	}
 
-	// There are filters
-	if len(filters) > 0 {
-		// Create filterer options
-		fo := astilibav.FiltererOptions{
-			Content: strings.Join(filters, ","),
-			Inputs: map[string]astilibav.FiltererInput{
-				"in": {
-					Context: inCtx,
-					Node:    n,
+		// Add slate
+		if f.Slate != nil {
+			// Invalid timeout
+			if f.Slate.Timeout <= 0 {
+				err = errors.New("main: slate timeout must be > 0")
+				return
+			}
+
+			o.Fallback = &astilibav.RateEnforcerFallbackOptions{
+				Slate: astilibav.SlateOptions{
+					Color:     f.Slate.Color,
+					ImagePath: f.Slate.ImagePath,
+					Pattern:   f.Slate.Pattern,
 				},
-			},
+				Timeout: time.Duration(f.Slate.Timeout * float64(time.Second)),
+			}
 		}
 
-		// Create filterer
-		if f, err = astilibav.NewFilterer(fo, bd.eh, bd.c); err != nil {
-			err = errors.Wrapf(err, "main: creating filterer with filters %+v failed", filters)
-			return
+		// Create rate enforcer
+		r = astilibav.NewRateEnforcer(o, bd.eh, bd.c)
+	default:
+		err = fmt.Errorf("main: media type %d is not supported", ctx.CodecType)
+	}
+	return
+}
+
+func streamMatchesOperationInput(s *avformat.Stream, i JobOperationInput) bool {
+	// Only process a specific PID
+	if i.PID != nil && s.Id() != *i.PID {
+		return false
+	}
+
+	// Only process a specific media type
+	if t := avutil.MediaTypeFromString(i.MediaType); t > -1 && s.CodecParameters().CodecType() != avcodec.MediaType(t) {
+		return false
+	}
+	return true
+}
+
+// addEncoding adds the filterer, the encoder and the outputs handlers of an operation
+// The first encoding input is used as reference for attributes that are not provided by the operation
+func (b *builder) addEncoding(bd *buildData, o JobOperation, eis []encodingInput, oos []operationOutput) (err error) {
+	// Create filtered ctx
+	var filteredCtx astilibav.Context
+	if filteredCtx, err = b.filteredCtx(o, eis); err != nil {
+		err = errors.Wrap(err, "main: creating filtered ctx failed")
+		return
+	}
+
+	// Create output ctx
+	var outCtx astilibav.Context
+	if outCtx, err = b.operationOutputCtx(bd, o, filteredCtx, oos); err != nil {
+		err = errors.Wrap(err, "main: creating output ctx failed")
+		return
+	}
+
+	// Create filterer
+	var f *astilibav.Filterer
+	if f, err = b.createFilterer(bd, o, eis, filteredCtx, outCtx); err != nil {
+		err = errors.Wrap(err, "main: creating filterer failed")
+		return
+	}
+
+	// Get the node frames leave the operation from
+	var n frameEmitter = eis[0].n
+	if f != nil {
+		for _, i := range eis {
+			i.n.Connect(f)
+		}
+		n = f
+	}
+
+	// Add detectors
+	b.addDetectors(bd, o, n, outCtx)
+
+	// Measure normalized loudness
+	if bd.j.LoudnessTarget != nil && outCtx.CodecType == avutil.AVMEDIA_TYPE_AUDIO {
+		n.Connect(astilibav.NewLoudnessMeter(astilibav.LoudnessMeterOptions{}, bd.eh))
+	}
+
+	// Loop through frame outputs
+	var eoos []operationOutput
+	for _, o := range oos {
+		// Switch on type
+		var h astilibav.FrameHandler
+		switch o.o.c.Type {
+		case JobOutputTypeFrameDump:
+			// Create frame dumper
+			if h, err = b.createFrameDumper(bd, o, eis[0]); err != nil {
+				err = errors.Wrapf(err, "main: creating frame dumper for output %s failed", o.c.Name)
+				return
+			}
+		case JobOutputTypeStoryboard:
+			// Create storyboard generator
+			if h, err = b.createStoryboardGenerator(bd, o, eis[0]); err != nil {
+				err = errors.Wrapf(err, "main: creating storyboard generator for output %s failed", o.c.Name)
+				return
+			}
+		default:
+			// Output is encoded
+			eoos = append(eoos, o)
+			continue
+		}
+
+		// Connect node to handler
+		n.Connect(h)
+	}
+
+	// No encoded outputs
+	if len(eoos) == 0 {
+		return
+	}
+
+	// Get key frame aligner
+	var a astilibav.KeyFrameAligner
+	if a, err = b.keyFrameAligner(bd, o, outCtx); err != nil {
+		err = errors.Wrap(err, "main: getting key frame aligner failed")
+		return
+	}
+
+	// Create encoder
+	var e *astilibav.Encoder
+	if e, err = astilibav.NewEncoder(astilibav.EncoderOptions{
+		Ctx:             outCtx,
+		KeyFrameAligner: a,
+	}, bd.eh, bd.c); err != nil {
+		err = errors.Wrap(err, "main: creating encoder failed")
+		return
+	}
+
+	// Connect node to encoder
+	n.Connect(e)
+
+	// Measure quality
+	if err = b.addQualityMeter(bd, o, n, e, outCtx); err != nil {
+		err = errors.Wrap(err, "main: adding quality meter failed")
+		return
+	}
+
+	// Loop through encoded outputs
+	for _, o := range eoos {
+		// Switch on type
+		var h astilibav.PktHandler
+		switch o.o.c.Type {
+		case JobOutputTypePktDump:
+			// Create pkt dumper
+			if h, err = astilibav.NewPktDumper(astilibav.PktDumperOptions{
+				Data:    map[string]interface{}{"input": eis[0].name},
+				Handler: astilibav.PktDumpFile,
+				Pattern: o.o.c.URL,
+			}, bd.eh); err != nil {
+				err = errors.Wrapf(err, "main: creating pkt dumper for output %s with conf %+v failed", o.c.Name, o.c)
+				return
+			}
+		default:
+			// Add stream
+			var os *avformat.Stream
+			if os, err = e.AddStream(o.o.m.CtxFormat()); err != nil {
+				err = errors.Wrapf(err, "main: adding stream for output %s failed", o.c.Name)
+				return
+			}
+
+			// Create muxer handler
+			h = o.o.m.NewPktHandler(os)
+		}
+
+		// Connect encoder to handler
+		e.Connect(h)
+	}
+	return
+}
+
+func (b *builder) addDetectors(bd *buildData, o JobOperation, n frameEmitter, outCtx astilibav.Context) {
+	// No detection
+	if o.Detection == nil {
+		return
+	}
+
+	// Add black detector
+	if c := o.Detection.Black; c != nil && outCtx.CodecType == avutil.AVMEDIA_TYPE_VIDEO {
+		n.Connect(astilibav.NewBlackDetector(astilibav.BlackDetectorOptions{
+			MinDuration:      time.Duration(c.MinDuration * float64(time.Second)),
+			PictureThreshold: c.PictureThreshold,
+			PixelThreshold:   c.PixelThreshold,
+		}, bd.eh))
+	}
+
+	// Add silence detector
+	if c := o.Detection.Silence; c != nil && outCtx.CodecType == avutil.AVMEDIA_TYPE_AUDIO {
+		n.Connect(astilibav.NewSilenceDetector(astilibav.SilenceDetectorOptions{
+			ClippingMinDuration: time.Duration(c.ClippingMinDuration * float64(time.Second)),
+			ClippingMinSamples:  c.ClippingMinSamples,
+			ClippingThreshold:   c.ClippingThreshold,
+			SilenceMinDuration:  time.Duration(c.MinDuration * float64(time.Second)),
+			SilenceThreshold:    c.Threshold,
+		}, bd.eh))
+	}
+}
+
+func (b *builder) addQualityMeter(bd *buildData, o JobOperation, n frameEmitter, e *astilibav.Encoder, outCtx astilibav.Context) (err error) {
+	// No quality measurement
+	if o.Quality == nil || outCtx.CodecType != avutil.AVMEDIA_TYPE_VIDEO {
+		return
+	}
+
+	// Get codec parameters
+	var cp *avcodec.CodecParameters
+	if cp, err = e.CodecParameters(bd.c); err != nil {
+		err = errors.Wrap(err, "main: getting encoder codec parameters failed")
+		return
+	}
+
+	// Create decoder
+	var d *astilibav.Decoder
+	if d, err = astilibav.NewDecoder(astilibav.DecoderOptions{CodecParams: cp}, bd.eh, bd.c); err != nil {
+		err = errors.Wrap(err, "main: creating decoder failed")
+		return
+	}
+
+	// Connect encoder to decoder
+	e.Connect(d)
+
+	// Create quality meter
+	var m *astilibav.QualityMeter
+	if m, err = astilibav.NewQualityMeter(astilibav.QualityMeterOptions{
+		Distorted: d,
+		Reference: n,
+		Window:    o.Quality.Window,
+	}, bd.eh); err != nil {
+		err = errors.Wrap(err, "main: creating quality meter failed")
+		return
+	}
+
+	// Connect nodes to quality meter
+	n.Connect(m)
+	d.Connect(m)
+
+	// No vmaf
+	if o.Quality.VMAFLog == "" || !astilibav.VMAFAvailable() {
+		return
+	}
+
+	// Create vmaf filterer
+	var f *astilibav.Filterer
+	if f, err = m.NewVMAFFilterer(outCtx, o.Quality.VMAFLog, bd.c); err != nil {
+		err = errors.Wrap(err, "main: creating vmaf filterer failed")
+		return
+	}
+
+	// Connect nodes to vmaf filterer
+	n.Connect(f)
+	d.Connect(f)
+	return
+}
+
+func (b *builder) createFrameDumper(bd *buildData, o operationOutput, i encodingInput) (d *astilibav.FrameDumper, err error) {
+	// Create options
+	fo := astilibav.FrameDumperOptions{
+		Data: map[string]interface{}{
+			"input":      i.name,
+			"stream_idx": i.streamIdx,
+		},
+		Pattern: o.o.c.URL,
+	}
+	if c := o.o.c.FrameDump; c != nil {
+		fo.Format = c.Format
+		fo.FrameInterval = c.FrameInterval
+		fo.JPEGQuality = c.JPEGQuality
+		fo.TimeInterval = time.Duration(c.Interval * float64(time.Second))
+	}
+
+	// Create frame dumper
+	if d, err = astilibav.NewFrameDumper(fo, bd.eh); err != nil {
+		err = errors.Wrapf(err, "main: creating frame dumper with conf %+v failed", o.o.c)
+		return
+	}
+	return
+}
+
+func (b *builder) createStoryboardGenerator(bd *buildData, o operationOutput, i encodingInput) (g *astilibav.StoryboardGenerator, err error) {
+	// Create options
+	so := astilibav.StoryboardGeneratorOptions{
+		Data: map[string]interface{}{
+			"input":      i.name,
+			"stream_idx": i.streamIdx,
+		},
+		Pattern: o.o.c.URL,
+	}
+	if c := o.o.c.Storyboard; c != nil {
+		so.Columns = c.Columns
+		so.Format = c.Format
+		so.Interval = time.Duration(c.Interval * float64(time.Second))
+		so.JPEGQuality = c.JPEGQuality
+		so.Rows = c.Rows
+		so.VTTPath = c.VTT
+	}
+
+	// Create storyboard generator
+	if g, err = astilibav.NewStoryboardGenerator(so, bd.eh); err != nil {
+		err = errors.Wrapf(err, "main: creating storyboard generator with conf %+v failed", o.o.c)
+		return
+	}
+	return
+}
+
+// hasEncodedOutputs checks whether frames need to be encoded for at least one output
+func hasEncodedOutputs(oos []operationOutput) bool {
+	for _, o := range oos {
+		switch o.o.c.Type {
+		case JobOutputTypeFrameDump, JobOutputTypeStoryboard:
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// filteredCtx returns the ctx frames will have once they've gone through the operation's custom filters
+func (b *builder) filteredCtx(o JobOperation, eis []encodingInput) (ctx astilibav.Context, err error) {
+	// Default ctx is the reference input's ctx
+	ctx = eis[0].ctx
+
+	// No custom filters
+	if len(o.Filters) == 0 {
+		return
+	}
+
+	// Check custom filters output
+	if _, err = customFiltersWithoutOutputLabel(o.Filters); err != nil {
+		err = errors.Wrap(err, "main: checking custom filters output failed")
+		return
+	}
+
+	// Get filterer output ctx
+	var ctxs map[string]astilibav.Context
+	if ctxs, err = astilibav.FiltererOutputContexts(astilibav.FiltererOptions{
+		Content: o.Filters,
+		Inputs:  b.filtererInputs(eis),
+	}); err != nil {
+		err = errors.Wrapf(err, "main: getting output contexts of filters %s failed", o.Filters)
+		return
+	}
+	fCtx := ctxs[astilibav.FiltererDefaultOutputName]
+
+	// Update attributes that may have been modified by the filters
+	ctx.TimeBase = fCtx.TimeBase
+	switch ctx.CodecType {
+	case avutil.AVMEDIA_TYPE_AUDIO:
+		ctx.ChannelLayout = fCtx.ChannelLayout
+		ctx.Channels = fCtx.Channels
+		ctx.SampleFmt = fCtx.SampleFmt
+		ctx.SampleRate = fCtx.SampleRate
+	case avutil.AVMEDIA_TYPE_VIDEO:
+		if fCtx.FrameRate.Num() > 0 {
+			ctx.FrameRate = fCtx.FrameRate
+		}
+		ctx.Height = fCtx.Height
+		ctx.PixelFormat = fCtx.PixelFormat
+		ctx.SampleAspectRatio = fCtx.SampleAspectRatio
+		ctx.Width = fCtx.Width
+	}
+	return
+}
+
+func (b *builder) filtererInputs(eis []encodingInput) (is map[string]astilibav.FiltererInput) {
+	is = make(map[string]astilibav.FiltererInput)
+	for _, i := range eis {
+		is[i.pad] = astilibav.FiltererInput{
+			Context: i.ctx,
+			Node:    i.n,
+		}
+	}
+	return
+}
+
+func (b *builder) operationInputsOutputs(o JobOperation, bd *buildData) (is []operationInput, os []operationOutput, err error) {
+	// Get inputs
+	var pis []JobOperationFilterInput
+	if len(o.FilterInputs) > 0 {
+		// Inputs and filter inputs are exclusive
+		if len(o.Inputs) > 0 {
+			err = errors.New("main: inputs and filter inputs can't be both provided")
+			return
+		}
+
+		// Filter inputs require filters
+		if len(o.Filters) == 0 {
+			err = errors.New("main: filter inputs require filters")
+			return
+		}
+		pis = o.FilterInputs
+	} else {
+		for _, pi := range o.Inputs {
+			pis = append(pis, JobOperationFilterInput{JobOperationInput: pi})
+		}
+	}
+
+	// No inputs
+	if len(pis) == 0 {
+		err = errors.New("main: no operation inputs provided")
+		return
+	}
+
+	// Loop through inputs
+	pads := make(map[string]bool)
+	for _, pi := range pis {
+		// Retrieve opened input
+		i, ok := bd.inputs[pi.Name]
+		if !ok {
+			err = fmt.Errorf("main: opened input %s not found", pi.Name)
+			return
+		}
+
+		// Check pad
+		if len(o.FilterInputs) > 0 {
+			if len(pi.Pad) == 0 {
+				err = fmt.Errorf("main: no pad provided for filter input %s", pi.Name)
+				return
+			} else if _, ok := pads[pi.Pad]; ok {
+				err = fmt.Errorf("main: pad %s is used by several filter inputs", pi.Pad)
+				return
+			}
+			pads[pi.Pad] = true
+		}
+
+		// Append input
+		is = append(is, operationInput{
+			c:   pi.JobOperationInput,
+			o:   i,
+			pad: pi.Pad,
+		})
+	}
+
+	// No outputs
+	if len(o.Outputs) == 0 {
+		err = errors.New("main: no operation outputs provided")
+		return
+	}
+
+	// Loop through outputs
+	for _, po := range o.Outputs {
+		// Retrieve opened output
+		o, ok := bd.outputs[po.Name]
+		if !ok {
+			err = fmt.Errorf("main: opened output %s not found", po.Name)
+			return
+		}
+
+		// Append output
+		os = append(os, operationOutput{
+			c: po,
+			o: o,
+		})
+	}
+	return
+}
+
+func (b *builder) operationOutputCtx(bd *buildData, o JobOperation, inCtx astilibav.Context, oos []operationOutput) (outCtx astilibav.Context, err error) {
+	// Default output ctx is input ctx
+	outCtx = inCtx
+
+	// Set codec name
+	outCtx.CodecName = o.Codec
+
+	// Set frame rate
+	if o.FrameRate != nil {
+		outCtx.FrameRate = avutil.NewRational(o.FrameRate.Num, o.FrameRate.Den)
+	}
+
+	// Set pixel format
+	if len(o.PixelFormat) > 0 {
+		outCtx.PixelFormat = avutil.PixelFormatFromString(o.PixelFormat)
+	}
+
+	// Negotiate attributes that have not been provided with the encoder
+	// Attributes that have been provided are left untouched so that the encoder can complain if they're not valid
+	if hasEncodedOutputs(oos) {
+		var nCtx astilibav.Context
+		if nCtx, err = outCtx.NegotiateWithEncoder(); err != nil {
+			err = errors.Wrap(err, "main: negotiating output ctx with encoder failed")
+			return
+		}
+		if o.FrameRate == nil {
+			outCtx.FrameRate = nCtx.FrameRate
+		}
+		if len(o.PixelFormat) == 0 {
+			outCtx.PixelFormat = nCtx.PixelFormat
+		}
+	} else if len(o.PixelFormat) == 0 && outCtx.CodecType == avutil.AVMEDIA_TYPE_VIDEO {
+		// Frames are not encoded, make sure they can be converted to images
+		outCtx.PixelFormat = avutil.AV_PIX_FMT_YUV420P
+	}
+
+	// Pixels are converted to the full range by the filterer when the pixel format is a yuvj pixel format, therefore
+	// the encoder must not label them with the input's range
+	if outCtx.CodecType == avutil.AVMEDIA_TYPE_VIDEO && astilibav.IsFullRangePixelFormat(outCtx.PixelFormat) {
+		outCtx.ColorRange = avutil.AVCOL_RANGE_JPEG
+	}
+
+	// Set time base
+	if o.TimeBase != nil {
+		outCtx.TimeBase = avutil.NewRational(o.TimeBase.Num, o.TimeBase.Den)
+	} else {
+		outCtx.TimeBase = avutil.NewRational(outCtx.FrameRate.Den(), outCtx.FrameRate.Num())
+	}
+
+	// Set geometry
+	if outCtx.CodecType == avutil.AVMEDIA_TYPE_VIDEO {
+		var g videoGeometry
+		if g, err = newVideoGeometry(o, inCtx); err != nil {
+			err = errors.Wrap(err, "main: creating video geometry failed")
+			return
+		}
+		if deinterlaceFilters(bd.j, inCtx) != nil {
+			outCtx.FieldOrder = avcodec.AV_FIELD_PROGRESSIVE
+		}
+		outCtx.Height = g.height
+		outCtx.Rotation = 0
+		outCtx.SampleAspectRatio = g.sar
+		outCtx.Width = g.width
+	}
+
+	// Set bit rate
+	if o.BitRate != nil {
+		outCtx.BitRate = *o.BitRate
+	}
+
+	// Set gop size
+	if o.GopSize != nil {
+		outCtx.GopSize = *o.GopSize
+	}
+
+	// Set rate control
+	if o.BufferSize != nil {
+		outCtx.BufferSize = *o.BufferSize
+	}
+	if o.MaxRate != nil {
+		outCtx.MaxRate = *o.MaxRate
+	}
+	if o.MinRate != nil {
+		outCtx.MinRate = *o.MinRate
+	}
+	outCtx.CRF = o.CRF
+	outCtx.GlobalQuality = o.GlobalQuality
+
+	// Set profile and level
+	outCtx.Profile = o.Profile
+	if o.Level != nil {
+		outCtx.Level = *o.Level
+	}
+
+	// Set gop structure
+	outCtx.ClosedGOP = o.ClosedGOP
+	outCtx.MaxBFrames = o.MaxBFrames
+	outCtx.Refs = o.Refs
+
+	// Set thread count
+	outCtx.ThreadCount = o.ThreadCount
+
+	// Set dict
+	outCtx.Dict = o.Dict
+
+	// Key frames are aligned
+	if o.KeyFrameAlignment != nil && outCtx.CodecType == avutil.AVMEDIA_TYPE_VIDEO && hasEncodedOutputs(oos) {
+		// Make sure the encoder doesn't place key frames before the aligner does
+		if o.GopSize == nil && o.KeyFrameAlignment.Interval > 0 && outCtx.FrameRate.Den() > 0 {
+			outCtx.GopSize = int(math.Ceil(o.KeyFrameAlignment.Interval*outCtx.FrameRate.ToDouble())) + 1
+		}
+
+		// Make sure forced key frames are IDR frames and the encoder doesn't add its own scene cuts
+		switch o.Codec {
+		case "libx264":
+			outCtx.Dict = joinDicts(outCtx.Dict, "forced-idr=1", "sc_threshold=0")
+		case "libx265":
+			outCtx.Dict = joinDicts(outCtx.Dict, "forced-idr=1", "x265-params=scenecut=0")
+		default:
+			err = fmt.Errorf("main: key frame alignment is not supported with codec %s", o.Codec)
+			return
+		}
+	}
+
+	// TODO Add audio options
+
+	// Set global header
+	for _, o := range oos {
+		if o.o.m != nil {
+			outCtx.GlobalHeader = o.o.m.CtxFormat().Oformat().Flags()&avformat.AVFMT_GLOBALHEADER > 0
+			break
+		}
+	}
+	return
+}
+
+func joinDicts(ds ...string) string {
+	var ss []string
+	for _, d := range ds {
+		if len(d) > 0 {
+			ss = append(ss, d)
+		}
+	}
+	return strings.Join(ss, ",")
+}
+
+func (b *builder) keyFrameAligner(bd *buildData, o JobOperation, outCtx astilibav.Context) (a astilibav.KeyFrameAligner, err error) {
+	// No alignment
+	if o.KeyFrameAlignment == nil || outCtx.CodecType != avutil.AVMEDIA_TYPE_VIDEO {
+		return
+	}
+
+	// Aligner already exists
+	c := *o.KeyFrameAlignment
+	if v, ok := bd.keyFrameAligners[c.Group]; ok {
+		if v.c != c {
+			err = fmt.Errorf("main: key frame alignment %+v is different from alignment %+v of group %s", c, v.c, c.Group)
+			return
+		}
+		a = v.a
+		return
+	}
+
+	// Create aligner
+	switch c.Mode {
+	case JobKeyFrameAlignmentModeInterval:
+		if c.Interval <= 0 {
+			err = fmt.Errorf("main: invalid key frame alignment interval %v", c.Interval)
+			return
+		}
+		a = astilibav.NewKeyFrameAlignerWithInterval(time.Duration(c.Interval * 1e9))
+	case JobKeyFrameAlignmentModeSceneCut:
+		// Without max interval, the encoder would place key frames on its own
+		if c.Interval <= 0 {
+			err = fmt.Errorf("main: invalid key frame alignment max interval %v", c.Interval)
+			return
+		}
+		a = astilibav.NewKeyFrameAlignerWithSceneCut(astilibav.KeyFrameAlignerSceneCutOptions{
+			MaxInterval: time.Duration(c.Interval * 1e9),
+			MinInterval: time.Duration(c.MinInterval * 1e9),
+			Threshold:   c.SceneThreshold,
+		})
+	default:
+		err = fmt.Errorf("main: invalid key frame alignment mode %s", c.Mode)
+		return
+	}
+
+	// Index aligner
+	bd.keyFrameAligners[c.Group] = keyFrameAligner{
+		a: a,
+		c: c,
+	}
+	return
+}
+
+func (b *builder) createDecoder(bd *buildData, i operationInput, is *avformat.Stream) (d *astilibav.Decoder, err error) {
+	// Get decoder
+	var okD, okS bool
+	if _, okD = bd.decoders[i.o.d]; okD {
+		d, okS = bd.decoders[i.o.d][is]
+	} else {
+		bd.decoders[i.o.d] = make(map[*avformat.Stream]*astilibav.Decoder)
+	}
+
+	// Decoder doesn't exist
+	if !okD || !okS {
+		// Create decoder
+		if d, err = astilibav.NewDecoder(astilibav.DecoderOptions{CodecParams: is.CodecParameters()}, bd.eh, bd.c); err != nil {
+			err = errors.Wrapf(err, "main: creating decoder for stream 0x%x(%d) of %s failed", is.Id(), is.Id(), i.c.Name)
+			return
+		}
+
+		// Connect demuxer
+		i.o.d.ConnectForStream(d, is)
+
+		// Index decoder
+		bd.decoders[i.o.d][is] = d
+	}
+	return
+}
+
+func (b *builder) createFilterer(bd *buildData, o JobOperation, eis []encodingInput, inCtx, outCtx astilibav.Context) (f *astilibav.Filterer, err error) {
+	// Create filters
+	// Input filters are applied to the reference input before the custom filters
+	var inputFilters, filters []string
+	var loudnessIdx int
+	var measuredFilter loudnessFilterFunc
+
+	// Switch on media type
+	switch inCtx.CodecType {
+	case avutil.AVMEDIA_TYPE_VIDEO:
+		// Deinterlace
+		inputFilters = deinterlaceFilters(bd.j, inCtx)
+
+		// Frame rate
+		if inCtx.FrameRate.Num() > 0 && inCtx.FrameRate.Den() > 0 && outCtx.FrameRate.Num() > 0 && outCtx.FrameRate.Den() > 0 && avutil.AvCmpQ(inCtx.FrameRate, outCtx.FrameRate) != 0 {
+			var filter string
+			if filter, err = frameRateFilter(o.FrameRateMode, inCtx.FrameRate, outCtx.FrameRate); err != nil {
+				err = errors.Wrap(err, "main: getting frame rate filter failed")
+				return
+			}
+			filters = append(filters, filter)
+		}
+
+		// Geometry
+		var g videoGeometry
+		if g, err = newVideoGeometry(o, inCtx); err != nil {
+			err = errors.Wrap(err, "main: creating video geometry failed")
+			return
+		}
+		filters = append(filters, g.filters()...)
+
+		// Make sure dimensions don't change if the input ones change mid-stream
+		if g.scaleHeight == g.inHeight && g.scaleWidth == g.inWidth && g.rotation == 0 {
+			filters = append(filters, fmt.Sprintf("scale='w=%d:h=%d'", outCtx.Width, outCtx.Height))
+		}
+
+		// Pixel format
+		// It's always added so that it doesn't change if the input one changes mid-stream
+		filters = append(filters, fmt.Sprintf("format=pix_fmts=%s", avutil.AvGetPixFmtName(int(outCtx.PixelFormat))))
+	case avutil.AVMEDIA_TYPE_AUDIO:
+		// Loudness
+		var filter string
+		if filter, measuredFilter, err = b.loudnessFilter(bd, o, eis); err != nil {
+			err = errors.Wrap(err, "main: getting loudness filter failed")
+			return
+		} else if len(filter) > 0 {
+			loudnessIdx = len(filters)
+			filters = append(filters, filter)
+		}
+
+		// Make sure the sample format, sample rate and channel layout don't change if the input ones change mid-stream
+		// It also brings the sample rate back after loudnorm which upsamples
+		filter = fmt.Sprintf("aformat=sample_fmts=%s:sample_rates=%d", avutil.AvGetSampleFmtName(int(outCtx.SampleFmt)), outCtx.SampleRate)
+		if outCtx.ChannelLayout > 0 {
+			filter += ":channel_layouts=" + avutil.AvGetChannelLayoutString(outCtx.ChannelLayout)
+		}
+		filters = append(filters, filter)
+	}
+
+	// There are filters
+	// Audio and video filterers rebuild their graph when the input format changes mid-stream
+	if len(o.Filters) > 0 || len(inputFilters) > 0 || len(filters) > 0 {
+		// Create graph
+		var g filterGraph
+		if g, err = newFilterGraph(o.Filters, eis[0].pad, inputFilters, filters); err != nil {
+			err = errors.Wrap(err, "main: creating filter graph failed")
+			return
+		}
+
+		// Create filterer options
+		fo := astilibav.FiltererOptions{
+			Content: g.content,
+			Inputs:  b.filtererInputs(eis),
+		}
+
+		// Reference input has been renamed
+		if g.inputPad != eis[0].pad {
+			fo.Inputs[g.inputPad] = fo.Inputs[eis[0].pad]
+			delete(fo.Inputs, eis[0].pad)
+		}
+
+		// Loudness is measured once the filterer has started so that building the workflow doesn't block
+		// The graph is normalized dynamically until then, which is also what happens if the measurement fails
+		if measuredFilter != nil {
+			fo.ContentFunc = func(ctx context.Context) (content string, err error) {
+				// Get measured loudness filter
+				fs := append([]string{}, filters...)
+				if fs[loudnessIdx], err = measuredFilter(ctx); err != nil {
+					err = errors.Wrap(err, "main: getting measured loudness filter failed")
+					return
+				}
+
+				// Create graph
+				var mg filterGraph
+				if mg, err = newFilterGraph(o.Filters, eis[0].pad, inputFilters, fs); err != nil {
+					err = errors.Wrap(err, "main: creating filter graph failed")
+					return
+				}
+				content = mg.content
+				return
+			}
+		}
+
+		// Create filterer
+		if f, err = astilibav.NewFilterer(fo, bd.eh, bd.c); err != nil {
+			err = errors.Wrapf(err, "main: creating filterer with filters %s failed", g.content)
+			return
+		}
+	}
+	return
+}
+
+// Labels used to chain custom filters with automatic filters
+const (
+	filterGraphCustomOutputLabel = "astiencoder_custom"
+	filterGraphInputLabelPrefix  = "astiencoder_raw_"
+)
+
+// filterGraph describes the content of a filter graph built out of custom and automatic filters
+type filterGraph struct {
+	content string
+	// Name of the pad the reference input must be connected to
+	inputPad string
+}
+
+// newFilterGraph chains input filters, custom filters and output filters through explicit labels
+// Input filters are applied to the reference input before the custom filters, and output filters are applied to the
+// output of the custom filters
+func newFilterGraph(custom, pad string, inputFilters, outputFilters []string) (g filterGraph, err error) {
+	// Default pad
+	g.inputPad = pad
+
+	// No custom filters
+	if len(custom) == 0 {
+		g.content = strings.Join(append(append([]string{}, inputFilters...), outputFilters...), ",")
+		return
+	}
+
+	// Get custom filters without their output label
+	if custom, err = customFiltersWithoutOutputLabel(custom); err != nil {
+		err = errors.Wrap(err, "main: parsing custom filters output failed")
+		return
+	}
+
+	// Input filters
+	// The reference input is renamed so that custom filters refer to its filtered version
+	if len(inputFilters) > 0 {
+		g.inputPad = filterGraphInputLabelPrefix + pad
+		if !strings.HasPrefix(custom, "[") {
+			custom = "[" + pad + "]" + custom
+		}
+		custom = fmt.Sprintf("[%s]%s[%s];%s", g.inputPad, strings.Join(inputFilters, ","), pad, custom)
+	}
+
+	// Output filters
+	if len(outputFilters) > 0 {
+		custom = fmt.Sprintf("%s[%s];[%s]%s", custom, filterGraphCustomOutputLabel, filterGraphCustomOutputLabel, strings.Join(outputFilters, ","))
+	}
+	g.content = custom
+	return
+}
+
+// customFiltersWithoutOutputLabel makes sure the last filter chain of custom filters either has an unlabeled output or an
+// output labeled as the filterer default output, and removes the label
+func customFiltersWithoutOutputLabel(custom string) (o string, err error) {
+	// Remove trailing separators
+	o = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(custom), ";"))
+
+	// Output is unlabeled
+	if !strings.HasSuffix(o, "]") {
+		return
+	}
+
+	// Get label
+	idx := strings.LastIndex(o, "[")
+	if idx < 0 {
+		err = fmt.Errorf("main: invalid output label in %s", custom)
+		return
+	}
+
+	// Check label
+	if l := o[idx+1 : len(o)-1]; l != astilibav.FiltererDefaultOutputName {
+		err = fmt.Errorf("main: last filter chain output must either be unlabeled or labeled %s, got %s", astilibav.FiltererDefaultOutputName, l)
+		return
+	}
+	o = strings.TrimSpace(o[:idx])
+	return
+}
+
+// loudnessFilterFunc returns a loudness filter once the loudness has been measured
+type loudnessFilterFunc func(ctx context.Context) (string, error)
+
+// loudnessFilter returns the loudness filter normalizing dynamically and, when the input can be measured beforehand,
+// a func returning the loudness filter normalizing linearly
+func (b *builder) loudnessFilter(bd *buildData, o JobOperation, eis []encodingInput) (filter string, measuredFilter loudnessFilterFunc, err error) {
+	// No loudness target
+	if bd.j.LoudnessTarget == nil {
+		return
+	}
+
+	// Default values
+	// The target is copied so that the job is not modified
+	t := *bd.j.LoudnessTarget
+	if t.Integrated == 0 {
+		t.Integrated = -23
+	}
+	if t.LRA == 0 {
+		t.LRA = 7
+	}
+	if t.TruePeak == 0 {
+		t.TruePeak = -1
+	}
+	filter = fmt.Sprintf("loudnorm=I=%.2f:LRA=%.2f:TP=%.2f", t.Integrated, t.LRA, t.TruePeak)
+
+	// Audio can only be measured beforehand when it comes untouched from a file
+	// Otherwise it's normalized dynamically
+	i, ok := bd.inputs[eis[0].name]
+	if len(eis) > 1 || len(o.Filters) > 0 || !ok || i.d == nil || i.c.EmulateRate || !isFileURL(i.c.URL) {
+		return
+	}
+
+	// Get measurement
+	// Streams are measured only once even if they're used by several operations
+	k := fmt.Sprintf("%s:%d", eis[0].name, eis[0].streamIdx)
+	m, ok := bd.loudnessMeasurements[k]
+	if !ok {
+		m = newLoudnessMeasurement(astilibav.MeasureLoudnessOptions{
+			Dict:        i.c.Dict,
+			StreamIndex: eis[0].streamIdx,
+			URL:         i.c.URL,
+		})
+		bd.loudnessMeasurements[k] = m
+	}
+
+	// Normalize linearly
+	measuredFilter = func(ctx context.Context) (f string, err error) {
+		// Measure
+		var lm astilibav.LoudnessMeasurement
+		if lm, err = m.measure(ctx); err != nil {
+			err = errors.Wrapf(err, "main: measuring loudness of stream %d of input %s failed", eis[0].streamIdx, eis[0].name)
+			return
+		}
+		f = fmt.Sprintf("%s:measured_I=%.2f:measured_LRA=%.2f:measured_TP=%.2f:measured_thresh=%.2f:linear=true", filter, lm.Integrated, lm.LRA, lm.TruePeak, lm.Threshold)
+		return
+	}
+	return
+}
+
+// loudnessMeasurement measures the loudness of a stream the first time it's needed
+type loudnessMeasurement struct {
+	err error
+	m   astilibav.LoudnessMeasurement
+	o   *sync.Once
+	opt astilibav.MeasureLoudnessOptions
+}
+
+func newLoudnessMeasurement(opt astilibav.MeasureLoudnessOptions) *loudnessMeasurement {
+	return &loudnessMeasurement{
+		o:   &sync.Once{},
+		opt: opt,
+	}
+}
+
+func (m *loudnessMeasurement) measure(ctx context.Context) (astilibav.LoudnessMeasurement, error) {
+	m.o.Do(func() {
+		m.m, m.err = astilibav.MeasureLoudness(ctx, m.opt)
+	})
+	return m.m, m.err
+}
+
+// isFileURL checks whether the url points to a local file
+func isFileURL(u string) bool {
+	return !strings.Contains(u, "://") || strings.HasPrefix(u, "file://")
+}
+
+func frameRateFilter(mode string, in, out avutil.Rational) (filter string, err error) {
+	// Pick a cheap mode
+	if len(mode) == 0 {
+		mode = defaultFrameRateMode(in, out)
+	}
+
+	// Switch on mode
+	switch mode {
+	case JobOperationFrameRateModeBlend:
+		filter = fmt.Sprintf("framerate=fps=%d/%d", out.Num(), out.Den())
+	case JobOperationFrameRateModeDrop, JobOperationFrameRateModeDuplicate:
+		// Check frame rates
+		if c := avutil.AvCmpQ(out, in); mode == JobOperationFrameRateModeDrop && c > 0 {
+			err = fmt.Errorf("main: frame rate mode %s can't increase the frame rate from %d/%d to %d/%d", mode, in.Num(), in.Den(), out.Num(), out.Den())
+			return
+		} else if mode == JobOperationFrameRateModeDuplicate && c < 0 {
+			err = fmt.Errorf("main: frame rate mode %s can't decrease the frame rate from %d/%d to %d/%d", mode, in.Num(), in.Den(), out.Num(), out.Den())
+			return
+		}
+		filter = fmt.Sprintf("fps=fps=%d/%d", out.Num(), out.Den())
+	case JobOperationFrameRateModeInterpolate:
+		filter = fmt.Sprintf("minterpolate='fps=%d/%d'", out.Num(), out.Den())
+	default:
+		err = fmt.Errorf("main: invalid frame rate mode %s", mode)
+	}
+	return
+}
+
+// defaultFrameRateMode drops frames when the output frame rate is lower, duplicates frames when the output frame rate
+// is a multiple of the input frame rate and blends frames otherwise
+func defaultFrameRateMode(in, out avutil.Rational) string {
+	// Drop
+	if avutil.AvCmpQ(out, in) < 0 {
+		return JobOperationFrameRateModeDrop
+	}
+
+	// Duplicate
+	// out / in = (out.num * in.den) / (out.den * in.num)
+	if n, d := int64(out.Num())*int64(in.Den()), int64(out.Den())*int64(in.Num()); d > 0 && n%d == 0 {
+		return JobOperationFrameRateModeDuplicate
+	}
+	return JobOperationFrameRateModeBlend
+}
+
+// videoGeometry describes how frames are rotated, scaled, padded or cropped
+type videoGeometry struct {
+	// Input attributes once rotated
+	inHeight int
+	inSAR    avutil.Rational
+	inWidth  int
+
+	height      int
+	mode        string
+	rotation    int
+	sar         avutil.Rational
+	scaleHeight int
+	scaleWidth  int
+	width       int
+}
+
+func newVideoGeometry(o JobOperation, inCtx astilibav.Context) (g videoGeometry, err error) {
+	// Create geometry
+	g = videoGeometry{
+		inHeight: inCtx.Height,
+		inSAR:    inCtx.SampleAspectRatio,
+		inWidth:  inCtx.Width,
+		mode:     o.ScaleMode,
+		rotation: inCtx.Rotation,
+	}
+
+	// Check mode
+	switch g.mode {
+	case "":
+		g.mode = JobOperationScaleModeStretch
+	case JobOperationScaleModeFill, JobOperationScaleModeFit, JobOperationScaleModeStretch:
+	default:
+		err = fmt.Errorf("main: invalid scale mode %s", g.mode)
+		return
+	}
+
+	// Unknown sample aspect ratio means square pixels
+	if g.inSAR.Num() <= 0 || g.inSAR.Den() <= 0 {
+		g.inSAR = avutil.NewRational(1, 1)
+	}
+
+	// Rotation swaps dimensions
+	if g.rotation == 90 || g.rotation == 270 {
+		g.inHeight, g.inWidth = g.inWidth, g.inHeight
+		g.inSAR = avutil.NewRational(g.inSAR.Den(), g.inSAR.Num())
+	}
+
+	// No dimensions provided
+	if o.Height == nil && o.Width == nil {
+		g.height, g.scaleHeight = g.inHeight, g.inHeight
+		g.sar = g.inSAR
+		g.width, g.scaleWidth = g.inWidth, g.inWidth
+		return
+	}
+
+	// We need input dimensions to keep the display aspect ratio
+	if g.inHeight <= 0 || g.inWidth <= 0 {
+		err = fmt.Errorf("main: invalid input dimensions %dx%d", g.inWidth, g.inHeight)
+		return
+	}
+
+	// Get display aspect ratio
+	dar := float64(g.inWidth) * g.inSAR.ToDouble() / float64(g.inHeight)
+
+	// Output pixels are square
+	g.sar = avutil.NewRational(1, 1)
+
+	// Switch on provided dimensions
+	switch {
+	case o.Height == nil:
+		g.width, g.scaleWidth = *o.Width, *o.Width
+		g.height = evenDimension(float64(*o.Width) / dar)
+		g.scaleHeight = g.height
+	case o.Width == nil:
+		g.height, g.scaleHeight = *o.Height, *o.Height
+		g.width = evenDimension(float64(*o.Height) * dar)
+		g.scaleWidth = g.width
+	default:
+		g.height, g.width = *o.Height, *o.Width
+		switch g.mode {
+		case JobOperationScaleModeFill:
+			if float64(g.width)/float64(g.height) > dar {
+				g.scaleHeight, g.scaleWidth = maxInt(evenDimension(float64(g.width)/dar), g.height), g.width
+			} else {
+				g.scaleHeight, g.scaleWidth = g.height, maxInt(evenDimension(float64(g.height)*dar), g.width)
+			}
+		case JobOperationScaleModeFit:
+			if float64(g.width)/float64(g.height) > dar {
+				g.scaleHeight, g.scaleWidth = g.height, minInt(evenDimension(float64(g.height)*dar), g.width)
+			} else {
+				g.scaleHeight, g.scaleWidth = minInt(evenDimension(float64(g.width)/dar), g.height), g.width
+			}
+		default:
+			g.scaleHeight, g.scaleWidth = g.height, g.width
+		}
+	}
+	return
+}
+
+func (g videoGeometry) filters() (fs []string) {
+	// Rotate
+	switch g.rotation {
+	case 90:
+		fs = append(fs, "transpose=clock")
+	case 180:
+		fs = append(fs, "hflip", "vflip")
+	case 270:
+		fs = append(fs, "transpose=cclock")
+	}
+
+	// Scale
+	if g.scaleHeight != g.inHeight || g.scaleWidth != g.inWidth {
+		fs = append(fs, fmt.Sprintf("scale='w=%d:h=%d'", g.scaleWidth, g.scaleHeight))
+	}
+
+	// Pad or crop
+	if g.scaleHeight != g.height || g.scaleWidth != g.width {
+		switch g.mode {
+		case JobOperationScaleModeFill:
+			fs = append(fs, fmt.Sprintf("crop='w=%d:h=%d'", g.width, g.height))
+		case JobOperationScaleModeFit:
+			fs = append(fs, fmt.Sprintf("pad='w=%d:h=%d:x=(ow-iw)/2:y=(oh-ih)/2'", g.width, g.height))
+		}
+	}
+
+	// Set sample aspect ratio
+	// Filters such as scale update the sample aspect ratio to keep the display aspect ratio which is not what we want
+	if len(fs) > 0 || avutil.AvCmpQ(g.sar, g.inSAR) != 0 {
+		fs = append(fs, fmt.Sprintf("setsar=%d/%d", g.sar.Num(), g.sar.Den()))
+	}
+	return
+}
+
+// evenDimension rounds a dimension to the nearest even value since most pixel formats subsample chroma
+func evenDimension(v float64) int {
+	return maxInt(int(math.Round(v/2))*2, 2)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// deinterlaceFilters returns the filters needed to make frames progressive, if any
+func deinterlaceFilters(j Job, inCtx astilibav.Context) (fs []string) {
+	// Get deinterlacer
+	d := j.Deinterlacer
+	if len(d) == 0 {
+		d = JobDeinterlacerYadif
+	}
+
+	// Switch on mode
+	switch j.Deinterlace {
+	case JobDeinterlaceAlways:
+		fs = append(fs, d+"=mode=send_frame:parity=auto:deint=all")
+	case JobDeinterlaceNever:
+	default:
+		switch inCtx.FieldOrder {
+		case avcodec.AV_FIELD_BB, avcodec.AV_FIELD_BT, avcodec.AV_FIELD_TB, avcodec.AV_FIELD_TT:
+			fs = append(fs, d+"=mode=send_frame:parity=auto:deint=all")
+		case avcodec.AV_FIELD_UNKNOWN:
+			// idet resets the interlaced flag of frames if, after analyzing the first frames, it appears that the flag
+			// is not accurate. Only frames still flagged as interlaced are deinterlaced
+			if j.DeinterlaceDetectionFrames > 0 {
+				fs = append(fs, fmt.Sprintf("idet=analyze_interlaced_flag=%d", j.DeinterlaceDetectionFrames), d+"=mode=send_frame:parity=auto:deint=interlaced")
+			}
 		}
 	}
 	return
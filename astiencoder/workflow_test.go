@@ -1,7 +1,16 @@
 package main
 
 import (
+	"context"
 	"testing"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astiencoder/libav"
+	"github.com/asticode/go-astitools/float"
+	"github.com/asticode/go-astitools/worker"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestCopy(t *testing.T) {
@@ -31,3 +40,547 @@ func TestEncode(t *testing.T) {
 		}
 	})
 }
+
+func TestOverlay(t *testing.T) {
+	testJobOutputs(t, "../examples/overlay.json", func(j Job) []string {
+		return []string{"../examples/tmp/overlay.mp4"}
+	})
+}
+
+func TestFailover(t *testing.T) {
+	testJobOutputs(t, "../examples/failover.json", func(j Job) []string {
+		return []string{"../examples/tmp/failover.mp4"}
+	})
+}
+
+func TestGenerator(t *testing.T) {
+	testJobOutputs(t, "../examples/generator.json", func(j Job) []string {
+		return []string{"../examples/tmp/generator.mp4"}
+	})
+}
+
+func TestThumbnails(t *testing.T) {
+	removeFiles("../examples/tmp/thumbnails-*.jpeg", t)
+	testJobOutputs(t, "../examples/thumbnails.json", func(j Job) []string {
+		return assertGlobNotEmpty("../examples/tmp/thumbnails-*.jpeg", t)
+	})
+}
+
+func TestStoryboard(t *testing.T) {
+	removeFiles("../examples/tmp/storyboard-*.jpeg", t)
+	testJobOutputs(t, "../examples/storyboard.json", func(j Job) []string {
+		return append(assertGlobNotEmpty("../examples/tmp/storyboard-*.jpeg", t), "../examples/tmp/storyboard.vtt")
+	})
+}
+
+func TestLoudness(t *testing.T) {
+	testJobOutputs(t, "../examples/loudness.json", func(j Job) []string {
+		return []string{"../examples/tmp/loudness.mp4"}
+	})
+}
+
+func TestQuality(t *testing.T) {
+	removeFiles("../examples/tmp/vmaf.json", t)
+	testJobOutputs(t, "../examples/quality.json", func(j Job) (ps []string) {
+		ps = []string{"../examples/tmp/quality.mp4"}
+		if astilibav.VMAFAvailable() {
+			ps = append(ps, "../examples/tmp/vmaf.json")
+		}
+		return
+	})
+}
+
+func TestNewFilterGraph(t *testing.T) {
+	// No custom filters
+	g, err := newFilterGraph("", "in", []string{"yadif"}, []string{"fps=25", "format=pix_fmts=yuv420p"})
+	assert.NoError(t, err)
+	assert.Equal(t, filterGraph{content: "yadif,fps=25,format=pix_fmts=yuv420p", inputPad: "in"}, g)
+
+	// Only custom filters
+	g, err = newFilterGraph("hflip[out]", "in", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, filterGraph{content: "hflip", inputPad: "in"}, g)
+
+	// Unlabeled custom filters
+	g, err = newFilterGraph("hflip", "in", []string{"yadif"}, []string{"fps=25"})
+	assert.NoError(t, err)
+	assert.Equal(t, filterGraph{
+		content:  "[astiencoder_raw_in]yadif[in];[in]hflip[astiencoder_custom];[astiencoder_custom]fps=25",
+		inputPad: "astiencoder_raw_in",
+	}, g)
+
+	// Several labeled filter chains
+	g, err = newFilterGraph("[pip]scale=w=iw/4:h=ih/4[small];[main][small]overlay[out]; ", "main", []string{"yadif"}, []string{"fps=25"})
+	assert.NoError(t, err)
+	assert.Equal(t, filterGraph{
+		content:  "[astiencoder_raw_main]yadif[main];[pip]scale=w=iw/4:h=ih/4[small];[main][small]overlay[astiencoder_custom];[astiencoder_custom]fps=25",
+		inputPad: "astiencoder_raw_main",
+	}, g)
+
+	// Invalid output label
+	_, err = newFilterGraph("[in]split[a][b]", "in", nil, []string{"fps=25"})
+	assert.Error(t, err)
+}
+
+func TestBuilderKeyFrameAligner(t *testing.T) {
+	b := newBuilder()
+	bd := newBuildData(Job{}, nil, astiencoder.NewEventHandler(), astiencoder.NewCloser())
+	defer bd.c.Close()
+	ctx := astilibav.Context{CodecType: avutil.AVMEDIA_TYPE_VIDEO}
+
+	// No alignment
+	a, err := b.keyFrameAligner(bd, JobOperation{}, ctx)
+	assert.NoError(t, err)
+	assert.Nil(t, a)
+
+	// Invalid alignments
+	for _, c := range []JobKeyFrameAlignment{
+		{Mode: "invalid", Interval: 2},
+		{Mode: JobKeyFrameAlignmentModeInterval},
+		{Mode: JobKeyFrameAlignmentModeSceneCut},
+	} {
+		c := c
+		_, err = b.keyFrameAligner(bd, JobOperation{KeyFrameAlignment: &c}, ctx)
+		assert.Error(t, err)
+	}
+
+	// Operations of the same group share the aligner
+	o := JobOperation{KeyFrameAlignment: &JobKeyFrameAlignment{Group: "g", Interval: 2, Mode: JobKeyFrameAlignmentModeInterval}}
+	a1, err := b.keyFrameAligner(bd, o, ctx)
+	assert.NoError(t, err)
+	a2, err := b.keyFrameAligner(bd, o, ctx)
+	assert.NoError(t, err)
+	assert.True(t, a1 == a2)
+
+	// Operations of the same group must have the same alignment
+	_, err = b.keyFrameAligner(bd, JobOperation{KeyFrameAlignment: &JobKeyFrameAlignment{Group: "g", Interval: 4, Mode: JobKeyFrameAlignmentModeInterval}}, ctx)
+	assert.Error(t, err)
+}
+
+func TestFrameRateFilter(t *testing.T) {
+	// Default mode
+	for _, v := range []struct {
+		filter  string
+		in, out avutil.Rational
+	}{
+		{filter: "fps=fps=25/1", in: avutil.NewRational(50, 1), out: avutil.NewRational(25, 1)},
+		{filter: "fps=fps=50/1", in: avutil.NewRational(25, 1), out: avutil.NewRational(50, 1)},
+		{filter: "fps=fps=60000/1001", in: avutil.NewRational(30000, 1001), out: avutil.NewRational(60000, 1001)},
+		{filter: "framerate=fps=30/1", in: avutil.NewRational(25, 1), out: avutil.NewRational(30, 1)},
+	} {
+		f, err := frameRateFilter("", v.in, v.out)
+		assert.NoError(t, err)
+		assert.Equal(t, v.filter, f)
+	}
+
+	// Explicit modes
+	f, err := frameRateFilter(JobOperationFrameRateModeInterpolate, avutil.NewRational(25, 1), avutil.NewRational(30, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, "minterpolate='fps=30/1'", f)
+	f, err = frameRateFilter(JobOperationFrameRateModeBlend, avutil.NewRational(50, 1), avutil.NewRational(25, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, "framerate=fps=25/1", f)
+
+	// Invalid modes
+	_, err = frameRateFilter(JobOperationFrameRateModeDrop, avutil.NewRational(25, 1), avutil.NewRational(30, 1))
+	assert.Error(t, err)
+	_, err = frameRateFilter(JobOperationFrameRateModeDuplicate, avutil.NewRational(30, 1), avutil.NewRational(25, 1))
+	assert.Error(t, err)
+	_, err = frameRateFilter("invalid", avutil.NewRational(30, 1), avutil.NewRational(25, 1))
+	assert.Error(t, err)
+}
+
+func TestDefaultFrameRateMode(t *testing.T) {
+	assert.Equal(t, JobOperationFrameRateModeDrop, defaultFrameRateMode(avutil.NewRational(30, 1), avutil.NewRational(25, 1)))
+	assert.Equal(t, JobOperationFrameRateModeDuplicate, defaultFrameRateMode(avutil.NewRational(25, 1), avutil.NewRational(25, 1)))
+	assert.Equal(t, JobOperationFrameRateModeDuplicate, defaultFrameRateMode(avutil.NewRational(24000, 1001), avutil.NewRational(48000, 1001)))
+	assert.Equal(t, JobOperationFrameRateModeBlend, defaultFrameRateMode(avutil.NewRational(24, 1), avutil.NewRational(25, 1)))
+}
+
+func TestNewVideoGeometry(t *testing.T) {
+	intPtr := func(i int) *int { return &i }
+	hd := astilibav.Context{Height: 1080, SampleAspectRatio: avutil.NewRational(1, 1), Width: 1920}
+	for _, v := range []struct {
+		filters []string
+		height  int
+		inCtx   astilibav.Context
+		o       JobOperation
+		sar     avutil.Rational
+		width   int
+	}{
+		{
+			height: 1080,
+			inCtx:  hd,
+			sar:    avutil.NewRational(1, 1),
+			width:  1920,
+		},
+		{
+			filters: []string{"scale='w=1280:h=720'", "setsar=1/1"},
+			height:  720,
+			inCtx:   hd,
+			o:       JobOperation{Width: intPtr(1280)},
+			sar:     avutil.NewRational(1, 1),
+			width:   1280,
+		},
+		{
+			filters: []string{"scale='w=1280:h=720'", "pad='w=1280:h=1280:x=(ow-iw)/2:y=(oh-ih)/2'", "setsar=1/1"},
+			height:  1280,
+			inCtx:   hd,
+			o:       JobOperation{Height: intPtr(1280), ScaleMode: JobOperationScaleModeFit, Width: intPtr(1280)},
+			sar:     avutil.NewRational(1, 1),
+			width:   1280,
+		},
+		{
+			filters: []string{"scale='w=2276:h=1280'", "crop='w=1280:h=1280'", "setsar=1/1"},
+			height:  1280,
+			inCtx:   hd,
+			o:       JobOperation{Height: intPtr(1280), ScaleMode: JobOperationScaleModeFill, Width: intPtr(1280)},
+			sar:     avutil.NewRational(1, 1),
+			width:   1280,
+		},
+		{
+			filters: []string{"scale='w=1280:h=1280'", "setsar=1/1"},
+			height:  1280,
+			inCtx:   hd,
+			o:       JobOperation{Height: intPtr(1280), Width: intPtr(1280)},
+			sar:     avutil.NewRational(1, 1),
+			width:   1280,
+		},
+		{
+			filters: []string{"transpose=clock", "scale='w=360:h=640'", "setsar=1/1"},
+			height:  640,
+			inCtx:   astilibav.Context{Height: 1080, Rotation: 90, Width: 1920},
+			o:       JobOperation{Height: intPtr(640)},
+			sar:     avutil.NewRational(1, 1),
+			width:   360,
+		},
+		{
+			height: 576,
+			inCtx:  astilibav.Context{Height: 576, SampleAspectRatio: avutil.NewRational(16, 15), Width: 720},
+			sar:    avutil.NewRational(16, 15),
+			width:  720,
+		},
+		{
+			filters: []string{"scale='w=768:h=576'", "setsar=1/1"},
+			height:  576,
+			inCtx:   astilibav.Context{Height: 576, SampleAspectRatio: avutil.NewRational(16, 15), Width: 720},
+			o:       JobOperation{Height: intPtr(576)},
+			sar:     avutil.NewRational(1, 1),
+			width:   768,
+		},
+	} {
+		g, err := newVideoGeometry(v.o, v.inCtx)
+		assert.NoError(t, err)
+		assert.Equal(t, v.height, g.height)
+		assert.Equal(t, v.width, g.width)
+		assert.Equal(t, 0, avutil.AvCmpQ(v.sar, g.sar))
+		assert.Equal(t, v.filters, g.filters())
+	}
+
+	// Invalid scale mode
+	_, err := newVideoGeometry(JobOperation{ScaleMode: "invalid"}, hd)
+	assert.Error(t, err)
+
+	// Invalid input dimensions
+	_, err = newVideoGeometry(JobOperation{Width: intPtr(1280)}, astilibav.Context{})
+	assert.Error(t, err)
+}
+
+func TestDeinterlaceFilters(t *testing.T) {
+	progressive := astilibav.Context{FieldOrder: avcodec.AV_FIELD_PROGRESSIVE}
+	interlaced := astilibav.Context{FieldOrder: avcodec.AV_FIELD_TT}
+	unknown := astilibav.Context{FieldOrder: avcodec.AV_FIELD_UNKNOWN}
+
+	// Auto
+	assert.Nil(t, deinterlaceFilters(Job{}, progressive))
+	assert.Equal(t, []string{"yadif=mode=send_frame:parity=auto:deint=all"}, deinterlaceFilters(Job{}, interlaced))
+	assert.Nil(t, deinterlaceFilters(Job{}, unknown))
+	assert.Equal(t, []string{"idet=analyze_interlaced_flag=10", "bwdif=mode=send_frame:parity=auto:deint=interlaced"}, deinterlaceFilters(Job{DeinterlaceDetectionFrames: 10, Deinterlacer: JobDeinterlacerBwdif}, unknown))
+
+	// Always
+	assert.Equal(t, []string{"yadif=mode=send_frame:parity=auto:deint=all"}, deinterlaceFilters(Job{Deinterlace: JobDeinterlaceAlways}, progressive))
+
+	// Never
+	assert.Nil(t, deinterlaceFilters(Job{Deinterlace: JobDeinterlaceNever}, interlaced))
+}
+
+func TestBuilderFailoverRateEnforcer(t *testing.T) {
+	b := newBuilder()
+	bd := newBuildData(Job{}, nil, astiencoder.NewEventHandler(), astiencoder.NewCloser())
+	defer bd.c.Close()
+	video := astilibav.Context{CodecType: avutil.AVMEDIA_TYPE_VIDEO, FrameRate: avutil.NewRational(25, 1), TimeBase: avutil.NewRational(1, 25)}
+
+	// Slate
+	r, err := b.failoverRateEnforcer(bd, video, JobInputFailover{Slate: &JobInputFailoverSlate{Pattern: "smptebars", Timeout: 1}})
+	assert.NoError(t, err)
+	assert.NotNil(t, r)
+
+	// Invalid slate timeout
+	_, err = b.failoverRateEnforcer(bd, video, JobInputFailover{Slate: &JobInputFailoverSlate{}})
+	assert.Error(t, err)
+
+	// No frame rate
+	_, err = b.failoverRateEnforcer(bd, astilibav.Context{CodecType: avutil.AVMEDIA_TYPE_VIDEO}, JobInputFailover{})
+	assert.Error(t, err)
+
+	// Unsupported media type
+	_, err = b.failoverRateEnforcer(bd, astilibav.Context{CodecType: avutil.AVMEDIA_TYPE_SUBTITLE}, JobInputFailover{})
+	assert.Error(t, err)
+}
+
+func TestBuilderCreateGenerators(t *testing.T) {
+	b := newBuilder()
+	bd := newBuildData(Job{}, nil, astiencoder.NewEventHandler(), astiencoder.NewCloser())
+	defer bd.c.Close()
+
+	// Audio and video
+	gs, err := b.createGenerators(bd, JobInput{Generator: &JobInputGenerator{
+		Audio: &JobInputGeneratorAudio{Channels: 2, SampleRate: 48000, Source: JobInputGeneratorSourceSine},
+		Video: &JobInputGeneratorVideo{FrameRate: &astifloat.Rational{Num: 25, Den: 1}, Height: 72, Source: JobInputGeneratorSourceBars, Width: 128},
+	}})
+	assert.NoError(t, err)
+	assert.Len(t, gs, 2)
+	a := gs[avcodec.AVMEDIA_TYPE_AUDIO].OutputCtx()
+	assert.Equal(t, 2, a.Channels)
+	assert.Equal(t, 48000, a.SampleRate)
+	v := gs[avcodec.AVMEDIA_TYPE_VIDEO].OutputCtx()
+	assert.Equal(t, 128, v.Width)
+	assert.Equal(t, 72, v.Height)
+	assert.Equal(t, avutil.AV_PIX_FMT_YUV420P, v.PixelFormat)
+
+	// No generator
+	_, err = b.createGenerators(bd, JobInput{Generator: &JobInputGenerator{}})
+	assert.Error(t, err)
+
+	// Invalid sources
+	_, err = b.createGenerators(bd, JobInput{Generator: &JobInputGenerator{Audio: &JobInputGeneratorAudio{Source: "invalid"}}})
+	assert.Error(t, err)
+	_, err = b.createGenerators(bd, JobInput{Generator: &JobInputGenerator{Video: &JobInputGeneratorVideo{Source: "invalid"}}})
+	assert.Error(t, err)
+}
+
+func TestBuilderCreateFrameDumper(t *testing.T) {
+	b := newBuilder()
+	bd := newBuildData(Job{}, nil, astiencoder.NewEventHandler(), astiencoder.NewCloser())
+	defer bd.c.Close()
+	i := encodingInput{name: "default", streamIdx: 1}
+
+	// Valid
+	d, err := b.createFrameDumper(bd, operationOutput{o: openedOutput{c: JobOutput{
+		FrameDump: &JobOutputFrameDump{Format: "raw", FrameInterval: 2},
+		URL:       "{{.input}}-{{.stream_idx}}-{{.count}}.yuv",
+	}}}, i)
+	assert.NoError(t, err)
+	assert.NotNil(t, d)
+
+	// Invalid format
+	_, err = b.createFrameDumper(bd, operationOutput{o: openedOutput{c: JobOutput{FrameDump: &JobOutputFrameDump{Format: "invalid"}}}}, i)
+	assert.Error(t, err)
+
+	// Invalid pattern
+	_, err = b.createFrameDumper(bd, operationOutput{o: openedOutput{c: JobOutput{URL: "{{"}}}, i)
+	assert.Error(t, err)
+}
+
+func TestBuilderCreateStoryboardGenerator(t *testing.T) {
+	b := newBuilder()
+	bd := newBuildData(Job{}, nil, astiencoder.NewEventHandler(), astiencoder.NewCloser())
+	defer bd.c.Close()
+	i := encodingInput{name: "default", streamIdx: 1}
+
+	// Valid
+	g, err := b.createStoryboardGenerator(bd, operationOutput{o: openedOutput{c: JobOutput{
+		Storyboard: &JobOutputStoryboard{Columns: 2, Format: "png", Interval: 1, Rows: 2, VTT: "storyboard.vtt"},
+		URL:        "storyboard-{{.count}}.png",
+	}}}, i)
+	assert.NoError(t, err)
+	assert.NotNil(t, g)
+
+	// No vtt
+	_, err = b.createStoryboardGenerator(bd, operationOutput{o: openedOutput{c: JobOutput{URL: "storyboard-{{.count}}.jpeg"}}}, i)
+	assert.Error(t, err)
+
+	// Invalid format
+	_, err = b.createStoryboardGenerator(bd, operationOutput{o: openedOutput{c: JobOutput{Storyboard: &JobOutputStoryboard{Format: "invalid", VTT: "storyboard.vtt"}}}}, i)
+	assert.Error(t, err)
+}
+
+type testFrameEmitter struct {
+	*astiencoder.BaseNode
+	hs []astilibav.FrameHandler
+}
+
+func newTestFrameEmitter(eh *astiencoder.EventHandler) (e *testFrameEmitter) {
+	e = &testFrameEmitter{}
+	e.BaseNode = astiencoder.NewBaseNode(astiencoder.NodeOptions{}, astiencoder.NewEventGeneratorNode(e), eh)
+	return
+}
+
+// Start implements the Starter interface
+func (e *testFrameEmitter) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	e.BaseNode.Start(ctx, t, func(t *astiworker.Task) {
+		<-e.Context().Done()
+	})
+}
+
+func (e *testFrameEmitter) Connect(h astilibav.FrameHandler) {
+	e.hs = append(e.hs, h)
+}
+
+func (e *testFrameEmitter) Disconnect(h astilibav.FrameHandler) {}
+
+func TestBuilderAddDetectors(t *testing.T) {
+	b := newBuilder()
+	bd := newBuildData(Job{}, nil, astiencoder.NewEventHandler(), astiencoder.NewCloser())
+	defer bd.c.Close()
+	o := JobOperation{Detection: &JobOperationDetection{
+		Black:   &JobDetectionBlack{MinDuration: 1},
+		Silence: &JobDetectionSilence{MinDuration: 1},
+	}}
+
+	// Video
+	e := newTestFrameEmitter(bd.eh)
+	b.addDetectors(bd, o, e, astilibav.Context{CodecType: avutil.AVMEDIA_TYPE_VIDEO})
+	assert.Len(t, e.hs, 1)
+	assert.IsType(t, &astilibav.BlackDetector{}, e.hs[0])
+
+	// Audio
+	e = newTestFrameEmitter(bd.eh)
+	b.addDetectors(bd, o, e, astilibav.Context{CodecType: avutil.AVMEDIA_TYPE_AUDIO})
+	assert.Len(t, e.hs, 1)
+	assert.IsType(t, &astilibav.SilenceDetector{}, e.hs[0])
+
+	// No detection
+	e = newTestFrameEmitter(bd.eh)
+	b.addDetectors(bd, JobOperation{}, e, astilibav.Context{CodecType: avutil.AVMEDIA_TYPE_VIDEO})
+	assert.Len(t, e.hs, 0)
+}
+
+func TestBuilderLoudnessFilter(t *testing.T) {
+	b := newBuilder()
+	bd := newBuildData(Job{}, nil, astiencoder.NewEventHandler(), astiencoder.NewCloser())
+	defer bd.c.Close()
+	eis := []encodingInput{{name: "file", streamIdx: 1}}
+
+	// No loudness target
+	f, mf, err := b.loudnessFilter(bd, JobOperation{}, eis)
+	assert.NoError(t, err)
+	assert.Equal(t, "", f)
+	assert.Nil(t, mf)
+
+	// Default values don't modify the job
+	bd.j.LoudnessTarget = &JobLoudnessTarget{}
+	f, mf, err = b.loudnessFilter(bd, JobOperation{}, eis)
+	assert.NoError(t, err)
+	assert.Equal(t, "loudnorm=I=-23.00:LRA=7.00:TP=-1.00", f)
+	assert.Nil(t, mf)
+	assert.Equal(t, JobLoudnessTarget{}, *bd.j.LoudnessTarget)
+
+	// Inputs that can't be measured beforehand are normalized dynamically
+	bd.j.LoudnessTarget = &JobLoudnessTarget{Integrated: -16, LRA: 11, TruePeak: -2}
+	bd.inputs = map[string]openedInput{
+		"emulated": {c: JobInput{EmulateRate: true, URL: "file.mp4"}, d: &astilibav.Demuxer{}},
+		"file":     {c: JobInput{URL: "file.mp4"}, d: &astilibav.Demuxer{}},
+		"network":  {c: JobInput{URL: "rtmp://host/app"}, d: &astilibav.Demuxer{}},
+	}
+	for _, v := range []struct {
+		eis []encodingInput
+		o   JobOperation
+	}{
+		{eis: []encodingInput{{name: "emulated"}}},
+		{eis: []encodingInput{{name: "network"}}},
+		{eis: []encodingInput{{name: "file"}, {name: "network"}}},
+		{eis: eis, o: JobOperation{Filters: "volume=2"}},
+	} {
+		f, mf, err = b.loudnessFilter(bd, v.o, v.eis)
+		assert.NoError(t, err)
+		assert.Equal(t, "loudnorm=I=-16.00:LRA=11.00:TP=-2.00", f)
+		assert.Nil(t, mf)
+	}
+
+	// Files are measured only once, when the measured filter is retrieved
+	_, mf, err = b.loudnessFilter(bd, JobOperation{}, eis)
+	assert.NoError(t, err)
+	assert.NotNil(t, mf)
+	_, mf2, err := b.loudnessFilter(bd, JobOperation{}, eis)
+	assert.NoError(t, err)
+	assert.NotNil(t, mf2)
+	assert.Len(t, bd.loudnessMeasurements, 1)
+	m := bd.loudnessMeasurements["file:1"]
+	m.o.Do(func() {
+		m.m = astilibav.LoudnessMeasurement{Integrated: -20, LRA: 5, Threshold: -30, TruePeak: -3}
+	})
+	f, err = mf(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "loudnorm=I=-16.00:LRA=11.00:TP=-2.00:measured_I=-20.00:measured_LRA=5.00:measured_TP=-3.00:measured_thresh=-30.00:linear=true", f)
+	f, err = mf2(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "loudnorm=I=-16.00:LRA=11.00:TP=-2.00:measured_I=-20.00:measured_LRA=5.00:measured_TP=-3.00:measured_thresh=-30.00:linear=true", f)
+}
+
+func TestBuilderAddQualityMeter(t *testing.T) {
+	if avcodec.AvcodecFindEncoderByName("mjpeg") == nil {
+		t.Skip("mjpeg encoder is not available")
+	}
+	b := newBuilder()
+	bd := newBuildData(Job{}, nil, astiencoder.NewEventHandler(), astiencoder.NewCloser())
+	defer bd.c.Close()
+	ctx := astilibav.Context{
+		CodecName:   "mjpeg",
+		CodecType:   avutil.AVMEDIA_TYPE_VIDEO,
+		Height:      16,
+		PixelFormat: avutil.AV_PIX_FMT_YUVJ420P,
+		TimeBase:    avutil.NewRational(1, 25),
+		Width:       16,
+	}
+	enc, err := astilibav.NewEncoder(astilibav.EncoderOptions{Ctx: ctx}, bd.eh, bd.c)
+	assert.NoError(t, err)
+
+	// No quality measurement
+	e := newTestFrameEmitter(bd.eh)
+	assert.NoError(t, b.addQualityMeter(bd, JobOperation{}, e, enc, ctx))
+	assert.Len(t, e.hs, 0)
+	assert.NoError(t, b.addQualityMeter(bd, JobOperation{Quality: &JobOperationQuality{}}, e, enc, astilibav.Context{CodecType: avutil.AVMEDIA_TYPE_AUDIO}))
+	assert.Len(t, e.hs, 0)
+
+	// Quality meter and vmaf filterer
+	assert.NoError(t, b.addQualityMeter(bd, JobOperation{Quality: &JobOperationQuality{VMAFLog: "vmaf.json"}}, e, enc, ctx))
+	if astilibav.VMAFAvailable() {
+		assert.Len(t, e.hs, 2)
+		assert.IsType(t, &astilibav.Filterer{}, e.hs[1])
+	} else {
+		assert.Len(t, e.hs, 1)
+	}
+	assert.IsType(t, &astilibav.QualityMeter{}, e.hs[0])
+}
+
+func TestBuilderOperationOutputCtx(t *testing.T) {
+	if avcodec.AvcodecFindEncoderByName("mjpeg") == nil {
+		t.Skip("mjpeg encoder is not available")
+	}
+	b := newBuilder()
+	bd := newBuildData(Job{}, nil, astiencoder.NewEventHandler(), astiencoder.NewCloser())
+	defer bd.c.Close()
+	inCtx := astilibav.Context{
+		CodecType:         avutil.AVMEDIA_TYPE_VIDEO,
+		ColorRange:        avutil.AVCOL_RANGE_MPEG,
+		FrameRate:         avutil.NewRational(25, 1),
+		Height:            16,
+		PixelFormat:       avutil.AV_PIX_FMT_YUV420P,
+		SampleAspectRatio: avutil.NewRational(1, 1),
+		TimeBase:          avutil.NewRational(1, 25),
+		Width:             16,
+	}
+	oos := []operationOutput{{}}
+
+	// Negotiated yuvj pixel formats use the full range
+	outCtx, err := b.operationOutputCtx(bd, JobOperation{Codec: "mjpeg"}, inCtx, oos)
+	assert.NoError(t, err)
+	assert.True(t, astilibav.IsFullRangePixelFormat(outCtx.PixelFormat))
+	assert.Equal(t, avutil.AVCOL_RANGE_JPEG, outCtx.ColorRange)
+
+	// Other pixel formats keep the input range
+	outCtx, err = b.operationOutputCtx(bd, JobOperation{Codec: "mjpeg", PixelFormat: "yuv420p"}, inCtx, oos)
+	assert.NoError(t, err)
+	assert.Equal(t, avutil.AVCOL_RANGE_MPEG, outCtx.ColorRange)
+}
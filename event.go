@@ -12,6 +12,7 @@ import (
 var (
 	EventNameError             = "astiencoder.error"
 	EventNameNodeContinued     = "astiencoder.node.continued"
+	EventNameNodeDataChanged   = "astiencoder.node.data.changed"
 	EventNameNodePaused        = "astiencoder.node.paused"
 	EventNameNodeStarted       = "astiencoder.node.started"
 	EventNameNodeStats         = "astiencoder.node.stats"
@@ -72,6 +73,17 @@ func NewEventHandler() *EventHandler {
 
 // Add adds a new callback for a specific target and event name
 func (h *EventHandler) Add(target interface{}, eventName string, c EventCallback) {
+	h.add(target, eventName, c)
+}
+
+// AddRemovable adds a new callback for a specific target and event name and returns a function removing it
+// It is useful when the callback needs to be removed before it's called
+func (h *EventHandler) AddRemovable(target interface{}, eventName string, c EventCallback) (remove func()) {
+	idx := h.add(target, eventName, c)
+	return func() { h.del(target, eventName, idx) }
+}
+
+func (h *EventHandler) add(target interface{}, eventName string, c EventCallback) int {
 	h.m.Lock()
 	defer h.m.Unlock()
 	if _, ok := h.cs[target]; !ok {
@@ -82,6 +94,7 @@ func (h *EventHandler) Add(target interface{}, eventName string, c EventCallback
 	}
 	h.idx++
 	h.cs[target][eventName][h.idx] = c
+	return h.idx
 }
 
 // AddForEventName adds a new callback for a specific event name
@@ -48,3 +48,17 @@ func TestEvent(t *testing.T) {
 	})
 	assert.Equal(t, []string{"2", "4", "5"}, es)
 }
+
+func TestEventHandlerAddRemovable(t *testing.T) {
+	eh := NewEventHandler()
+	var count int
+	remove := eh.AddRemovable("test", "test", func(evt Event) bool {
+		count++
+		return false
+	})
+	eh.Emit(Event{Name: "test", Target: "test"})
+	assert.Equal(t, 1, count)
+	remove()
+	eh.Emit(Event{Name: "test", Target: "test"})
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,211 @@
+package astilibav
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astitools/stat"
+	"github.com/asticode/go-astitools/sync"
+	"github.com/asticode/go-astitools/worker"
+	"github.com/asticode/goav/avutil"
+)
+
+var countBlackDetector uint64
+
+// BlackDetector represents an object capable of detecting black video intervals
+type BlackDetector struct {
+	*astiencoder.BaseNode
+	eh               *astiencoder.EventHandler
+	i                *intervalDetector
+	o                BlackDetectorOptions
+	previousPts      *int64
+	q                *astisync.CtxQueue
+	statBlackRatio   *valueStat
+	statIncomingRate *astistat.IncrementStat
+	statWorkRatio    *astistat.DurationRatioStat
+}
+
+// BlackDetectorOptions represents black detector options
+type BlackDetectorOptions struct {
+	// Min duration of a black interval. Defaults to 2s
+	MinDuration time.Duration
+	Node        astiencoder.NodeOptions
+	// Ratio of black pixels (between 0 and 1) above which a frame is considered black. Defaults to 0.98
+	PictureThreshold float64
+	// Luma (between 0 and 1 of the luma range) under which a pixel is considered black. Defaults to 0.1
+	PixelThreshold float64
+}
+
+// BlackDetectorExposedData represents black detector exposed data
+type BlackDetectorExposedData struct {
+	Intervals []DetectionInterval `json:"intervals"`
+}
+
+// NewBlackDetector creates a new black detector
+func NewBlackDetector(o BlackDetectorOptions, eh *astiencoder.EventHandler) (d *BlackDetector) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countBlackDetector, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("black_detector_%d", count), fmt.Sprintf("Black Detector #%d", count), "Detects black frames")
+
+	// Default options
+	if o.MinDuration <= 0 {
+		o.MinDuration = 2 * time.Second
+	}
+	if o.PictureThreshold <= 0 {
+		o.PictureThreshold = 0.98
+	}
+	if o.PixelThreshold <= 0 {
+		o.PixelThreshold = 0.1
+	}
+
+	// Create detector
+	d = &BlackDetector{
+		eh:               eh,
+		o:                o,
+		q:                astisync.NewCtxQueue(),
+		statBlackRatio:   newValueStat(),
+		statIncomingRate: astistat.NewIncrementStat(),
+		statWorkRatio:    astistat.NewDurationRatioStat(),
+	}
+	d.BaseNode = astiencoder.NewBaseNode(o.Node, astiencoder.NewEventGeneratorNode(d), eh)
+	d.i = newIntervalDetector(o.MinDuration, EventNameBlackStarted, EventNameBlackEnded, d, eh)
+	d.addStats()
+	return
+}
+
+func (d *BlackDetector) addStats() {
+	// Add incoming rate
+	d.Stater().AddStat(astistat.StatMetadata{
+		Description: "Number of frames coming in per second",
+		Label:       "Incoming rate",
+		Unit:        "fps",
+	}, d.statIncomingRate)
+
+	// Add black ratio
+	d.Stater().AddStat(astistat.StatMetadata{
+		Description: "Percentage of black pixels in the last frame",
+		Label:       "Black ratio",
+		Unit:        "%",
+	}, d.statBlackRatio)
+
+	// Add work ratio
+	d.Stater().AddStat(astistat.StatMetadata{
+		Description: "Percentage of time spent doing some actual work",
+		Label:       "Work ratio",
+		Unit:        "%",
+	}, d.statWorkRatio)
+
+	// Add queue stats
+	d.q.AddStats(d.Stater())
+}
+
+// ExposedData implements the astiencoder.NodeExposer interface
+func (d *BlackDetector) ExposedData() interface{} {
+	return d.Report()
+}
+
+// Report returns the black intervals detected so far
+func (d *BlackDetector) Report() BlackDetectorExposedData {
+	return BlackDetectorExposedData{Intervals: d.i.report()}
+}
+
+// Start starts the black detector
+func (d *BlackDetector) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	d.BaseNode.Start(ctx, t, func(t *astiworker.Task) {
+		// Handle context
+		go d.q.HandleCtx(d.Context())
+
+		// Make sure to stop the queue properly
+		defer d.q.Stop()
+
+		// Start queue
+		d.q.Start(func(dp interface{}) {
+			// Handle pause
+			defer d.HandlePause()
+
+			// Assert payload
+			p := dp.(*FrameHandlerPayload)
+
+			// Increment incoming rate
+			d.statIncomingRate.Add(1)
+
+			// Detect
+			d.statWorkRatio.Add(true)
+			d.detect(p)
+			d.statWorkRatio.Done(true)
+		})
+
+		// Close ongoing interval
+		d.i.close()
+	})
+}
+
+func (d *BlackDetector) detect(p *FrameHandlerPayload) {
+	// Get black ratio
+	r, ok := d.blackRatio(p.Frame)
+	if ok {
+		d.statBlackRatio.set(r * 100)
+	}
+
+	// Get frame times
+	// The end of a frame is estimated with the previous frame duration
+	start := detectionTime{pts: p.Frame.Pts()}
+	end := start
+	if d.previousPts != nil && start.pts > *d.previousPts {
+		end.pts += start.pts - *d.previousPts
+	}
+	start.t = time.Duration(avutil.AvRescaleQ(start.pts, p.Descriptor.TimeBase(), nanosecondRational))
+	end.t = time.Duration(avutil.AvRescaleQ(end.pts, p.Descriptor.TimeBase(), nanosecondRational))
+	pts := p.Frame.Pts()
+	d.previousPts = &pts
+
+	// Handle decision
+	d.i.handle(ok && r >= d.o.PictureThreshold, start, end)
+}
+
+// blackRatio returns the ratio of black pixels in the luma plane
+func (d *BlackDetector) blackRatio(f *avutil.Frame) (r float64, ok bool) {
+	// Only planar yuv and gray pixel formats have a luma plane first
+	pf := avutil.PixelFormat(f.Format())
+	if !isLumaFirstPixelFormat(pf) || f.Width() <= 0 || f.Height() <= 0 {
+		return
+	}
+
+	// Get plane
+	data := avutil.Data(f)
+	linesize := int(avutil.Linesize(f)[0])
+	if data[0] == nil || linesize <= 0 {
+		return
+	}
+	plane := (*[1 << 30]uint8)(unsafe.Pointer(data[0]))[: linesize*f.Height() : linesize*f.Height()]
+
+	// Get threshold
+	// Only full range frames use the whole luma range
+	min, max := 16.0, 235.0
+	if frameFullRange(f) {
+		min, max = 0, 255
+	}
+	th := uint8(min + d.o.PixelThreshold*(max-min))
+
+	// Loop through pixels
+	// Pixels are sampled with a stride to keep things cheap
+	var black, total int
+	for y := 0; y < f.Height(); y += 2 {
+		for x := 0; x < f.Width(); x += 2 {
+			if plane[y*linesize+x] <= th {
+				black++
+			}
+			total++
+		}
+	}
+	return float64(black) / float64(total), true
+}
+
+// HandleFrame implements the FrameHandler interface
+func (d *BlackDetector) HandleFrame(p *FrameHandlerPayload) {
+	d.q.Send(p)
+}
@@ -0,0 +1,56 @@
+package astilibav
+
+import (
+	"testing"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLumaFrame(t *testing.T, f *avutil.Frame, pf avutil.PixelFormat, cr avutil.ColorRange, y uint8) {
+	avutil.AvFrameUnref(f)
+	f.SetColorRange(cr)
+	f.SetFormat(int(pf))
+	f.SetHeight(4)
+	f.SetWidth(4)
+	if ret := avutil.AvFrameGetBuffer(f, 0); ret < 0 {
+		t.Fatal(NewAvError(ret))
+	}
+	b, linesize := framePlane(f, 0, 4)
+	for idx := range b[:3*linesize+4] {
+		b[idx] = y
+	}
+}
+
+func TestBlackDetectorBlackRatio(t *testing.T) {
+	d := NewBlackDetector(BlackDetectorOptions{}, astiencoder.NewEventHandler())
+	f := avutil.AvFrameAlloc()
+	defer avutil.AvFrameFree(f)
+
+	// Limited range threshold is 16 + 0.1 * 219 whereas full range threshold is 0.1 * 255
+	for _, v := range []struct {
+		cr       avutil.ColorRange
+		expected float64
+		pf       avutil.PixelFormat
+	}{
+		{cr: avutil.AVCOL_RANGE_UNSPECIFIED, expected: 1, pf: avutil.AV_PIX_FMT_YUV420P},
+		{cr: avutil.AVCOL_RANGE_MPEG, expected: 1, pf: avutil.AV_PIX_FMT_NV12},
+		{cr: avutil.AVCOL_RANGE_JPEG, expected: 0, pf: avutil.AV_PIX_FMT_YUV420P},
+		{cr: avutil.AVCOL_RANGE_UNSPECIFIED, expected: 0, pf: avutil.AV_PIX_FMT_YUVJ420P},
+		{cr: avutil.AVCOL_RANGE_UNSPECIFIED, expected: 0, pf: avutil.AV_PIX_FMT_GRAY8},
+	} {
+		newTestLumaFrame(t, f, v.pf, v.cr, 30)
+		r, ok := d.blackRatio(f)
+		assert.True(t, ok)
+		assert.Equal(t, v.expected, r, "%s", avutil.AvGetPixFmtName(int(v.pf)))
+	}
+
+	// Pixel format without luma plane
+	avutil.AvFrameUnref(f)
+	f.SetFormat(int(avutil.AV_PIX_FMT_RGBA))
+	f.SetHeight(4)
+	f.SetWidth(4)
+	_, ok := d.blackRatio(f)
+	assert.False(t, ok)
+}
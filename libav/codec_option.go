@@ -0,0 +1,67 @@
+package astilibav
+
+//#cgo pkg-config: libavcodec libavutil
+//#include <libavcodec/avcodec.h>
+//#include <libavutil/opt.h>
+//#include <stdlib.h>
+//static int astilibav_codec_has_option(const AVCodec *c, const char *name) {
+//	const AVClass *cc = avcodec_get_class();
+//	if (av_opt_find(&cc, name, NULL, 0, AV_OPT_SEARCH_FAKE_OBJ)) {
+//		return 1;
+//	}
+//	if (c->priv_class) {
+//		const AVClass *pc = c->priv_class;
+//		if (av_opt_find(&pc, name, NULL, 0, AV_OPT_SEARCH_FAKE_OBJ)) {
+//			return 1;
+//		}
+//	}
+//	return 0;
+//}
+import "C"
+import (
+	"strings"
+	"unsafe"
+
+	"github.com/asticode/goav/avcodec"
+)
+
+// codecHasOption checks whether an option is either a generic codec option or one of the codec's private options
+// goav doesn't expose av_opt_find, we therefore look it up in C
+func codecHasOption(c *avcodec.Codec, name string) bool {
+	cn := C.CString(name)
+	defer C.free(unsafe.Pointer(cn))
+	return C.astilibav_codec_has_option((*C.AVCodec)(unsafe.Pointer(c)), cn) == 1
+}
+
+// dictKeys returns the keys of a dict formatted as libav parses it ("k1=v1,k2=v2")
+// Separators escaped with a backslash are not taken into account
+func dictKeys(dict string) (ks []string) {
+	var escaped bool
+	var key strings.Builder
+	var inValue bool
+	for _, r := range dict {
+		switch {
+		case escaped:
+			escaped = false
+			if !inValue {
+				key.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+		case r == ',':
+			if k := strings.TrimSpace(key.String()); len(k) > 0 {
+				ks = append(ks, k)
+			}
+			key.Reset()
+			inValue = false
+		case r == '=' && !inValue:
+			inValue = true
+		case !inValue:
+			key.WriteRune(r)
+		}
+	}
+	if k := strings.TrimSpace(key.String()); len(k) > 0 {
+		ks = append(ks, k)
+	}
+	return
+}
@@ -0,0 +1,36 @@
+package astilibav
+
+import (
+	"testing"
+
+	"github.com/asticode/goav/avcodec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDictKeys(t *testing.T) {
+	assert.Equal(t, []string(nil), dictKeys(""))
+	assert.Equal(t, []string{"preset", "tune"}, dictKeys("preset=fast,tune=film"))
+	assert.Equal(t, []string{"x264-params", "g"}, dictKeys("x264-params=keyint=50:min-keyint=50,g=50"))
+	assert.Equal(t, []string{"a", "c"}, dictKeys("a=1\\,b=2,c=3"))
+	assert.Equal(t, []string{"a"}, dictKeys(" a = 1 ,"))
+}
+
+func TestCodecHasOption(t *testing.T) {
+	c := avcodec.AvcodecFindEncoderByName("mpeg4")
+	if c == nil {
+		t.Skip("mpeg4 encoder is not available")
+	}
+	assert.True(t, codecHasOption(c, "b"))
+	assert.True(t, codecHasOption(c, "mpeg_quant"))
+	assert.False(t, codecHasOption(c, "invalid"))
+}
+
+func TestContextUnknownOptions(t *testing.T) {
+	c := avcodec.AvcodecFindEncoderByName("mpeg4")
+	if c == nil {
+		t.Skip("mpeg4 encoder is not available")
+	}
+	crf := 23.0
+	assert.Equal(t, []string{"invalid", "crf"}, Context{CRF: &crf, Dict: "b=1000,invalid=1"}.unknownOptions(c))
+	assert.Equal(t, []string(nil), Context{Dict: "g=12"}.unknownOptions(c))
+}
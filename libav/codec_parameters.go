@@ -0,0 +1,110 @@
+package astilibav
+
+//#cgo pkg-config: libavcodec libavutil
+//#include <libavcodec/avcodec.h>
+//#include <libavutil/mem.h>
+//#include <string.h>
+//static int astilibav_set_codec_parameters_extradata(AVCodecParameters *p, const uint8_t *data, int size) {
+//	av_freep(&p->extradata);
+//	p->extradata_size = 0;
+//	if (size <= 0) {
+//		return 0;
+//	}
+//	p->extradata = av_mallocz(size + AV_INPUT_BUFFER_PADDING_SIZE);
+//	if (!p->extradata) {
+//		return AVERROR(ENOMEM);
+//	}
+//	memcpy(p->extradata, data, size);
+//	p->extradata_size = size;
+//	return 0;
+//}
+import "C"
+import (
+	"unsafe"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/goav/avcodec"
+	"github.com/pkg/errors"
+)
+
+// allocCodecParameters allocates codec parameters that are freed by the closer
+// goav doesn't expose avcodec_parameters_alloc, we therefore call it in C
+func allocCodecParameters(c *astiencoder.Closer) (cp *avcodec.CodecParameters, err error) {
+	// Alloc
+	p := C.avcodec_parameters_alloc()
+	if p == nil {
+		err = errors.New("astilibav: no codec parameters allocated")
+		return
+	}
+
+	// Make sure the codec parameters are freed
+	c.Add(func() error {
+		C.avcodec_parameters_free(&p)
+		return nil
+	})
+
+	// Convert
+	cp = (*avcodec.CodecParameters)(unsafe.Pointer(p))
+	return
+}
+
+// newCodecParameters creates codec parameters out of a context
+// Parameters are set on a codec context through goav setters and copied to the codec parameters afterwards
+func newCodecParameters(ctx Context, extraData []byte, c *astiencoder.Closer) (cp *avcodec.CodecParameters, err error) {
+	// Alloc codec parameters
+	if cp, err = allocCodecParameters(c); err != nil {
+		err = errors.Wrap(err, "astilibav: allocating codec parameters failed")
+		return
+	}
+
+	// Alloc codec context
+	// It's not bound to any codec since it's only used to hold parameters
+	var ctxCodec *avcodec.Context
+	if ctxCodec = (*avcodec.Codec)(nil).AvcodecAllocContext3(); ctxCodec == nil {
+		err = errors.New("astilibav: no context allocated")
+		return
+	}
+
+	// Make sure the codec context is freed
+	defer func() {
+		p := (*C.struct_AVCodecContext)(unsafe.Pointer(ctxCodec))
+		C.avcodec_free_context(&p)
+	}()
+
+	// Set shared parameters
+	ctxCodec.SetCodecType(ctx.CodecType)
+	ctxCodec.SetCodecId(ctx.CodecID)
+	ctxCodec.SetBitRate(int64(ctx.BitRate))
+
+	// Set media type-specific parameters
+	switch ctx.CodecType {
+	case avcodec.AVMEDIA_TYPE_AUDIO:
+		ctxCodec.SetChannelLayout(ctx.ChannelLayout)
+		ctxCodec.SetChannels(ctx.Channels)
+		ctxCodec.SetSampleFmt(ctx.SampleFmt)
+		ctxCodec.SetSampleRate(ctx.SampleRate)
+	case avcodec.AVMEDIA_TYPE_VIDEO:
+		ctxCodec.SetHeight(ctx.Height)
+		ctxCodec.SetPixFmt(ctx.PixelFormat)
+		if ctx.SampleAspectRatio.Den() > 0 {
+			ctxCodec.SetSampleAspectRatio(ctx.SampleAspectRatio)
+		}
+		ctxCodec.SetWidth(ctx.Width)
+	}
+
+	// Copy parameters
+	if ret := avcodec.AvcodecParametersFromContext(cp, ctxCodec); ret < 0 {
+		err = errors.Wrap(NewAvError(ret), "astilibav: avcodec.AvcodecParametersFromContext failed")
+		return
+	}
+
+	// Set extra data
+	// Neither libavcodec nor goav expose an extra data setter, we therefore set it in C
+	if len(extraData) > 0 {
+		if ret := C.astilibav_set_codec_parameters_extradata((*C.struct_AVCodecParameters)(unsafe.Pointer(cp)), (*C.uint8_t)(unsafe.Pointer(&extraData[0])), C.int(len(extraData))); ret < 0 {
+			err = errors.Wrap(NewAvError(int(ret)), "astilibav: setting extra data failed")
+			return
+		}
+	}
+	return
+}
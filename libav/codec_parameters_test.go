@@ -0,0 +1,54 @@
+package astilibav
+
+import (
+	"testing"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCodecParameters(t *testing.T) {
+	c := astiencoder.NewCloser()
+	defer c.Close()
+
+	// Video
+	cp, err := newCodecParameters(Context{
+		BitRate:           1000,
+		CodecID:           avcodec.CodecId(avcodec.AV_CODEC_ID_H264),
+		CodecType:         avcodec.AVMEDIA_TYPE_VIDEO,
+		Height:            720,
+		PixelFormat:       avutil.AV_PIX_FMT_YUV420P,
+		SampleAspectRatio: avutil.NewRational(1, 1),
+		Width:             1280,
+	}, []byte{1, 2, 3}, c)
+	assert.NoError(t, err)
+	assert.Equal(t, avcodec.CodecId(avcodec.AV_CODEC_ID_H264), cp.CodecId())
+	assert.Equal(t, avcodec.MediaType(avcodec.AVMEDIA_TYPE_VIDEO), cp.CodecType())
+	cdc := avcodec.AvcodecFindDecoder(cp.CodecId())
+	ctxCodec := cdc.AvcodecAllocContext3()
+	assert.True(t, avcodec.AvcodecParametersToContext(ctxCodec, cp) >= 0)
+	assert.Equal(t, 1280, ctxCodec.Width())
+	assert.Equal(t, 720, ctxCodec.Height())
+	assert.Equal(t, avutil.AV_PIX_FMT_YUV420P, ctxCodec.PixFmt())
+	assert.Equal(t, 1, ctxCodec.SampleAspectRatio().Num())
+
+	// Audio
+	cp, err = newCodecParameters(Context{
+		ChannelLayout: stereoChannelLayout,
+		Channels:      2,
+		CodecID:       avcodec.CodecId(avcodec.AV_CODEC_ID_AAC),
+		CodecType:     avcodec.AVMEDIA_TYPE_AUDIO,
+		SampleFmt:     avcodec.AvSampleFormat(avutil.AvGetSampleFmt("fltp")),
+		SampleRate:    48000,
+	}, nil, c)
+	assert.NoError(t, err)
+	assert.Equal(t, avcodec.CodecId(avcodec.AV_CODEC_ID_AAC), cp.CodecId())
+	cdc = avcodec.AvcodecFindDecoder(cp.CodecId())
+	ctxCodec = cdc.AvcodecAllocContext3()
+	assert.True(t, avcodec.AvcodecParametersToContext(ctxCodec, cp) >= 0)
+	assert.Equal(t, stereoChannelLayout, ctxCodec.ChannelLayout())
+	assert.Equal(t, 2, ctxCodec.Channels())
+	assert.Equal(t, 48000, ctxCodec.SampleRate())
+}
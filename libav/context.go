@@ -2,24 +2,35 @@ package astilibav
 
 import (
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"unsafe"
 
 	"github.com/asticode/goav/avcodec"
 	"github.com/asticode/goav/avformat"
 	"github.com/asticode/goav/avutil"
+	"github.com/pkg/errors"
 )
 
 // Context represents parameters of an audio or a video context
 type Context struct {
 	// Shared
 	BitRate      int
+	BufferSize   int
 	CodecID      avcodec.CodecId
 	CodecName    string
 	CodecType    avcodec.MediaType
 	Dict         string
 	GlobalHeader bool
-	ThreadCount  *int
-	TimeBase     avutil.Rational
+	// Global quality using the codec's own scale (qscale)
+	GlobalQuality *int
+	MaxRate       int
+	MinRate       int
+	Profile       string
+	ThreadCount   *int
+	TimeBase      avutil.Rational
 
 	// Audio
 	ChannelLayout uint64
@@ -28,10 +39,28 @@ type Context struct {
 	SampleRate    int
 
 	// Video
-	FrameRate         avutil.Rational
-	GopSize           int
-	Height            int
-	PixelFormat       avutil.PixelFormat
+	ChromaLocation              avutil.ChromaLocation
+	ClosedGOP                   bool
+	ColorPrimaries              avutil.ColorPrimaries
+	ColorRange                  avutil.ColorRange
+	ColorSpace                  avutil.ColorSpace
+	ColorTransferCharacteristic avutil.ColorTransferCharacteristic
+	// Raw AV_PKT_DATA_CONTENT_LIGHT_LEVEL side data
+	ContentLightLevel []byte
+	// Constant rate factor, only available for codecs exposing a "crf" private option
+	CRF        *float64
+	FieldOrder avcodec.AvFieldOrder
+	FrameRate  avutil.Rational
+	GopSize    int
+	Height     int
+	Level      int
+	// Raw AV_PKT_DATA_MASTERING_DISPLAY_METADATA side data
+	MasteringDisplay []byte
+	MaxBFrames       *int
+	PixelFormat      avutil.PixelFormat
+	Refs             *int
+	// Clockwise rotation in degrees (0, 90, 180 or 270) frames need for them to be displayed properly
+	Rotation          int
 	SampleAspectRatio avutil.Rational
 	Width             int
 }
@@ -53,15 +82,65 @@ func NewContextFromStream(s *avformat.Stream) Context {
 		SampleRate:    ctxCodec.SampleRate(),
 
 		// Video
-		FrameRate:         streamFrameRate(s),
-		GopSize:           ctxCodec.GopSize(),
-		Height:            ctxCodec.Height(),
-		PixelFormat:       ctxCodec.PixFmt(),
-		SampleAspectRatio: s.SampleAspectRatio(),
-		Width:             ctxCodec.Width(),
+		ChromaLocation:              ctxCodec.ChromaSampleLocation(),
+		ColorPrimaries:              ctxCodec.ColorPrimaries(),
+		ColorRange:                  ctxCodec.ColorRange(),
+		ColorSpace:                  ctxCodec.Colorspace(),
+		ColorTransferCharacteristic: ctxCodec.ColorTrc(),
+		ContentLightLevel:           streamSideData(s, avcodec.AV_PKT_DATA_CONTENT_LIGHT_LEVEL),
+		FieldOrder:                  ctxCodec.FieldOrder(),
+		FrameRate:                   streamFrameRate(s),
+		GopSize:                     ctxCodec.GopSize(),
+		Height:                      ctxCodec.Height(),
+		MasteringDisplay:            streamSideData(s, avcodec.AV_PKT_DATA_MASTERING_DISPLAY_METADATA),
+		PixelFormat:                 ctxCodec.PixFmt(),
+		Rotation:                    streamRotation(s),
+		SampleAspectRatio:           s.SampleAspectRatio(),
+		Width:                       ctxCodec.Width(),
 	}
 }
 
+// newContextFromFrame creates a new context from a frame
+// Only attributes that can be retrieved from the frame are set
+func newContextFromFrame(f *avutil.Frame, t avcodec.MediaType) (ctx Context) {
+	ctx.CodecType = t
+	switch t {
+	case avcodec.AVMEDIA_TYPE_AUDIO:
+		ctx.ChannelLayout = f.ChannelLayout()
+		ctx.Channels = f.Channels()
+		ctx.SampleFmt = avcodec.AvSampleFormat(f.Format())
+		ctx.SampleRate = f.SampleRate()
+	case avcodec.AVMEDIA_TYPE_VIDEO:
+		ctx.Height = f.Height()
+		ctx.PixelFormat = avutil.PixelFormat(f.Format())
+		ctx.SampleAspectRatio = f.SampleAspectRatio()
+		ctx.Width = f.Width()
+	}
+	return
+}
+
+// sameFrameFormat checks whether attributes retrieved from frames are the same
+func (ctx Context) sameFrameFormat(c Context) bool {
+	switch ctx.CodecType {
+	case avcodec.AVMEDIA_TYPE_AUDIO:
+		return ctx.ChannelLayout == c.ChannelLayout && ctx.Channels == c.Channels && ctx.SampleFmt == c.SampleFmt && ctx.SampleRate == c.SampleRate
+	case avcodec.AVMEDIA_TYPE_VIDEO:
+		return ctx.Height == c.Height && ctx.PixelFormat == c.PixelFormat && ctx.Width == c.Width
+	}
+	return true
+}
+
+func (ctx Context) streamSideData() (sd map[avcodec.AvPacketSideDataType][]byte) {
+	sd = make(map[avcodec.AvPacketSideDataType][]byte)
+	if len(ctx.ContentLightLevel) > 0 {
+		sd[avcodec.AV_PKT_DATA_CONTENT_LIGHT_LEVEL] = ctx.ContentLightLevel
+	}
+	if len(ctx.MasteringDisplay) > 0 {
+		sd[avcodec.AV_PKT_DATA_MASTERING_DISPLAY_METADATA] = ctx.MasteringDisplay
+	}
+	return
+}
+
 func streamFrameRate(s *avformat.Stream) avutil.Rational {
 	if v := s.AvgFrameRate(); v.Num() > 0 {
 		return s.AvgFrameRate()
@@ -70,6 +149,40 @@ func streamFrameRate(s *avformat.Stream) avutil.Rational {
 }
 
 func (ctx Context) validWithCodec(c *avcodec.Codec) (err error) {
+	// Check rates
+	if ctx.MinRate < 0 || ctx.MaxRate < 0 || ctx.BufferSize < 0 {
+		err = fmt.Errorf("astilibav: min rate %d, max rate %d and buffer size %d must be positive", ctx.MinRate, ctx.MaxRate, ctx.BufferSize)
+		return
+	} else if ctx.MaxRate > 0 && ctx.MinRate > ctx.MaxRate {
+		err = fmt.Errorf("astilibav: min rate %d is greater than max rate %d", ctx.MinRate, ctx.MaxRate)
+		return
+	} else if ctx.MaxRate > 0 && ctx.BufferSize == 0 {
+		err = fmt.Errorf("astilibav: max rate %d requires a buffer size", ctx.MaxRate)
+		return
+	}
+
+	// Check profile
+	if len(ctx.Profile) > 0 && len(c.Profiles()) > 0 {
+		if _, ok := ctx.codecProfile(c); !ok {
+			err = fmt.Errorf("astilibav: profile %s is not valid with chosen codec", ctx.Profile)
+			return
+		}
+	}
+
+	// Check options
+	if ks := ctx.unknownOptions(c); len(ks) > 0 {
+		err = fmt.Errorf("astilibav: options %s are not valid with chosen codec", strings.Join(ks, ", "))
+		return
+	}
+
+	// Check video-only attributes
+	if ctx.CodecType != avutil.AVMEDIA_TYPE_VIDEO {
+		if ctx.ClosedGOP || ctx.CRF != nil || ctx.Level > 0 || ctx.MaxBFrames != nil || ctx.Refs != nil {
+			err = errors.New("astilibav: closed gop, crf, level, max b frames and refs are only valid with video codecs")
+			return
+		}
+	}
+
 	switch ctx.CodecType {
 	case avutil.AVMEDIA_TYPE_AUDIO:
 		// Check channel layout
@@ -116,6 +229,168 @@ func (ctx Context) validWithCodec(c *avcodec.Codec) (err error) {
 				return
 			}
 		}
+	case avutil.AVMEDIA_TYPE_VIDEO:
+		// Check pixel format
+		if len(c.PixFmts()) > 0 {
+			var correct bool
+			for _, v := range c.PixFmts() {
+				if v == ctx.PixelFormat {
+					correct = true
+					break
+				}
+			}
+			if !correct {
+				err = fmt.Errorf("astilibav: pixel format %v is not valid with chosen codec", ctx.PixelFormat)
+				return
+			}
+		}
+
+		// Check frame rate
+		if len(c.SupportedFramerates()) > 0 && ctx.FrameRate.Num() > 0 {
+			var correct bool
+			for _, v := range c.SupportedFramerates() {
+				if avutil.AvCmpQ(v, ctx.FrameRate) == 0 {
+					correct = true
+					break
+				}
+			}
+			if !correct {
+				err = fmt.Errorf("astilibav: frame rate %d/%d is not valid with chosen codec", ctx.FrameRate.Num(), ctx.FrameRate.Den())
+				return
+			}
+		}
+
+		// Check max b frames
+		if ctx.MaxBFrames != nil && *ctx.MaxBFrames < 0 {
+			err = fmt.Errorf("astilibav: max b frames %d is not valid", *ctx.MaxBFrames)
+			return
+		}
+
+		// Check refs
+		if ctx.Refs != nil && *ctx.Refs <= 0 {
+			err = fmt.Errorf("astilibav: refs %d is not valid", *ctx.Refs)
+			return
+		}
+	}
+	return
+}
+
+// NegotiateWithEncoder returns a copy of the context where attributes that are not supported by the encoder have
+// been replaced with the nearest supported values
+// At this point only the pixel format and the frame rate are negotiated
+func (ctx Context) NegotiateWithEncoder() (o Context, err error) {
+	// Find encoder
+	var c *avcodec.Codec
+	if c, err = findEncoder(ctx); err != nil {
+		err = errors.Wrap(err, "astilibav: finding encoder failed")
+		return
+	}
+
+	// Copy context
+	o = ctx
+
+	// Switch on codec type
+	switch ctx.CodecType {
+	case avutil.AVMEDIA_TYPE_VIDEO:
+		// Negotiate pixel format
+		if fs := c.PixFmts(); len(fs) > 0 {
+			var found bool
+			for _, v := range fs {
+				if v == ctx.PixelFormat {
+					found = true
+					break
+				}
+			}
+			if !found {
+				o.PixelFormat = avcodec.AvcodecFindBestPixFmtOfList(fs, ctx.PixelFormat, 0, nil)
+			}
+		}
+
+		// Negotiate frame rate
+		if rs := c.SupportedFramerates(); len(rs) > 0 && ctx.FrameRate.Num() > 0 {
+			o.FrameRate = nearestRational(ctx.FrameRate, rs)
+		}
+	}
+	return
+}
+
+// IsFullRangePixelFormat checks whether the pixel format is a yuvj pixel format, whose pixels always use the full range
+func IsFullRangePixelFormat(p avutil.PixelFormat) bool {
+	switch p {
+	case avutil.AV_PIX_FMT_YUVJ420P, avutil.AV_PIX_FMT_YUVJ422P, avutil.AV_PIX_FMT_YUVJ440P, avutil.AV_PIX_FMT_YUVJ444P:
+		return true
+	}
+	return false
+}
+
+func nearestRational(r avutil.Rational, rs []avutil.Rational) (n avutil.Rational) {
+	var delta float64
+	for idx, v := range rs {
+		if d := math.Abs(v.ToDouble() - r.ToDouble()); idx == 0 || d < delta {
+			delta = d
+			n = v
+		}
+	}
+	return
+}
+
+func findEncoder(ctx Context) (c *avcodec.Codec, err error) {
+	if len(ctx.CodecName) > 0 {
+		if c = avcodec.AvcodecFindEncoderByName(ctx.CodecName); c == nil {
+			err = fmt.Errorf("astilibav: no encoder with name %s", ctx.CodecName)
+			return
+		}
+	} else if ctx.CodecID > 0 {
+		if c = avcodec.AvcodecFindEncoder(ctx.CodecID); c == nil {
+			err = fmt.Errorf("astilibav: no encoder with id %+v", ctx.CodecID)
+			return
+		}
+	} else {
+		err = errors.New("astilibav: neither codec name nor codec id provided")
+		return
+	}
+	return
+}
+
+func (ctx Context) codecProfile(c *avcodec.Codec) (p int, ok bool) {
+	for _, v := range c.Profiles() {
+		if v.Name() == ctx.Profile {
+			return v.Profile(), true
+		}
+	}
+	return
+}
+
+// privateOptions returns the attributes that can't be set on the codec context and must be provided to the codec
+// through its private options
+func (ctx Context) privateOptions(c *avcodec.Codec) (os map[string]string) {
+	os = make(map[string]string)
+	if len(ctx.Profile) > 0 && len(c.Profiles()) == 0 {
+		os["profile"] = ctx.Profile
+	}
+	if ctx.CRF != nil {
+		os["crf"] = strconv.FormatFloat(*ctx.CRF, 'f', -1, 64)
+	}
+	return
+}
+
+// optionKeys returns the keys of both the dict and the private options
+func (ctx Context) optionKeys(c *avcodec.Codec) (ks []string) {
+	ks = dictKeys(ctx.Dict)
+	var pks []string
+	for k := range ctx.privateOptions(c) {
+		pks = append(pks, k)
+	}
+	sort.Strings(pks)
+	return append(ks, pks...)
+}
+
+// unknownOptions returns the option keys that are neither generic codec options nor codec private options
+func (ctx Context) unknownOptions(c *avcodec.Codec) (ks []string) {
+	for _, k := range ctx.optionKeys(c) {
+		if !codecHasOption(c, k) {
+			ks = append(ks, k)
+		}
 	}
 	return
 }
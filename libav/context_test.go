@@ -0,0 +1,80 @@
+package astilibav
+
+import (
+	"testing"
+
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNearestRational(t *testing.T) {
+	rs := []avutil.Rational{avutil.NewRational(24, 1), avutil.NewRational(25, 1), avutil.NewRational(30000, 1001)}
+	n := nearestRational(avutil.NewRational(25, 1), rs)
+	assert.Equal(t, 0, avutil.AvCmpQ(avutil.NewRational(25, 1), n))
+	n = nearestRational(avutil.NewRational(30, 1), rs)
+	assert.Equal(t, 0, avutil.AvCmpQ(avutil.NewRational(30000, 1001), n))
+	n = nearestRational(avutil.NewRational(23976, 1000), rs)
+	assert.Equal(t, 0, avutil.AvCmpQ(avutil.NewRational(24, 1), n))
+}
+
+func TestContextNegotiateWithEncoder(t *testing.T) {
+	if avcodec.AvcodecFindEncoderByName("mjpeg") == nil {
+		t.Skip("mjpeg encoder is not available")
+	}
+
+	// Unsupported pixel format is replaced
+	ctx := Context{
+		CodecName:   "mjpeg",
+		CodecType:   avutil.AVMEDIA_TYPE_VIDEO,
+		FrameRate:   avutil.NewRational(25, 1),
+		PixelFormat: avutil.AV_PIX_FMT_RGB24,
+	}
+	o, err := ctx.NegotiateWithEncoder()
+	assert.NoError(t, err)
+	assert.NotEqual(t, avutil.AV_PIX_FMT_RGB24, o.PixelFormat)
+	assert.Equal(t, 0, avutil.AvCmpQ(ctx.FrameRate, o.FrameRate))
+
+	// Supported pixel format is kept
+	ctx.PixelFormat = avutil.AV_PIX_FMT_YUVJ420P
+	o, err = ctx.NegotiateWithEncoder()
+	assert.NoError(t, err)
+	assert.Equal(t, avutil.AV_PIX_FMT_YUVJ420P, o.PixelFormat)
+
+	// Unknown encoder
+	_, err = Context{CodecName: "invalid"}.NegotiateWithEncoder()
+	assert.Error(t, err)
+}
+
+func TestIsFullRangePixelFormat(t *testing.T) {
+	assert.True(t, IsFullRangePixelFormat(avutil.AV_PIX_FMT_YUVJ420P))
+	assert.True(t, IsFullRangePixelFormat(avutil.AV_PIX_FMT_YUVJ444P))
+	assert.False(t, IsFullRangePixelFormat(avutil.AV_PIX_FMT_YUV420P))
+	assert.False(t, IsFullRangePixelFormat(avutil.AV_PIX_FMT_GRAY8))
+}
+
+func TestContextStreamSideData(t *testing.T) {
+	assert.Equal(t, map[avcodec.AvPacketSideDataType][]byte{}, Context{}.streamSideData())
+	assert.Equal(t, map[avcodec.AvPacketSideDataType][]byte{
+		avcodec.AV_PKT_DATA_CONTENT_LIGHT_LEVEL:        []byte("cll"),
+		avcodec.AV_PKT_DATA_MASTERING_DISPLAY_METADATA: []byte("mdm"),
+	}, Context{ContentLightLevel: []byte("cll"), MasteringDisplay: []byte("mdm")}.streamSideData())
+}
+
+func TestContextSameFrameFormat(t *testing.T) {
+	// Audio
+	a := Context{ChannelLayout: 3, Channels: 2, CodecType: avcodec.AVMEDIA_TYPE_AUDIO, SampleRate: 48000}
+	b := a
+	b.TimeBase = avutil.NewRational(1, 48000)
+	assert.True(t, a.sameFrameFormat(b))
+	b.ChannelLayout = 0
+	assert.False(t, a.sameFrameFormat(b))
+
+	// Video
+	a = Context{CodecType: avcodec.AVMEDIA_TYPE_VIDEO, Height: 1080, Width: 1920}
+	b = a
+	b.SampleAspectRatio = avutil.NewRational(4, 3)
+	assert.True(t, a.sameFrameFormat(b))
+	b.Width = 1280
+	assert.False(t, a.sameFrameFormat(b))
+}
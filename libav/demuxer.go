@@ -275,6 +275,11 @@ func (d *Demuxer) readFrame(ctx context.Context) (stop bool) {
 		if ret != avutil.AVERROR_EOF || !d.loop {
 			if ret != avutil.AVERROR_EOF {
 				emitAvError(d, d.eh, ret, "ctxFormat.AvReadFrame on %s failed", d.ctxFormat.Filename())
+			} else {
+				d.eh.Emit(astiencoder.Event{
+					Name:   EventNameEOF,
+					Target: d,
+				})
 			}
 			stop = true
 		} else if d.loopFirstPkt != nil {
@@ -8,3 +8,16 @@ import (
 type Descriptor interface {
 	TimeBase() avutil.Rational
 }
+
+type timeBaseDescriptor struct {
+	timeBase avutil.Rational
+}
+
+func newTimeBaseDescriptor(timeBase avutil.Rational) *timeBaseDescriptor {
+	return &timeBaseDescriptor{timeBase: timeBase}
+}
+
+// TimeBase implements the Descriptor interface
+func (d *timeBaseDescriptor) TimeBase() avutil.Rational {
+	return d.timeBase
+}
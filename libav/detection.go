@@ -0,0 +1,132 @@
+package astilibav
+
+import (
+	"sync"
+	"time"
+
+	"github.com/asticode/go-astiencoder"
+)
+
+// DetectionInterval represents an interval during which something has been detected
+// Times are relative to the pts origin
+type DetectionInterval struct {
+	End   time.Duration `json:"end"`
+	Start time.Duration `json:"start"`
+}
+
+// EventDetection represents the payload of a detection start or end event
+type EventDetection struct {
+	// Pts in the time base of the descriptor
+	Pts  int64
+	Time time.Duration
+}
+
+type detectionTime struct {
+	pts int64
+	t   time.Duration
+}
+
+// intervalDetector turns per-frame decisions into intervals lasting at least a minimum duration, and emits events
+// when they start and end
+type intervalDetector struct {
+	eh               *astiencoder.EventHandler
+	end              *detectionTime
+	eventNameEnded   string
+	eventNameStarted string
+	intervals        []DetectionInterval
+	m                *sync.Mutex
+	minDuration      time.Duration
+	start            *detectionTime
+	started          bool
+	target           interface{}
+}
+
+func newIntervalDetector(minDuration time.Duration, eventNameStarted, eventNameEnded string, target interface{}, eh *astiencoder.EventHandler) *intervalDetector {
+	return &intervalDetector{
+		eh:               eh,
+		eventNameEnded:   eventNameEnded,
+		eventNameStarted: eventNameStarted,
+		intervals:        []DetectionInterval{},
+		m:                &sync.Mutex{},
+		minDuration:      minDuration,
+		target:           target,
+	}
+}
+
+// handle processes the decision made for a frame starting at start and ending at end
+func (d *intervalDetector) handle(detected bool, start, end detectionTime) {
+	// Make sure to store the end of the frame
+	defer func() { d.end = &end }()
+
+	// Nothing has been detected
+	if !detected {
+		d.stop(start)
+		return
+	}
+
+	// Store the start of the interval
+	if d.start == nil {
+		d.start = &start
+	}
+
+	// Interval is long enough
+	if !d.started && end.t-d.start.t >= d.minDuration {
+		d.started = true
+		d.emit(d.eventNameStarted, *d.start)
+	}
+}
+
+// close stops the ongoing interval at the end of the last frame
+func (d *intervalDetector) close() {
+	if d.end != nil {
+		d.stop(*d.end)
+	}
+}
+
+func (d *intervalDetector) stop(t detectionTime) {
+	// Make sure to reset the start of the interval
+	defer func() {
+		d.start = nil
+		d.started = false
+	}()
+
+	// No interval has started
+	if !d.started {
+		return
+	}
+
+	// Store interval
+	d.m.Lock()
+	d.intervals = append(d.intervals, DetectionInterval{
+		End:   t.t,
+		Start: d.start.t,
+	})
+	d.m.Unlock()
+
+	// Emit events
+	d.emit(d.eventNameEnded, t)
+	d.eh.Emit(astiencoder.Event{
+		Name:   astiencoder.EventNameNodeDataChanged,
+		Target: d.target,
+	})
+}
+
+func (d *intervalDetector) emit(name string, t detectionTime) {
+	d.eh.Emit(astiencoder.Event{
+		Name: name,
+		Payload: EventDetection{
+			Pts:  t.pts,
+			Time: t.t,
+		},
+		Target: d.target,
+	})
+}
+
+// report returns the intervals that have ended so far
+func (d *intervalDetector) report() (is []DetectionInterval) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	is = make([]DetectionInterval, len(d.intervals))
+	copy(is, d.intervals)
+	return
+}
@@ -0,0 +1,48 @@
+package astilibav
+
+import (
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDetectionTime(s int) detectionTime {
+	return detectionTime{pts: int64(s * 1000), t: time.Duration(s) * time.Second}
+}
+
+func TestIntervalDetector(t *testing.T) {
+	// Create detector
+	eh := astiencoder.NewEventHandler()
+	d := newIntervalDetector(2*time.Second, "started", "ended", nil, eh)
+	var es []string
+	for _, n := range []string{"started", "ended"} {
+		n := n
+		eh.AddForEventName(n, func(e astiencoder.Event) bool {
+			es = append(es, n+":"+e.Payload.(EventDetection).Time.String())
+			return false
+		})
+	}
+	eh.AddForEventName(astiencoder.EventNameNodeDataChanged, func(e astiencoder.Event) bool {
+		es = append(es, "data_changed")
+		return false
+	})
+
+	// Handle decisions
+	// Intervals shorter than the min duration are ignored
+	for s, detected := range []bool{true, true, false, true, false, true, true} {
+		d.handle(detected, newTestDetectionTime(s), newTestDetectionTime(s+1))
+	}
+	assert.Equal(t, []string{"started:0s", "ended:2s", "data_changed", "started:5s"}, es)
+	assert.Equal(t, []DetectionInterval{{End: 2 * time.Second, Start: 0}}, d.report())
+
+	// Close stops the ongoing interval at the end of the last frame
+	d.close()
+	assert.Equal(t, []string{"started:0s", "ended:2s", "data_changed", "started:5s", "ended:7s", "data_changed"}, es)
+	assert.Equal(t, []DetectionInterval{{End: 2 * time.Second, Start: 0}, {End: 7 * time.Second, Start: 5 * time.Second}}, d.report())
+
+	// Report is a copy
+	d.report()[0].Start = time.Hour
+	assert.Equal(t, time.Duration(0), d.report()[0].Start)
+}
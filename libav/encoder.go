@@ -3,6 +3,7 @@ package astilibav
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync/atomic"
 
 	"github.com/asticode/goav/avformat"
@@ -25,16 +26,21 @@ type Encoder struct {
 	ctxCodec           *avcodec.Context
 	d                  *pktDispatcher
 	eh                 *astiencoder.EventHandler
+	keyFrameAligner    KeyFrameAligner
 	previousDescriptor Descriptor
 	q                  *astisync.CtxQueue
+	streamSideData     map[avcodec.AvPacketSideDataType][]byte
 	statIncomingRate   *astistat.IncrementStat
 	statWorkRatio      *astistat.DurationRatioStat
 }
 
 // EncoderOptions represents encoder options
 type EncoderOptions struct {
-	Ctx  Context
-	Node astiencoder.NodeOptions
+	Ctx Context
+	// If set, the aligner decides which frames are forced as key frames. Share it between encoders fed by the same
+	// input to align their key frames
+	KeyFrameAligner KeyFrameAligner
+	Node            astiencoder.NodeOptions
 }
 
 // NewEncoder creates a new encoder
@@ -47,27 +53,19 @@ func NewEncoder(o EncoderOptions, eh *astiencoder.EventHandler, c *astiencoder.C
 	e = &Encoder{
 		d:                newPktDispatcher(c),
 		eh:               eh,
+		keyFrameAligner:  o.KeyFrameAligner,
 		q:                astisync.NewCtxQueue(),
 		statIncomingRate: astistat.NewIncrementStat(),
 		statWorkRatio:    astistat.NewDurationRatioStat(),
+		streamSideData:   o.Ctx.streamSideData(),
 	}
 	e.BaseNode = astiencoder.NewBaseNode(o.Node, astiencoder.NewEventGeneratorNode(e), eh)
 	e.addStats()
 
 	// Find encoder
 	var cdc *avcodec.Codec
-	if len(o.Ctx.CodecName) > 0 {
-		if cdc = avcodec.AvcodecFindEncoderByName(o.Ctx.CodecName); cdc == nil {
-			err = fmt.Errorf("astilibav: no encoder with name %s", o.Ctx.CodecName)
-			return
-		}
-	} else if o.Ctx.CodecID > 0 {
-		if cdc = avcodec.AvcodecFindEncoder(o.Ctx.CodecID); cdc == nil {
-			err = fmt.Errorf("astilibav: no encoder with id %+v", o.Ctx.CodecID)
-			return
-		}
-	} else {
-		err = errors.New("astilibav: neither codec name nor codec id provided")
+	if cdc, err = findEncoder(o.Ctx); err != nil {
+		err = errors.Wrap(err, "astilibav: finding encoder failed")
 		return
 	}
 
@@ -90,21 +88,54 @@ func NewEncoder(o EncoderOptions, eh *astiencoder.EventHandler, c *astiencoder.C
 	if o.Ctx.ThreadCount != nil {
 		e.ctxCodec.SetThreadCount(*o.Ctx.ThreadCount)
 	}
+	e.ctxCodec.SetBitRate(int64(o.Ctx.BitRate))
+	if o.Ctx.BufferSize > 0 {
+		e.ctxCodec.SetRcBufferSize(o.Ctx.BufferSize)
+	}
+	if o.Ctx.MaxRate > 0 {
+		e.ctxCodec.SetRcMaxRate(int64(o.Ctx.MaxRate))
+	}
+	if o.Ctx.MinRate > 0 {
+		e.ctxCodec.SetRcMinRate(int64(o.Ctx.MinRate))
+	}
+	if o.Ctx.GlobalQuality != nil {
+		e.ctxCodec.SetFlags(e.ctxCodec.Flags() | avcodec.AV_CODEC_FLAG_QSCALE)
+		e.ctxCodec.SetGlobalQuality(*o.Ctx.GlobalQuality * avutil.FF_QP2LAMBDA)
+	}
+	if p, ok := o.Ctx.codecProfile(cdc); ok {
+		e.ctxCodec.SetProfile(p)
+	}
 
 	// Set media type-specific context parameters
 	switch o.Ctx.CodecType {
 	case avutil.AVMEDIA_TYPE_AUDIO:
-		e.ctxCodec.SetBitRate(int64(o.Ctx.BitRate))
 		e.ctxCodec.SetChannelLayout(o.Ctx.ChannelLayout)
 		e.ctxCodec.SetChannels(o.Ctx.Channels)
 		e.ctxCodec.SetSampleFmt(o.Ctx.SampleFmt)
 		e.ctxCodec.SetSampleRate(o.Ctx.SampleRate)
 	case avutil.AVMEDIA_TYPE_VIDEO:
-		e.ctxCodec.SetBitRate(int64(o.Ctx.BitRate))
+		e.ctxCodec.SetChromaSampleLocation(o.Ctx.ChromaLocation)
+		if o.Ctx.ClosedGOP {
+			e.ctxCodec.SetFlags(e.ctxCodec.Flags() | avcodec.AV_CODEC_FLAG_CLOSED_GOP)
+		}
+		e.ctxCodec.SetColorPrimaries(o.Ctx.ColorPrimaries)
+		e.ctxCodec.SetColorRange(o.Ctx.ColorRange)
+		e.ctxCodec.SetColorspace(o.Ctx.ColorSpace)
+		e.ctxCodec.SetColorTrc(o.Ctx.ColorTransferCharacteristic)
+		e.ctxCodec.SetFieldOrder(o.Ctx.FieldOrder)
 		e.ctxCodec.SetFramerate(o.Ctx.FrameRate)
 		e.ctxCodec.SetGopSize(o.Ctx.GopSize)
 		e.ctxCodec.SetHeight(o.Ctx.Height)
+		if o.Ctx.Level > 0 {
+			e.ctxCodec.SetLevel(o.Ctx.Level)
+		}
+		if o.Ctx.MaxBFrames != nil {
+			e.ctxCodec.SetMaxBFrames(*o.Ctx.MaxBFrames)
+		}
 		e.ctxCodec.SetPixFmt(o.Ctx.PixelFormat)
+		if o.Ctx.Refs != nil {
+			e.ctxCodec.SetRefs(*o.Ctx.Refs)
+		}
 		e.ctxCodec.SetSampleAspectRatio(o.Ctx.SampleAspectRatio)
 		e.ctxCodec.SetTimeBase(o.Ctx.TimeBase)
 		e.ctxCodec.SetWidth(o.Ctx.Width)
@@ -121,9 +152,18 @@ func NewEncoder(o EncoderOptions, eh *astiencoder.EventHandler, c *astiencoder.C
 			err = errors.Wrapf(NewAvError(ret), "astilibav: avutil.AvDictParseString on %s failed", o.Ctx.Dict)
 			return
 		}
+	}
 
-		// Make sure the dict is freed
-		defer avutil.AvDictFree(&dict)
+	// Make sure the dict is freed
+	defer avutil.AvDictFree(&dict)
+
+	// Private options take precedence over the dict
+	pos := o.Ctx.privateOptions(cdc)
+	for k, v := range pos {
+		if ret := avutil.AvDictSet(&dict, k, v, 0); ret < 0 {
+			err = errors.Wrapf(NewAvError(ret), "astilibav: avutil.AvDictSet on %s=%s failed", k, v)
+			return
+		}
 	}
 
 	// Open codec
@@ -139,6 +179,18 @@ func NewEncoder(o EncoderOptions, eh *astiencoder.EventHandler, c *astiencoder.C
 		}
 		return nil
 	})
+
+	// Options that have not been consumed by the codec are not supported
+	var ks []string
+	for _, k := range o.Ctx.optionKeys(cdc) {
+		if avutil.AvDictGet(dict, k, nil, 0) != nil {
+			ks = append(ks, k)
+		}
+	}
+	if len(ks) > 0 {
+		err = fmt.Errorf("astilibav: options %s have not been consumed by chosen codec", strings.Join(ks, ", "))
+		return
+	}
 	return
 }
 
@@ -225,6 +277,11 @@ func (e *Encoder) encode(p *FrameHandlerPayload) {
 		case avutil.AVMEDIA_TYPE_VIDEO:
 			p.Frame.SetKeyFrame(0)
 			p.Frame.SetPictType(avutil.AvPictureType(avutil.AV_PICTURE_TYPE_NONE))
+
+			// Force key frame
+			if e.keyFrameAligner != nil && e.keyFrameAligner.ShouldForce(p.Frame, p.Descriptor, e) {
+				p.Frame.SetPictType(avutil.AvPictureType(avutil.AV_PICTURE_TYPE_I))
+			}
 		}
 	}
 
@@ -302,6 +359,30 @@ func (e *Encoder) AddStream(ctxFormat *avformat.Context) (o *avformat.Stream, er
 
 	// Set other attributes
 	o.SetTimeBase(e.ctxCodec.TimeBase())
+
+	// Add side data
+	for t, b := range e.streamSideData {
+		if err = addStreamSideData(o, t, b); err != nil {
+			err = errors.Wrapf(err, "astilibav: adding stream side data %v failed", t)
+			return
+		}
+	}
+	return
+}
+
+// CodecParameters returns the codec parameters matching the encoded packets, that can be used to create a decoder
+func (e *Encoder) CodecParameters(c *astiencoder.Closer) (cp *avcodec.CodecParameters, err error) {
+	// Alloc codec parameters
+	if cp, err = allocCodecParameters(c); err != nil {
+		err = errors.Wrap(err, "astilibav: allocating codec parameters failed")
+		return
+	}
+
+	// Set codec parameters
+	if ret := avcodec.AvcodecParametersFromContext(cp, e.ctxCodec); ret < 0 {
+		err = errors.Wrapf(NewAvError(ret), "astilibav: avcodec.AvcodecParametersFromContext from %+v to %+v failed", e.ctxCodec, cp)
+		return
+	}
 	return
 }
 
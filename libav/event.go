@@ -2,8 +2,26 @@ package astilibav
 
 // Event names
 const (
-	EventNameEOF = "astilibav.eof"
-	EventNameFiltererSwitchInDone  = "astilibav.filterer.switch.in.done"
-	EventNameFiltererSwitchOutDone = "astilibav.filterer.switch.out.done"
-	EventNameRateEnforcerSwitched = "astilibav.rate.enforcer.switched"
+	EventNameBlackEnded                  = "astilibav.black.ended"
+	EventNameBlackStarted                = "astilibav.black.started"
+	EventNameClippingEnded               = "astilibav.clipping.ended"
+	EventNameClippingStarted             = "astilibav.clipping.started"
+	EventNameEOF                         = "astilibav.eof"
+	EventNameFailoverSwitched            = "astilibav.failover.switched"
+	EventNameFiltererSwitchInDone        = "astilibav.filterer.switch.in.done"
+	EventNameFiltererSwitchOutDone       = "astilibav.filterer.switch.out.done"
+	EventNameFormatChanged               = "astilibav.format.changed"
+	EventNameRateEnforcerFallbackEntered = "astilibav.rate.enforcer.fallback.entered"
+	EventNameRateEnforcerFallbackLeft    = "astilibav.rate.enforcer.fallback.left"
+	EventNameRateEnforcerSwitched        = "astilibav.rate.enforcer.switched"
+	EventNameSceneChanged                = "astilibav.scene.changed"
+	EventNameSilenceEnded                = "astilibav.silence.ended"
+	EventNameSilenceStarted              = "astilibav.silence.started"
 )
+
+// EventFormatChanged represents the payload of a format changed event
+// Only attributes that can be retrieved from frames are set
+type EventFormatChanged struct {
+	From Context
+	To   Context
+}
@@ -0,0 +1,339 @@
+package astilibav
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astitools/stat"
+	"github.com/asticode/go-astitools/time"
+	"github.com/asticode/go-astitools/worker"
+)
+
+var countFailover uint64
+
+// Failover represents an object capable of switching its switchers to the highest-priority healthy source
+// It must be connected to the branches of its sources so that it knows when they're producing frames. A source is
+// unhealthy when it has stalled, when one of its nodes has emitted an error recently or when it has reached EOF.
+type Failover struct {
+	*astiencoder.BaseNode
+	current          *failoverSource
+	eh               *astiencoder.EventHandler
+	m                *sync.Mutex
+	o                FailoverOptions
+	origins          map[astiencoder.Node]*failoverSource
+	ss               []*failoverSource
+	statIncomingRate *astistat.IncrementStat
+	switchers        []FailoverSwitcher
+}
+
+// FailoverOptions represents failover options
+type FailoverOptions struct {
+	Node astiencoder.NodeOptions
+	// Duration during which a source must stay healthy before switching back to it. Defaults to 10s
+	StabilityWindow time.Duration
+	// Duration without frames after which a source is considered stalled. Defaults to 1s
+	StallTimeout time.Duration
+}
+
+// FailoverSource represents a failover source
+// Node is what switchers are switched to
+type FailoverSource struct {
+	Name     string
+	Node     astiencoder.Node
+	Priority int
+}
+
+// FailoverSwitcher represents an object capable of switching sources such as a rate enforcer
+type FailoverSwitcher interface {
+	Switch(n astiencoder.Node)
+}
+
+type failoverSource struct {
+	FailoverSource
+	eof          bool
+	healthySince time.Time
+	lastErrorAt  time.Time
+	lastFrameAt  time.Time
+}
+
+// NewFailover creates a new failover
+func NewFailover(o FailoverOptions, eh *astiencoder.EventHandler) (f *Failover) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countFailover, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("failover_%d", count), fmt.Sprintf("Failover #%d", count), "Fails over")
+
+	// Default options
+	if o.StabilityWindow <= 0 {
+		o.StabilityWindow = 10 * time.Second
+	}
+	if o.StallTimeout <= 0 {
+		o.StallTimeout = time.Second
+	}
+
+	// Create failover
+	f = &Failover{
+		eh:               eh,
+		m:                &sync.Mutex{},
+		o:                o,
+		origins:          make(map[astiencoder.Node]*failoverSource),
+		statIncomingRate: astistat.NewIncrementStat(),
+	}
+	f.BaseNode = astiencoder.NewBaseNode(o.Node, astiencoder.NewEventGeneratorNode(f), eh)
+	f.addStats()
+
+	// Handle errors
+	// Listeners are removed once the failover has stopped
+	eh.AddForEventName(astiencoder.EventNameError, func(e astiencoder.Event) bool {
+		if f.stopped() {
+			return true
+		}
+		f.handleEvent(e, func(s *failoverSource) { s.lastErrorAt = time.Now() })
+		return false
+	})
+
+	// Handle EOF
+	eh.AddForEventName(EventNameEOF, func(e astiencoder.Event) bool {
+		if f.stopped() {
+			return true
+		}
+		f.handleEvent(e, func(s *failoverSource) { s.eof = true })
+		return false
+	})
+	return
+}
+
+func (f *Failover) stopped() bool {
+	return f.Context() != nil && f.Context().Err() != nil
+}
+
+func (f *Failover) addStats() {
+	// Add incoming rate
+	f.Stater().AddStat(astistat.StatMetadata{
+		Description: "Number of frames coming in per second",
+		Label:       "Incoming rate",
+		Unit:        "fps",
+	}, f.statIncomingRate)
+}
+
+// AddSource adds a source
+func (f *Failover) AddSource(s FailoverSource) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.ss = append(f.ss, &failoverSource{FailoverSource: s})
+	sort.SliceStable(f.ss, func(i, j int) bool { return f.ss[i].Priority > f.ss[j].Priority })
+	f.origins = make(map[astiencoder.Node]*failoverSource)
+}
+
+// AddSwitcher adds a switcher
+// It's switched right away if a source has already been chosen
+func (f *Failover) AddSwitcher(s FailoverSwitcher) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.switchers = append(f.switchers, s)
+	if f.current != nil {
+		s.Switch(f.current.Node)
+	}
+}
+
+// Current returns the name of the current source
+func (f *Failover) Current() string {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.current == nil {
+		return ""
+	}
+	return f.current.Name
+}
+
+// FailoverExposedData represents failover exposed data
+type FailoverExposedData struct {
+	Current string                  `json:"current"`
+	Sources []FailoverExposedSource `json:"sources"`
+}
+
+// FailoverExposedSource represents a failover exposed source
+type FailoverExposedSource struct {
+	EOF      bool   `json:"eof"`
+	Healthy  bool   `json:"healthy"`
+	Name     string `json:"name"`
+	Priority int    `json:"priority"`
+}
+
+// ExposedData implements the astiencoder.NodeExposer interface
+func (f *Failover) ExposedData() interface{} {
+	f.m.Lock()
+	defer f.m.Unlock()
+	d := FailoverExposedData{Sources: []FailoverExposedSource{}}
+	if f.current != nil {
+		d.Current = f.current.Name
+	}
+	for _, s := range f.ss {
+		d.Sources = append(d.Sources, FailoverExposedSource{
+			EOF:      s.eof,
+			Healthy:  !s.healthySince.IsZero(),
+			Name:     s.Name,
+			Priority: s.Priority,
+		})
+	}
+	return d
+}
+
+// source returns the source a node belongs to. It assumes the mutex is locked
+// Nodes descending from several sources such as the switchers don't belong to any source
+func (f *Failover) source(n astiencoder.Node) (s *failoverSource) {
+	// Check cache
+	var ok bool
+	if s, ok = f.origins[n]; ok {
+		return
+	}
+
+	// Get sources
+	ss := make(map[*failoverSource]bool)
+	f.sources(n, ss)
+	if len(ss) == 1 {
+		for v := range ss {
+			s = v
+		}
+	}
+
+	// Store in cache
+	f.origins[n] = s
+	return
+}
+
+func (f *Failover) sources(n astiencoder.Node, ss map[*failoverSource]bool) {
+	// Loop through sources
+	for _, s := range f.ss {
+		if s.Node == n {
+			ss[s] = true
+			return
+		}
+	}
+
+	// Loop through parents
+	for _, p := range n.Parents() {
+		f.sources(p, ss)
+	}
+}
+
+func (f *Failover) handleEvent(e astiencoder.Event, fn func(s *failoverSource)) {
+	// Target is not a node
+	n, ok := e.Target.(astiencoder.Node)
+	if !ok {
+		return
+	}
+
+	// Lock
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	// Get source
+	if s := f.source(n); s != nil {
+		fn(s)
+	}
+}
+
+// HandleFrame implements the FrameHandler interface
+func (f *Failover) HandleFrame(p *FrameHandlerPayload) {
+	// Increment incoming rate
+	f.statIncomingRate.Add(1)
+
+	// Lock
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	// Get source
+	if s := f.source(p.Node); s != nil {
+		s.eof = false
+		s.lastFrameAt = time.Now()
+	}
+}
+
+// Start starts the failover
+func (f *Failover) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	f.BaseNode.Start(ctx, t, func(t *astiworker.Task) {
+		for {
+			// Sleep
+			astitime.Sleep(f.Context(), f.o.StallTimeout/4)
+
+			// Check context
+			if f.Context().Err() != nil {
+				return
+			}
+
+			// Handle pause
+			f.HandlePause()
+
+			// Evaluate
+			for _, e := range f.evaluate(time.Now()) {
+				f.eh.Emit(e)
+			}
+		}
+	})
+}
+
+// evaluate updates sources health and switches to the best source if needed
+// Events are returned instead of being emitted so that listeners are not called while the mutex is locked
+func (f *Failover) evaluate(now time.Time) (es []astiencoder.Event) {
+	// Lock
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	// Update health
+	var changed bool
+	for _, s := range f.ss {
+		healthy := !s.eof && !s.lastFrameAt.IsZero() && now.Sub(s.lastFrameAt) < f.o.StallTimeout && (s.lastErrorAt.IsZero() || now.Sub(s.lastErrorAt) >= f.o.StallTimeout)
+		if healthy && s.healthySince.IsZero() {
+			s.healthySince = now
+			changed = true
+		} else if !healthy && !s.healthySince.IsZero() {
+			s.healthySince = time.Time{}
+			changed = true
+		}
+	}
+
+	// Get next source
+	// Sources are sorted by priority. If the current source is healthy, we only switch to a higher-priority source once
+	// it has been healthy for the stability window
+	var next *failoverSource
+	for _, s := range f.ss {
+		// Source is unhealthy
+		if s.healthySince.IsZero() {
+			continue
+		}
+
+		// Source is the current source or is stable enough
+		if s == f.current || f.current == nil || f.current.healthySince.IsZero() || now.Sub(s.healthySince) >= f.o.StabilityWindow {
+			next = s
+			break
+		}
+	}
+
+	// Switch
+	if next != nil && next != f.current {
+		f.current = next
+		changed = true
+		for _, s := range f.switchers {
+			s.Switch(next.Node)
+		}
+		es = append(es, astiencoder.Event{
+			Name:    EventNameFailoverSwitched,
+			Payload: next.Name,
+			Target:  f,
+		})
+	}
+
+	// Data changed
+	if changed {
+		es = append(es, astiencoder.Event{
+			Name:   astiencoder.EventNameNodeDataChanged,
+			Target: f,
+		})
+	}
+	return
+}
@@ -0,0 +1,85 @@
+package astilibav
+
+import (
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/stretchr/testify/assert"
+)
+
+type testFailoverSwitcher struct {
+	ns []astiencoder.Node
+}
+
+func (s *testFailoverSwitcher) Switch(n astiencoder.Node) {
+	s.ns = append(s.ns, n)
+}
+
+func TestFailover(t *testing.T) {
+	// Create failover
+	eh := astiencoder.NewEventHandler()
+	f := NewFailover(FailoverOptions{StabilityWindow: 2 * time.Second, StallTimeout: time.Second}, eh)
+	n1 := newTestNode("main", eh)
+	n2 := newTestNode("backup", eh)
+	f.AddSource(FailoverSource{Name: "backup", Node: n2, Priority: 1})
+	f.AddSource(FailoverSource{Name: "main", Node: n1, Priority: 2})
+	s := &testFailoverSwitcher{}
+	f.AddSwitcher(s)
+
+	// Listeners can use the failover since events are emitted once the mutex is unlocked
+	var switched []string
+	eh.AddForEventName(EventNameFailoverSwitched, func(e astiencoder.Event) bool {
+		switched = append(switched, f.Current())
+		return false
+	})
+
+	// No source is healthy
+	emit := func(es []astiencoder.Event) {
+		for _, e := range es {
+			eh.Emit(e)
+		}
+	}
+	emit(f.evaluate(time.Now()))
+	assert.Equal(t, "", f.Current())
+
+	// Backup is healthy
+	f.HandleFrame(&FrameHandlerPayload{Node: n2})
+	now := time.Now()
+	emit(f.evaluate(now))
+	assert.Equal(t, "backup", f.Current())
+	assert.Equal(t, []astiencoder.Node{n2}, s.ns)
+	assert.Equal(t, []string{"backup"}, switched)
+
+	// Main becomes healthy but the current source is healthy as well
+	f.HandleFrame(&FrameHandlerPayload{Node: n1})
+	f.HandleFrame(&FrameHandlerPayload{Node: n2})
+	emit(f.evaluate(now))
+	assert.Equal(t, "backup", f.Current())
+
+	// Main has been healthy for the stability window
+	f.m.Lock()
+	for _, v := range f.ss {
+		v.lastFrameAt = now.Add(2 * time.Second)
+		v.healthySince = v.healthySince.Add(-2 * time.Second)
+	}
+	f.m.Unlock()
+	emit(f.evaluate(now.Add(2 * time.Second)))
+	assert.Equal(t, "main", f.Current())
+	assert.Equal(t, []string{"backup", "main"}, switched)
+
+	// Main has an error
+	eh.Emit(astiencoder.Event{Name: astiencoder.EventNameError, Target: n1})
+	emit(f.evaluate(time.Now()))
+	assert.Equal(t, "backup", f.Current())
+
+	// Main reaches EOF
+	f.HandleFrame(&FrameHandlerPayload{Node: n1})
+	eh.Emit(astiencoder.Event{Name: EventNameEOF, Target: n1})
+	d := f.ExposedData().(FailoverExposedData)
+	assert.Equal(t, "backup", d.Current)
+	assert.Equal(t, []FailoverExposedSource{
+		{EOF: true, Name: "main", Priority: 2},
+		{Healthy: true, Name: "backup", Priority: 1},
+	}, d.Sources)
+}
@@ -0,0 +1,42 @@
+package astilibav
+
+//#cgo pkg-config: libavfilter libavutil
+//#include <stdlib.h>
+//#include <libavfilter/avfilter.h>
+//#include <libavutil/mem.h>
+import "C"
+import (
+	"unsafe"
+
+	"github.com/asticode/goav/avfilter"
+	"github.com/pkg/errors"
+)
+
+const filterGraphCommandResponseSize = 256
+
+// sendFilterGraphCommand sends a command to a filter graph and returns libav's response
+// goav copies the response buffer with C.CString, which means the response is lost, we therefore call
+// avfilter_graph_send_command in C
+func sendFilterGraphCommand(g *avfilter.Graph, target, cmd, arg string, flags int) (res string, err error) {
+	// Convert strings
+	ct, cc, ca := C.CString(target), C.CString(cmd), C.CString(arg)
+	defer C.free(unsafe.Pointer(ct))
+	defer C.free(unsafe.Pointer(cc))
+	defer C.free(unsafe.Pointer(ca))
+
+	// Alloc response
+	r := (*C.char)(C.av_mallocz(filterGraphCommandResponseSize))
+	if r == nil {
+		err = errors.New("astilibav: no response allocated")
+		return
+	}
+	defer C.av_free(unsafe.Pointer(r))
+
+	// Send command
+	if ret := C.avfilter_graph_send_command((*C.AVFilterGraph)(unsafe.Pointer(g)), ct, cc, ca, r, filterGraphCommandResponseSize, C.int(flags)); ret < 0 {
+		err = errors.Wrap(NewAvError(int(ret)), "astilibav: avfilter_graph_send_command failed")
+		return
+	}
+	res = C.GoString(r)
+	return
+}
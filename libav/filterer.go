@@ -3,9 +3,9 @@ package astilibav
 import (
 	"context"
 	"fmt"
-	"sync/atomic"
-
+	"sort"
 	"sync"
+	"sync/atomic"
 
 	"github.com/asticode/go-astiencoder"
 	"github.com/asticode/go-astitools/stat"
@@ -22,28 +22,49 @@ var countFilterer uint64
 // Filterer represents an object capable of applying a filter to frames
 type Filterer struct {
 	*astiencoder.BaseNode
-	bufferSinkCtx    *avfilter.Context
 	bufferSrcCtxs    map[astiencoder.Node]*avfilter.Context
 	c                *astiencoder.Closer
 	cc               *astiencoder.Closer // Child closer used to close only things related to the filterer
-	d                *frameDispatcher
 	eh               *astiencoder.EventHandler
+	frameFormats     map[astiencoder.Node]Context // Format of the last frame of each input
 	g                *avfilter.Graph
+	gc               *astiencoder.Closer // Graph closer used to close only the graph when it's rebuilt
+	gm               *sync.Mutex         // Locks g since it is used by both the queue and commands
+	o                FiltererOptions
+	os               []*filtererOutput
 	q                *astisync.CtxQueue
 	s                FiltererSwitcher
 	statIncomingRate *astistat.IncrementStat
 	statWorkRatio    *astistat.DurationRatioStat
 }
 
+type filtererOutput struct {
+	bufferSinkCtx *avfilter.Context
+	d             *frameDispatcher
+	name          string
+}
+
+// FiltererDefaultOutputName is the name of the output created when no outputs are provided in the filterer options
+const FiltererDefaultOutputName = "out"
+
 // FiltererOptions represents filterer options
 type FiltererOptions struct {
-	Content  string
-	Inputs   map[string]FiltererInput
-	Node     astiencoder.NodeOptions
+	Content string
+	// If set, it's called once the filterer has started and before frames are filtered, and the graph is recreated
+	// with the content it returns if not empty. It allows retrieving content that is expensive to compute without
+	// blocking the creation of the filterer. Outputs must have the same contexts with both contents.
+	ContentFunc func(ctx context.Context) (string, error)
+	Inputs      map[string]FiltererInput
+	Node        astiencoder.NodeOptions
+	// Indexed by output pad name. If empty, a single output named FiltererDefaultOutputName is created.
+	// If an output context's codec type is unknown, the inputs' codec type is used.
+	Outputs  map[string]Context
 	Switcher FiltererSwitcher
 }
 
 // FiltererInput represents a filterer input
+// Buffer sources only accept color properties since libavfilter 10 (FFmpeg 7.0), therefore the context's color
+// properties are not used and color properties only pass through on frame properties
 type FiltererInput struct {
 	Context Context
 	Node    astiencoder.Node
@@ -57,19 +78,19 @@ func NewFilterer(o FiltererOptions, eh *astiencoder.EventHandler, c *astiencoder
 
 	// Create filterer
 	f = &Filterer{
-		bufferSrcCtxs:    make(map[astiencoder.Node]*avfilter.Context),
 		c:                c,
 		cc:               c.NewChild(),
 		eh:               eh,
-		g:                avfilter.AvfilterGraphAlloc(),
+		frameFormats:     make(map[astiencoder.Node]Context),
+		gm:               &sync.Mutex{},
+		o:                o,
 		q:                astisync.NewCtxQueue(),
 		s:                o.Switcher,
 		statIncomingRate: astistat.NewIncrementStat(),
 		statWorkRatio:    astistat.NewDurationRatioStat(),
 	}
 	f.BaseNode = astiencoder.NewBaseNode(o.Node, astiencoder.NewEventGeneratorNode(f), eh)
-	f.d = newFrameDispatcher(f, eh, f.cc)
-	f.addStats()
+	f.gc = f.cc.NewChild()
 
 	// We need a filterer switcher
 	if f.s == nil {
@@ -77,8 +98,99 @@ func NewFilterer(o FiltererOptions, eh *astiencoder.EventHandler, c *astiencoder
 	}
 
 	// Create graph
-	f.cc.Add(func() error {
-		f.g.AvfilterGraphFree()
+	var g *filterGraph
+	if g, err = newFilterGraph(o, f.gc); err != nil {
+		err = errors.Wrap(err, "astilibav: creating filter graph failed")
+		return
+	}
+	f.bufferSrcCtxs = g.bufferSrcCtxs
+	f.g = g.g
+
+	// Create outputs
+	for _, s := range g.sinks {
+		f.os = append(f.os, &filtererOutput{
+			bufferSinkCtx: s.ctx,
+			d:             newFrameDispatcher(f, eh, f.cc),
+			name:          s.name,
+		})
+	}
+
+	// Add stats
+	f.addStats()
+	return
+}
+
+// FiltererOutputContexts returns the contexts of the outputs that a filterer would have with the provided options
+// without creating the filterer. Contexts only contain attributes that can be retrieved from the filter graph
+func FiltererOutputContexts(o FiltererOptions) (ctxs map[string]Context, err error) {
+	// Make sure everything is closed
+	c := astiencoder.NewCloser()
+	defer c.Close()
+
+	// Create graph
+	var g *filterGraph
+	if g, err = newFilterGraph(o, c); err != nil {
+		err = errors.Wrap(err, "astilibav: creating filter graph failed")
+		return
+	}
+
+	// Loop through sinks
+	ctxs = make(map[string]Context)
+	for _, s := range g.sinks {
+		ctxs[s.name] = newContextFromBufferSink(s.ctx, s.codecType)
+	}
+	return
+}
+
+func newContextFromBufferSink(ctx *avfilter.Context, t avcodec.MediaType) (o Context) {
+	// Get link
+	o.CodecType = t
+	is := ctx.Inputs()
+	if len(is) == 0 {
+		return
+	}
+	l := is[0]
+
+	// Switch on codec type
+	o.TimeBase = l.TimeBase()
+	switch t {
+	case avcodec.AVMEDIA_TYPE_AUDIO:
+		o.ChannelLayout = l.ChannelLayout()
+		o.Channels = avutil.AvGetChannelLayoutNbChannels(l.ChannelLayout())
+		o.SampleFmt = avcodec.AvSampleFormat(l.Format())
+		o.SampleRate = l.SampleRate()
+	case avcodec.AVMEDIA_TYPE_VIDEO:
+		o.FrameRate = l.FrameRate()
+		o.Height = l.H()
+		o.PixelFormat = avutil.PixelFormat(l.Format())
+		o.SampleAspectRatio = l.SampleAspectRatio()
+		o.Width = l.W()
+	}
+	return
+}
+
+type filterGraph struct {
+	bufferSrcCtxs map[astiencoder.Node]*avfilter.Context
+	g             *avfilter.Graph
+	sinks         []filterGraphSink
+}
+
+type filterGraphSink struct {
+	codecType avcodec.MediaType
+	ctx       *avfilter.Context
+	name      string
+}
+
+func newFilterGraph(o FiltererOptions, c *astiencoder.Closer) (g *filterGraph, err error) {
+	// Create graph
+	g = &filterGraph{
+		bufferSrcCtxs: make(map[astiencoder.Node]*avfilter.Context),
+		g:             avfilter.AvfilterGraphAlloc(),
+	}
+
+	// Make sure the graph is freed
+	c.Add(func() error {
+		g.g.AvfilterGraphFree()
 		return nil
 	})
 
@@ -105,33 +217,73 @@ func NewFilterer(o FiltererOptions, eh *astiencoder.EventHandler, c *astiencoder
 		}
 	}
 
-	// Create buffer func and buffer sink
+	// Create buffer func
 	var bufferFunc func() *avfilter.Filter
-	var bufferSink *avfilter.Filter
 	switch codecType {
 	case avcodec.AVMEDIA_TYPE_AUDIO:
 		bufferFunc = func() *avfilter.Filter { return avfilter.AvfilterGetByName("abuffer") }
-		bufferSink = avfilter.AvfilterGetByName("abuffersink")
 	case avcodec.AVMEDIA_TYPE_VIDEO:
 		bufferFunc = func() *avfilter.Filter { return avfilter.AvfilterGetByName("buffer") }
-		bufferSink = avfilter.AvfilterGetByName("buffersink")
 	}
 
-	// Create buffer sink ctx
-	// We need to create an intermediate variable to avoid "cgo argument has Go pointer to Go pointer" errors
-	var bufferSinkCtx *avfilter.Context
-	if ret := avfilter.AvfilterGraphCreateFilter(&bufferSinkCtx, bufferSink, "out", "", nil, f.g); ret < 0 {
-		err = errors.Wrap(NewAvError(ret), "astilibav: avfilter.AvfilterGraphCreateFilter on empty args failed")
-		return
+	// Default outputs
+	if len(o.Outputs) == 0 {
+		o.Outputs = map[string]Context{FiltererDefaultOutputName: {CodecType: codecType}}
+	}
+
+	// Sort outputs so that they are always processed in the same order
+	var ons []string
+	for n := range o.Outputs {
+		ons = append(ons, n)
 	}
-	f.bufferSinkCtx = bufferSinkCtx
+	sort.Strings(ons)
+
+	// Loop through options outputs
+	var previousInput *avfilter.Input
+	for _, n := range ons {
+		// Get codec type
+		t := o.Outputs[n].CodecType
+		if t == avcodec.AVMEDIA_TYPE_UNKNOWN {
+			t = codecType
+		}
+
+		// Create buffer sink
+		var bufferSink *avfilter.Filter
+		switch t {
+		case avcodec.AVMEDIA_TYPE_AUDIO:
+			bufferSink = avfilter.AvfilterGetByName("abuffersink")
+		case avcodec.AVMEDIA_TYPE_VIDEO:
+			bufferSink = avfilter.AvfilterGetByName("buffersink")
+		default:
+			err = fmt.Errorf("astilibav: codec type %v of output %s is not handled by filterer", t, n)
+			return
+		}
+
+		// Create buffer sink ctx
+		// We need to create an intermediate variable to avoid "cgo argument has Go pointer to Go pointer" errors
+		var bufferSinkCtx *avfilter.Context
+		if ret := avfilter.AvfilterGraphCreateFilter(&bufferSinkCtx, bufferSink, n, "", nil, g.g); ret < 0 {
+			err = errors.Wrapf(NewAvError(ret), "astilibav: avfilter.AvfilterGraphCreateFilter on empty args for output %s failed", n)
+			return
+		}
+
+		// Create inputs
+		inputs := avfilter.AvfilterInoutAlloc()
+		inputs.SetName(n)
+		inputs.SetFilterCtx(bufferSinkCtx)
+		inputs.SetPadIdx(0)
+		inputs.SetNext(previousInput)
+
+		// Store sink
+		g.sinks = append(g.sinks, filterGraphSink{
+			codecType: t,
+			ctx:       bufferSinkCtx,
+			name:      n,
+		})
 
-	// Create inputs
-	inputs := avfilter.AvfilterInoutAlloc()
-	inputs.SetName("out")
-	inputs.SetFilterCtx(f.bufferSinkCtx)
-	inputs.SetPadIdx(0)
-	inputs.SetNext(nil)
+		// Set previous input
+		previousInput = inputs
+	}
 
 	// Loop through options inputs
 	var previousOutput *avfilter.Input
@@ -153,7 +305,7 @@ func NewFilterer(o FiltererOptions, eh *astiencoder.EventHandler, c *astiencoder
 
 		// Create ctx
 		var bufferSrcCtx *avfilter.Context
-		if ret := avfilter.AvfilterGraphCreateFilter(&bufferSrcCtx, bufferSrc, "in", args, nil, f.g); ret < 0 {
+		if ret := avfilter.AvfilterGraphCreateFilter(&bufferSrcCtx, bufferSrc, n, args, nil, g.g); ret < 0 {
 			err = errors.Wrapf(NewAvError(ret), "astilibav: avfilter.AvfilterGraphCreateFilter on args %s failed", args)
 			return
 		}
@@ -166,20 +318,20 @@ func NewFilterer(o FiltererOptions, eh *astiencoder.EventHandler, c *astiencoder
 		outputs.SetNext(previousOutput)
 
 		// Store ctx
-		f.bufferSrcCtxs[i.Node] = bufferSrcCtx
+		g.bufferSrcCtxs[i.Node] = bufferSrcCtx
 
 		// Set previous output
 		previousOutput = outputs
 	}
 
 	// Parse content
-	if ret := f.g.AvfilterGraphParsePtr(o.Content, &inputs, &previousOutput, nil); ret < 0 {
+	if ret := g.g.AvfilterGraphParsePtr(o.Content, &previousInput, &previousOutput, nil); ret < 0 {
 		err = errors.Wrapf(NewAvError(ret), "astilibav: g.AvfilterGraphParsePtr on content %s failed", o.Content)
 		return
 	}
 
 	// Configure
-	if ret := f.g.AvfilterGraphConfig(nil); ret < 0 {
+	if ret := g.g.AvfilterGraphConfig(nil); ret < 0 {
 		err = errors.Wrap(NewAvError(ret), "astilibav: g.AvfilterGraphConfig failed")
 		return
 	}
@@ -201,39 +353,111 @@ func (f *Filterer) addStats() {
 		Unit:        "%",
 	}, f.statWorkRatio)
 
-	// Add dispatcher stats
-	f.d.addStats(f.Stater())
+	// Add dispatchers stats
+	for _, o := range f.os {
+		o.d.addStats(f.Stater())
+	}
 
 	// Add queue stats
 	f.q.AddStats(f.Stater())
 }
 
 // Connect implements the FrameHandlerConnector interface
+// It connects the handler to the default output which is either the only output or the output named
+// FiltererDefaultOutputName
+// Since the interface doesn't allow returning an error, an error event is emitted when the filterer has no default
+// output. Use ConnectDefaultOutput to get the error instead.
 func (f *Filterer) Connect(h FrameHandler) {
+	if err := f.ConnectDefaultOutput(h); err != nil {
+		f.eh.Emit(astiencoder.EventError(f, err))
+	}
+}
+
+// ConnectDefaultOutput connects the default output to the handler
+// The default output is either the only output or the output named FiltererDefaultOutputName
+func (f *Filterer) ConnectDefaultOutput(h FrameHandler) (err error) {
+	// Get default output
+	o := f.defaultOutput()
+	if o == nil {
+		err = errors.New("astilibav: filterer has no default output")
+		return
+	}
+
+	// Connect output
+	f.connectOutput(o, h)
+	return
+}
+
+// ConnectOutput connects a specific output to the handler
+func (f *Filterer) ConnectOutput(name string, h FrameHandler) (err error) {
+	// Get output
+	o := f.output(name)
+	if o == nil {
+		err = fmt.Errorf("astilibav: output %s doesn't exist", name)
+		return
+	}
+
+	// Connect output
+	f.connectOutput(o, h)
+	return
+}
+
+func (f *Filterer) connectOutput(o *filtererOutput, h FrameHandler) {
 	// Add handler
-	f.d.addHandler(h)
+	o.d.addHandler(h)
 
 	// Connect nodes
 	astiencoder.ConnectNodes(f, h)
 }
 
 // Disconnect implements the FrameHandlerConnector interface
+// It disconnects the handler from all outputs
 func (f *Filterer) Disconnect(h FrameHandler) {
 	// Delete handler
-	f.d.delHandler(h)
+	for _, o := range f.os {
+		o.d.delHandler(h)
+	}
 
 	// Disconnect nodes
 	astiencoder.DisconnectNodes(f, h)
 }
 
+func (f *Filterer) defaultOutput() *filtererOutput {
+	if len(f.os) == 1 {
+		return f.os[0]
+	}
+	return f.output(FiltererDefaultOutputName)
+}
+
+func (f *Filterer) output(name string) *filtererOutput {
+	for _, o := range f.os {
+		if o.name == name {
+			return o
+		}
+	}
+	return nil
+}
+
+// OutputNames returns the filterer output names
+func (f *Filterer) OutputNames() (ns []string) {
+	for _, o := range f.os {
+		ns = append(ns, o.name)
+	}
+	return
+}
+
 // Start starts the filterer
 func (f *Filterer) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
 	f.BaseNode.Start(ctx, t, func(t *astiworker.Task) {
 		// Handle context
 		go f.q.HandleCtx(f.Context())
 
-		// Make sure to wait for all dispatcher subprocesses to be done so that they are properly closed
-		defer f.d.wait()
+		// Make sure to wait for all dispatchers subprocesses to be done so that they are properly closed
+		defer func() {
+			for _, o := range f.os {
+				o.d.wait()
+			}
+		}()
 
 		// Make sure to stop the queue properly
 		defer f.q.Stop()
@@ -243,6 +467,17 @@ func (f *Filterer) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
 			f.s.Reset()
 		}
 
+		// Update content
+		// The graph created with the options' content is kept when the content func fails
+		if f.o.ContentFunc != nil {
+			if err := f.updateContent(f.Context()); err != nil {
+				if f.Context().Err() != nil {
+					return
+				}
+				f.eh.Emit(astiencoder.EventError(f, errors.Wrap(err, "astilibav: updating content failed")))
+			}
+		}
+
 		// Start queue
 		f.q.Start(func(dp interface{}) {
 			// Handle pause
@@ -254,6 +489,16 @@ func (f *Filterer) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
 			// Increment incoming rate
 			f.statIncomingRate.Add(1)
 
+			// Lock graph so that it's not used concurrently by commands
+			f.gm.Lock()
+			defer f.gm.Unlock()
+
+			// Make sure the graph matches the frame format
+			if err := f.checkInputFormat(p); err != nil {
+				f.eh.Emit(astiencoder.EventError(f, errors.Wrap(err, "astilibav: checking input format failed")))
+				return
+			}
+
 			// Retrieve buffer ctx
 			bufferSrcCtx, ok := f.bufferSrcCtxs[p.Node]
 			if !ok {
@@ -281,32 +526,32 @@ func (f *Filterer) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
 				f.s.IncIn(p.Node)
 			}
 
-			// Loop
-			for {
-				// Pull filtered frame
-				if stop := f.pullFilteredFrame(p.Descriptor); stop {
-					return
+			// Loop through outputs
+			for _, o := range f.os {
+				for {
+					// Pull filtered frame
+					if stop := f.pullFilteredFrame(o, p.Descriptor); stop {
+						break
+					}
 				}
 			}
 		})
 	})
 }
 
-func (f *Filterer) pullFilteredFrame(descriptor Descriptor) (stop bool) {
+func (f *Filterer) pullFilteredFrame(o *filtererOutput, descriptor Descriptor) (stop bool) {
 	// Get frame
-	fm := f.d.p.get()
-	defer f.d.p.put(fm)
+	fm := o.d.p.get()
+	defer o.d.p.put(fm)
 
 	// Check switcher
-	if f.s != nil {
-		if stop = f.s.ShouldOut(); stop {
-			return
-		}
+	if stop = f.shouldOut(o); stop {
+		return
 	}
 
 	// Pull filtered frame from graph
 	f.statWorkRatio.Add(true)
-	if ret := f.g.AvBuffersinkGetFrame(f.bufferSinkCtx, fm); ret < 0 {
+	if ret := f.g.AvBuffersinkGetFrame(o.bufferSinkCtx, fm); ret < 0 {
 		f.statWorkRatio.Done(true)
 		if ret != avutil.AVERROR_EOF && ret != avutil.AVERROR_EAGAIN {
 			emitAvError(f, f.eh, ret, "f.g.AvBuffersinkGetFrame failed")
@@ -317,15 +562,236 @@ func (f *Filterer) pullFilteredFrame(descriptor Descriptor) (stop bool) {
 	f.statWorkRatio.Done(true)
 
 	// Increment switcher
-	if f.s != nil {
-		f.s.IncOut()
-	}
+	f.incOut(o)
 
 	// Dispatch frame
-	f.d.dispatch(fm, newFiltererDescriptor(f.bufferSinkCtx, descriptor))
+	o.d.dispatch(fm, newFiltererDescriptor(o.bufferSinkCtx, descriptor))
+	return
+}
+
+func (f *Filterer) shouldOut(o *filtererOutput) bool {
+	switch s := f.s.(type) {
+	case nil:
+		return false
+	case FiltererOutputSwitcher:
+		return s.ShouldOutput(o.name)
+	default:
+		return s.ShouldOut()
+	}
+}
+
+func (f *Filterer) incOut(o *filtererOutput) {
+	switch s := f.s.(type) {
+	case nil:
+	case FiltererOutputSwitcher:
+		s.IncOutput(o.name)
+	default:
+		// Switchers that don't handle outputs independently count frames of the first output only
+		if o == f.os[0] {
+			s.IncOut()
+		}
+	}
+}
+
+// checkInputFormat rebuilds the graph if the frame format is different from the one its buffer source has been
+// configured with
+func (f *Filterer) checkInputFormat(p *FrameHandlerPayload) (err error) {
+	// Get input
+	var n string
+	var i FiltererInput
+	var ok bool
+	for k, v := range f.o.Inputs {
+		if v.Node == p.Node {
+			n, i, ok = k, v, true
+			break
+		}
+	}
+
+	// Input not found
+	if !ok {
+		return
+	}
+
+	// Get reference format
+	// The first frame is compared to the format the graph has been configured with, which is usually retrieved from
+	// the stream and may therefore be incomplete. Following frames are compared to the previous frame.
+	fCtx := newContextFromFrame(p.Frame, i.Context.CodecType)
+	ref, changed := f.frameFormats[p.Node]
+	if !changed {
+		ref = i.Context
+	}
+
+	// Nothing changed
+	if ref.sameFrameFormat(fCtx) {
+		f.frameFormats[p.Node] = fCtx
+		return
+	}
+
+	// Rebuild graph
+	// The format is only considered as changed if it's different from the previous frame's
+	if err = f.rebuild(n, fCtx, p.Descriptor, changed); err != nil {
+		err = errors.Wrapf(err, "astilibav: rebuilding graph for input %s failed", n)
+		return
+	}
+
+	// Store format
+	// It's only stored once the graph has been rebuilt so that next frames try rebuilding it again otherwise
+	f.frameFormats[p.Node] = fCtx
 	return
 }
 
+// rebuild replaces the graph with one whose input matches the new frame format
+// Contrary to a switch, the filterer node, its inputs and its outputs stay the same: only the graph is replaced.
+// The previous graph is kept untouched when the new graph can't be created.
+// The graph mutex must be locked
+func (f *Filterer) rebuild(input string, fCtx Context, descriptor Descriptor, emitEvent bool) (err error) {
+	// Update options
+	// Inputs are copied since the map may be shared with the caller
+	o := f.o
+	o.Inputs = make(map[string]FiltererInput)
+	for k, v := range f.o.Inputs {
+		o.Inputs[k] = v
+	}
+	i := o.Inputs[input]
+	from := i.Context
+	switch i.Context.CodecType {
+	case avcodec.AVMEDIA_TYPE_AUDIO:
+		i.Context.ChannelLayout = fCtx.ChannelLayout
+		i.Context.Channels = fCtx.Channels
+		i.Context.SampleFmt = fCtx.SampleFmt
+		i.Context.SampleRate = fCtx.SampleRate
+	case avcodec.AVMEDIA_TYPE_VIDEO:
+		i.Context.Height = fCtx.Height
+		i.Context.PixelFormat = fCtx.PixelFormat
+		i.Context.SampleAspectRatio = fCtx.SampleAspectRatio
+		i.Context.Width = fCtx.Width
+	}
+	o.Inputs[input] = i
+
+	// Create graph
+	// It's created before flushing the previous graph so that the previous graph can still be used if it fails
+	g, gc, err := f.newGraph(o)
+	if err != nil {
+		err = errors.Wrap(err, "astilibav: creating graph failed")
+		return
+	}
+
+	// Flush previous graph so that frames it has buffered are not lost
+	// All inputs are flushed since the graph may need all of them to be ended before outputting its last frames
+	for _, bufferSrcCtx := range f.bufferSrcCtxs {
+		if ret := f.g.AvBuffersrcAddFrameFlags(bufferSrcCtx, nil, 0); ret < 0 {
+			emitAvError(f, f.eh, ret, "f.g.AvBuffersrcAddFrameFlags failed")
+		}
+	}
+	for _, o := range f.os {
+		for {
+			if stop := f.pullFilteredFrame(o, descriptor); stop {
+				break
+			}
+		}
+	}
+
+	// Swap graphs
+	f.swapGraph(o, g, gc)
+
+	// Emit event
+	if !emitEvent {
+		return
+	}
+	f.eh.Emit(astiencoder.Event{
+		Name: EventNameFormatChanged,
+		Payload: EventFormatChanged{
+			From: from,
+			To:   i.Context,
+		},
+		Target: f,
+	})
+	return
+}
+
+// updateContent replaces the graph with one created with the content returned by the content func
+// Frames have not been pushed in the graph yet, therefore it doesn't need to be flushed
+func (f *Filterer) updateContent(ctx context.Context) (err error) {
+	// Get content
+	var content string
+	if content, err = f.o.ContentFunc(ctx); err != nil {
+		err = errors.Wrap(err, "astilibav: getting content failed")
+		return
+	}
+
+	// Lock graph
+	f.gm.Lock()
+	defer f.gm.Unlock()
+
+	// Update options
+	// The content func is removed so that it's not called again by filterers created from these options
+	o := f.o
+	o.ContentFunc = nil
+	if len(content) > 0 {
+		o.Content = content
+	}
+
+	// Nothing changed
+	if o.Content == f.o.Content {
+		f.o = o
+		return
+	}
+
+	// Replace graph
+	if err = f.replaceGraph(o); err != nil {
+		err = errors.Wrap(err, "astilibav: replacing graph failed")
+		return
+	}
+	return
+}
+
+// The graph mutex must be locked
+func (f *Filterer) replaceGraph(o FiltererOptions) (err error) {
+	// Create graph
+	g, gc, err := f.newGraph(o)
+	if err != nil {
+		err = errors.Wrap(err, "astilibav: creating graph failed")
+		return
+	}
+
+	// Swap graphs
+	f.swapGraph(o, g, gc)
+	return
+}
+
+func (f *Filterer) newGraph(o FiltererOptions) (g *filterGraph, gc *astiencoder.Closer, err error) {
+	gc = f.cc.NewChild()
+	if g, err = newFilterGraph(o, gc); err != nil {
+		gc.Close()
+		err = errors.Wrap(err, "astilibav: creating filter graph failed")
+		return
+	}
+	return
+}
+
+// The graph mutex must be locked
+func (f *Filterer) swapGraph(o FiltererOptions, g *filterGraph, gc *astiencoder.Closer) {
+	// Index sinks
+	sinks := make(map[string]*avfilter.Context)
+	for _, s := range g.sinks {
+		sinks[s.name] = s.ctx
+	}
+
+	// Close previous graph
+	if errC := f.gc.Close(); errC != nil {
+		f.eh.Emit(astiencoder.EventError(f, errors.Wrap(errC, "astilibav: closing previous graph failed")))
+	}
+
+	// Swap graphs
+	f.bufferSrcCtxs = g.bufferSrcCtxs
+	f.g = g.g
+	f.gc = gc
+	f.o = o
+	for _, o := range f.os {
+		o.bufferSinkCtx = sinks[o.name]
+	}
+}
+
 // HandleFrame implements the FrameHandler interface
 func (f *Filterer) HandleFrame(p *FrameHandlerPayload) {
 	f.q.Send(p)
@@ -333,9 +799,20 @@ func (f *Filterer) HandleFrame(p *FrameHandlerPayload) {
 
 // SendCommand sends a command to the filterer
 func (f *Filterer) SendCommand(target, cmd, arg string, flags int) (err error) {
-	var res string
-	if ret := f.g.AvfilterGraphSendCommand(target, cmd, arg, res, 255, flags); ret < 0 {
-		err = errors.Wrapf(NewAvError(ret), "astilibav: f.g.AvfilterGraphSendCommand for target %s, cmd %s, arg %s and flag %d failed with res %s", target, cmd, arg, flags, res)
+	_, err = f.SendCommandWithResponse(target, cmd, arg, flags)
+	return
+}
+
+// SendCommandWithResponse sends a command to the filterer and returns libav's response
+// It implements the astiencoder.NodeCommander interface
+func (f *Filterer) SendCommandWithResponse(target, cmd, arg string, flags int) (res string, err error) {
+	// Lock
+	f.gm.Lock()
+	defer f.gm.Unlock()
+
+	// Send command
+	if res, err = sendFilterGraphCommand(f.g, target, cmd, arg, flags); err != nil {
+		err = errors.Wrapf(err, "astilibav: sending command for target %s, cmd %s, arg %s and flag %d failed", target, cmd, arg, flags)
 		return
 	}
 	return
@@ -349,34 +826,90 @@ type FiltererSwitchOptions struct {
 
 // Switch disconnects/stops/closes the current filterer and starts/connects the new filterer properly
 func (f *Filterer) Switch(opt FiltererSwitchOptions) (nf *Filterer, err error) {
+	var s *filtererSwitch
+	if s, err = f.startSwitch(opt); err != nil {
+		return
+	}
+	nf = s.nf
+	return
+}
+
+// filtererSwitch represents an ongoing filterer switch
+type filtererSwitch struct {
+	cancelled bool
+	f         *Filterer
+	m         *sync.Mutex // Locks cancelled, outDone and connections
+	nf        *Filterer
+	nns       map[astiencoder.Node]bool // Next filterer nodes
+	o         *sync.Once
+	outDone   bool
+	pns       map[astiencoder.Node]bool // Previous filterer nodes
+	removes   []func()
+}
+
+func (f *Filterer) startSwitch(opt FiltererSwitchOptions) (s *filtererSwitch, err error) {
 	// Create next filterer
+	var nf *Filterer
 	if nf, err = NewFilterer(opt.Filter, f.eh, f.c); err != nil {
 		err = errors.Wrap(err, "astilibav: creating new filterer failed")
 		return
 	}
 
 	// Connect next filterer to previous filterer's children
-	for _, c := range f.Children() {
-		nf.Connect(c.(FrameHandler))
+	var hs []FrameHandler
+	for _, o := range f.os {
+		for _, h := range o.d.handlers() {
+			if err = nf.ConnectOutput(o.name, h); err != nil {
+				err = errors.Wrapf(err, "astilibav: connecting output %s of new filterer failed", o.name)
+
+				// Make sure the next filterer doesn't stay in the workflow
+				for _, h := range hs {
+					nf.Disconnect(h)
+				}
+				if errC := nf.cc.Close(); errC != nil {
+					f.eh.Emit(astiencoder.EventError(f, errors.Wrap(errC, "astilibav: closing new filterer failed")))
+				}
+				return
+			}
+			hs = append(hs, h)
+		}
+	}
+
+	// Create switch
+	s = &filtererSwitch{
+		f:   f,
+		m:   &sync.Mutex{},
+		nf:  nf,
+		nns: make(map[astiencoder.Node]bool),
+		o:   &sync.Once{},
+		pns: make(map[astiencoder.Node]bool),
 	}
 
 	// Start next filterer
 	opt.Workflow.StartNodes(nf)
 
 	// Index previous filterer nodes
-	pns := make(map[astiencoder.Node]bool)
 	for _, n := range f.Parents() {
-		pns[n] = true
+		s.pns[n] = true
 	}
 
 	// Index next filterer nodes
-	nns := make(map[astiencoder.Node]bool)
 	for _, i := range opt.Filter.Inputs {
-		nns[i.Node] = true
+		s.nns[i.Node] = true
 	}
 
 	// Handle out
-	f.eh.Add(f, EventNameFiltererSwitchOutDone, func(e astiencoder.Event) bool {
+	s.removes = append(s.removes, f.eh.AddRemovable(f, EventNameFiltererSwitchOutDone, func(e astiencoder.Event) bool {
+		// Lock
+		s.m.Lock()
+		defer s.m.Unlock()
+
+		// Switch has been cancelled
+		if s.cancelled {
+			return true
+		}
+		s.outDone = true
+
 		// Disconnect previous filterer's children
 		for _, c := range f.Children() {
 			f.Disconnect(c.(FrameHandler))
@@ -393,39 +926,180 @@ func (f *Filterer) Switch(opt FiltererSwitchOptions) (nf *Filterer, err error) {
 		// Stop previous filterer
 		f.Stop()
 		return true
-	})
+	}))
 
 	// Handle in
-	o := &sync.Once{}
-	f.eh.Add(f, EventNameFiltererSwitchInDone, func(e astiencoder.Event) bool {
-		// Assert node
-		n := e.Payload.(astiencoder.Node)
-		c := e.Payload.(FrameHandlerConnector)
-
-		// Disconnect node
-		c.Disconnect(f)
-
-		// Connect node if part of next filterer's inputs
-		if _, ok := nns[n]; ok {
-			c.Connect(nf)
+	s.removes = append(s.removes, f.eh.AddRemovable(f, EventNameFiltererSwitchInDone, func(e astiencoder.Event) bool {
+		// Lock
+		s.m.Lock()
+		defer s.m.Unlock()
+
+		// Switch has been cancelled
+		if s.cancelled {
+			return true
 		}
 
-		// Connect other nodes only once
-		o.Do(func() {
-			for n := range nns {
-				if _, ok := pns[n]; !ok {
-					n.(FrameHandlerConnector).Connect(nf)
-				}
-			}
-		})
+		// Switch node
+		s.switchIn(e.Payload.(astiencoder.Node))
 		return len(f.Parents()) == 0
-	})
+	}))
 
 	// Switch
 	f.s.Switch()
 	return
 }
 
+// switchIn disconnects a node from the previous filterer and connects it to the next filterer if needed
+// The switch mutex must be locked
+func (s *filtererSwitch) switchIn(n astiencoder.Node) {
+	// Disconnect node
+	c := n.(FrameHandlerConnector)
+	c.Disconnect(s.f)
+
+	// Connect node if part of next filterer's inputs
+	if _, ok := s.nns[n]; ok {
+		c.Connect(s.nf)
+	}
+
+	// Connect other nodes only once
+	s.o.Do(func() {
+		for n := range s.nns {
+			if _, ok := s.pns[n]; !ok {
+				n.(FrameHandlerConnector).Connect(s.nf)
+			}
+		}
+	})
+}
+
+// cancel stops listening to switch events. If the previous filterer's outputs have not been switched yet, the switch is
+// rolled back and the next filterer is stopped. Otherwise, since the previous filterer is stopped, nodes that have not
+// been switched yet are switched right away.
+func (s *filtererSwitch) cancel() (rolledBack bool) {
+	// Lock
+	s.m.Lock()
+
+	// Remove listeners
+	s.cancelled = true
+	for _, r := range s.removes {
+		r()
+	}
+
+	// Previous filterer is stopped
+	if s.outDone {
+		for _, n := range s.f.Parents() {
+			s.switchIn(n)
+		}
+		s.m.Unlock()
+		return
+	}
+
+	// Unlock
+	// Switchers emit events while being locked, we therefore need to unlock before resetting the switcher.
+	// Listeners being cancelled, connections can't change anymore
+	s.m.Unlock()
+
+	// Reset previous filterer's switcher so that frames flow again
+	s.f.s.Reset()
+
+	// Reconnect nodes that have been switched
+	ps := make(map[astiencoder.Node]bool)
+	for _, n := range s.f.Parents() {
+		ps[n] = true
+	}
+	for n := range s.pns {
+		if _, ok := ps[n]; !ok {
+			n.(FrameHandlerConnector).Connect(s.f)
+		}
+	}
+
+	// Disconnect next filterer
+	for _, n := range s.nf.Parents() {
+		n.(FrameHandlerConnector).Disconnect(s.nf)
+	}
+	for _, c := range s.nf.Children() {
+		s.nf.Disconnect(c.(FrameHandler))
+	}
+
+	// Make sure to close the next filterer once stopped
+	s.nf.eh.Add(s.nf, astiencoder.EventNameNodeStopped, func(e astiencoder.Event) bool {
+		if err := s.nf.cc.Close(); err != nil {
+			s.nf.eh.Emit(astiencoder.EventError(s.nf, errors.Wrap(err, "astilibav: closing filterer failed")))
+		}
+		return true
+	})
+
+	// Stop next filterer
+	s.nf.Stop()
+	return true
+}
+
+// SwitchContent implements the astiencoder.NodeSwitcher interface
+// The new filterer reuses the inputs and outputs of the current filterer. It blocks until both the in and out switches
+// are done or the context is done. In the latter case, the switch is rolled back if the outputs have not been switched
+// yet and completed otherwise, and the node that ends up in the workflow is returned alongside the error.
+func (f *Filterer) SwitchContent(ctx context.Context, w *astiencoder.Workflow, content string) (n astiencoder.Node, es []astiencoder.Event, err error) {
+	// Create options
+	o := f.o
+	o.Content = content
+	o.ContentFunc = nil
+	o.Node = astiencoder.NodeOptions{NoIndirectStop: f.o.Node.NoIndirectStop}
+
+	// Listen to switch events
+	// Listeners need to be added before switching since events may be emitted right away
+	ins := len(f.Parents())
+	ch := make(chan astiencoder.Event, ins+1)
+	send := func(e astiencoder.Event) {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+	removeIn := f.eh.AddRemovable(f, EventNameFiltererSwitchInDone, func(e astiencoder.Event) bool {
+		send(e)
+		return false
+	})
+	defer removeIn()
+	removeOut := f.eh.AddRemovable(f, EventNameFiltererSwitchOutDone, func(e astiencoder.Event) bool {
+		send(e)
+		return true
+	})
+	defer removeOut()
+
+	// Switch
+	var s *filtererSwitch
+	if s, err = f.startSwitch(FiltererSwitchOptions{
+		Filter:   o,
+		Workflow: w,
+	}); err != nil {
+		err = errors.Wrap(err, "astilibav: switching filterer failed")
+		return
+	}
+	n = s.nf
+
+	// Wait for events
+	// Without parents, only the out switch is waited for
+	var inCount int
+	var outDone bool
+	for inCount < ins || !outDone {
+		select {
+		case e := <-ch:
+			es = append(es, e)
+			if e.Name == EventNameFiltererSwitchOutDone {
+				outDone = true
+			} else {
+				inCount++
+			}
+		case <-ctx.Done():
+			if s.cancel() {
+				n = f
+			}
+			err = errors.Wrap(ctx.Err(), "astilibav: waiting for switch events failed")
+			return
+		}
+	}
+	return
+}
+
 type filtererDescriptor struct {
 	timeBase avutil.Rational
 }
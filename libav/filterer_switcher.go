@@ -16,14 +16,23 @@ type FiltererSwitcher interface {
 	Switch()
 }
 
+// FiltererOutputSwitcher represents a filterer switcher that synchronizes each filterer output independently
+// Outputs are identified by their name. When a switcher doesn't implement this interface, IncOut is only called for
+// the first output of the filterer
+type FiltererOutputSwitcher interface {
+	FiltererSwitcher
+	IncOutput(output string)
+	ShouldOutput(output string) (ko bool)
+}
+
 type filtererSwitcher struct {
 	eh *astiencoder.EventHandler
 	f  *Filterer
 	is map[astiencoder.Node]int
 	l  int
 	m  *sync.Mutex
-	o  int
-	os *sync.Once
+	o  *sync.Once
+	os map[string]int
 }
 
 func newFiltererSwitcher(f *Filterer, eh *astiencoder.EventHandler) *filtererSwitcher {
@@ -32,7 +41,8 @@ func newFiltererSwitcher(f *Filterer, eh *astiencoder.EventHandler) *filtererSwi
 		f:  f,
 		is: make(map[astiencoder.Node]int),
 		m:  &sync.Mutex{},
-		os: &sync.Once{},
+		o:  &sync.Once{},
+		os: make(map[string]int),
 	}
 }
 
@@ -56,29 +66,49 @@ func (s *filtererSwitcher) IncIn(n astiencoder.Node) {
 	return
 }
 
+// IncOut implements the FiltererSwitcher interface
+// It increments the first output
 func (s *filtererSwitcher) IncOut() {
+	if len(s.f.os) > 0 {
+		s.IncOutput(s.f.os[0].name)
+	}
+}
+
+// IncOutput implements the FiltererOutputSwitcher interface
+func (s *filtererSwitcher) IncOutput(output string) {
 	// Lock
 	s.m.Lock()
 	defer s.m.Unlock()
 
 	// Increment
-	s.o++
+	s.os[output]++
 
 	// Send event
-	if s.l > 0 && s.o == s.l {
+	if s.l > 0 && s.os[output] == s.l && s.outputsDone() {
 		s.emitEventOut()
 	}
 }
 
+// outputsDone checks whether all outputs have reached the limit
+func (s *filtererSwitcher) outputsDone() bool {
+	for _, o := range s.f.os {
+		if s.os[o.name] != s.l {
+			return false
+		}
+	}
+	return true
+}
+
 func (s *filtererSwitcher) Reset() {
 	// Lock
 	s.m.Lock()
 	defer s.m.Unlock()
 
 	// Reset
+	s.l = 0
 	s.is = make(map[astiencoder.Node]int)
-	s.o = 0
-	s.os = &sync.Once{}
+	s.o = &sync.Once{}
+	s.os = make(map[string]int)
 }
 
 func (s *filtererSwitcher) ShouldIn(n astiencoder.Node) (ko bool) {
@@ -98,20 +128,30 @@ func (s *filtererSwitcher) ShouldIn(n astiencoder.Node) (ko bool) {
 	return
 }
 
+// ShouldOut implements the FiltererSwitcher interface
+// It checks the first output
 func (s *filtererSwitcher) ShouldOut() (ko bool) {
+	if len(s.f.os) > 0 {
+		return s.ShouldOutput(s.f.os[0].name)
+	}
+	return
+}
+
+// ShouldOutput implements the FiltererOutputSwitcher interface
+func (s *filtererSwitcher) ShouldOutput(output string) (ko bool) {
 	// Lock
 	s.m.Lock()
 	defer s.m.Unlock()
 
 	// Check limit
-	if s.l > 0 && s.o+1 > s.l {
+	if s.l > 0 && s.os[output]+1 > s.l {
 		return true
 	}
 	return
 }
 
 func (s *filtererSwitcher) Switch() {
-	s.os.Do(func() {
+	s.o.Do(func() {
 		// Lock
 		s.m.Lock()
 		defer s.m.Unlock()
@@ -131,7 +171,7 @@ func (s *filtererSwitcher) Switch() {
 		}
 
 		// Send out event if limit has already been reached
-		if s.o == s.l {
+		if s.outputsDone() {
 			s.emitEventOut()
 		}
 	})
@@ -0,0 +1,80 @@
+package astilibav
+
+import (
+	"context"
+	"testing"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astitools/worker"
+	"github.com/stretchr/testify/assert"
+)
+
+type testNode struct {
+	*astiencoder.BaseNode
+}
+
+func newTestNode(name string, eh *astiencoder.EventHandler) (n *testNode) {
+	n = &testNode{}
+	n.BaseNode = astiencoder.NewBaseNode(astiencoder.NodeOptions{Metadata: astiencoder.NodeMetadata{Name: name}}, astiencoder.NewEventGeneratorNode(n), eh)
+	return
+}
+
+// Start implements the Starter interface
+func (n *testNode) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	n.BaseNode.Start(ctx, t, func(t *astiworker.Task) {
+		<-n.Context().Done()
+	})
+}
+
+func TestFiltererSwitcher(t *testing.T) {
+	// Create switcher
+	eh := astiencoder.NewEventHandler()
+	f := &Filterer{os: []*filtererOutput{{name: "a"}, {name: "b"}}}
+	s := newFiltererSwitcher(f, eh)
+	var ins []astiencoder.Node
+	var outs int
+	eh.AddForEventName(EventNameFiltererSwitchInDone, func(e astiencoder.Event) bool {
+		ins = append(ins, e.Payload.(astiencoder.Node))
+		return false
+	})
+	eh.AddForEventName(EventNameFiltererSwitchOutDone, func(e astiencoder.Event) bool {
+		outs++
+		return false
+	})
+
+	// No limit before the switch
+	n := newTestNode("source", eh)
+	s.IncIn(n)
+	s.IncIn(n)
+	s.IncOutput("a")
+	assert.False(t, s.ShouldIn(n))
+	assert.False(t, s.ShouldOutput("a"))
+
+	// Switch
+	s.Switch()
+	assert.Equal(t, []astiencoder.Node{n}, ins)
+	assert.True(t, s.ShouldIn(n))
+
+	// Outputs are synchronized independently
+	s.IncOutput("a")
+	assert.True(t, s.ShouldOutput("a"))
+	assert.True(t, s.ShouldOut())
+	assert.False(t, s.ShouldOutput("b"))
+	assert.Equal(t, 0, outs)
+	s.IncOutput("b")
+	s.IncOutput("b")
+	assert.True(t, s.ShouldOutput("b"))
+	assert.Equal(t, 1, outs)
+
+	// Reset
+	s.Reset()
+	assert.False(t, s.ShouldOutput("a"))
+	s.IncOut()
+	assert.False(t, s.ShouldOutput("b"))
+
+	// Limit is reset as well
+	for i := 0; i < 3; i++ {
+		s.IncIn(n)
+	}
+	assert.False(t, s.ShouldIn(n))
+}
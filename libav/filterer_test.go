@@ -0,0 +1,96 @@
+package astilibav
+
+import (
+	"testing"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avfilter"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestFilterer(t *testing.T, content string, outputs map[string]Context, eh *astiencoder.EventHandler, c *astiencoder.Closer) (f *Filterer, n *testNode) {
+	n = newTestNode("in", eh)
+	var err error
+	if f, err = NewFilterer(FiltererOptions{
+		Content: content,
+		Inputs: map[string]FiltererInput{"in": {
+			Context: Context{
+				CodecType:         avcodec.AVMEDIA_TYPE_VIDEO,
+				Height:            4,
+				PixelFormat:       avutil.AV_PIX_FMT_YUV420P,
+				SampleAspectRatio: avutil.NewRational(1, 1),
+				TimeBase:          avutil.NewRational(1, 25),
+				Width:             4,
+			},
+			Node: n,
+		}},
+		Outputs: outputs,
+	}, eh, c); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestFiltererSendCommandWithResponse(t *testing.T) {
+	c := astiencoder.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	f, _ := newTestFilterer(t, "[in]null", nil, eh, c)
+
+	// libav's response is returned
+	res, err := f.SendCommandWithResponse("all", "ping", "", 0)
+	assert.NoError(t, err)
+	assert.Contains(t, res, "pong from:null")
+
+	// Unknown target
+	_, err = f.SendCommandWithResponse("invalid", "ping", "", 0)
+	assert.Error(t, err)
+}
+
+func TestFiltererStartSwitchFailure(t *testing.T) {
+	c := astiencoder.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	f, _ := newTestFilterer(t, "[in]split[a][b]", map[string]Context{"a": {}, "b": {}}, eh, c)
+	ha, hb := newTestFrameHandler(avcodec.AVMEDIA_TYPE_VIDEO, eh), newTestFrameHandler(avcodec.AVMEDIA_TYPE_VIDEO, eh)
+	assert.NoError(t, f.ConnectOutput("a", ha))
+	assert.NoError(t, f.ConnectOutput("b", hb))
+
+	// The next filterer only has one of the outputs
+	o := f.o
+	o.Content = "[in]null[a]"
+	o.Outputs = map[string]Context{"a": {}}
+	_, err := f.startSwitch(FiltererSwitchOptions{Filter: o})
+	assert.Error(t, err)
+
+	// Handlers are only connected to the previous filterer
+	assert.Equal(t, []astiencoder.Node{f}, ha.Parents())
+	assert.Equal(t, []astiencoder.Node{f}, hb.Parents())
+}
+
+func TestFiltererRebuildFailure(t *testing.T) {
+	c := astiencoder.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	f, n := newTestFilterer(t, "[in]crop=4:4", nil, eh, c)
+	g := f.g
+
+	// The graph can't be rebuilt since the frame is smaller than the cropped area
+	fm := avutil.AvFrameAlloc()
+	defer avutil.AvFrameFree(fm)
+	p := &FrameHandlerPayload{Descriptor: newTimeBaseDescriptor(avutil.NewRational(1, 25)), Frame: fm, Node: n}
+	newTestScalerFrame(t, fm, 2, 2)
+	assert.Error(t, f.checkInputFormat(p))
+	assert.True(t, g == f.g)
+	assert.Equal(t, 4, f.o.Inputs["in"].Context.Width)
+	_, ok := f.frameFormats[n]
+	assert.False(t, ok)
+
+	// The previous graph has not been flushed and can still be used
+	newTestScalerFrame(t, fm, 4, 4)
+	assert.NoError(t, f.checkInputFormat(p))
+	assert.True(t, g == f.g)
+	assert.True(t, f.g.AvBuffersrcAddFrameFlags(f.bufferSrcCtxs[n], fm, avfilter.AV_BUFFERSRC_FLAG_KEEP_REF) >= 0)
+}
@@ -63,6 +63,15 @@ func (d *frameDispatcher) delHandler(h FrameHandler) {
 	delete(d.hs, h.Metadata().Name)
 }
 
+func (d *frameDispatcher) handlers() (hs []FrameHandler) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	for _, h := range d.hs {
+		hs = append(hs, h)
+	}
+	return
+}
+
 func (d *frameDispatcher) dispatch(f *avutil.Frame, descriptor Descriptor) {
 	// Copy handlers
 	d.m.Lock()
@@ -0,0 +1,41 @@
+package astilibav
+
+//#cgo pkg-config: libavutil
+//#include <libavutil/frame.h>
+//#include <libavutil/imgutils.h>
+//static int astilibav_frame_image_buffer_size(const AVFrame *f) {
+//	return av_image_get_buffer_size(f->format, f->width, f->height, 1);
+//}
+//static int astilibav_frame_image_copy_to_buffer(uint8_t *dst, int size, const AVFrame *f) {
+//	return av_image_copy_to_buffer(dst, size, (const uint8_t * const *)f->data, f->linesize, f->format, f->width, f->height, 1);
+//}
+import "C"
+import (
+	"unsafe"
+
+	"github.com/asticode/goav/avutil"
+	"github.com/pkg/errors"
+)
+
+// frameImageBuffer copies the planes of a video frame one after the other, without padding, whatever its pixel format
+// goav doesn't expose av_image_copy_to_buffer, we therefore call it in C
+func frameImageBuffer(f *avutil.Frame) (b []byte, err error) {
+	// Get size
+	cf := (*C.AVFrame)(unsafe.Pointer(f))
+	size := C.astilibav_frame_image_buffer_size(cf)
+	if size < 0 {
+		err = errors.Wrap(NewAvError(int(size)), "astilibav: av_image_get_buffer_size failed")
+		return
+	}
+
+	// Copy
+	b = make([]byte, int(size))
+	if size == 0 {
+		return
+	}
+	if ret := C.astilibav_frame_image_copy_to_buffer((*C.uint8_t)(unsafe.Pointer(&b[0])), size, cf); ret < 0 {
+		err = errors.Wrap(NewAvError(int(ret)), "astilibav: av_image_copy_to_buffer failed")
+		return
+	}
+	return
+}
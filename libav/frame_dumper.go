@@ -0,0 +1,318 @@
+package astilibav
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astitools/stat"
+	"github.com/asticode/go-astitools/sync"
+	"github.com/asticode/go-astitools/worker"
+	"github.com/asticode/goav/avutil"
+	"github.com/pkg/errors"
+)
+
+var countFrameDumper uint64
+
+// Frame dumper formats
+const (
+	FrameDumperFormatJPEG = "jpeg"
+	FrameDumperFormatPNG  = "png"
+	// Video frames are written in their pixel format as their planes one after the other, without padding, and audio
+	// frames as interleaved little-endian samples. Successive frames dumped to the same path are appended
+	FrameDumperFormatRaw = "raw"
+)
+
+// FrameDumper represents an object capable of dumping frames
+type FrameDumper struct {
+	*astiencoder.BaseNode
+	count            uint32
+	eh               *astiencoder.EventHandler
+	incoming         uint64
+	lastPts          *time.Duration
+	o                FrameDumperOptions
+	paths            map[string]bool
+	q                *astisync.CtxQueue
+	statDumpedRate   *astistat.IncrementStat
+	statIncomingRate *astistat.IncrementStat
+	statWorkRatio    *astistat.DurationRatioStat
+	t                *template.Template
+}
+
+// FrameDumperOptions represents frame dumper options
+// When neither frame interval nor time interval is provided, every frame is dumped
+type FrameDumperOptions struct {
+	// Available in the pattern along with "count" (number of dumped frames, starting at 1) and "pts"
+	// Since frames don't carry their stream index, "stream_idx" should be provided here when needed. It's copied and
+	// can therefore be modified once the frame dumper is created
+	Data map[string]interface{}
+	// Possible values are "jpeg", "png" (default) and "raw"
+	Format string
+	// A frame is dumped every n frames
+	FrameInterval int
+	// Between 1 and 100. Defaults to jpeg.DefaultQuality
+	JPEGQuality int
+	Node        astiencoder.NodeOptions
+	// Template of the path frames are dumped to
+	Pattern string
+	// A frame is dumped every time its pts has moved forward by the interval since the previous dumped frame
+	TimeInterval time.Duration
+}
+
+// NewFrameDumper creates a new frame dumper
+func NewFrameDumper(o FrameDumperOptions, eh *astiencoder.EventHandler) (d *FrameDumper, err error) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countFrameDumper, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("frame_dumper_%d", count), fmt.Sprintf("Frame Dumper #%d", count), "Dumps frames")
+
+	// Copy data since "count" and "pts" are updated for every dumped frame
+	data := make(map[string]interface{})
+	for k, v := range o.Data {
+		data[k] = v
+	}
+	o.Data = data
+
+	// Default options
+	if len(o.Format) == 0 {
+		o.Format = FrameDumperFormatPNG
+	}
+	if o.JPEGQuality <= 0 {
+		o.JPEGQuality = jpeg.DefaultQuality
+	}
+
+	// Create frame dumper
+	d = &FrameDumper{
+		eh:               eh,
+		o:                o,
+		paths:            make(map[string]bool),
+		q:                astisync.NewCtxQueue(),
+		statDumpedRate:   astistat.NewIncrementStat(),
+		statIncomingRate: astistat.NewIncrementStat(),
+		statWorkRatio:    astistat.NewDurationRatioStat(),
+	}
+	d.BaseNode = astiencoder.NewBaseNode(o.Node, astiencoder.NewEventGeneratorNode(d), eh)
+	d.addStats()
+
+	// Check format
+	switch o.Format {
+	case FrameDumperFormatJPEG, FrameDumperFormatPNG, FrameDumperFormatRaw:
+	default:
+		err = fmt.Errorf("astilibav: invalid format %s", o.Format)
+		return
+	}
+
+	// Parse pattern
+	if d.t, err = template.New("").Parse(o.Pattern); err != nil {
+		err = errors.Wrapf(err, "astilibav: parsing pattern %s as template failed", o.Pattern)
+		return
+	}
+	return
+}
+
+func (d *FrameDumper) addStats() {
+	// Add incoming rate
+	d.Stater().AddStat(astistat.StatMetadata{
+		Description: "Number of frames coming in per second",
+		Label:       "Incoming rate",
+		Unit:        "fps",
+	}, d.statIncomingRate)
+
+	// Add dumped rate
+	d.Stater().AddStat(astistat.StatMetadata{
+		Description: "Number of frames dumped per second",
+		Label:       "Dumped rate",
+		Unit:        "fps",
+	}, d.statDumpedRate)
+
+	// Add work ratio
+	d.Stater().AddStat(astistat.StatMetadata{
+		Description: "Percentage of time spent doing some actual work",
+		Label:       "Work ratio",
+		Unit:        "%",
+	}, d.statWorkRatio)
+
+	// Add queue stats
+	d.q.AddStats(d.Stater())
+}
+
+// Start starts the frame dumper
+func (d *FrameDumper) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	d.BaseNode.Start(ctx, t, func(t *astiworker.Task) {
+		// Handle context
+		go d.q.HandleCtx(d.Context())
+
+		// Make sure to stop the queue properly
+		defer d.q.Stop()
+
+		// Start queue
+		d.q.Start(func(dp interface{}) {
+			// Handle pause
+			defer d.HandlePause()
+
+			// Assert payload
+			p := dp.(*FrameHandlerPayload)
+
+			// Increment incoming rate
+			d.statIncomingRate.Add(1)
+
+			// Frame should not be dumped
+			if !d.shouldDump(p) {
+				return
+			}
+
+			// Dump
+			d.statWorkRatio.Add(true)
+			if err := d.dump(p); err != nil {
+				d.statWorkRatio.Done(true)
+				d.eh.Emit(astiencoder.EventError(d, errors.Wrap(err, "astilibav: dumping frame failed")))
+				return
+			}
+			d.statWorkRatio.Done(true)
+		})
+	})
+}
+
+func (d *FrameDumper) shouldDump(p *FrameHandlerPayload) bool {
+	// Increment incoming count
+	d.incoming++
+
+	// Check frame interval
+	if d.o.FrameInterval > 1 && (d.incoming-1)%uint64(d.o.FrameInterval) != 0 {
+		return false
+	}
+
+	// Check time interval
+	if d.o.TimeInterval > 0 {
+		pts := time.Duration(avutil.AvRescaleQ(p.Frame.Pts(), p.Descriptor.TimeBase(), nanosecondRational))
+		if d.lastPts != nil && pts-*d.lastPts < d.o.TimeInterval {
+			return false
+		}
+		d.lastPts = &pts
+	}
+	return true
+}
+
+func (d *FrameDumper) dump(p *FrameHandlerPayload) (err error) {
+	// Increment count
+	c := atomic.AddUint32(&d.count, 1)
+
+	// Update data
+	d.o.Data["count"] = c
+	d.o.Data["pts"] = p.Frame.Pts()
+
+	// Execute template
+	buf := &bytes.Buffer{}
+	if err = d.t.Execute(buf, d.o.Data); err != nil {
+		err = errors.Wrapf(err, "astilibav: executing template %s with data %+v failed", d.o.Pattern, d.o.Data)
+		return
+	}
+	path := buf.String()
+
+	// Open file
+	// Raw frames dumped to the same path are appended
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if d.o.Format == FrameDumperFormatRaw && d.paths[path] {
+		flag = os.O_APPEND | os.O_WRONLY
+	}
+	var f *os.File
+	if f, err = os.OpenFile(path, flag, 0666); err != nil {
+		err = errors.Wrapf(err, "astilibav: opening file %s failed", path)
+		return
+	}
+	defer f.Close()
+
+	// Store path
+	// Only raw frames are appended, therefore paths are not stored for other formats so that they don't pile up
+	if d.o.Format == FrameDumperFormatRaw {
+		d.paths[path] = true
+	}
+
+	// Write frame
+	if err = d.write(f, p.Frame); err != nil {
+		err = errors.Wrapf(err, "astilibav: writing frame to %s failed", path)
+		return
+	}
+
+	// Increment dumped rate
+	d.statDumpedRate.Add(1)
+	return
+}
+
+func (d *FrameDumper) write(w io.Writer, f *avutil.Frame) (err error) {
+	// Audio frame
+	if f.NbSamples() > 0 {
+		// Only raw is supported
+		if d.o.Format != FrameDumperFormatRaw {
+			err = fmt.Errorf("astilibav: format %s is not supported for audio frames", d.o.Format)
+			return
+		}
+
+		// Get samples
+		var s AudioSamples
+		if s, err = frameAudioSamples(f); err != nil {
+			err = errors.Wrap(err, "astilibav: getting audio samples failed")
+			return
+		}
+
+		// Write samples
+		if err = binary.Write(w, binary.LittleEndian, s.interleaved()); err != nil {
+			err = errors.Wrap(err, "astilibav: writing audio samples failed")
+			return
+		}
+		return
+	}
+
+	// Raw video frames are written in their pixel format
+	if d.o.Format == FrameDumperFormatRaw {
+		// Get buffer
+		var b []byte
+		if b, err = frameImageBuffer(f); err != nil {
+			err = errors.Wrap(err, "astilibav: getting image buffer failed")
+			return
+		}
+
+		// Write buffer
+		if _, err = w.Write(b); err != nil {
+			err = errors.Wrap(err, "astilibav: writing image buffer failed")
+			return
+		}
+		return
+	}
+
+	// Get image
+	var i image.Image
+	if i, err = frameImage(f); err != nil {
+		err = errors.Wrap(err, "astilibav: getting image failed")
+		return
+	}
+
+	// Switch on format
+	switch d.o.Format {
+	case FrameDumperFormatJPEG:
+		if err = jpeg.Encode(w, i, &jpeg.Options{Quality: d.o.JPEGQuality}); err != nil {
+			err = errors.Wrap(err, "astilibav: encoding jpeg failed")
+			return
+		}
+	case FrameDumperFormatPNG:
+		if err = png.Encode(w, i); err != nil {
+			err = errors.Wrap(err, "astilibav: encoding png failed")
+			return
+		}
+	}
+	return
+}
+
+// HandleFrame implements the FrameHandler interface
+func (d *FrameDumper) HandleFrame(p *FrameHandlerPayload) {
+	d.q.Send(p)
+}
@@ -0,0 +1,146 @@
+package astilibav
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestNV12Frame(t *testing.T) *avutil.Frame {
+	f := avutil.AvFrameAlloc()
+	f.SetFormat(int(avutil.AV_PIX_FMT_NV12))
+	f.SetHeight(2)
+	f.SetWidth(2)
+	if ret := avutil.AvFrameGetBuffer(f, 0); ret < 0 {
+		t.Fatal(NewAvError(ret))
+	}
+	y, yLinesize := framePlane(f, 0, 2)
+	copy(y[:2], []uint8{1, 2})
+	copy(y[yLinesize:yLinesize+2], []uint8{3, 4})
+	uv, _ := framePlane(f, 1, 1)
+	copy(uv[:2], []uint8{5, 6})
+	return f
+}
+
+func TestNewFrameDumper(t *testing.T) {
+	// Invalid format
+	_, err := NewFrameDumper(FrameDumperOptions{Format: "invalid"}, astiencoder.NewEventHandler())
+	assert.Error(t, err)
+
+	// Data is copied
+	data := map[string]interface{}{"input": "default"}
+	d, err := NewFrameDumper(FrameDumperOptions{Data: data}, astiencoder.NewEventHandler())
+	assert.NoError(t, err)
+	assert.Equal(t, FrameDumperFormatPNG, d.o.Format)
+	data["input"] = "updated"
+	assert.Equal(t, "default", d.o.Data["input"])
+}
+
+func TestFrameDumperShouldDump(t *testing.T) {
+	f := avutil.AvFrameAlloc()
+	defer avutil.AvFrameFree(f)
+	p := &FrameHandlerPayload{Descriptor: newTimeBaseDescriptor(avutil.NewRational(1, 10)), Frame: f}
+
+	// Frame interval
+	d, err := NewFrameDumper(FrameDumperOptions{FrameInterval: 2}, astiencoder.NewEventHandler())
+	assert.NoError(t, err)
+	var dumped []bool
+	for idx := 0; idx < 4; idx++ {
+		dumped = append(dumped, d.shouldDump(p))
+	}
+	assert.Equal(t, []bool{true, false, true, false}, dumped)
+
+	// Time interval
+	d, err = NewFrameDumper(FrameDumperOptions{TimeInterval: time.Second}, astiencoder.NewEventHandler())
+	assert.NoError(t, err)
+	dumped = []bool{}
+	for _, pts := range []int64{0, 5, 10, 15, 25} {
+		f.SetPts(pts)
+		dumped = append(dumped, d.shouldDump(p))
+	}
+	assert.Equal(t, []bool{true, false, true, false, true}, dumped)
+}
+
+func TestFrameDumperWrite(t *testing.T) {
+	// Raw video frames keep their pixel format
+	f := newTestNV12Frame(t)
+	defer avutil.AvFrameFree(f)
+	d, err := NewFrameDumper(FrameDumperOptions{Format: FrameDumperFormatRaw}, astiencoder.NewEventHandler())
+	assert.NoError(t, err)
+	buf := &bytes.Buffer{}
+	assert.NoError(t, d.write(buf, f))
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6}, buf.Bytes())
+
+	// Raw audio frames are interleaved
+	a := avutil.AvFrameAlloc()
+	defer avutil.AvFrameFree(a)
+	a.SetChannelLayout(stereoChannelLayout)
+	a.SetChannels(2)
+	assert.NoError(t, writeAudioSamples(AudioSamples{Uint8: [][]uint8{{1, 2}, {3, 4}}}, a))
+	buf.Reset()
+	assert.NoError(t, d.write(buf, a))
+	assert.Equal(t, []byte{1, 3, 2, 4}, buf.Bytes())
+
+	// Png
+	d, err = NewFrameDumper(FrameDumperOptions{}, astiencoder.NewEventHandler())
+	assert.NoError(t, err)
+	assert.Error(t, d.write(buf, a))
+	g := avutil.AvFrameAlloc()
+	defer avutil.AvFrameFree(g)
+	assert.NoError(t, writeImage(image.NewGray(image.Rect(0, 0, 3, 2)), g))
+	buf.Reset()
+	assert.NoError(t, d.write(buf, g))
+	i, err := png.Decode(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 3, 2), i.Bounds())
+}
+
+func TestFrameDumperDump(t *testing.T) {
+	// Create temp dir
+	dir, err := ioutil.TempDir("", "astilibav_frame_dumper_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Create frame dumper
+	d, err := NewFrameDumper(FrameDumperOptions{
+		Data:    map[string]interface{}{"stream_idx": 1},
+		Format:  FrameDumperFormatRaw,
+		Pattern: filepath.Join(dir, "{{.stream_idx}}.yuv"),
+	}, astiencoder.NewEventHandler())
+	assert.NoError(t, err)
+
+	// Raw frames dumped to the same path are appended
+	f := newTestNV12Frame(t)
+	defer avutil.AvFrameFree(f)
+	p := &FrameHandlerPayload{Descriptor: newTimeBaseDescriptor(avutil.NewRational(1, 10)), Frame: f}
+	assert.NoError(t, d.dump(p))
+	assert.NoError(t, d.dump(p))
+	b, err := ioutil.ReadFile(filepath.Join(dir, "1.yuv"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6, 1, 2, 3, 4, 5, 6}, b)
+	assert.Equal(t, uint32(2), d.o.Data["count"])
+	assert.Len(t, d.paths, 1)
+
+	// Paths are only stored for raw frames
+	d, err = NewFrameDumper(FrameDumperOptions{Pattern: filepath.Join(dir, "{{.count}}.png")}, astiencoder.NewEventHandler())
+	assert.NoError(t, err)
+	newTestGrayFrame(t, f, 128, 0)
+	assert.NoError(t, d.dump(p))
+	assert.NoError(t, d.dump(p))
+	assert.Len(t, d.paths, 0)
+	for _, n := range []string{"1.png", "2.png"} {
+		_, err = os.Stat(filepath.Join(dir, n))
+		assert.NoError(t, err)
+	}
+}
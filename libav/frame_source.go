@@ -0,0 +1,198 @@
+package astilibav
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"sync/atomic"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astitools/stat"
+	"github.com/asticode/go-astitools/sync"
+	"github.com/asticode/go-astitools/worker"
+	"github.com/asticode/goav/avutil"
+	"github.com/pkg/errors"
+)
+
+var countFrameSource uint64
+
+// FrameSource represents an object capable of dispatching frames created out of images and audio samples pushed
+// from Go
+type FrameSource struct {
+	*astiencoder.BaseNode
+	d              *frameDispatcher
+	descriptor     Descriptor
+	eh             *astiencoder.EventHandler
+	o              FrameSourceOptions
+	q              *astisync.CtxQueue
+	statPushedRate *astistat.IncrementStat
+	statWorkRatio  *astistat.DurationRatioStat
+}
+
+// FrameSourceOptions represents frame source options
+type FrameSourceOptions struct {
+	// Time base is used for every frame. Audio frames use channel layout (or channels) and sample rate as well, and
+	// video frames use color range and sample aspect ratio
+	// Pixel format and sample format are deduced from what is pushed: *image.YCbCr become planar yuv, *image.Gray
+	// become gray8, other images become rgba, and audio samples become their planar sample format (e.g. Float32
+	// become fltp). Unless color range is "jpeg", yuv pixels are compressed to the limited range
+	Ctx  Context
+	Node astiencoder.NodeOptions
+}
+
+// NewFrameSource creates a new frame source
+func NewFrameSource(o FrameSourceOptions, eh *astiencoder.EventHandler, c *astiencoder.Closer) (s *FrameSource) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countFrameSource, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("frame_source_%d", count), fmt.Sprintf("Frame Source #%d", count), "Sources frames")
+
+	// Create source
+	s = &FrameSource{
+		descriptor:     newTimeBaseDescriptor(o.Ctx.TimeBase),
+		eh:             eh,
+		o:              o,
+		q:              astisync.NewCtxQueue(),
+		statPushedRate: astistat.NewIncrementStat(),
+		statWorkRatio:  astistat.NewDurationRatioStat(),
+	}
+	s.BaseNode = astiencoder.NewBaseNode(o.Node, astiencoder.NewEventGeneratorNode(s), eh)
+	s.d = newFrameDispatcher(s, eh, c)
+	s.addStats()
+	return
+}
+
+func (s *FrameSource) addStats() {
+	// Add pushed rate
+	s.Stater().AddStat(astistat.StatMetadata{
+		Description: "Number of frames pushed per second",
+		Label:       "Pushed rate",
+		Unit:        "fps",
+	}, s.statPushedRate)
+
+	// Add work ratio
+	s.Stater().AddStat(astistat.StatMetadata{
+		Description: "Percentage of time spent doing some actual work",
+		Label:       "Work ratio",
+		Unit:        "%",
+	}, s.statWorkRatio)
+
+	// Add dispatcher stats
+	s.d.addStats(s.Stater())
+
+	// Add queue stats
+	s.q.AddStats(s.Stater())
+}
+
+// Connect implements the FrameHandlerConnector interface
+func (s *FrameSource) Connect(h FrameHandler) {
+	// Add handler
+	s.d.addHandler(h)
+
+	// Connect nodes
+	astiencoder.ConnectNodes(s, h)
+}
+
+// Disconnect implements the FrameHandlerConnector interface
+func (s *FrameSource) Disconnect(h FrameHandler) {
+	// Delete handler
+	s.d.delHandler(h)
+
+	// Disconnect nodes
+	astiencoder.DisconnectNodes(s, h)
+}
+
+// Start starts the frame source
+func (s *FrameSource) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	s.BaseNode.Start(ctx, t, func(t *astiworker.Task) {
+		// Handle context
+		go s.q.HandleCtx(s.Context())
+
+		// Make sure to wait for all dispatcher subprocesses to be done so that they are properly closed
+		defer s.d.wait()
+
+		// Make sure to stop the queue properly
+		defer s.q.Stop()
+
+		// Start queue
+		s.q.Start(func(dp interface{}) {
+			// Handle pause
+			defer s.HandlePause()
+
+			// Assert payload
+			f := dp.(*avutil.Frame)
+
+			// Make sure the frame is put back in the pool once dispatched
+			defer s.d.p.put(f)
+
+			// Dispatch frame
+			s.d.dispatch(f, s.descriptor)
+		})
+	})
+}
+
+// Push converts an image into a video frame and dispatches it asynchronously
+// pts must be in the time base of the context
+// The image is copied and can therefore be modified once Push returns
+func (s *FrameSource) Push(i image.Image, pts int64) (err error) {
+	return s.push(pts, func(f *avutil.Frame) (err error) {
+		// Set video attributes
+		f.SetColorRange(s.o.Ctx.ColorRange)
+		if s.o.Ctx.SampleAspectRatio.Num() > 0 && s.o.Ctx.SampleAspectRatio.Den() > 0 {
+			f.SetSampleAspectRatio(s.o.Ctx.SampleAspectRatio)
+		}
+
+		// Write image
+		if err = writeImage(i, f); err != nil {
+			err = errors.Wrap(err, "astilibav: writing image failed")
+			return
+		}
+		return
+	})
+}
+
+// PushAudio converts audio samples into an audio frame and dispatches it asynchronously
+// pts must be in the time base of the context
+// Samples are copied and can therefore be modified once PushAudio returns
+func (s *FrameSource) PushAudio(samples AudioSamples, pts int64) (err error) {
+	return s.push(pts, func(f *avutil.Frame) (err error) {
+		// Set audio attributes
+		cl, cs := s.o.Ctx.ChannelLayout, s.o.Ctx.Channels
+		if cl > 0 {
+			cs = avutil.AvGetChannelLayoutNbChannels(cl)
+		} else if cs == 0 {
+			cs = samples.channels()
+		}
+		f.SetChannelLayout(cl)
+		f.SetChannels(cs)
+		f.SetSampleRate(s.o.Ctx.SampleRate)
+
+		// Write audio samples
+		if err = writeAudioSamples(samples, f); err != nil {
+			err = errors.Wrap(err, "astilibav: writing audio samples failed")
+			return
+		}
+		return
+	})
+}
+
+func (s *FrameSource) push(pts int64, fn func(f *avutil.Frame) error) (err error) {
+	// Increment pushed rate
+	s.statPushedRate.Add(1)
+
+	// Get frame
+	f := s.d.p.get()
+
+	// Write frame
+	s.statWorkRatio.Add(true)
+	f.SetPts(pts)
+	if err = fn(f); err != nil {
+		s.statWorkRatio.Done(true)
+		s.d.p.put(f)
+		return
+	}
+	s.statWorkRatio.Done(true)
+
+	// Send frame
+	s.q.Send(f)
+	return
+}
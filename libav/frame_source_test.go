@@ -0,0 +1,127 @@
+package astilibav
+
+import (
+	"context"
+	"image"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astitools/worker"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type testFrameHandler struct {
+	*astiencoder.BaseNode
+	ch   chan struct{}
+	ctxs []Context
+	m    *sync.Mutex
+	pts  []int64
+	t    avcodec.MediaType
+}
+
+func newTestFrameHandler(t avcodec.MediaType, eh *astiencoder.EventHandler) (h *testFrameHandler) {
+	h = &testFrameHandler{
+		ch: make(chan struct{}, 10),
+		m:  &sync.Mutex{},
+		t:  t,
+	}
+	h.BaseNode = astiencoder.NewBaseNode(astiencoder.NodeOptions{Metadata: astiencoder.NodeMetadata{Name: "test"}}, astiencoder.NewEventGeneratorNode(h), eh)
+	return
+}
+
+// Start implements the Starter interface
+func (h *testFrameHandler) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	h.BaseNode.Start(ctx, t, func(t *astiworker.Task) {
+		<-h.Context().Done()
+	})
+}
+
+func (h *testFrameHandler) HandleFrame(p *FrameHandlerPayload) {
+	h.m.Lock()
+	h.ctxs = append(h.ctxs, newContextFromFrame(p.Frame, h.t))
+	h.pts = append(h.pts, p.Frame.Pts())
+	h.m.Unlock()
+	h.ch <- struct{}{}
+}
+
+func (h *testFrameHandler) wait(t *testing.T, n int) {
+	for idx := 0; idx < n; idx++ {
+		select {
+		case <-h.ch:
+		case <-time.After(time.Second):
+			t.Fatal("timeout while waiting for frames")
+		}
+	}
+}
+
+func startTestNode(n astiencoder.Node) (stop func()) {
+	w := astiworker.NewWorker()
+	ctx, cancel := context.WithCancel(context.Background())
+	n.Start(ctx, w.NewTask)
+	return func() {
+		cancel()
+		w.Stop()
+		w.Wait()
+	}
+}
+
+func TestFrameSourcePush(t *testing.T) {
+	// Create source
+	c := astiencoder.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	s := NewFrameSource(FrameSourceOptions{Ctx: Context{
+		CodecType:         avcodec.AVMEDIA_TYPE_VIDEO,
+		SampleAspectRatio: avutil.NewRational(1, 1),
+		TimeBase:          avutil.NewRational(1, 25),
+	}}, eh, c)
+	h := newTestFrameHandler(avcodec.AVMEDIA_TYPE_VIDEO, eh)
+	s.Connect(h)
+	defer startTestNode(s)()
+
+	// Push
+	i := image.NewYCbCr(image.Rect(0, 0, 4, 2), image.YCbCrSubsampleRatio420)
+	assert.NoError(t, s.Push(i, 1))
+	assert.NoError(t, s.Push(image.NewGray(image.Rect(0, 0, 2, 2)), 2))
+	h.wait(t, 2)
+	assert.Equal(t, []int64{1, 2}, h.pts)
+	assert.Equal(t, []Context{
+		{CodecType: avcodec.AVMEDIA_TYPE_VIDEO, Height: 2, PixelFormat: avutil.AV_PIX_FMT_YUV420P, SampleAspectRatio: avutil.NewRational(1, 1), Width: 4},
+		{CodecType: avcodec.AVMEDIA_TYPE_VIDEO, Height: 2, PixelFormat: avutil.AV_PIX_FMT_GRAY8, SampleAspectRatio: avutil.NewRational(1, 1), Width: 2},
+	}, h.ctxs)
+}
+
+func TestFrameSourcePushAudio(t *testing.T) {
+	// Create source
+	c := astiencoder.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	s := NewFrameSource(FrameSourceOptions{Ctx: Context{
+		ChannelLayout: stereoChannelLayout,
+		CodecType:     avcodec.AVMEDIA_TYPE_AUDIO,
+		SampleRate:    48000,
+		TimeBase:      avutil.NewRational(1, 48000),
+	}}, eh, c)
+	h := newTestFrameHandler(avcodec.AVMEDIA_TYPE_AUDIO, eh)
+	s.Connect(h)
+	defer startTestNode(s)()
+
+	// Push
+	assert.NoError(t, s.PushAudio(AudioSamples{Float32: [][]float32{{0, 1}, {1, 0}}}, 1024))
+	h.wait(t, 1)
+	assert.Equal(t, []int64{1024}, h.pts)
+	assert.Equal(t, []Context{{
+		ChannelLayout: stereoChannelLayout,
+		Channels:      2,
+		CodecType:     avcodec.AVMEDIA_TYPE_AUDIO,
+		SampleFmt:     avcodec.AvSampleFormat(avutil.AvGetSampleFmt("fltp")),
+		SampleRate:    48000,
+	}}, h.ctxs)
+
+	// Channels don't match
+	assert.Error(t, s.PushAudio(AudioSamples{Float32: [][]float32{{0, 1}}}, 2048))
+}
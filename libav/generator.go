@@ -0,0 +1,347 @@
+package astilibav
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astitools/stat"
+	"github.com/asticode/go-astitools/time"
+	"github.com/asticode/go-astitools/worker"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avfilter"
+	"github.com/asticode/goav/avutil"
+	"github.com/pkg/errors"
+)
+
+var countGenerator uint64
+
+// Generator sources
+const (
+	GeneratorSourceColor       = "color"
+	GeneratorSourceSilence     = "anullsrc"
+	GeneratorSourceSine        = "sine"
+	GeneratorSourceSMPTEBars   = "smptebars"
+	GeneratorSourceTestPattern = "testsrc2"
+)
+
+// Generator represents an object capable of generating frames out of a source-only filter graph
+type Generator struct {
+	*astiencoder.BaseNode
+	bufferSinkCtx *avfilter.Context
+	d             *frameDispatcher
+	eh            *astiencoder.EventHandler
+	g             *avfilter.Graph
+	o             GeneratorOptions
+	outputCtx     Context
+	statWorkRatio *astistat.DurationRatioStat
+}
+
+// GeneratorOptions represents generator options
+type GeneratorOptions struct {
+	// Only used by the "color" source. Color name or hexadecimal value as libavfilter expects it. Defaults to "black"
+	Color string
+	// libavfilter source-only graph with a single unlabeled output such as "mandelbrot,format=yuv420p"
+	// When provided, Source is ignored and only the codec type of Ctx is used
+	Content string
+	// Video sources use frame rate, height, pixel format, sample aspect ratio and width. Pixel format's zero value
+	// being yuv420p, it's always used
+	// Audio sources use channel layout (or channels), sample format and sample rate. Sample format's zero value
+	// being u8, it's only used when greater than 0
+	// Codec type is mandatory
+	Ctx Context
+	// Generation stops once frames reach the duration. 0 means infinite
+	Duration time.Duration
+	// Only used by the "sine" source. Defaults to 440Hz
+	Frequency float64
+	Node      astiencoder.NodeOptions
+	// If true, frames are dispatched in real time, otherwise they're dispatched as fast as possible
+	RealTime bool
+	// Possible values are the GeneratorSource constants or any other libavfilter source name
+	Source string
+}
+
+// NewGenerator creates a new generator
+func NewGenerator(o GeneratorOptions, eh *astiencoder.EventHandler, c *astiencoder.Closer) (g *Generator, err error) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countGenerator, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("generator_%d", count), fmt.Sprintf("Generator #%d", count), "Generates")
+
+	// Create generator
+	g = &Generator{
+		eh:            eh,
+		o:             o,
+		statWorkRatio: astistat.NewDurationRatioStat(),
+	}
+	g.BaseNode = astiencoder.NewBaseNode(o.Node, astiencoder.NewEventGeneratorNode(g), eh)
+	g.d = newFrameDispatcher(g, eh, c)
+	g.addStats()
+
+	// Create graph
+	if err = g.createGraph(c); err != nil {
+		err = errors.Wrap(err, "astilibav: creating graph failed")
+		return
+	}
+	return
+}
+
+func (g *Generator) addStats() {
+	// Add work ratio
+	g.Stater().AddStat(astistat.StatMetadata{
+		Description: "Percentage of time spent doing some actual work",
+		Label:       "Work ratio",
+		Unit:        "%",
+	}, g.statWorkRatio)
+
+	// Add dispatcher stats
+	g.d.addStats(g.Stater())
+}
+
+func (o GeneratorOptions) content() (c string, err error) {
+	// Custom content
+	if len(o.Content) > 0 {
+		c = o.Content
+		return
+	}
+
+	// Switch on codec type
+	switch o.Ctx.CodecType {
+	case avcodec.AVMEDIA_TYPE_AUDIO:
+		// Source
+		switch o.Source {
+		case GeneratorSourceSine:
+			frequency := o.Frequency
+			if frequency <= 0 {
+				frequency = 440
+			}
+			c = fmt.Sprintf("sine=frequency=%v", frequency)
+		case "":
+			c = GeneratorSourceSilence
+		default:
+			c = o.Source
+		}
+
+		// Sample rate
+		if o.Ctx.SampleRate > 0 {
+			c += fmt.Sprintf(",aresample=%d", o.Ctx.SampleRate)
+		}
+
+		// Format
+		var fs []string
+		if o.Ctx.SampleFmt > 0 {
+			fs = append(fs, "sample_fmts="+avutil.AvGetSampleFmtName(int(o.Ctx.SampleFmt)))
+		}
+		if o.Ctx.ChannelLayout > 0 {
+			fs = append(fs, "channel_layouts="+avutil.AvGetChannelLayoutString(o.Ctx.ChannelLayout))
+		} else if o.Ctx.Channels > 0 {
+			fs = append(fs, fmt.Sprintf("channel_layouts=%dc", o.Ctx.Channels))
+		}
+		if len(fs) > 0 {
+			c += ",aformat=" + strings.Join(fs, ":")
+		}
+	case avcodec.AVMEDIA_TYPE_VIDEO:
+		// Source
+		source := o.Source
+		if len(source) == 0 {
+			source = GeneratorSourceTestPattern
+		}
+		var args []string
+		if o.Ctx.Width > 0 && o.Ctx.Height > 0 {
+			args = append(args, fmt.Sprintf("size=%dx%d", o.Ctx.Width, o.Ctx.Height))
+		}
+		if o.Ctx.FrameRate.Num() > 0 && o.Ctx.FrameRate.Den() > 0 {
+			args = append(args, fmt.Sprintf("rate=%d/%d", o.Ctx.FrameRate.Num(), o.Ctx.FrameRate.Den()))
+		}
+		if source == GeneratorSourceColor {
+			color := o.Color
+			if len(color) == 0 {
+				color = "black"
+			}
+			args = append(args, "color="+color)
+		}
+		c = source
+		if len(args) > 0 {
+			c += "=" + strings.Join(args, ":")
+		}
+
+		// Pixel format
+		c += fmt.Sprintf(",format=pix_fmts=%s", avutil.AvGetPixFmtName(int(o.Ctx.PixelFormat)))
+
+		// Sample aspect ratio
+		if o.Ctx.SampleAspectRatio.Num() > 0 && o.Ctx.SampleAspectRatio.Den() > 0 {
+			c += fmt.Sprintf(",setsar=%d/%d", o.Ctx.SampleAspectRatio.Num(), o.Ctx.SampleAspectRatio.Den())
+		}
+	default:
+		err = fmt.Errorf("astilibav: codec type %v is not handled by generator", o.Ctx.CodecType)
+	}
+	return
+}
+
+func (g *Generator) createGraph(c *astiencoder.Closer) (err error) {
+	// Get content
+	var content string
+	if content, err = g.o.content(); err != nil {
+		err = errors.Wrap(err, "astilibav: getting content failed")
+		return
+	}
+
+	// Create graph
+	g.g = avfilter.AvfilterGraphAlloc()
+	c.Add(func() error {
+		g.g.AvfilterGraphFree()
+		return nil
+	})
+
+	// Create buffer sink
+	var bufferSink *avfilter.Filter
+	switch g.o.Ctx.CodecType {
+	case avcodec.AVMEDIA_TYPE_AUDIO:
+		bufferSink = avfilter.AvfilterGetByName("abuffersink")
+	default:
+		bufferSink = avfilter.AvfilterGetByName("buffersink")
+	}
+
+	// Create buffer sink ctx
+	// We need to create an intermediate variable to avoid "cgo argument has Go pointer to Go pointer" errors
+	var bufferSinkCtx *avfilter.Context
+	if ret := avfilter.AvfilterGraphCreateFilter(&bufferSinkCtx, bufferSink, "out", "", nil, g.g); ret < 0 {
+		err = errors.Wrap(NewAvError(ret), "astilibav: avfilter.AvfilterGraphCreateFilter on empty args failed")
+		return
+	}
+	g.bufferSinkCtx = bufferSinkCtx
+
+	// Create inputs
+	inputs := avfilter.AvfilterInoutAlloc()
+	inputs.SetName("out")
+	inputs.SetFilterCtx(bufferSinkCtx)
+	inputs.SetPadIdx(0)
+	inputs.SetNext(nil)
+
+	// Parse content
+	var outputs *avfilter.Input
+	if ret := g.g.AvfilterGraphParsePtr(content, &inputs, &outputs, nil); ret < 0 {
+		err = errors.Wrapf(NewAvError(ret), "astilibav: g.g.AvfilterGraphParsePtr on content %s failed", content)
+		return
+	}
+
+	// Configure
+	if ret := g.g.AvfilterGraphConfig(nil); ret < 0 {
+		err = errors.Wrap(NewAvError(ret), "astilibav: g.g.AvfilterGraphConfig failed")
+		return
+	}
+
+	// Store output ctx
+	g.outputCtx = newContextFromBufferSink(g.bufferSinkCtx, g.o.Ctx.CodecType)
+	if g.outputCtx.CodecType == avcodec.AVMEDIA_TYPE_VIDEO {
+		g.outputCtx.FieldOrder = avcodec.AV_FIELD_PROGRESSIVE
+	}
+	return
+}
+
+// OutputCtx returns the context of the generated frames
+func (g *Generator) OutputCtx() Context {
+	return g.outputCtx
+}
+
+// Connect implements the FrameHandlerConnector interface
+func (g *Generator) Connect(h FrameHandler) {
+	// Add handler
+	g.d.addHandler(h)
+
+	// Connect nodes
+	astiencoder.ConnectNodes(g, h)
+}
+
+// Disconnect implements the FrameHandlerConnector interface
+func (g *Generator) Disconnect(h FrameHandler) {
+	// Delete handler
+	g.d.delHandler(h)
+
+	// Disconnect nodes
+	astiencoder.DisconnectNodes(g, h)
+}
+
+// Start starts the generator
+func (g *Generator) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	g.BaseNode.Start(ctx, t, func(t *astiworker.Task) {
+		// Make sure to wait for all dispatcher subprocesses to be done so that they are properly closed
+		defer g.d.wait()
+
+		// Loop
+		var firstPts *int64
+		startAt := time.Now()
+		for {
+			// Generate
+			if stop := g.generate(&firstPts, startAt); stop {
+				return
+			}
+
+			// Handle pause
+			g.HandlePause()
+
+			// Check context
+			if g.Context().Err() != nil {
+				return
+			}
+		}
+	})
+}
+
+func (g *Generator) generate(firstPts **int64, startAt time.Time) (stop bool) {
+	// Get frame
+	f := g.d.p.get()
+	defer g.d.p.put(f)
+
+	// Pull frame
+	g.statWorkRatio.Add(true)
+	if ret := g.g.AvBuffersinkGetFrame(g.bufferSinkCtx, f); ret < 0 {
+		g.statWorkRatio.Done(true)
+		if ret != avutil.AVERROR_EOF {
+			emitAvError(g, g.eh, ret, "g.g.AvBuffersinkGetFrame failed")
+		} else {
+			g.emitEOF()
+		}
+		stop = true
+		return
+	}
+	g.statWorkRatio.Done(true)
+
+	// Get elapsed duration
+	if *firstPts == nil {
+		pts := f.Pts()
+		*firstPts = &pts
+	}
+	elapsed := time.Duration(avutil.AvRescaleQ(f.Pts()-**firstPts, g.outputCtx.TimeBase, nanosecondRational))
+
+	// Duration has been reached
+	if g.o.Duration > 0 && elapsed >= g.o.Duration {
+		g.emitEOF()
+		stop = true
+		return
+	}
+
+	// Wait for the frame to be due
+	if g.o.RealTime {
+		if delta := startAt.Add(elapsed).Sub(time.Now()); delta > 0 {
+			astitime.Sleep(g.Context(), delta)
+		}
+		if g.Context().Err() != nil {
+			stop = true
+			return
+		}
+	}
+
+	// Dispatch frame
+	g.d.dispatch(f, newTimeBaseDescriptor(g.outputCtx.TimeBase))
+	return
+}
+
+func (g *Generator) emitEOF() {
+	g.eh.Emit(astiencoder.Event{
+		Name:   EventNameEOF,
+		Target: g,
+	})
+}
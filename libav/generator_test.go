@@ -0,0 +1,51 @@
+package astilibav
+
+import (
+	"testing"
+
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeneratorOptionsContent(t *testing.T) {
+	// Custom content
+	c, err := GeneratorOptions{Content: "mandelbrot,format=yuv420p", Ctx: Context{CodecType: avcodec.AVMEDIA_TYPE_VIDEO}, Source: GeneratorSourceColor}.content()
+	assert.NoError(t, err)
+	assert.Equal(t, "mandelbrot,format=yuv420p", c)
+
+	// Audio
+	c, err = GeneratorOptions{Ctx: Context{CodecType: avcodec.AVMEDIA_TYPE_AUDIO}}.content()
+	assert.NoError(t, err)
+	assert.Equal(t, "anullsrc", c)
+	c, err = GeneratorOptions{Ctx: Context{
+		ChannelLayout: stereoChannelLayout,
+		CodecType:     avcodec.AVMEDIA_TYPE_AUDIO,
+		SampleFmt:     avcodec.AvSampleFormat(avutil.AvGetSampleFmt("s16")),
+		SampleRate:    48000,
+	}, Source: GeneratorSourceSine}.content()
+	assert.NoError(t, err)
+	assert.Equal(t, "sine=frequency=440,aresample=48000,aformat=sample_fmts=s16:channel_layouts=stereo", c)
+	c, err = GeneratorOptions{Ctx: Context{Channels: 2, CodecType: avcodec.AVMEDIA_TYPE_AUDIO}, Frequency: 1000, Source: GeneratorSourceSine}.content()
+	assert.NoError(t, err)
+	assert.Equal(t, "sine=frequency=1000,aformat=channel_layouts=2c", c)
+
+	// Video
+	c, err = GeneratorOptions{Ctx: Context{CodecType: avcodec.AVMEDIA_TYPE_VIDEO}}.content()
+	assert.NoError(t, err)
+	assert.Equal(t, "testsrc2,format=pix_fmts=yuv420p", c)
+	c, err = GeneratorOptions{Ctx: Context{
+		CodecType:         avcodec.AVMEDIA_TYPE_VIDEO,
+		FrameRate:         avutil.NewRational(25, 1),
+		Height:            720,
+		PixelFormat:       avutil.AV_PIX_FMT_RGBA,
+		SampleAspectRatio: avutil.NewRational(1, 1),
+		Width:             1280,
+	}, Source: GeneratorSourceColor}.content()
+	assert.NoError(t, err)
+	assert.Equal(t, "color=size=1280x720:rate=25/1:color=black,format=pix_fmts=rgba,setsar=1/1", c)
+
+	// Invalid codec type
+	_, err = GeneratorOptions{Ctx: Context{CodecType: avutil.AVMEDIA_TYPE_SUBTITLE}}.content()
+	assert.Error(t, err)
+}
@@ -0,0 +1,622 @@
+package astilibav
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+	"unsafe"
+
+	"github.com/asticode/goav/avutil"
+	"github.com/pkg/errors"
+)
+
+// AudioSamples represents audio samples indexed by channel
+// Only the slice matching the sample format is set, whether the format is planar or interleaved
+type AudioSamples struct {
+	Float32 [][]float32
+	Float64 [][]float64
+	Int16   [][]int16
+	Int32   [][]int32
+	Uint8   [][]uint8
+}
+
+func (s AudioSamples) channels() int {
+	switch {
+	case s.Float32 != nil:
+		return len(s.Float32)
+	case s.Float64 != nil:
+		return len(s.Float64)
+	case s.Int16 != nil:
+		return len(s.Int16)
+	case s.Int32 != nil:
+		return len(s.Int32)
+	default:
+		return len(s.Uint8)
+	}
+}
+
+func (s AudioSamples) nbSamples() int {
+	if s.channels() == 0 {
+		return 0
+	}
+	switch {
+	case s.Float32 != nil:
+		return len(s.Float32[0])
+	case s.Float64 != nil:
+		return len(s.Float64[0])
+	case s.Int16 != nil:
+		return len(s.Int16[0])
+	case s.Int32 != nil:
+		return len(s.Int32[0])
+	default:
+		return len(s.Uint8[0])
+	}
+}
+
+// sampleFmtName returns the name of the planar sample format matching the samples
+func (s AudioSamples) sampleFmtName() string {
+	switch {
+	case s.Float32 != nil:
+		return "fltp"
+	case s.Float64 != nil:
+		return "dblp"
+	case s.Int16 != nil:
+		return "s16p"
+	case s.Int32 != nil:
+		return "s32p"
+	default:
+		return "u8p"
+	}
+}
+
+// normalized returns the samples as float64 between -1 and 1
+func (s AudioSamples) normalized() (o [][]float64) {
+	o = make([][]float64, s.channels())
+	for c := range o {
+		o[c] = make([]float64, s.nbSamples())
+		for i := range o[c] {
+			switch {
+			case s.Float32 != nil:
+				o[c][i] = float64(s.Float32[c][i])
+			case s.Float64 != nil:
+				o[c][i] = s.Float64[c][i]
+			case s.Int16 != nil:
+				o[c][i] = float64(s.Int16[c][i]) / (1 << 15)
+			case s.Int32 != nil:
+				o[c][i] = float64(s.Int32[c][i]) / (1 << 31)
+			default:
+				o[c][i] = (float64(s.Uint8[c][i]) - (1 << 7)) / (1 << 7)
+			}
+		}
+	}
+	return
+}
+
+// interleaved returns the samples interleaved in a single slice
+func (s AudioSamples) interleaved() interface{} {
+	cs, ns := s.channels(), s.nbSamples()
+	switch {
+	case s.Float32 != nil:
+		o := make([]float32, cs*ns)
+		for c := range s.Float32 {
+			for i, v := range s.Float32[c] {
+				o[i*cs+c] = v
+			}
+		}
+		return o
+	case s.Float64 != nil:
+		o := make([]float64, cs*ns)
+		for c := range s.Float64 {
+			for i, v := range s.Float64[c] {
+				o[i*cs+c] = v
+			}
+		}
+		return o
+	case s.Int16 != nil:
+		o := make([]int16, cs*ns)
+		for c := range s.Int16 {
+			for i, v := range s.Int16[c] {
+				o[i*cs+c] = v
+			}
+		}
+		return o
+	case s.Int32 != nil:
+		o := make([]int32, cs*ns)
+		for c := range s.Int32 {
+			for i, v := range s.Int32[c] {
+				o[i*cs+c] = v
+			}
+		}
+		return o
+	default:
+		o := make([]uint8, cs*ns)
+		for c := range s.Uint8 {
+			for i, v := range s.Uint8[c] {
+				o[i*cs+c] = v
+			}
+		}
+		return o
+	}
+}
+
+// convert converts the samples to a sample format name without its planar suffix such as "s16" or "flt"
+// Samples are returned as is if they already match the sample format
+func (s AudioSamples) convert(name string) (o AudioSamples) {
+	// Nothing to do
+	switch {
+	case name == "dbl" && s.Float64 != nil, name == "flt" && s.Float32 != nil, name == "s16" && s.Int16 != nil,
+		name == "s32" && s.Int32 != nil, name == "u8" && s.Uint8 != nil:
+		return s
+	}
+
+	// Loop through normalized samples
+	n := s.normalized()
+	switch name {
+	case "dbl":
+		o.Float64 = n
+	case "flt":
+		o.Float32 = make([][]float32, len(n))
+	case "s16":
+		o.Int16 = make([][]int16, len(n))
+	case "s32":
+		o.Int32 = make([][]int32, len(n))
+	default:
+		o.Uint8 = make([][]uint8, len(n))
+	}
+	for c := range n {
+		switch name {
+		case "dbl":
+			continue
+		case "flt":
+			o.Float32[c] = make([]float32, len(n[c]))
+		case "s16":
+			o.Int16[c] = make([]int16, len(n[c]))
+		case "s32":
+			o.Int32[c] = make([]int32, len(n[c]))
+		default:
+			o.Uint8[c] = make([]uint8, len(n[c]))
+		}
+		for i, v := range n[c] {
+			switch name {
+			case "flt":
+				o.Float32[c][i] = float32(v)
+			case "s16":
+				o.Int16[c][i] = int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(v*(1<<15)))))
+			case "s32":
+				o.Int32[c][i] = int32(math.Max(math.MinInt32, math.Min(math.MaxInt32, math.Round(v*(1<<31)))))
+			default:
+				o.Uint8[c][i] = uint8(math.Max(0, math.Min(math.MaxUint8, math.Round(v*(1<<7)+(1<<7)))))
+			}
+		}
+	}
+	return
+}
+
+// audioLayout describes where samples are located in a frame
+type audioLayout struct {
+	bytesPerSample int
+	channels       int
+	data           [8]*uint8
+	name           string
+	planar         bool
+}
+
+func newAudioLayout(f *avutil.Frame) (l audioLayout, err error) {
+	// Get sample format
+	n := avutil.AvGetSampleFmtName(f.Format())
+	l = audioLayout{
+		channels: f.Channels(),
+		data:     avutil.Data(f),
+		name:     strings.TrimSuffix(n, "p"),
+		planar:   strings.HasSuffix(n, "p"),
+	}
+
+	// Get bytes per sample
+	switch l.name {
+	case "u8":
+		l.bytesPerSample = 1
+	case "s16":
+		l.bytesPerSample = 2
+	case "flt", "s32":
+		l.bytesPerSample = 4
+	case "dbl":
+		l.bytesPerSample = 8
+	default:
+		err = fmt.Errorf("astilibav: sample format %s is not handled", n)
+		return
+	}
+
+	// Planar frames with more than 8 channels store their planes in extended data
+	if l.planar && l.channels > len(l.data) {
+		err = fmt.Errorf("astilibav: %d channels is not handled for planar sample format %s", l.channels, n)
+		return
+	}
+	return
+}
+
+// sample returns a pointer to the sample of a channel
+func (l audioLayout) sample(channel, idx int) unsafe.Pointer {
+	if l.planar {
+		return unsafe.Pointer(uintptr(unsafe.Pointer(l.data[channel])) + uintptr(idx*l.bytesPerSample))
+	}
+	return unsafe.Pointer(uintptr(unsafe.Pointer(l.data[0])) + uintptr((idx*l.channels+channel)*l.bytesPerSample))
+}
+
+// frameAudioSamples copies the samples of an audio frame so that they can outlive the frame
+func frameAudioSamples(f *avutil.Frame) (s AudioSamples, err error) {
+	// Get layout
+	var l audioLayout
+	if l, err = newAudioLayout(f); err != nil {
+		err = errors.Wrap(err, "astilibav: creating audio layout failed")
+		return
+	}
+
+	// Switch on sample format
+	n := f.NbSamples()
+	switch l.name {
+	case "dbl":
+		s.Float64 = make([][]float64, l.channels)
+		for c := range s.Float64 {
+			s.Float64[c] = make([]float64, n)
+			for idx := range s.Float64[c] {
+				s.Float64[c][idx] = *(*float64)(l.sample(c, idx))
+			}
+		}
+	case "flt":
+		s.Float32 = make([][]float32, l.channels)
+		for c := range s.Float32 {
+			s.Float32[c] = make([]float32, n)
+			for idx := range s.Float32[c] {
+				s.Float32[c][idx] = *(*float32)(l.sample(c, idx))
+			}
+		}
+	case "s16":
+		s.Int16 = make([][]int16, l.channels)
+		for c := range s.Int16 {
+			s.Int16[c] = make([]int16, n)
+			for idx := range s.Int16[c] {
+				s.Int16[c][idx] = *(*int16)(l.sample(c, idx))
+			}
+		}
+	case "s32":
+		s.Int32 = make([][]int32, l.channels)
+		for c := range s.Int32 {
+			s.Int32[c] = make([]int32, n)
+			for idx := range s.Int32[c] {
+				s.Int32[c][idx] = *(*int32)(l.sample(c, idx))
+			}
+		}
+	case "u8":
+		s.Uint8 = make([][]uint8, l.channels)
+		for c := range s.Uint8 {
+			s.Uint8[c] = make([]uint8, n)
+			for idx := range s.Uint8[c] {
+				s.Uint8[c][idx] = *(*uint8)(l.sample(c, idx))
+			}
+		}
+	}
+	return
+}
+
+// writeAudioSamples writes samples in an audio frame
+// The frame's channel layout and sample rate must be set beforehand. Its number of samples and buffer are set here.
+// If the frame's sample format is not set, the planar sample format matching the samples is used. Otherwise samples
+// are converted to the frame's sample format
+func writeAudioSamples(s AudioSamples, f *avutil.Frame) (err error) {
+	// Check channels
+	if s.channels() != f.Channels() {
+		err = fmt.Errorf("astilibav: %d channels of samples don't match %d channels of frame", s.channels(), f.Channels())
+		return
+	}
+
+	// Convert samples
+	if f.Format() < 0 {
+		f.SetFormat(int(avutil.AvGetSampleFmt(s.sampleFmtName())))
+	} else {
+		s = s.convert(strings.TrimSuffix(avutil.AvGetSampleFmtName(f.Format()), "p"))
+	}
+
+	// Allocate buffer
+	n := s.nbSamples()
+	f.SetNbSamples(n)
+	if ret := avutil.AvFrameGetBuffer(f, 0); ret < 0 {
+		err = errors.Wrap(NewAvError(ret), "astilibav: avutil.AvFrameGetBuffer failed")
+		return
+	}
+
+	// Get layout
+	var l audioLayout
+	if l, err = newAudioLayout(f); err != nil {
+		err = errors.Wrap(err, "astilibav: creating audio layout failed")
+		return
+	}
+
+	// Loop through channels
+	for c := 0; c < l.channels; c++ {
+		// Check number of samples
+		var cn int
+		switch {
+		case s.Float32 != nil:
+			cn = len(s.Float32[c])
+		case s.Float64 != nil:
+			cn = len(s.Float64[c])
+		case s.Int16 != nil:
+			cn = len(s.Int16[c])
+		case s.Int32 != nil:
+			cn = len(s.Int32[c])
+		default:
+			cn = len(s.Uint8[c])
+		}
+		if cn != n {
+			err = fmt.Errorf("astilibav: channel %d has %d samples whereas channel 0 has %d samples", c, cn, n)
+			return
+		}
+
+		// Loop through samples
+		for idx := 0; idx < n; idx++ {
+			switch {
+			case s.Float32 != nil:
+				*(*float32)(l.sample(c, idx)) = s.Float32[c][idx]
+			case s.Float64 != nil:
+				*(*float64)(l.sample(c, idx)) = s.Float64[c][idx]
+			case s.Int16 != nil:
+				*(*int16)(l.sample(c, idx)) = s.Int16[c][idx]
+			case s.Int32 != nil:
+				*(*int32)(l.sample(c, idx)) = s.Int32[c][idx]
+			default:
+				*(*uint8)(l.sample(c, idx)) = s.Uint8[c][idx]
+			}
+		}
+	}
+	return
+}
+
+// framePlane returns the bytes of a frame plane
+func framePlane(f *avutil.Frame, idx, rows int) (b []uint8, linesize int) {
+	data := avutil.Data(f)
+	linesize = int(avutil.Linesize(f)[idx])
+	if data[idx] == nil || linesize <= 0 {
+		return
+	}
+	b = (*[1 << 30]uint8)(unsafe.Pointer(data[idx]))[: linesize*rows : linesize*rows]
+	return
+}
+
+// copyPlane copies rows of width bytes
+func copyPlane(dst []uint8, dstStride int, src []uint8, srcStride, width, rows int) {
+	for y := 0; y < rows; y++ {
+		copy(dst[y*dstStride:y*dstStride+width], src[y*srcStride:y*srcStride+width])
+	}
+}
+
+// Limited range luma goes from 16 to 235 and limited range chroma from 16 to 240 whereas Go images use the full range
+var (
+	chromaToFullRange    = newRangeTable(func(v float64) float64 { return (v-128)*255/224 + 128 })
+	chromaToLimitedRange = newRangeTable(func(v float64) float64 { return (v-128)*224/255 + 128 })
+	lumaToFullRange      = newRangeTable(func(v float64) float64 { return (v - 16) * 255 / 219 })
+	lumaToLimitedRange   = newRangeTable(func(v float64) float64 { return v*219/255 + 16 })
+)
+
+func newRangeTable(fn func(v float64) float64) (t [256]uint8) {
+	for i := range t {
+		t[i] = uint8(math.Max(0, math.Min(math.MaxUint8, math.Round(fn(float64(i))))))
+	}
+	return
+}
+
+// applyRangeTable updates rows of width bytes in place
+func applyRangeTable(t [256]uint8, b []uint8, stride, width, rows int) {
+	for y := 0; y < rows; y++ {
+		for x := y * stride; x < y*stride+width; x++ {
+			b[x] = t[b[x]]
+		}
+	}
+}
+
+// frameFullRange checks whether the pixels of a gray or yuv video frame use the full range
+// yuvj pixel formats always use the full range. Other yuv pixel formats use the limited range unless the frame's color
+// range states otherwise whereas gray8 uses the full range unless the frame's color range states otherwise
+func frameFullRange(f *avutil.Frame) bool {
+	switch p := avutil.PixelFormat(f.Format()); {
+	case IsFullRangePixelFormat(p):
+		return true
+	case p == avutil.AV_PIX_FMT_GRAY8:
+		return f.ColorRange() != avutil.AVCOL_RANGE_MPEG
+	}
+	return f.ColorRange() == avutil.AVCOL_RANGE_JPEG
+}
+
+// frameImage copies the pixels of a video frame into an image so that it can outlive the frame
+// Planar yuv pixel formats are converted into *image.YCbCr, gray8 into *image.Gray and rgba into *image.RGBA. Other
+// pixel formats need to be converted beforehand, with a scaler for instance. Limited range pixels are expanded to the
+// full range Go images expect
+func frameImage(f *avutil.Frame) (i image.Image, err error) {
+	// Switch on pixel format
+	r := image.Rect(0, 0, f.Width(), f.Height())
+	switch pf := avutil.PixelFormat(f.Format()); pf {
+	case avutil.AV_PIX_FMT_GRAY8:
+		img := image.NewGray(r)
+		src, linesize := framePlane(f, 0, f.Height())
+		copyPlane(img.Pix, img.Stride, src, linesize, img.Stride, f.Height())
+		if !frameFullRange(f) {
+			applyRangeTable(lumaToFullRange, img.Pix, img.Stride, img.Stride, f.Height())
+		}
+		i = img
+	case avutil.AV_PIX_FMT_RGBA:
+		img := image.NewRGBA(r)
+		src, linesize := framePlane(f, 0, f.Height())
+		copyPlane(img.Pix, img.Stride, src, linesize, img.Stride, f.Height())
+		i = img
+	case avutil.AV_PIX_FMT_YUV420P, avutil.AV_PIX_FMT_YUVJ420P,
+		avutil.AV_PIX_FMT_YUV422P, avutil.AV_PIX_FMT_YUVJ422P,
+		avutil.AV_PIX_FMT_YUV444P, avutil.AV_PIX_FMT_YUVJ444P:
+		img := image.NewYCbCr(r, ycbcrSubsampleRatio(pf))
+		chromaRows := len(img.Cb) / img.CStride
+		y, yLinesize := framePlane(f, 0, f.Height())
+		cb, cbLinesize := framePlane(f, 1, chromaRows)
+		cr, crLinesize := framePlane(f, 2, chromaRows)
+		copyPlane(img.Y, img.YStride, y, yLinesize, img.YStride, f.Height())
+		copyPlane(img.Cb, img.CStride, cb, cbLinesize, img.CStride, chromaRows)
+		copyPlane(img.Cr, img.CStride, cr, crLinesize, img.CStride, chromaRows)
+		if !frameFullRange(f) {
+			applyRangeTable(lumaToFullRange, img.Y, img.YStride, img.YStride, f.Height())
+			applyRangeTable(chromaToFullRange, img.Cb, img.CStride, img.CStride, chromaRows)
+			applyRangeTable(chromaToFullRange, img.Cr, img.CStride, img.CStride, chromaRows)
+		}
+		i = img
+	default:
+		err = fmt.Errorf("astilibav: pixel format %s is not handled", avutil.AvGetPixFmtName(int(pf)))
+	}
+	return
+}
+
+func ycbcrSubsampleRatio(f avutil.PixelFormat) image.YCbCrSubsampleRatio {
+	switch f {
+	case avutil.AV_PIX_FMT_YUV422P, avutil.AV_PIX_FMT_YUVJ422P:
+		return image.YCbCrSubsampleRatio422
+	case avutil.AV_PIX_FMT_YUV444P, avutil.AV_PIX_FMT_YUVJ444P:
+		return image.YCbCrSubsampleRatio444
+	default:
+		return image.YCbCrSubsampleRatio420
+	}
+}
+
+// writeImage writes an image in a video frame
+// The frame's dimensions and buffer are set here. If the frame's pixel format is not set, *image.YCbCr with a 4:2:0,
+// 4:2:2 or 4:4:4 subsample ratio are written as planar yuv, *image.Gray as gray8 and other images as rgba. Otherwise
+// the image is converted to the frame's pixel format which must be one of the pixel formats handled by frameImage.
+// Pixels are compressed to the limited range unless the frame uses the full range
+func writeImage(i image.Image, f *avutil.Frame) (err error) {
+	// Convert image
+	if f.Format() < 0 {
+		f.SetFormat(int(defaultImagePixelFormat(i)))
+	}
+	if i, err = convertImageToPixelFormat(i, avutil.PixelFormat(f.Format())); err != nil {
+		err = errors.Wrap(err, "astilibav: converting image failed")
+		return
+	}
+
+	// Get bounds
+	// They must be retrieved after the conversion since converted images' origin is (0, 0)
+	b := i.Bounds()
+
+	// Allocate buffer
+	f.SetHeight(b.Dy())
+	f.SetWidth(b.Dx())
+	if ret := avutil.AvFrameGetBuffer(f, 0); ret < 0 {
+		err = errors.Wrap(NewAvError(ret), "astilibav: avutil.AvFrameGetBuffer failed")
+		return
+	}
+
+	// Copy pixels
+	switch v := i.(type) {
+	case *image.Gray:
+		dst, linesize := framePlane(f, 0, b.Dy())
+		copyPlane(dst, linesize, v.Pix[v.PixOffset(b.Min.X, b.Min.Y):], v.Stride, b.Dx(), b.Dy())
+		if !frameFullRange(f) {
+			applyRangeTable(lumaToLimitedRange, dst, linesize, b.Dx(), b.Dy())
+		}
+	case *image.RGBA:
+		dst, linesize := framePlane(f, 0, b.Dy())
+		copyPlane(dst, linesize, v.Pix[v.PixOffset(b.Min.X, b.Min.Y):], v.Stride, 4*b.Dx(), b.Dy())
+	case *image.YCbCr:
+		// Get chroma dimensions
+		cw, ch := b.Dx(), b.Dy()
+		switch v.SubsampleRatio {
+		case image.YCbCrSubsampleRatio420:
+			cw, ch = (b.Dx()+1)/2, (b.Dy()+1)/2
+		case image.YCbCrSubsampleRatio422:
+			cw = (b.Dx() + 1) / 2
+		}
+
+		// Copy planes
+		y, yLinesize := framePlane(f, 0, b.Dy())
+		cb, cbLinesize := framePlane(f, 1, ch)
+		cr, crLinesize := framePlane(f, 2, ch)
+		copyPlane(y, yLinesize, v.Y[v.YOffset(b.Min.X, b.Min.Y):], v.YStride, b.Dx(), b.Dy())
+		copyPlane(cb, cbLinesize, v.Cb[v.COffset(b.Min.X, b.Min.Y):], v.CStride, cw, ch)
+		copyPlane(cr, crLinesize, v.Cr[v.COffset(b.Min.X, b.Min.Y):], v.CStride, cw, ch)
+		if !frameFullRange(f) {
+			applyRangeTable(lumaToLimitedRange, y, yLinesize, b.Dx(), b.Dy())
+			applyRangeTable(chromaToLimitedRange, cb, cbLinesize, cw, ch)
+			applyRangeTable(chromaToLimitedRange, cr, crLinesize, cw, ch)
+		}
+	}
+	return
+}
+
+// defaultImagePixelFormat returns the pixel format an image is written as when the frame's pixel format is not set
+func defaultImagePixelFormat(i image.Image) avutil.PixelFormat {
+	switch v := i.(type) {
+	case *image.Gray:
+		return avutil.AV_PIX_FMT_GRAY8
+	case *image.YCbCr:
+		switch v.SubsampleRatio {
+		case image.YCbCrSubsampleRatio420:
+			return avutil.AV_PIX_FMT_YUV420P
+		case image.YCbCrSubsampleRatio422:
+			return avutil.AV_PIX_FMT_YUV422P
+		case image.YCbCrSubsampleRatio444:
+			return avutil.AV_PIX_FMT_YUV444P
+		}
+	}
+	return avutil.AV_PIX_FMT_RGBA
+}
+
+// convertImageToPixelFormat converts an image to the Go image type matching a pixel format handled by frameImage
+func convertImageToPixelFormat(i image.Image, pf avutil.PixelFormat) (o image.Image, err error) {
+	switch pf {
+	case avutil.AV_PIX_FMT_GRAY8, avutil.AV_PIX_FMT_RGBA,
+		avutil.AV_PIX_FMT_YUV420P, avutil.AV_PIX_FMT_YUVJ420P,
+		avutil.AV_PIX_FMT_YUV422P, avutil.AV_PIX_FMT_YUVJ422P,
+		avutil.AV_PIX_FMT_YUV444P, avutil.AV_PIX_FMT_YUVJ444P:
+		o = convertImage(i, pf)
+	default:
+		err = fmt.Errorf("astilibav: pixel format %s is not handled", avutil.AvGetPixFmtName(int(pf)))
+	}
+	return
+}
+
+// convertImage converts an image to the Go image type matching a pixel format handled by frameImage
+// Images are returned as is if they already match the pixel format
+func convertImage(i image.Image, pf avutil.PixelFormat) image.Image {
+	b := i.Bounds()
+	switch pf {
+	case avutil.AV_PIX_FMT_GRAY8:
+		if _, ok := i.(*image.Gray); ok {
+			return i
+		}
+		o := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(o, o.Bounds(), i, b.Min, draw.Src)
+		return o
+	case avutil.AV_PIX_FMT_RGBA:
+		if _, ok := i.(*image.RGBA); ok {
+			return i
+		}
+		return toRGBA(i)
+	default:
+		r := ycbcrSubsampleRatio(pf)
+		if v, ok := i.(*image.YCbCr); ok && v.SubsampleRatio == r {
+			return i
+		}
+		o := image.NewYCbCr(image.Rect(0, 0, b.Dx(), b.Dy()), r)
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				c := color.YCbCrModel.Convert(i.At(b.Min.X+x, b.Min.Y+y)).(color.YCbCr)
+				o.Y[o.YOffset(x, y)] = c.Y
+				o.Cb[o.COffset(x, y)] = c.Cb
+				o.Cr[o.COffset(x, y)] = c.Cr
+			}
+		}
+		return o
+	}
+}
+
+func toRGBA(i image.Image) *image.RGBA {
+	o := image.NewRGBA(image.Rect(0, 0, i.Bounds().Dx(), i.Bounds().Dy()))
+	draw.Draw(o, o.Bounds(), i, i.Bounds().Min, draw.Src)
+	return o
+}
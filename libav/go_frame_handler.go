@@ -0,0 +1,231 @@
+package astilibav
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"sync/atomic"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astitools/stat"
+	"github.com/asticode/go-astitools/sync"
+	"github.com/asticode/go-astitools/worker"
+	"github.com/asticode/goav/avutil"
+	"github.com/pkg/errors"
+)
+
+var countGoFrameHandler uint64
+
+// GoFrameHandler represents an object capable of handing frames over to a Go callback as images or audio samples
+type GoFrameHandler struct {
+	*astiencoder.BaseNode
+	d                *frameDispatcher
+	eh               *astiencoder.EventHandler
+	o                GoFrameHandlerOptions
+	q                *astisync.CtxQueue
+	statIncomingRate *astistat.IncrementStat
+	statWorkRatio    *astistat.DurationRatioStat
+}
+
+// GoFrame represents a frame converted to Go types
+type GoFrame struct {
+	// Only set for audio frames
+	AudioSamples *AudioSamples
+	// Only set for video frames
+	Image image.Image
+	// Pts in the time base of the descriptor
+	Pts int64
+}
+
+// GoFrameCallback represents a callback executed for every frame
+// When it returns a go frame, its image or audio samples are written in a new frame that is dispatched instead of
+// the incoming frame. Its pts is used as well. Images are converted to the pixel format and color range of the
+// incoming frame, and audio samples to its sample format, so that downstream nodes keep receiving the same format.
+type GoFrameCallback func(f GoFrame, d Descriptor) (o *GoFrame, err error)
+
+// GoFrameHandlerOptions represents go frame handler options
+type GoFrameHandlerOptions struct {
+	Callback GoFrameCallback
+	Node     astiencoder.NodeOptions
+}
+
+// NewGoFrameHandler creates a new go frame handler
+func NewGoFrameHandler(o GoFrameHandlerOptions, eh *astiencoder.EventHandler, c *astiencoder.Closer) (h *GoFrameHandler) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countGoFrameHandler, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("go_frame_handler_%d", count), fmt.Sprintf("Go Frame Handler #%d", count), "Handles frames in Go")
+
+	// Create handler
+	h = &GoFrameHandler{
+		eh:               eh,
+		o:                o,
+		q:                astisync.NewCtxQueue(),
+		statIncomingRate: astistat.NewIncrementStat(),
+		statWorkRatio:    astistat.NewDurationRatioStat(),
+	}
+	h.BaseNode = astiencoder.NewBaseNode(o.Node, astiencoder.NewEventGeneratorNode(h), eh)
+	h.d = newFrameDispatcher(h, eh, c)
+	h.addStats()
+	return
+}
+
+func (h *GoFrameHandler) addStats() {
+	// Add incoming rate
+	h.Stater().AddStat(astistat.StatMetadata{
+		Description: "Number of frames coming in per second",
+		Label:       "Incoming rate",
+		Unit:        "fps",
+	}, h.statIncomingRate)
+
+	// Add work ratio
+	h.Stater().AddStat(astistat.StatMetadata{
+		Description: "Percentage of time spent doing some actual work",
+		Label:       "Work ratio",
+		Unit:        "%",
+	}, h.statWorkRatio)
+
+	// Add dispatcher stats
+	h.d.addStats(h.Stater())
+
+	// Add queue stats
+	h.q.AddStats(h.Stater())
+}
+
+// Connect implements the FrameHandlerConnector interface
+func (h *GoFrameHandler) Connect(n FrameHandler) {
+	// Add handler
+	h.d.addHandler(n)
+
+	// Connect nodes
+	astiencoder.ConnectNodes(h, n)
+}
+
+// Disconnect implements the FrameHandlerConnector interface
+func (h *GoFrameHandler) Disconnect(n FrameHandler) {
+	// Delete handler
+	h.d.delHandler(n)
+
+	// Disconnect nodes
+	astiencoder.DisconnectNodes(h, n)
+}
+
+// Start starts the go frame handler
+func (h *GoFrameHandler) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	h.BaseNode.Start(ctx, t, func(t *astiworker.Task) {
+		// Handle context
+		go h.q.HandleCtx(h.Context())
+
+		// Make sure to wait for all dispatcher subprocesses to be done so that they are properly closed
+		defer h.d.wait()
+
+		// Make sure to stop the queue properly
+		defer h.q.Stop()
+
+		// Start queue
+		h.q.Start(func(dp interface{}) {
+			// Handle pause
+			defer h.HandlePause()
+
+			// Assert payload
+			p := dp.(*FrameHandlerPayload)
+
+			// Increment incoming rate
+			h.statIncomingRate.Add(1)
+
+			// Handle frame
+			h.statWorkRatio.Add(true)
+			if err := h.handleFrame(p); err != nil {
+				h.statWorkRatio.Done(true)
+				h.eh.Emit(astiencoder.EventError(h, errors.Wrap(err, "astilibav: handling go frame failed")))
+				return
+			}
+			h.statWorkRatio.Done(true)
+		})
+	})
+}
+
+func (h *GoFrameHandler) handleFrame(p *FrameHandlerPayload) (err error) {
+	// Convert frame
+	var i GoFrame
+	if i, err = newGoFrame(p.Frame); err != nil {
+		err = errors.Wrap(err, "astilibav: creating go frame failed")
+		return
+	}
+
+	// Execute callback
+	var o *GoFrame
+	if h.o.Callback != nil {
+		if o, err = h.o.Callback(i, p.Descriptor); err != nil {
+			err = errors.Wrap(err, "astilibav: executing callback failed")
+			return
+		}
+	}
+
+	// Nothing to write back
+	if o == nil {
+		h.d.dispatch(p.Frame, p.Descriptor)
+		return
+	}
+
+	// Get frame
+	f := h.d.p.get()
+	defer h.d.p.put(f)
+
+	// Copy frame properties
+	if ret := avutil.AvFrameCopyProps(f, p.Frame); ret < 0 {
+		err = errors.Wrap(NewAvError(ret), "astilibav: avutil.AvFrameCopyProps failed")
+		return
+	}
+	f.SetPts(o.Pts)
+
+	// Write go frame in the incoming format
+	f.SetFormat(p.Frame.Format())
+	switch {
+	case o.AudioSamples != nil:
+		f.SetChannelLayout(p.Frame.ChannelLayout())
+		f.SetChannels(p.Frame.Channels())
+		f.SetSampleRate(p.Frame.SampleRate())
+		if err = writeAudioSamples(*o.AudioSamples, f); err != nil {
+			err = errors.Wrap(err, "astilibav: writing audio samples failed")
+			return
+		}
+	case o.Image != nil:
+		if err = writeImage(o.Image, f); err != nil {
+			err = errors.Wrap(err, "astilibav: writing image failed")
+			return
+		}
+	default:
+		err = errors.New("astilibav: go frame has neither audio samples nor image")
+		return
+	}
+
+	// Dispatch frame
+	h.d.dispatch(f, p.Descriptor)
+	return
+}
+
+func newGoFrame(f *avutil.Frame) (o GoFrame, err error) {
+	// Audio frame
+	o.Pts = f.Pts()
+	if f.NbSamples() > 0 {
+		var s AudioSamples
+		if s, err = frameAudioSamples(f); err != nil {
+			err = errors.Wrap(err, "astilibav: getting audio samples failed")
+			return
+		}
+		o.AudioSamples = &s
+		return
+	}
+
+	// Video frame
+	if o.Image, err = frameImage(f); err != nil {
+		err = errors.Wrap(err, "astilibav: getting image failed")
+		return
+	}
+	return
+}
+
+// HandleFrame implements the FrameHandler interface
+func (h *GoFrameHandler) HandleFrame(p *FrameHandlerPayload) {
+	h.q.Send(p)
+}
@@ -0,0 +1,187 @@
+package astilibav
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAudioSamples(t *testing.T) {
+	s := AudioSamples{Int16: [][]int16{{-1 << 15, 0, 1 << 14}, {1, 2, 3}}}
+	assert.Equal(t, 2, s.channels())
+	assert.Equal(t, 3, s.nbSamples())
+	assert.Equal(t, "s16p", s.sampleFmtName())
+	assert.Equal(t, []int16{-1 << 15, 1, 0, 2, 1 << 14, 3}, s.interleaved())
+	assert.Equal(t, []float64{-1, 0, 0.5}, s.normalized()[0])
+
+	// Convert
+	assert.Equal(t, s, s.convert("s16"))
+	assert.Equal(t, [][]float32{{-1, 0, 0.5}}, AudioSamples{Int16: s.Int16[:1]}.convert("flt").Float32)
+	assert.Equal(t, [][]uint8{{0, 128, 192}}, AudioSamples{Int16: s.Int16[:1]}.convert("u8").Uint8)
+	assert.Equal(t, [][]int16{{-1 << 15, 0, 1<<15 - 1}}, AudioSamples{Float64: [][]float64{{-1.5, 0, 1}}}.convert("s16").Int16)
+	assert.Equal(t, [][]int32{{-1 << 31, 0, 1 << 30}}, AudioSamples{Float32: [][]float32{{-1, 0, 0.5}}}.convert("s32").Int32)
+}
+
+func TestWriteAudioSamples(t *testing.T) {
+	s := AudioSamples{Float32: [][]float32{{-1, 0, 0.5}, {0.25, 0.5, 0.75}}}
+
+	// Sample format is not set
+	f := avutil.AvFrameAlloc()
+	defer avutil.AvFrameFree(f)
+	f.SetChannelLayout(stereoChannelLayout)
+	f.SetChannels(2)
+	assert.NoError(t, writeAudioSamples(s, f))
+	assert.Equal(t, "fltp", avutil.AvGetSampleFmtName(f.Format()))
+	o, err := frameAudioSamples(f)
+	assert.NoError(t, err)
+	assert.Equal(t, s, o)
+
+	// Sample format is set
+	avutil.AvFrameUnref(f)
+	f.SetChannelLayout(stereoChannelLayout)
+	f.SetChannels(2)
+	f.SetFormat(int(avutil.AvGetSampleFmt("s16")))
+	assert.NoError(t, writeAudioSamples(s, f))
+	assert.Equal(t, "s16", avutil.AvGetSampleFmtName(f.Format()))
+	o, err = frameAudioSamples(f)
+	assert.NoError(t, err)
+	assert.Equal(t, AudioSamples{Int16: [][]int16{{-1 << 15, 0, 1 << 14}, {1 << 13, 1 << 14, 3 << 13}}}, o)
+
+	// Invalid channels
+	avutil.AvFrameUnref(f)
+	f.SetChannels(1)
+	assert.Error(t, writeAudioSamples(s, f))
+}
+
+func TestRangeTables(t *testing.T) {
+	assert.Equal(t, uint8(0), lumaToFullRange[0])
+	assert.Equal(t, uint8(0), lumaToFullRange[16])
+	assert.Equal(t, uint8(255), lumaToFullRange[235])
+	assert.Equal(t, uint8(255), lumaToFullRange[255])
+	assert.Equal(t, uint8(16), lumaToLimitedRange[0])
+	assert.Equal(t, uint8(235), lumaToLimitedRange[255])
+	assert.Equal(t, uint8(0), chromaToFullRange[16])
+	assert.Equal(t, uint8(128), chromaToFullRange[128])
+	assert.Equal(t, uint8(255), chromaToFullRange[240])
+	assert.Equal(t, uint8(16), chromaToLimitedRange[0])
+	assert.Equal(t, uint8(128), chromaToLimitedRange[128])
+	assert.Equal(t, uint8(240), chromaToLimitedRange[255])
+}
+
+func TestFrameFullRange(t *testing.T) {
+	f := avutil.AvFrameAlloc()
+	defer avutil.AvFrameFree(f)
+	for _, v := range []struct {
+		cr       avutil.ColorRange
+		expected bool
+		pf       avutil.PixelFormat
+	}{
+		{cr: avutil.AVCOL_RANGE_UNSPECIFIED, expected: false, pf: avutil.AV_PIX_FMT_YUV420P},
+		{cr: avutil.AVCOL_RANGE_JPEG, expected: true, pf: avutil.AV_PIX_FMT_YUV420P},
+		{cr: avutil.AVCOL_RANGE_UNSPECIFIED, expected: true, pf: avutil.AV_PIX_FMT_YUVJ420P},
+		{cr: avutil.AVCOL_RANGE_MPEG, expected: true, pf: avutil.AV_PIX_FMT_YUVJ444P},
+		{cr: avutil.AVCOL_RANGE_UNSPECIFIED, expected: true, pf: avutil.AV_PIX_FMT_GRAY8},
+		{cr: avutil.AVCOL_RANGE_MPEG, expected: false, pf: avutil.AV_PIX_FMT_GRAY8},
+	} {
+		f.SetColorRange(v.cr)
+		f.SetFormat(int(v.pf))
+		assert.Equal(t, v.expected, frameFullRange(f), "%s", avutil.AvGetPixFmtName(int(v.pf)))
+	}
+}
+
+func newTestYCbCr() *image.YCbCr {
+	i := image.NewYCbCr(image.Rect(0, 0, 4, 2), image.YCbCrSubsampleRatio420)
+	for idx := range i.Y {
+		i.Y[idx] = uint8(idx * 30)
+	}
+	copy(i.Cb, []uint8{0, 255})
+	copy(i.Cr, []uint8{128, 64})
+	return i
+}
+
+func TestWriteImage(t *testing.T) {
+	f := avutil.AvFrameAlloc()
+	defer avutil.AvFrameFree(f)
+
+	// Full range
+	i := newTestYCbCr()
+	f.SetColorRange(avutil.AVCOL_RANGE_JPEG)
+	assert.NoError(t, writeImage(i, f))
+	assert.Equal(t, avutil.AV_PIX_FMT_YUV420P, avutil.PixelFormat(f.Format()))
+	assert.Equal(t, 4, f.Width())
+	assert.Equal(t, 2, f.Height())
+	o, err := frameImage(f)
+	assert.NoError(t, err)
+	assert.Equal(t, i, o)
+
+	// Limited range pixels are compressed on write and expanded on read
+	avutil.AvFrameUnref(f)
+	assert.NoError(t, writeImage(i, f))
+	y, _ := framePlane(f, 0, 1)
+	assert.Equal(t, []uint8{16, 42, 68, 93}, y[:4])
+	o, err = frameImage(f)
+	assert.NoError(t, err)
+	for idx, v := range o.(*image.YCbCr).Y {
+		assert.InDelta(t, i.Y[idx], v, 1)
+	}
+	for idx, v := range o.(*image.YCbCr).Cb {
+		assert.InDelta(t, i.Cb[idx], v, 1)
+	}
+
+	// Images are converted to the frame's pixel format
+	avutil.AvFrameUnref(f)
+	f.SetColorRange(avutil.AVCOL_RANGE_JPEG)
+	f.SetFormat(int(avutil.AV_PIX_FMT_YUVJ444P))
+	rgba := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for idx := 0; idx < 4; idx++ {
+		rgba.Set(idx%2, idx/2, color.RGBA{R: 255, A: 255})
+	}
+	assert.NoError(t, writeImage(rgba, f))
+	assert.Equal(t, avutil.AV_PIX_FMT_YUVJ444P, avutil.PixelFormat(f.Format()))
+	o, err = frameImage(f)
+	assert.NoError(t, err)
+	assert.Equal(t, image.YCbCrSubsampleRatio444, o.(*image.YCbCr).SubsampleRatio)
+	r, g, b, _ := o.At(1, 1).RGBA()
+	assert.InDelta(t, 0xffff, r, 0x200)
+	assert.InDelta(t, 0, g, 0x200)
+	assert.InDelta(t, 0, b, 0x200)
+
+	// Sub images are converted from their origin
+	avutil.AvFrameUnref(f)
+	f.SetFormat(int(avutil.AV_PIX_FMT_YUV420P))
+	rgba = image.NewRGBA(image.Rect(0, 0, 6, 6))
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 6; x++ {
+			if x >= 2 && y >= 2 {
+				rgba.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+	assert.NoError(t, writeImage(rgba.SubImage(image.Rect(2, 2, 6, 6)), f))
+	assert.Equal(t, 4, f.Width())
+	assert.Equal(t, 4, f.Height())
+	o, err = frameImage(f)
+	assert.NoError(t, err)
+	r, g, b, _ = o.At(0, 0).RGBA()
+	assert.InDelta(t, 0, r, 0x400)
+	assert.InDelta(t, 0, g, 0x400)
+	assert.InDelta(t, 0xffff, b, 0x400)
+
+	// Gray
+	avutil.AvFrameUnref(f)
+	gray := image.NewGray(image.Rect(0, 0, 2, 1))
+	copy(gray.Pix, []uint8{0, 255})
+	assert.NoError(t, writeImage(gray, f))
+	assert.Equal(t, avutil.AV_PIX_FMT_GRAY8, avutil.PixelFormat(f.Format()))
+	o, err = frameImage(f)
+	assert.NoError(t, err)
+	assert.Equal(t, gray, o)
+
+	// Unhandled pixel format
+	avutil.AvFrameUnref(f)
+	f.SetFormat(int(avutil.AV_PIX_FMT_NV12))
+	assert.Error(t, writeImage(gray, f))
+}
@@ -0,0 +1,276 @@
+package astilibav
+
+import (
+	"math"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/asticode/goav/avutil"
+)
+
+// KeyFrameAligner represents an object capable of deciding whether a frame should be forced as a key frame so that
+// several encoders fed by the same input place their key frames at the same pts
+type KeyFrameAligner interface {
+	ShouldForce(f *avutil.Frame, d Descriptor, id interface{}) bool
+}
+
+type keyFrameAlignerWithInterval struct {
+	interval   time.Duration
+	m          *sync.Mutex
+	tolerances keyFrameTolerances
+	windows    map[interface{}]int64
+}
+
+// NewKeyFrameAlignerWithInterval creates a new key frame aligner that forces a key frame on the first frame of every
+// window of the provided duration
+// Windows are computed based on the frame pts so that all encoders sharing the aligner pick the same frames
+func NewKeyFrameAlignerWithInterval(interval time.Duration) KeyFrameAligner {
+	return &keyFrameAlignerWithInterval{
+		interval:   interval,
+		m:          &sync.Mutex{},
+		tolerances: make(keyFrameTolerances),
+		windows:    make(map[interface{}]int64),
+	}
+}
+
+// ShouldForce implements the KeyFrameAligner interface
+func (a *keyFrameAlignerWithInterval) ShouldForce(f *avutil.Frame, d Descriptor, id interface{}) bool {
+	// Invalid interval
+	if a.interval <= 0 {
+		return false
+	}
+
+	// Get pts
+	pts := avutil.AvRescaleQ(f.Pts(), d.TimeBase(), nanosecondRational)
+
+	// Lock
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	// Get window
+	// The tolerance makes sure frames slightly before the window start because of rescaling belong to the window
+	w := int64(math.Floor(float64(pts+a.tolerances.get(id, pts)) / float64(a.interval)))
+
+	// Window has already been processed
+	if pw, ok := a.windows[id]; ok && pw >= w {
+		return false
+	}
+
+	// Store window
+	a.windows[id] = w
+	return true
+}
+
+// KeyFrameAlignerSceneCutOptions represents scene cut key frame aligner options
+type KeyFrameAlignerSceneCutOptions struct {
+	// Key frames are forced at least every MaxInterval. Disabled if <= 0
+	MaxInterval time.Duration
+	// Scene cuts occurring less than MinInterval after the previous key frame are ignored
+	MinInterval time.Duration
+	// Scene score (between 0 and 1) above which a frame is considered a scene cut. Defaults to 0.4
+	Threshold float64
+}
+
+type keyFrameAlignerWithSceneCut struct {
+	decisions       *keyFrameDecisions
+	lastKeyFramePts *int64
+	m               *sync.Mutex
+	o               KeyFrameAlignerSceneCutOptions
+	thumbnails      map[interface{}]*lumaThumbnail
+	tolerances      keyFrameTolerances
+}
+
+// NewKeyFrameAlignerWithSceneCut creates a new key frame aligner that forces key frames on scene cuts
+// The first encoder reaching a pts decides whether it's a scene cut and other encoders sharing the aligner reuse that
+// decision so that their key frames stay aligned
+func NewKeyFrameAlignerWithSceneCut(o KeyFrameAlignerSceneCutOptions) KeyFrameAligner {
+	if o.Threshold <= 0 {
+		o.Threshold = 0.4
+	}
+	return &keyFrameAlignerWithSceneCut{
+		decisions:  newKeyFrameDecisions(),
+		m:          &sync.Mutex{},
+		o:          o,
+		thumbnails: make(map[interface{}]*lumaThumbnail),
+		tolerances: make(keyFrameTolerances),
+	}
+}
+
+// ShouldForce implements the KeyFrameAligner interface
+func (a *keyFrameAlignerWithSceneCut) ShouldForce(f *avutil.Frame, d Descriptor, id interface{}) (force bool) {
+	// Get pts
+	pts := avutil.AvRescaleQ(f.Pts(), d.TimeBase(), nanosecondRational)
+
+	// Create thumbnail
+	t := newLumaThumbnail(f)
+
+	// Lock
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	// Make sure to store the thumbnail
+	defer func() { a.thumbnails[id] = t }()
+
+	// Decision has already been made by another encoder
+	var ok bool
+	if force, ok = a.decisions.get(pts, a.tolerances.get(id, pts)); ok {
+		return
+	}
+
+	// Decide
+	if a.lastKeyFramePts == nil {
+		force = true
+	} else if delta := time.Duration(pts - *a.lastKeyFramePts); a.o.MaxInterval > 0 && delta >= a.o.MaxInterval {
+		force = true
+	} else if delta >= a.o.MinInterval {
+		if pt, ok := a.thumbnails[id]; ok {
+			force = t.score(pt) >= a.o.Threshold
+		}
+	}
+
+	// Store decision
+	a.decisions.set(pts, force)
+	if force {
+		a.lastKeyFramePts = &pts
+	}
+	return
+}
+
+const (
+	// Decisions are kept for that long after the most recent one so that encoders lagging behind or starting late can
+	// still reuse them
+	keyFrameDecisionRetention = 10 * time.Second
+	// Tolerance used when the frame duration is still unknown. It only absorbs rescaling rounding errors
+	defaultKeyFrameTolerance = time.Millisecond
+)
+
+// keyFrameDecisions stores key frame decisions indexed by pts in nanoseconds
+type keyFrameDecisions struct {
+	ds     map[int64]bool
+	maxPts *int64
+}
+
+func newKeyFrameDecisions() *keyFrameDecisions {
+	return &keyFrameDecisions{ds: make(map[int64]bool)}
+}
+
+// get returns the decisions made in [pts - tolerance, pts + tolerance[
+// Renditions may have been rescaled differently or may have different frame rates, therefore a frame matches decisions
+// made for any pts it covers. The interval is half-open so that a decision doesn't match 2 consecutive frames.
+func (ds *keyFrameDecisions) get(pts, tolerance int64) (force, ok bool) {
+	for k, v := range ds.ds {
+		if k >= pts-tolerance && k < pts+tolerance {
+			ok = true
+			force = force || v
+		}
+	}
+	return
+}
+
+func (ds *keyFrameDecisions) set(pts int64, force bool) {
+	// Store decision
+	ds.ds[pts] = force
+
+	// Update max pts
+	if ds.maxPts == nil || pts > *ds.maxPts {
+		ds.maxPts = &pts
+	}
+
+	// Remove decisions that are too old
+	for k := range ds.ds {
+		if k < *ds.maxPts-int64(keyFrameDecisionRetention) {
+			delete(ds.ds, k)
+		}
+	}
+}
+
+// keyFrameTolerances stores the previous pts of each encoder in order to compute their tolerance which is half the
+// duration of a frame
+type keyFrameTolerances map[interface{}]int64
+
+func (ts keyFrameTolerances) get(id interface{}, pts int64) (t int64) {
+	t = int64(defaultKeyFrameTolerance)
+	if p, ok := ts[id]; ok && pts > p {
+		t = (pts - p) / 2
+	}
+	ts[id] = pts
+	return
+}
+
+const (
+	lumaThumbnailHeight = 18
+	lumaThumbnailWidth  = 32
+)
+
+// lumaThumbnail is a low resolution version of the luma plane used to compute scene scores cheaply
+type lumaThumbnail struct {
+	vs [lumaThumbnailHeight * lumaThumbnailWidth]float64
+}
+
+func newLumaThumbnail(f *avutil.Frame) (t *lumaThumbnail) {
+	// Only planar yuv and gray pixel formats have a luma plane first
+	if !isLumaFirstPixelFormat(avutil.PixelFormat(f.Format())) || f.Width() < lumaThumbnailWidth || f.Height() < lumaThumbnailHeight {
+		return
+	}
+
+	// Get plane
+	data := avutil.Data(f)
+	linesize := int(avutil.Linesize(f)[0])
+	if data[0] == nil || linesize <= 0 {
+		return
+	}
+	plane := (*[1 << 30]uint8)(unsafe.Pointer(data[0]))[: linesize*f.Height() : linesize*f.Height()]
+
+	// Loop through cells
+	t = &lumaThumbnail{}
+	cw, ch := f.Width()/lumaThumbnailWidth, f.Height()/lumaThumbnailHeight
+	for y := 0; y < lumaThumbnailHeight; y++ {
+		for x := 0; x < lumaThumbnailWidth; x++ {
+			// Sample cell with a stride to keep things cheap
+			var sum, count float64
+			for cy := y * ch; cy < (y+1)*ch; cy += 2 {
+				for cx := x * cw; cx < (x+1)*cw; cx += 2 {
+					sum += float64(plane[cy*linesize+cx])
+					count++
+				}
+			}
+			if count > 0 {
+				t.vs[y*lumaThumbnailWidth+x] = sum / count
+			}
+		}
+	}
+	return
+}
+
+// score returns the mean absolute difference between 2 thumbnails normalized between 0 and 1
+func (t *lumaThumbnail) score(p *lumaThumbnail) float64 {
+	if t == nil || p == nil {
+		return 0
+	}
+	var sum float64
+	for idx := range t.vs {
+		sum += math.Abs(t.vs[idx] - p.vs[idx])
+	}
+	return sum / float64(len(t.vs)) / 255
+}
+
+func isLumaFirstPixelFormat(f avutil.PixelFormat) bool {
+	switch f {
+	case avutil.AV_PIX_FMT_GRAY8,
+		avutil.AV_PIX_FMT_NV12,
+		avutil.AV_PIX_FMT_NV21,
+		avutil.AV_PIX_FMT_YUV410P,
+		avutil.AV_PIX_FMT_YUV411P,
+		avutil.AV_PIX_FMT_YUV420P,
+		avutil.AV_PIX_FMT_YUV422P,
+		avutil.AV_PIX_FMT_YUV440P,
+		avutil.AV_PIX_FMT_YUV444P,
+		avutil.AV_PIX_FMT_YUVJ420P,
+		avutil.AV_PIX_FMT_YUVJ422P,
+		avutil.AV_PIX_FMT_YUVJ440P,
+		avutil.AV_PIX_FMT_YUVJ444P:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package astilibav
+
+import (
+	"testing"
+	"time"
+
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyFrameAlignerWithInterval(t *testing.T) {
+	// Create aligner
+	a := NewKeyFrameAlignerWithInterval(2 * time.Second)
+	f := avutil.AvFrameAlloc()
+	defer avutil.AvFrameFree(f)
+
+	// First encoder: 25fps in a 1/1000 time base
+	d1 := newTimeBaseDescriptor(avutil.NewRational(1, 1000))
+	var fs1 []int64
+	for pts := int64(0); pts < 5000; pts += 40 {
+		f.SetPts(pts)
+		if a.ShouldForce(f, d1, "1") {
+			fs1 = append(fs1, pts)
+		}
+	}
+	assert.Equal(t, []int64{0, 2000, 4000}, fs1)
+
+	// Second encoder: 50fps in a 1/90000 time base whose pts are 1 tick early because of rescaling
+	d2 := newTimeBaseDescriptor(avutil.NewRational(1, 90000))
+	var fs2 []int64
+	for pts := int64(0); pts < 450000; pts += 1800 {
+		v := pts
+		if v > 0 {
+			v--
+		}
+		f.SetPts(v)
+		if a.ShouldForce(f, d2, "2") {
+			fs2 = append(fs2, pts)
+		}
+	}
+	assert.Equal(t, []int64{0, 180000, 360000}, fs2)
+}
+
+func TestKeyFrameDecisions(t *testing.T) {
+	ds := newKeyFrameDecisions()
+	ds.set(int64(time.Second), true)
+	ds.set(int64(time.Second+40*time.Millisecond), false)
+
+	// Exact match
+	force, ok := ds.get(int64(time.Second), int64(20*time.Millisecond))
+	assert.True(t, ok)
+	assert.True(t, force)
+
+	// Rounding error
+	force, ok = ds.get(int64(time.Second)-1, int64(time.Millisecond))
+	assert.True(t, ok)
+	assert.True(t, force)
+
+	// Lower frame rate covering the decision
+	force, ok = ds.get(int64(time.Second+20*time.Millisecond), int64(40*time.Millisecond))
+	assert.True(t, ok)
+	assert.True(t, force)
+
+	// Interval is half-open
+	force, ok = ds.get(int64(time.Second-20*time.Millisecond), int64(20*time.Millisecond))
+	assert.False(t, ok)
+	assert.False(t, force)
+
+	// Old decisions are purged
+	ds.set(int64(time.Second+keyFrameDecisionRetention+time.Millisecond), false)
+	_, ok = ds.get(int64(time.Second), int64(time.Millisecond))
+	assert.False(t, ok)
+	_, ok = ds.get(int64(time.Second+40*time.Millisecond), int64(time.Millisecond))
+	assert.True(t, ok)
+}
+
+func TestKeyFrameTolerances(t *testing.T) {
+	ts := make(keyFrameTolerances)
+	assert.Equal(t, int64(defaultKeyFrameTolerance), ts.get("1", 0))
+	assert.Equal(t, int64(20*time.Millisecond), ts.get("1", int64(40*time.Millisecond)))
+	assert.Equal(t, int64(defaultKeyFrameTolerance), ts.get("2", int64(40*time.Millisecond)))
+}
@@ -0,0 +1,671 @@
+package astilibav
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astitools/stat"
+	"github.com/asticode/go-astitools/sync"
+	"github.com/asticode/go-astitools/worker"
+	"github.com/pkg/errors"
+)
+
+var countLoudnessMeter uint64
+
+// LoudnessMeter represents an object capable of measuring the loudness of audio frames as described in EBU R128
+type LoudnessMeter struct {
+	*astiencoder.BaseNode
+	eh               *astiencoder.EventHandler
+	m                *sync.Mutex
+	o                LoudnessMeterOptions
+	q                *astisync.CtxQueue
+	s                *loudnessState
+	statIncomingRate *astistat.IncrementStat
+	statIntegrated   *valueStat
+	statLRA          *valueStat
+	statMomentary    *valueStat
+	statShortTerm    *valueStat
+	statTruePeak     *valueStat
+	statWorkRatio    *astistat.DurationRatioStat
+}
+
+// LoudnessMeterOptions represents loudness meter options
+type LoudnessMeterOptions struct {
+	Node astiencoder.NodeOptions
+}
+
+// LoudnessMeasurement represents a loudness measurement
+type LoudnessMeasurement struct {
+	// Integrated loudness in LUFS
+	Integrated float64 `json:"integrated"`
+	// Loudness range in LU
+	LRA float64 `json:"lra"`
+	// Momentary loudness (400ms) in LUFS
+	Momentary float64 `json:"momentary"`
+	// Short-term loudness (3s) in LUFS
+	ShortTerm float64 `json:"short_term"`
+	// Relative gating threshold used to compute the integrated loudness, in LUFS
+	Threshold float64 `json:"threshold"`
+	// True peak in dBTP
+	TruePeak float64 `json:"true_peak"`
+}
+
+// NewLoudnessMeter creates a new loudness meter
+func NewLoudnessMeter(o LoudnessMeterOptions, eh *astiencoder.EventHandler) (m *LoudnessMeter) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countLoudnessMeter, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("loudness_meter_%d", count), fmt.Sprintf("Loudness Meter #%d", count), "Measures loudness")
+
+	// Create meter
+	m = &LoudnessMeter{
+		eh:               eh,
+		m:                &sync.Mutex{},
+		o:                o,
+		q:                astisync.NewCtxQueue(),
+		statIncomingRate: astistat.NewIncrementStat(),
+		statIntegrated:   newValueStat(),
+		statLRA:          newValueStat(),
+		statMomentary:    newValueStat(),
+		statShortTerm:    newValueStat(),
+		statTruePeak:     newValueStat(),
+		statWorkRatio:    astistat.NewDurationRatioStat(),
+	}
+	m.BaseNode = astiencoder.NewBaseNode(o.Node, astiencoder.NewEventGeneratorNode(m), eh)
+	m.addStats()
+	return
+}
+
+func (m *LoudnessMeter) addStats() {
+	// Add incoming rate
+	m.Stater().AddStat(astistat.StatMetadata{
+		Description: "Number of frames coming in per second",
+		Label:       "Incoming rate",
+		Unit:        "fps",
+	}, m.statIncomingRate)
+
+	// Add momentary loudness
+	m.Stater().AddStat(astistat.StatMetadata{
+		Description: "Loudness of the last 400ms",
+		Label:       "Momentary loudness",
+		Unit:        "LUFS",
+	}, m.statMomentary)
+
+	// Add short-term loudness
+	m.Stater().AddStat(astistat.StatMetadata{
+		Description: "Loudness of the last 3s",
+		Label:       "Short-term loudness",
+		Unit:        "LUFS",
+	}, m.statShortTerm)
+
+	// Add integrated loudness
+	m.Stater().AddStat(astistat.StatMetadata{
+		Description: "Gated loudness since the beginning",
+		Label:       "Integrated loudness",
+		Unit:        "LUFS",
+	}, m.statIntegrated)
+
+	// Add loudness range
+	m.Stater().AddStat(astistat.StatMetadata{
+		Description: "Variation of the short-term loudness since the beginning",
+		Label:       "Loudness range",
+		Unit:        "LU",
+	}, m.statLRA)
+
+	// Add true peak
+	m.Stater().AddStat(astistat.StatMetadata{
+		Description: "Max true peak since the beginning",
+		Label:       "True peak",
+		Unit:        "dBTP",
+	}, m.statTruePeak)
+
+	// Add work ratio
+	m.Stater().AddStat(astistat.StatMetadata{
+		Description: "Percentage of time spent doing some actual work",
+		Label:       "Work ratio",
+		Unit:        "%",
+	}, m.statWorkRatio)
+
+	// Add queue stats
+	m.q.AddStats(m.Stater())
+}
+
+// Measurement returns the loudness measured so far
+func (m *LoudnessMeter) Measurement() LoudnessMeasurement {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.s == nil {
+		return LoudnessMeasurement{
+			Integrated: minDB,
+			Momentary:  minDB,
+			ShortTerm:  minDB,
+			Threshold:  minDB,
+			TruePeak:   minDB,
+		}
+	}
+	return m.s.measurement()
+}
+
+// ExposedData implements the astiencoder.NodeExposer interface
+func (m *LoudnessMeter) ExposedData() interface{} {
+	return m.Measurement()
+}
+
+// Start starts the loudness meter
+func (m *LoudnessMeter) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	m.BaseNode.Start(ctx, t, func(t *astiworker.Task) {
+		// Handle context
+		go m.q.HandleCtx(m.Context())
+
+		// Make sure to stop the queue properly
+		defer m.q.Stop()
+
+		// Start queue
+		m.q.Start(func(dp interface{}) {
+			// Handle pause
+			defer m.HandlePause()
+
+			// Assert payload
+			p := dp.(*FrameHandlerPayload)
+
+			// Increment incoming rate
+			m.statIncomingRate.Add(1)
+
+			// Measure
+			m.statWorkRatio.Add(true)
+			if err := m.measure(p); err != nil {
+				m.statWorkRatio.Done(true)
+				m.eh.Emit(astiencoder.EventError(m, errors.Wrap(err, "astilibav: measuring loudness failed")))
+				return
+			}
+			m.statWorkRatio.Done(true)
+		})
+
+		// Let listeners know the final measurement is available
+		m.eh.Emit(astiencoder.Event{
+			Name:   astiencoder.EventNameNodeDataChanged,
+			Target: m,
+		})
+	})
+}
+
+func (m *LoudnessMeter) measure(p *FrameHandlerPayload) (err error) {
+	// Get samples
+	var s AudioSamples
+	if s, err = frameAudioSamples(p.Frame); err != nil {
+		err = errors.Wrap(err, "astilibav: getting audio samples failed")
+		return
+	}
+	ss := s.normalized()
+
+	// Lock
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	// Create state
+	// Filters only work for a specific sample rate and channels count, the measurement is therefore reset when they
+	// change
+	if m.s == nil || m.s.sampleRate != p.Frame.SampleRate() || len(m.s.channels) != len(ss) {
+		m.s = newLoudnessState(p.Frame.SampleRate(), len(ss))
+	}
+
+	// Add samples
+	m.s.add(ss)
+
+	// Update stats
+	ms := m.s.measurement()
+	m.statIntegrated.set(ms.Integrated)
+	m.statLRA.set(ms.LRA)
+	m.statMomentary.set(ms.Momentary)
+	m.statShortTerm.set(ms.ShortTerm)
+	m.statTruePeak.set(ms.TruePeak)
+	return
+}
+
+// HandleFrame implements the FrameHandler interface
+func (m *LoudnessMeter) HandleFrame(p *FrameHandlerPayload) {
+	m.q.Send(p)
+}
+
+// MeasureLoudnessOptions represents measure loudness options
+type MeasureLoudnessOptions struct {
+	// String content of the demuxer as you would use in ffmpeg
+	Dict string
+	// Index of the audio stream to measure
+	StreamIndex int
+	URL         string
+}
+
+// MeasureLoudness measures the loudness of an audio stream by decoding it entirely, which is the first pass of a two
+// pass loudness normalization
+func MeasureLoudness(ctx context.Context, o MeasureLoudnessOptions) (m LoudnessMeasurement, err error) {
+	// Create closer
+	c := astiencoder.NewCloser()
+	defer c.Close()
+
+	// Create event handler
+	eh := astiencoder.NewEventHandler()
+
+	// Store the first error
+	var errFirst error
+	var errM sync.Mutex
+	eh.AddForEventName(astiencoder.EventNameError, func(e astiencoder.Event) bool {
+		errM.Lock()
+		defer errM.Unlock()
+		if errFirst == nil {
+			errFirst = e.Payload.(error)
+		}
+		return false
+	})
+
+	// Create worker
+	w := astiworker.NewWorker()
+	defer w.Stop()
+
+	// Create workflow
+	wf := astiencoder.NewWorkflow(ctx, "loudness", eh, w.NewTask, c)
+
+	// Create demuxer
+	var d *Demuxer
+	if d, err = NewDemuxer(DemuxerOptions{
+		Dict: o.Dict,
+		URL:  o.URL,
+	}, eh, c); err != nil {
+		err = errors.Wrap(err, "astilibav: creating demuxer failed")
+		return
+	}
+	wf.AddChild(d)
+
+	// Get stream
+	ss := d.CtxFormat().Streams()
+	if o.StreamIndex < 0 || o.StreamIndex >= len(ss) {
+		err = fmt.Errorf("astilibav: invalid stream index %d", o.StreamIndex)
+		return
+	}
+	s := ss[o.StreamIndex]
+
+	// Create decoder
+	var dc *Decoder
+	if dc, err = NewDecoder(DecoderOptions{CodecParams: s.CodecParameters()}, eh, c); err != nil {
+		err = errors.Wrap(err, "astilibav: creating decoder failed")
+		return
+	}
+	d.ConnectForStream(dc, s)
+
+	// Create loudness meter
+	lm := NewLoudnessMeter(LoudnessMeterOptions{}, eh)
+	dc.Connect(lm)
+
+	// Make sure to know when the workflow is stopped
+	done := make(chan struct{})
+	eh.AddForEventName(astiencoder.EventNameWorkflowStopped, func(e astiencoder.Event) bool {
+		close(done)
+		return true
+	})
+
+	// Start workflow
+	// The workflow wouldn't start, and therefore wouldn't stop, with a cancelled context
+	if err = ctx.Err(); err != nil {
+		err = errors.Wrap(err, "astilibav: context error")
+		return
+	}
+	wf.Start()
+
+	// Wait for the workflow to be stopped
+	select {
+	case <-done:
+	case <-ctx.Done():
+		wf.Stop()
+		<-done
+		err = errors.Wrap(ctx.Err(), "astilibav: context error")
+		return
+	}
+
+	// An error occurred
+	errM.Lock()
+	defer errM.Unlock()
+	if errFirst != nil {
+		err = errors.Wrap(errFirst, "astilibav: workflow failed")
+		return
+	}
+
+	// Get measurement
+	m = lm.Measurement()
+	return
+}
+
+const (
+	loudnessHistogramMin  = -70
+	loudnessHistogramSize = 8000
+	loudnessHistogramStep = 0.01
+)
+
+// loudnessState implements the ITU-R BS.1770-4 loudness and EBU Tech 3342 loudness range algorithms
+type loudnessState struct {
+	channels       []*loudnessChannel
+	gating         *loudnessHistogram
+	lra            *loudnessHistogram
+	momentary      float64
+	sampleRate     int
+	shortTerm      float64
+	subBlockCount  int
+	subBlockEnergy float64
+	subBlockSize   int
+	subBlocks      []float64
+	truePeak       float64
+}
+
+type loudnessChannel struct {
+	filters []*biquad
+	history []float64
+	weight  float64
+}
+
+func newLoudnessState(sampleRate, channels int) (s *loudnessState) {
+	s = &loudnessState{
+		gating:       newLoudnessHistogram(),
+		lra:          newLoudnessHistogram(),
+		momentary:    minDB,
+		sampleRate:   sampleRate,
+		shortTerm:    minDB,
+		subBlockSize: sampleRate / 10,
+	}
+	for idx := 0; idx < channels; idx++ {
+		s.channels = append(s.channels, &loudnessChannel{
+			filters: kWeightingFilters(float64(sampleRate)),
+			history: make([]float64, len(truePeakFilter)/truePeakOversampling),
+			weight:  loudnessChannelWeight(idx, channels),
+		})
+	}
+	return
+}
+
+// loudnessChannelWeight assumes the libav channel order. LFE is ignored and surround channels are boosted
+func loudnessChannelWeight(idx, channels int) float64 {
+	if channels != 6 {
+		return 1
+	}
+	switch idx {
+	case 3:
+		return 0
+	case 4, 5:
+		return 1.41
+	}
+	return 1
+}
+
+// add adds samples indexed by channel
+func (s *loudnessState) add(ss [][]float64) {
+	// No samples
+	if len(ss) == 0 {
+		return
+	}
+
+	// Loop through samples
+	for idx := range ss[0] {
+		// Loop through channels
+		for c, ch := range s.channels {
+			// Update true peak
+			x := ss[c][idx]
+			if v := ch.truePeak(x); v > s.truePeak {
+				s.truePeak = v
+			}
+
+			// Apply K-weighting
+			y := x
+			for _, f := range ch.filters {
+				y = f.process(y)
+			}
+
+			// Update energy
+			s.subBlockEnergy += ch.weight * y * y
+		}
+
+		// Sub block is not complete
+		if s.subBlockCount++; s.subBlockCount < s.subBlockSize {
+			continue
+		}
+
+		// Store sub block
+		// Only the last 3s are kept
+		s.subBlocks = append(s.subBlocks, s.subBlockEnergy/float64(s.subBlockSize))
+		if len(s.subBlocks) > 30 {
+			s.subBlocks = s.subBlocks[len(s.subBlocks)-30:]
+		}
+		s.subBlockCount = 0
+		s.subBlockEnergy = 0
+
+		// Update momentary loudness
+		// Momentary blocks overlap by 75% and are used for gating
+		if len(s.subBlocks) >= 4 {
+			e := meanEnergy(s.subBlocks[len(s.subBlocks)-4:])
+			s.momentary = energyToLUFS(e)
+			s.gating.add(e)
+		}
+
+		// Update short-term loudness
+		if len(s.subBlocks) >= 30 {
+			e := meanEnergy(s.subBlocks)
+			s.shortTerm = energyToLUFS(e)
+			s.lra.add(e)
+		}
+	}
+}
+
+func (s *loudnessState) measurement() (m LoudnessMeasurement) {
+	m.Integrated, m.Threshold = s.gating.integrated()
+	m.LRA = s.lra.loudnessRange()
+	m.Momentary = s.momentary
+	m.ShortTerm = s.shortTerm
+	m.TruePeak = amplitudeToDB(s.truePeak)
+	return
+}
+
+func meanEnergy(es []float64) float64 {
+	var sum float64
+	for _, e := range es {
+		sum += e
+	}
+	return sum / float64(len(es))
+}
+
+func energyToLUFS(e float64) float64 {
+	if e <= 0 {
+		return minDB
+	}
+	return math.Max(-0.691+10*math.Log10(e), minDB)
+}
+
+// loudnessHistogram stores blocks by loudness so that memory doesn't grow with the duration
+// Blocks under the absolute gate are ignored
+type loudnessHistogram struct {
+	counts   []uint64
+	energies []float64
+}
+
+func newLoudnessHistogram() *loudnessHistogram {
+	return &loudnessHistogram{
+		counts:   make([]uint64, loudnessHistogramSize),
+		energies: make([]float64, loudnessHistogramSize),
+	}
+}
+
+func (h *loudnessHistogram) add(e float64) {
+	// Absolute gate
+	l := energyToLUFS(e)
+	if l < loudnessHistogramMin {
+		return
+	}
+
+	// Add
+	idx := h.index(l)
+	h.counts[idx]++
+	h.energies[idx] += e
+}
+
+func (h *loudnessHistogram) index(l float64) int {
+	idx := int((l - loudnessHistogramMin) / loudnessHistogramStep)
+	if idx < 0 {
+		return 0
+	} else if idx >= loudnessHistogramSize {
+		return loudnessHistogramSize - 1
+	}
+	return idx
+}
+
+// relativeGate returns the index of the first block above the mean loudness minus the offset
+func (h *loudnessHistogram) relativeGate(offset float64) (idx int, threshold float64, ok bool) {
+	var count uint64
+	var energy float64
+	for idx := range h.counts {
+		count += h.counts[idx]
+		energy += h.energies[idx]
+	}
+	if count == 0 {
+		return
+	}
+	threshold = energyToLUFS(energy/float64(count)) - offset
+	idx = h.index(threshold)
+	ok = true
+	return
+}
+
+func (h *loudnessHistogram) integrated() (l, threshold float64) {
+	// Get relative gate
+	start, threshold, ok := h.relativeGate(10)
+	if !ok {
+		return minDB, minDB
+	}
+
+	// Get mean energy above the gate
+	var count uint64
+	var energy float64
+	for idx := start; idx < len(h.counts); idx++ {
+		count += h.counts[idx]
+		energy += h.energies[idx]
+	}
+	if count == 0 {
+		return minDB, threshold
+	}
+	return energyToLUFS(energy / float64(count)), threshold
+}
+
+func (h *loudnessHistogram) loudnessRange() float64 {
+	// Get relative gate
+	start, _, ok := h.relativeGate(20)
+	if !ok {
+		return 0
+	}
+
+	// Count blocks above the gate
+	var count uint64
+	for idx := start; idx < len(h.counts); idx++ {
+		count += h.counts[idx]
+	}
+	if count == 0 {
+		return 0
+	}
+
+	// Get percentiles
+	low, high := h.percentile(start, count, 0.1), h.percentile(start, count, 0.95)
+	return high - low
+}
+
+func (h *loudnessHistogram) percentile(start int, count uint64, p float64) float64 {
+	target := uint64(math.Ceil(p * float64(count)))
+	var sum uint64
+	for idx := start; idx < len(h.counts); idx++ {
+		if sum += h.counts[idx]; sum >= target && sum > 0 {
+			return loudnessHistogramMin + float64(idx)*loudnessHistogramStep
+		}
+	}
+	return loudnessHistogramMin + float64(len(h.counts)-1)*loudnessHistogramStep
+}
+
+// biquad represents a biquad filter in the transposed direct form II
+type biquad struct {
+	a1, a2     float64
+	b0, b1, b2 float64
+	z1, z2     float64
+}
+
+func (f *biquad) process(x float64) (y float64) {
+	y = f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return
+}
+
+// kWeightingFilters returns the high shelf and high pass filters of the K-weighting for any sample rate
+func kWeightingFilters(sampleRate float64) []*biquad {
+	// High shelf
+	f0, g, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / sampleRate)
+	vh := math.Pow(10, g/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := &biquad{
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+	}
+
+	// High pass
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / sampleRate)
+	a0 = 1 + k/q + k*k
+	pass := &biquad{
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+		b0: 1,
+		b1: -2,
+		b2: 1,
+	}
+	return []*biquad{shelf, pass}
+}
+
+const truePeakOversampling = 4
+
+// truePeakFilter is a 48 taps windowed sinc low pass filter used to oversample by 4
+var truePeakFilter = func() (h []float64) {
+	n := 12 * truePeakOversampling
+	h = make([]float64, n)
+	for idx := range h {
+		// Sinc
+		t := (float64(idx) - float64(n-1)/2) / truePeakOversampling
+		v := 1.0
+		if t != 0 {
+			v = math.Sin(math.Pi*t) / (math.Pi * t)
+		}
+
+		// Blackman window
+		w := 0.42 - 0.5*math.Cos(2*math.Pi*float64(idx)/float64(n-1)) + 0.08*math.Cos(4*math.Pi*float64(idx)/float64(n-1))
+		h[idx] = v * w
+	}
+	return
+}()
+
+// truePeak returns the max absolute value of the sample and of the values interpolated before it
+func (c *loudnessChannel) truePeak(x float64) (peak float64) {
+	// Update history
+	copy(c.history[1:], c.history[:len(c.history)-1])
+	c.history[0] = x
+
+	// Loop through phases
+	peak = math.Abs(x)
+	for p := 0; p < truePeakOversampling; p++ {
+		var y float64
+		for k, v := range c.history {
+			y += truePeakFilter[p+k*truePeakOversampling] * v
+		}
+		if y = math.Abs(y); y > peak {
+			peak = y
+		}
+	}
+	return
+}
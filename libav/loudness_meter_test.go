@@ -0,0 +1,112 @@
+package astilibav
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func lufsToEnergy(l float64) float64 {
+	return math.Pow(10, (l+0.691)/10)
+}
+
+func TestEnergyToLUFS(t *testing.T) {
+	assert.Equal(t, float64(minDB), energyToLUFS(0))
+	assert.InDelta(t, -0.691, energyToLUFS(1), 1e-9)
+	assert.InDelta(t, -23, energyToLUFS(lufsToEnergy(-23)), 1e-9)
+}
+
+func TestLoudnessChannelWeight(t *testing.T) {
+	assert.Equal(t, 1.0, loudnessChannelWeight(1, 2))
+	assert.Equal(t, 1.0, loudnessChannelWeight(2, 6))
+	assert.Equal(t, 0.0, loudnessChannelWeight(3, 6))
+	assert.Equal(t, 1.41, loudnessChannelWeight(4, 6))
+	assert.Equal(t, 1.41, loudnessChannelWeight(5, 6))
+}
+
+func TestKWeightingFilters(t *testing.T) {
+	// Coefficients at 48kHz are the ones listed in ITU-R BS.1770
+	fs := kWeightingFilters(48000)
+	assert.Len(t, fs, 2)
+	assert.InDelta(t, -1.69065929318241, fs[0].a1, 1e-6)
+	assert.InDelta(t, 0.73248077421585, fs[0].a2, 1e-6)
+	assert.InDelta(t, 1.53512485958697, fs[0].b0, 1e-6)
+	assert.InDelta(t, -2.69169618940638, fs[0].b1, 1e-6)
+	assert.InDelta(t, 1.19839281085285, fs[0].b2, 1e-6)
+	assert.InDelta(t, -1.99004745483398, fs[1].a1, 1e-6)
+	assert.InDelta(t, 0.99007225036621, fs[1].a2, 1e-6)
+	assert.Equal(t, 1.0, fs[1].b0)
+	assert.Equal(t, -2.0, fs[1].b1)
+	assert.Equal(t, 1.0, fs[1].b2)
+
+	// DC is removed by the high pass filter
+	f := kWeightingFilters(44100)[1]
+	var y float64
+	for idx := 0; idx < 44100; idx++ {
+		y = f.process(1)
+	}
+	assert.InDelta(t, 0, y, 1e-3)
+}
+
+func TestLoudnessHistogram(t *testing.T) {
+	// Empty
+	h := newLoudnessHistogram()
+	l, th := h.integrated()
+	assert.Equal(t, float64(minDB), l)
+	assert.Equal(t, float64(minDB), th)
+	assert.Equal(t, 0.0, h.loudnessRange())
+
+	// Blocks under the absolute gate are ignored and blocks under the relative gate are not taken into account
+	for idx := 0; idx < 10; idx++ {
+		h.add(lufsToEnergy(-20))
+		h.add(lufsToEnergy(-60))
+		h.add(lufsToEnergy(-80))
+	}
+	assert.Equal(t, uint64(20), h.counts[h.index(-20)]+h.counts[h.index(-60)]+h.counts[h.index(-80)])
+	l, th = h.integrated()
+	assert.InDelta(t, -20, l, 1e-9)
+	assert.InDelta(t, -33.01, th, 0.01)
+
+	// Loudness range is the difference between the 10th and the 95th percentiles
+	h = newLoudnessHistogram()
+	for idx := 0; idx < 100; idx++ {
+		h.add(lufsToEnergy(-30 + float64(idx)*0.2))
+	}
+	assert.InDelta(t, 17, h.loudnessRange(), 0.02)
+}
+
+func TestLoudnessState(t *testing.T) {
+	// Stereo 1kHz sine at -23dBFS measures -23LUFS
+	s := newLoudnessState(48000, 2)
+	a := math.Pow(10, -23.0/20)
+	ss := [][]float64{make([]float64, 48000), make([]float64, 48000)}
+	for sec := 0; sec < 20; sec++ {
+		for idx := range ss[0] {
+			v := a * math.Sin(2*math.Pi*1000*float64(sec*48000+idx)/48000)
+			ss[0][idx] = v
+			ss[1][idx] = v
+		}
+		s.add(ss)
+	}
+	m := s.measurement()
+	assert.InDelta(t, -23, m.Integrated, 0.1)
+	assert.InDelta(t, -33, m.Threshold, 0.1)
+	assert.InDelta(t, 0, m.LRA, 0.1)
+	assert.InDelta(t, -23, m.Momentary, 0.1)
+	assert.InDelta(t, -23, m.ShortTerm, 0.1)
+	assert.InDelta(t, -23, m.TruePeak, 0.1)
+}
+
+func TestLoudnessChannelTruePeak(t *testing.T) {
+	// Samples of a sine at a quarter of the sample rate with a 45° phase never reach its peak
+	c := &loudnessChannel{history: make([]float64, len(truePeakFilter)/truePeakOversampling)}
+	var samplePeak, truePeak float64
+	for idx := 0; idx < 100; idx++ {
+		x := math.Sin(math.Pi/2*float64(idx) + math.Pi/4)
+		samplePeak = math.Max(samplePeak, math.Abs(x))
+		truePeak = math.Max(truePeak, c.truePeak(x))
+	}
+	assert.InDelta(t, math.Sqrt2/2, samplePeak, 1e-9)
+	assert.InDelta(t, 1, truePeak, 0.05)
+}
@@ -0,0 +1,200 @@
+package astilibav
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astitools/stat"
+	"github.com/asticode/go-astitools/sync"
+	"github.com/asticode/go-astitools/worker"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avformat"
+	"github.com/pkg/errors"
+)
+
+var countPktSource uint64
+
+// PktSource represents an object capable of dispatching packets created out of encoded payloads pushed from Go
+type PktSource struct {
+	*astiencoder.BaseNode
+	cp             *avcodec.CodecParameters
+	d              *pktDispatcher
+	descriptor     Descriptor
+	eh             *astiencoder.EventHandler
+	o              PktSourceOptions
+	q              *astisync.CtxQueue
+	statPushedRate *astistat.IncrementStat
+}
+
+// PktSourceOptions represents pkt source options
+type PktSourceOptions struct {
+	// Codec id, codec type and time base are mandatory. Audio sources use channel layout, channels, sample format and
+	// sample rate, and video sources use height, pixel format, sample aspect ratio and width. Bit rate is used as well
+	Ctx Context
+	// Out-of-band codec configuration such as the h264 avcC box or the aac AudioSpecificConfig
+	ExtraData []byte
+	Node      astiencoder.NodeOptions
+}
+
+// PktSourcePkt represents a pkt pushed in a pkt source
+// Timestamps and duration must be in the time base of the context
+type PktSourcePkt struct {
+	Data     []byte
+	Dts      int64
+	Duration int64
+	KeyFrame bool
+	Pts      int64
+}
+
+// NewPktSource creates a new pkt source
+func NewPktSource(o PktSourceOptions, eh *astiencoder.EventHandler, c *astiencoder.Closer) (s *PktSource, err error) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countPktSource, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("pkt_source_%d", count), fmt.Sprintf("Pkt Source #%d", count), "Sources packets")
+
+	// Create source
+	s = &PktSource{
+		d:              newPktDispatcher(c),
+		descriptor:     newTimeBaseDescriptor(o.Ctx.TimeBase),
+		eh:             eh,
+		o:              o,
+		q:              astisync.NewCtxQueue(),
+		statPushedRate: astistat.NewIncrementStat(),
+	}
+	s.BaseNode = astiencoder.NewBaseNode(o.Node, astiencoder.NewEventGeneratorNode(s), eh)
+	s.addStats()
+
+	// Check time base
+	if o.Ctx.TimeBase.Num() <= 0 || o.Ctx.TimeBase.Den() <= 0 {
+		err = fmt.Errorf("astilibav: invalid time base %d/%d", o.Ctx.TimeBase.Num(), o.Ctx.TimeBase.Den())
+		return
+	}
+
+	// Create codec parameters
+	if s.cp, err = newCodecParameters(o.Ctx, o.ExtraData, c); err != nil {
+		err = errors.Wrap(err, "astilibav: creating codec parameters failed")
+		return
+	}
+	return
+}
+
+func (s *PktSource) addStats() {
+	// Add pushed rate
+	s.Stater().AddStat(astistat.StatMetadata{
+		Description: "Number of packets pushed per second",
+		Label:       "Pushed rate",
+		Unit:        "pps",
+	}, s.statPushedRate)
+
+	// Add dispatcher stats
+	s.d.addStats(s.Stater())
+
+	// Add queue stats
+	s.q.AddStats(s.Stater())
+}
+
+// CodecParameters returns the codec parameters matching the pushed packets, that can be used to create a decoder
+func (s *PktSource) CodecParameters() *avcodec.CodecParameters {
+	return s.cp
+}
+
+// AddStream adds a stream matching the pushed packets to a format context, usually the muxer's one
+func (s *PktSource) AddStream(ctxFormat *avformat.Context) (o *avformat.Stream, err error) {
+	// Add stream
+	o = AddStream(ctxFormat)
+
+	// Copy codec parameters
+	if ret := avcodec.AvcodecParametersCopy(o.CodecParameters(), s.cp); ret < 0 {
+		err = errors.Wrapf(NewAvError(ret), "astilibav: avcodec.AvcodecParametersCopy from %+v to %+v failed", s.cp, o.CodecParameters())
+		return
+	}
+
+	// Set other attributes
+	o.SetTimeBase(s.o.Ctx.TimeBase)
+	return
+}
+
+// Connect implements the PktHandlerConnector interface
+func (s *PktSource) Connect(h PktHandler) {
+	// Add handler
+	s.d.addHandler(h)
+
+	// Connect nodes
+	astiencoder.ConnectNodes(s, h)
+}
+
+// Disconnect implements the PktHandlerConnector interface
+func (s *PktSource) Disconnect(h PktHandler) {
+	// Delete handler
+	s.d.delHandler(h)
+
+	// Disconnect nodes
+	astiencoder.DisconnectNodes(s, h)
+}
+
+// Start starts the pkt source
+func (s *PktSource) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	s.BaseNode.Start(ctx, t, func(t *astiworker.Task) {
+		// Handle context
+		go s.q.HandleCtx(s.Context())
+
+		// Make sure to wait for all dispatcher subprocesses to be done so that they are properly closed
+		defer s.d.wait()
+
+		// Make sure to stop the queue properly
+		defer s.q.Stop()
+
+		// Start queue
+		s.q.Start(func(dp interface{}) {
+			// Handle pause
+			defer s.HandlePause()
+
+			// Assert payload
+			pkt := dp.(*avcodec.Packet)
+
+			// Make sure the pkt is put back in the pool once dispatched
+			defer s.d.p.put(pkt)
+
+			// Dispatch pkt
+			s.d.dispatch(pkt, s.descriptor)
+		})
+	})
+}
+
+// Push converts a payload into a pkt and dispatches it asynchronously
+// The payload is copied and can therefore be modified once Push returns
+func (s *PktSource) Push(p PktSourcePkt) (err error) {
+	// Increment pushed rate
+	s.statPushedRate.Add(1)
+
+	// Get pkt
+	pkt := s.d.p.get()
+
+	// Allocate data
+	if ret := pkt.AvNewPacket(len(p.Data)); ret < 0 {
+		s.d.p.put(pkt)
+		err = errors.Wrap(NewAvError(ret), "astilibav: pkt.AvNewPacket failed")
+		return
+	}
+
+	// Copy data
+	if len(p.Data) > 0 {
+		copy((*[1 << 30]byte)(unsafe.Pointer(pkt.Data()))[:len(p.Data):len(p.Data)], p.Data)
+	}
+
+	// Set attributes
+	pkt.SetDts(p.Dts)
+	pkt.SetDuration(p.Duration)
+	if p.KeyFrame {
+		pkt.SetFlags(pkt.Flags() | avcodec.AV_PKT_FLAG_KEY)
+	}
+	pkt.SetPts(p.Pts)
+	pkt.SetStreamIndex(0)
+
+	// Send pkt
+	s.q.Send(pkt)
+	return
+}
@@ -0,0 +1,138 @@
+package astilibav
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astitools/worker"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avformat"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type testPktHandler struct {
+	*astiencoder.BaseNode
+	ch   chan struct{}
+	m    *sync.Mutex
+	pkts []testPkt
+}
+
+type testPkt struct {
+	data        []byte
+	dts         int64
+	duration    int64
+	flags       int
+	pts         int64
+	streamIndex int
+}
+
+func newTestPktHandler(eh *astiencoder.EventHandler) (h *testPktHandler) {
+	h = &testPktHandler{
+		ch: make(chan struct{}, 10),
+		m:  &sync.Mutex{},
+	}
+	h.BaseNode = astiencoder.NewBaseNode(astiencoder.NodeOptions{Metadata: astiencoder.NodeMetadata{Name: "test"}}, astiencoder.NewEventGeneratorNode(h), eh)
+	return
+}
+
+// Start implements the Starter interface
+func (h *testPktHandler) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	h.BaseNode.Start(ctx, t, func(t *astiworker.Task) {
+		<-h.Context().Done()
+	})
+}
+
+func (h *testPktHandler) HandlePkt(p *PktHandlerPayload) {
+	// Pkts are put back in the pool once handled, therefore data must be copied
+	data := make([]byte, p.Pkt.Size())
+	if len(data) > 0 {
+		copy(data, (*[1 << 30]byte)(unsafe.Pointer(p.Pkt.Data()))[:len(data):len(data)])
+	}
+	h.m.Lock()
+	h.pkts = append(h.pkts, testPkt{
+		data:        data,
+		dts:         p.Pkt.Dts(),
+		duration:    p.Pkt.Duration(),
+		flags:       p.Pkt.Flags(),
+		pts:         p.Pkt.Pts(),
+		streamIndex: p.Pkt.StreamIndex(),
+	})
+	h.m.Unlock()
+	h.ch <- struct{}{}
+}
+
+func (h *testPktHandler) wait(t *testing.T, n int) {
+	for idx := 0; idx < n; idx++ {
+		select {
+		case <-h.ch:
+		case <-time.After(time.Second):
+			t.Fatal("timeout while waiting for pkts")
+		}
+	}
+}
+
+func newTestPktSource(t *testing.T, eh *astiencoder.EventHandler, c *astiencoder.Closer) *PktSource {
+	s, err := NewPktSource(PktSourceOptions{Ctx: Context{
+		CodecID:           avcodec.CodecId(avcodec.AV_CODEC_ID_H264),
+		CodecType:         avcodec.AVMEDIA_TYPE_VIDEO,
+		Height:            720,
+		PixelFormat:       avutil.AV_PIX_FMT_YUV420P,
+		SampleAspectRatio: avutil.NewRational(1, 1),
+		TimeBase:          avutil.NewRational(1, 90000),
+		Width:             1280,
+	}}, eh, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestPktSourceAddStream(t *testing.T) {
+	c := astiencoder.NewCloser()
+	defer c.Close()
+	s := newTestPktSource(t, astiencoder.NewEventHandler(), c)
+	ctxFormat := avformat.AvformatAllocContext()
+	defer ctxFormat.AvformatFreeContext()
+
+	// Codec parameters are copied and time base is set
+	o, err := s.AddStream(ctxFormat)
+	assert.NoError(t, err)
+	assert.Equal(t, avcodec.CodecId(avcodec.AV_CODEC_ID_H264), o.CodecParameters().CodecId())
+	assert.Equal(t, avcodec.MediaType(avcodec.AVMEDIA_TYPE_VIDEO), o.CodecParameters().CodecType())
+	ctxCodec := avcodec.AvcodecFindDecoder(o.CodecParameters().CodecId()).AvcodecAllocContext3()
+	assert.True(t, avcodec.AvcodecParametersToContext(ctxCodec, o.CodecParameters()) >= 0)
+	assert.Equal(t, 1280, ctxCodec.Width())
+	assert.Equal(t, 720, ctxCodec.Height())
+	assert.Equal(t, 1, o.TimeBase().Num())
+	assert.Equal(t, 90000, o.TimeBase().Den())
+}
+
+func TestPktSourcePush(t *testing.T) {
+	// Create source
+	c := astiencoder.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	s := newTestPktSource(t, eh, c)
+	h := newTestPktHandler(eh)
+	s.Connect(h)
+	defer startTestNode(s)()
+
+	// Push
+	// The payload can be modified once pushed
+	data := []byte{1, 2, 3, 4}
+	assert.NoError(t, s.Push(PktSourcePkt{Data: data, Dts: 1, Duration: 3600, KeyFrame: true, Pts: 2}))
+	data[0] = 5
+	assert.NoError(t, s.Push(PktSourcePkt{Data: []byte{6}, Dts: 3601, Duration: 3600, Pts: 3602}))
+	h.wait(t, 2)
+	h.m.Lock()
+	defer h.m.Unlock()
+	assert.Equal(t, []testPkt{
+		{data: []byte{1, 2, 3, 4}, dts: 1, duration: 3600, flags: avcodec.AV_PKT_FLAG_KEY, pts: 2},
+		{data: []byte{6}, dts: 3601, duration: 3600, pts: 3602},
+	}, h.pkts)
+}
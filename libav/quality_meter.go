@@ -0,0 +1,608 @@
+package astilibav
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astitools/stat"
+	"github.com/asticode/go-astitools/sync"
+	"github.com/asticode/go-astitools/worker"
+	"github.com/asticode/goav/avfilter"
+	"github.com/asticode/goav/avutil"
+	"github.com/pkg/errors"
+)
+
+var countQualityMeter uint64
+
+const (
+	// PSNR of identical frames, since it's infinite otherwise
+	maxPSNR = 100
+	// Max number of unmatched frames kept per input
+	qualityMeterMaxPendingFrames = 250
+)
+
+// QualityMeter represents an object capable of measuring the quality of distorted video frames (the re-decoded
+// output of an encoder for instance) compared to reference video frames (the frames sent to the encoder for
+// instance)
+// Frames are matched by pts with a tolerance of half a frame duration and PSNR and SSIM are computed on the luma plane
+type QualityMeter struct {
+	*astiencoder.BaseNode
+	eh               *astiencoder.EventHandler
+	m                *sync.Mutex
+	o                QualityMeterOptions
+	pending          map[astiencoder.Node]map[int64]*qualityFrame
+	psnrs            []float64
+	q                *astisync.CtxQueue
+	s                QualitySummary
+	ssims            []float64
+	statIncomingRate *astistat.IncrementStat
+	statPSNR         *valueStat
+	statSSIM         *valueStat
+	statUnmatched    *valueStat
+	statVMAF         *valueStat
+	statWorkRatio    *astistat.DurationRatioStat
+	tolerances       keyFrameTolerances
+	vmafFrames       int
+	vmafs            []float64
+}
+
+// QualityMeterOptions represents quality meter options
+type QualityMeterOptions struct {
+	// Node whose frames are compared to the reference ones
+	Distorted astiencoder.Node
+	Node      astiencoder.NodeOptions
+	// Node whose frames are used as reference
+	Reference astiencoder.Node
+	// Number of frames rolling averages are computed on. Defaults to 25
+	Window int
+}
+
+// QualitySummary represents a quality summary
+type QualitySummary struct {
+	// Number of frames that have been compared
+	Frames int           `json:"frames"`
+	PSNR   QualityMetric `json:"psnr"`
+	SSIM   QualityMetric `json:"ssim"`
+	// Number of frames that have been dropped without being compared
+	Unmatched int `json:"unmatched"`
+	// Only set once the libvmaf log has been loaded
+	VMAF *QualityMetric `json:"vmaf,omitempty"`
+}
+
+// QualityMetric represents a quality metric aggregated over all compared frames
+type QualityMetric struct {
+	Average float64 `json:"average"`
+	Min     float64 `json:"min"`
+}
+
+// NewQualityMeter creates a new quality meter
+func NewQualityMeter(o QualityMeterOptions, eh *astiencoder.EventHandler) (m *QualityMeter, err error) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countQualityMeter, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("quality_meter_%d", count), fmt.Sprintf("Quality Meter #%d", count), "Measures quality")
+
+	// Check options
+	if o.Distorted == nil || o.Reference == nil {
+		err = errors.New("astilibav: distorted and reference nodes are mandatory")
+		return
+	} else if o.Distorted == o.Reference {
+		err = errors.New("astilibav: distorted and reference nodes must be different")
+		return
+	}
+
+	// Default options
+	if o.Window <= 0 {
+		o.Window = 25
+	}
+
+	// Create meter
+	m = &QualityMeter{
+		eh: eh,
+		m:  &sync.Mutex{},
+		o:  o,
+		pending: map[astiencoder.Node]map[int64]*qualityFrame{
+			o.Distorted: make(map[int64]*qualityFrame),
+			o.Reference: make(map[int64]*qualityFrame),
+		},
+		q:                astisync.NewCtxQueue(),
+		statIncomingRate: astistat.NewIncrementStat(),
+		statPSNR:         newValueStat(),
+		statSSIM:         newValueStat(),
+		statUnmatched:    newValueStat(),
+		statVMAF:         newValueStat(),
+		statWorkRatio:    astistat.NewDurationRatioStat(),
+		tolerances:       make(keyFrameTolerances),
+	}
+	m.BaseNode = astiencoder.NewBaseNode(o.Node, astiencoder.NewEventGeneratorNode(m), eh)
+	m.addStats()
+	return
+}
+
+// VMAFAvailable checks whether ffmpeg has been built with libvmaf, in which case the "libvmaf" filter can be used
+// in a filterer fed with both distorted and reference frames
+func VMAFAvailable() bool {
+	return avfilter.AvfilterGetByName("libvmaf") != nil
+}
+
+// NewVMAFFilterer creates a filterer computing VMAF with libvmaf. The distorted and reference nodes still need to be
+// connected to it.
+// libvmaf doesn't expose per-frame scores before its filter is closed, where it writes them in its log. The log is
+// therefore loaded in the rolling stats and the summary when the closer is closed.
+func (m *QualityMeter) NewVMAFFilterer(ctx Context, logPath string, c *astiencoder.Closer) (f *Filterer, err error) {
+	// Load log once the filterer has been closed
+	// Close funcs are executed in the reverse order they've been added
+	c.Add(func() error {
+		if f == nil {
+			return nil
+		}
+		return m.loadVMAFLog(logPath)
+	})
+
+	// Create filterer
+	// libvmaf expects the distorted frames first
+	var vf *Filterer
+	if vf, err = NewFilterer(FiltererOptions{
+		Content: fmt.Sprintf("[distorted][reference]libvmaf=log_fmt=json:log_path='%s'", logPath),
+		Inputs: map[string]FiltererInput{
+			"distorted": {
+				Context: ctx,
+				Node:    m.o.Distorted,
+			},
+			"reference": {
+				Context: ctx,
+				Node:    m.o.Reference,
+			},
+		},
+	}, m.eh, c); err != nil {
+		err = errors.Wrap(err, "astilibav: creating filterer failed")
+		return
+	}
+	f = vf
+
+	// Add vmaf stat
+	m.Stater().AddStat(astistat.StatMetadata{
+		Description: fmt.Sprintf("Average VMAF of the last %d compared frames, available once the libvmaf log has been loaded", m.o.Window),
+		Label:       "VMAF",
+	}, m.statVMAF)
+	return
+}
+
+// loadVMAFLog adds the per-frame scores of the libvmaf log to the rolling stats and the summary
+func (m *QualityMeter) loadVMAFLog(path string) (err error) {
+	// Open log
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		err = errors.Wrapf(err, "astilibav: opening %s failed", path)
+		return
+	}
+	defer f.Close()
+
+	// Parse log
+	var vs []float64
+	if vs, err = parseVMAFLog(f); err != nil {
+		err = errors.Wrapf(err, "astilibav: parsing %s failed", path)
+		return
+	}
+
+	// Add scores
+	m.addVMAF(vs)
+
+	// Let listeners know the summary has changed
+	m.eh.Emit(astiencoder.Event{
+		Name:   astiencoder.EventNameNodeDataChanged,
+		Target: m,
+	})
+	return
+}
+
+// vmafLog represents the parts of the libvmaf JSON log that are used
+type vmafLog struct {
+	Frames []struct {
+		FrameNum int `json:"frameNum"`
+		Metrics  struct {
+			VMAF float64 `json:"vmaf"`
+		} `json:"metrics"`
+	} `json:"frames"`
+}
+
+// parseVMAFLog returns the per-frame scores of a libvmaf JSON log ordered by frame number
+func parseVMAFLog(r io.Reader) (vs []float64, err error) {
+	// Unmarshal
+	var l vmafLog
+	if err = json.NewDecoder(r).Decode(&l); err != nil {
+		err = errors.Wrap(err, "astilibav: unmarshaling failed")
+		return
+	}
+
+	// Sort frames
+	sort.SliceStable(l.Frames, func(i, j int) bool { return l.Frames[i].FrameNum < l.Frames[j].FrameNum })
+
+	// Get scores
+	for _, f := range l.Frames {
+		vs = append(vs, f.Metrics.VMAF)
+	}
+	return
+}
+
+func (m *QualityMeter) addVMAF(vs []float64) {
+	// No scores
+	if len(vs) == 0 {
+		return
+	}
+
+	// Update rolling average
+	for _, v := range vs {
+		m.vmafs = appendRolling(m.vmafs, v, m.o.Window)
+	}
+	m.statVMAF.set(average(m.vmafs))
+
+	// Update summary
+	m.m.Lock()
+	defer m.m.Unlock()
+	for _, v := range vs {
+		if m.s.VMAF == nil {
+			m.s.VMAF = &QualityMetric{Min: v}
+		} else if v < m.s.VMAF.Min {
+			m.s.VMAF.Min = v
+		}
+		m.s.VMAF.Average += v
+		m.vmafFrames++
+	}
+}
+
+func (m *QualityMeter) addStats() {
+	// Add incoming rate
+	m.Stater().AddStat(astistat.StatMetadata{
+		Description: "Number of frames coming in per second",
+		Label:       "Incoming rate",
+		Unit:        "fps",
+	}, m.statIncomingRate)
+
+	// Add psnr
+	m.Stater().AddStat(astistat.StatMetadata{
+		Description: fmt.Sprintf("Average luma PSNR of the last %d compared frames", m.o.Window),
+		Label:       "PSNR",
+		Unit:        "dB",
+	}, m.statPSNR)
+
+	// Add ssim
+	m.Stater().AddStat(astistat.StatMetadata{
+		Description: fmt.Sprintf("Average luma SSIM of the last %d compared frames", m.o.Window),
+		Label:       "SSIM",
+	}, m.statSSIM)
+
+	// Add unmatched frames
+	m.Stater().AddStat(astistat.StatMetadata{
+		Description: "Number of frames that have been dropped without being compared",
+		Label:       "Unmatched frames",
+	}, m.statUnmatched)
+
+	// Add work ratio
+	m.Stater().AddStat(astistat.StatMetadata{
+		Description: "Percentage of time spent doing some actual work",
+		Label:       "Work ratio",
+		Unit:        "%",
+	}, m.statWorkRatio)
+
+	// Add queue stats
+	m.q.AddStats(m.Stater())
+}
+
+// Summary returns the quality measured so far
+func (m *QualityMeter) Summary() QualitySummary {
+	m.m.Lock()
+	defer m.m.Unlock()
+	s := m.s
+	if s.Frames > 0 {
+		s.PSNR.Average /= float64(s.Frames)
+		s.SSIM.Average /= float64(s.Frames)
+	}
+	if s.VMAF != nil {
+		v := *s.VMAF
+		v.Average /= float64(m.vmafFrames)
+		s.VMAF = &v
+	}
+	return s
+}
+
+// ExposedData implements the astiencoder.NodeExposer interface
+func (m *QualityMeter) ExposedData() interface{} {
+	return m.Summary()
+}
+
+// Start starts the quality meter
+func (m *QualityMeter) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	m.BaseNode.Start(ctx, t, func(t *astiworker.Task) {
+		// Handle context
+		go m.q.HandleCtx(m.Context())
+
+		// Make sure to stop the queue properly
+		defer m.q.Stop()
+
+		// Start queue
+		m.q.Start(func(dp interface{}) {
+			// Handle pause
+			defer m.HandlePause()
+
+			// Assert payload
+			p := dp.(*FrameHandlerPayload)
+
+			// Increment incoming rate
+			m.statIncomingRate.Add(1)
+
+			// Measure
+			m.statWorkRatio.Add(true)
+			if err := m.measure(p); err != nil {
+				m.statWorkRatio.Done(true)
+				m.eh.Emit(astiencoder.EventError(m, errors.Wrap(err, "astilibav: measuring quality failed")))
+				return
+			}
+			m.statWorkRatio.Done(true)
+		})
+
+		// Frames that are still pending will never be matched
+		var unmatched int
+		for _, ps := range m.pending {
+			unmatched += purgeQualityFrames(ps, math.MaxInt64)
+		}
+		m.addUnmatched(unmatched)
+
+		// Let listeners know the summary is available
+		m.eh.Emit(astiencoder.Event{
+			Name:   astiencoder.EventNameNodeDataChanged,
+			Target: m,
+		})
+	})
+}
+
+func (m *QualityMeter) measure(p *FrameHandlerPayload) (err error) {
+	// Get pending frames
+	ps, ok := m.pending[p.Node]
+	if !ok {
+		return
+	}
+
+	// Copy frame
+	f, ok := newQualityFrame(p.Frame)
+	if !ok {
+		return
+	}
+
+	// Get the pending frames of the other node
+	var ops map[int64]*qualityFrame
+	for n, v := range m.pending {
+		if n != p.Node {
+			ops = v
+		}
+	}
+
+	// Frames are matched on their time since both nodes may not share the same time base
+	pts := avutil.AvRescaleQ(p.Frame.Pts(), p.Descriptor.TimeBase(), nanosecondRational)
+
+	// No matching frame yet
+	// Both nodes may have rescaled their pts differently, therefore frames are matched with a tolerance
+	opts, ok := matchQualityFrame(ops, pts, m.tolerances.get(p.Node, pts))
+	if !ok {
+		ps[pts] = f
+		m.addUnmatched(purgeQualityFrames(ps, math.MinInt64))
+		return
+	}
+
+	// Frames older than the matching ones will never be matched
+	of := ops[opts]
+	delete(ops, opts)
+	m.addUnmatched(purgeQualityFrames(ps, pts) + purgeQualityFrames(ops, opts))
+
+	// Get distorted and reference frames
+	d, r := f, of
+	if p.Node == m.o.Reference {
+		d, r = of, f
+	}
+
+	// Check dimensions
+	if d.width != r.width || d.height != r.height {
+		err = fmt.Errorf("astilibav: distorted frame dimensions %dx%d are different from reference frame dimensions %dx%d", d.width, d.height, r.width, r.height)
+		return
+	}
+
+	// Compute metrics
+	psnr := d.psnr(r)
+	ssim := d.ssim(r)
+
+	// Update rolling averages
+	m.psnrs = appendRolling(m.psnrs, psnr, m.o.Window)
+	m.ssims = appendRolling(m.ssims, ssim, m.o.Window)
+	m.statPSNR.set(average(m.psnrs))
+	m.statSSIM.set(average(m.ssims))
+
+	// Update summary
+	m.m.Lock()
+	if m.s.Frames == 0 || psnr < m.s.PSNR.Min {
+		m.s.PSNR.Min = psnr
+	}
+	if m.s.Frames == 0 || ssim < m.s.SSIM.Min {
+		m.s.SSIM.Min = ssim
+	}
+	m.s.Frames++
+	m.s.PSNR.Average += psnr
+	m.s.SSIM.Average += ssim
+	m.m.Unlock()
+	return
+}
+
+func (m *QualityMeter) addUnmatched(n int) {
+	// Nothing to add
+	if n == 0 {
+		return
+	}
+
+	// Update summary
+	m.m.Lock()
+	m.s.Unmatched += n
+	v := m.s.Unmatched
+	m.m.Unlock()
+
+	// Update stat
+	m.statUnmatched.set(float64(v))
+}
+
+// matchQualityFrame returns the pts of the frame closest to pts in [pts - tolerance, pts + tolerance[
+func matchQualityFrame(fs map[int64]*qualityFrame, pts, tolerance int64) (match int64, ok bool) {
+	for k := range fs {
+		if k < pts-tolerance || k >= pts+tolerance {
+			continue
+		}
+		if !ok || math.Abs(float64(k-pts)) < math.Abs(float64(match-pts)) {
+			match, ok = k, true
+		}
+	}
+	return
+}
+
+// purgeQualityFrames removes frames older than pts as well as the oldest frames when there are too many of them and
+// returns the number of frames removed
+func purgeQualityFrames(fs map[int64]*qualityFrame, pts int64) (n int) {
+	var ks []int64
+	for k := range fs {
+		if k < pts {
+			delete(fs, k)
+			n++
+		} else {
+			ks = append(ks, k)
+		}
+	}
+	if len(ks) <= qualityMeterMaxPendingFrames {
+		return
+	}
+	sort.Slice(ks, func(i, j int) bool { return ks[i] < ks[j] })
+	for _, k := range ks[:len(ks)-qualityMeterMaxPendingFrames] {
+		delete(fs, k)
+		n++
+	}
+	return
+}
+
+func appendRolling(vs []float64, v float64, size int) []float64 {
+	vs = append(vs, v)
+	if len(vs) > size {
+		vs = vs[len(vs)-size:]
+	}
+	return vs
+}
+
+func average(vs []float64) (a float64) {
+	if len(vs) == 0 {
+		return
+	}
+	for _, v := range vs {
+		a += v
+	}
+	return a / float64(len(vs))
+}
+
+// HandleFrame implements the FrameHandler interface
+func (m *QualityMeter) HandleFrame(p *FrameHandlerPayload) {
+	m.q.Send(p)
+}
+
+// qualityFrame is a copy of the luma plane of a frame so that it can outlive the frame while waiting for its match
+type qualityFrame struct {
+	height int
+	width  int
+	y      []uint8
+}
+
+func newQualityFrame(f *avutil.Frame) (q *qualityFrame, ok bool) {
+	// Only planar yuv and gray pixel formats have a luma plane first
+	if !isLumaFirstPixelFormat(avutil.PixelFormat(f.Format())) || f.Width() <= 0 || f.Height() <= 0 {
+		return
+	}
+
+	// Get plane
+	b, linesize := framePlane(f, 0, f.Height())
+	if b == nil {
+		return
+	}
+
+	// Copy plane
+	q = &qualityFrame{
+		height: f.Height(),
+		width:  f.Width(),
+		y:      make([]uint8, f.Width()*f.Height()),
+	}
+	copyPlane(q.y, q.width, b, linesize, q.width, q.height)
+	ok = true
+	return
+}
+
+// psnr returns the peak signal-to-noise ratio in dB
+func (q *qualityFrame) psnr(r *qualityFrame) float64 {
+	var sum float64
+	for idx := range q.y {
+		d := float64(q.y[idx]) - float64(r.y[idx])
+		sum += d * d
+	}
+	mse := sum / float64(len(q.y))
+	if mse == 0 {
+		return maxPSNR
+	}
+	return math.Min(10*math.Log10(255*255/mse), maxPSNR)
+}
+
+// ssim returns the structural similarity between 0 and 1
+// Like libavfilter and x264, it's computed on overlapping 8x8 windows built out of 4x4 blocks
+func (q *qualityFrame) ssim(r *qualityFrame) float64 {
+	// Frame is too small
+	bw, bh := q.width/4, q.height/4
+	if bw < 2 || bh < 2 {
+		return 1
+	}
+
+	// Compute 4x4 block sums
+	type blockSums struct{ a, b, aa, bb, ab float64 }
+	bs := make([]blockSums, bw*bh)
+	for by := 0; by < bh; by++ {
+		for bx := 0; bx < bw; bx++ {
+			s := &bs[by*bw+bx]
+			for y := by * 4; y < by*4+4; y++ {
+				for x := bx * 4; x < bx*4+4; x++ {
+					a, b := float64(q.y[y*q.width+x]), float64(r.y[y*q.width+x])
+					s.a += a
+					s.b += b
+					s.aa += a * a
+					s.bb += b * b
+					s.ab += a * b
+				}
+			}
+		}
+	}
+
+	// Loop through 8x8 windows
+	const n = 64
+	c1, c2 := math.Pow(0.01*255, 2), math.Pow(0.03*255, 2)
+	var sum float64
+	for by := 0; by < bh-1; by++ {
+		for bx := 0; bx < bw-1; bx++ {
+			var w blockSums
+			for _, s := range []blockSums{bs[by*bw+bx], bs[by*bw+bx+1], bs[(by+1)*bw+bx], bs[(by+1)*bw+bx+1]} {
+				w.a += s.a
+				w.b += s.b
+				w.aa += s.aa
+				w.bb += s.bb
+				w.ab += s.ab
+			}
+			ma, mb := w.a/n, w.b/n
+			va, vb, cov := w.aa/n-ma*ma, w.bb/n-mb*mb, w.ab/n-ma*mb
+			sum += ((2*ma*mb + c1) * (2*cov + c2)) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+		}
+	}
+	return sum / float64((bw-1)*(bh-1))
+}
@@ -0,0 +1,147 @@
+package astilibav
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestQualityFrame(w, h int, fn func(x, y int) uint8) *qualityFrame {
+	q := &qualityFrame{height: h, width: w, y: make([]uint8, w*h)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			q.y[y*w+x] = fn(x, y)
+		}
+	}
+	return q
+}
+
+func TestQualityFramePSNR(t *testing.T) {
+	r := newTestQualityFrame(16, 16, func(x, y int) uint8 { return uint8(x * y) })
+	assert.Equal(t, float64(maxPSNR), r.psnr(r))
+	d := newTestQualityFrame(16, 16, func(x, y int) uint8 { return uint8(x*y) + 1 })
+	assert.InDelta(t, 10*math.Log10(255*255), d.psnr(r), 1e-9)
+}
+
+func TestQualityFrameSSIM(t *testing.T) {
+	// Identical frames
+	r := newTestQualityFrame(16, 16, func(x, y int) uint8 { return uint8((x * 37) ^ (y * 11)) })
+	assert.InDelta(t, 1, r.ssim(r), 1e-9)
+
+	// Frames that are too small
+	assert.Equal(t, 1.0, newTestQualityFrame(4, 4, func(x, y int) uint8 { return 0 }).ssim(newTestQualityFrame(4, 4, func(x, y int) uint8 { return 255 })))
+
+	// Structure is lost
+	d := newTestQualityFrame(16, 16, func(x, y int) uint8 { return 128 })
+	assert.True(t, d.ssim(r) < 0.1)
+
+	// Luminance is shifted slightly
+	d = newTestQualityFrame(16, 16, func(x, y int) uint8 { return uint8((x*37)^(y*11)) / 2 })
+	v := d.ssim(r)
+	assert.True(t, v > 0.5 && v < 1)
+}
+
+func TestMatchQualityFrame(t *testing.T) {
+	fs := map[int64]*qualityFrame{0: nil, 39: nil, 41: nil, 80: nil}
+	pts, ok := matchQualityFrame(fs, 40, 20)
+	assert.True(t, ok)
+	assert.Equal(t, int64(39), pts)
+	pts, ok = matchQualityFrame(fs, 60, 20)
+	assert.True(t, ok)
+	assert.Equal(t, int64(41), pts)
+	_, ok = matchQualityFrame(fs, 110, 20)
+	assert.False(t, ok)
+	_, ok = matchQualityFrame(map[int64]*qualityFrame{}, 0, 20)
+	assert.False(t, ok)
+}
+
+func TestPurgeQualityFrames(t *testing.T) {
+	fs := map[int64]*qualityFrame{0: nil, 1: nil, 2: nil}
+	assert.Equal(t, 2, purgeQualityFrames(fs, 2))
+	assert.Equal(t, map[int64]*qualityFrame{2: nil}, fs)
+
+	fs = make(map[int64]*qualityFrame)
+	for idx := 0; idx < qualityMeterMaxPendingFrames+2; idx++ {
+		fs[int64(idx)] = nil
+	}
+	assert.Equal(t, 2, purgeQualityFrames(fs, math.MinInt64))
+	assert.Len(t, fs, qualityMeterMaxPendingFrames)
+	_, ok := fs[1]
+	assert.False(t, ok)
+	_, ok = fs[2]
+	assert.True(t, ok)
+}
+
+func TestParseVMAFLog(t *testing.T) {
+	vs, err := parseVMAFLog(strings.NewReader(`{"version":"1.3.9","frames":[{"frameNum":1,"metrics":{"adm2":0.9,"vmaf":80.5}},{"frameNum":0,"metrics":{"adm2":0.8,"vmaf":70.25}}],"VMAF score":75.375}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{70.25, 80.5}, vs)
+	_, err = parseVMAFLog(strings.NewReader("invalid"))
+	assert.Error(t, err)
+}
+
+func TestQualityMeter(t *testing.T) {
+	// Create meter
+	eh := astiencoder.NewEventHandler()
+	d, r := newTestNode("distorted", eh), newTestNode("reference", eh)
+	_, err := NewQualityMeter(QualityMeterOptions{Distorted: d, Reference: d}, eh)
+	assert.Error(t, err)
+	m, err := NewQualityMeter(QualityMeterOptions{Distorted: d, Reference: r, Window: 2}, eh)
+	assert.NoError(t, err)
+
+	// Frames are matched with a tolerance since distorted pts are rescaled
+	f := avutil.AvFrameAlloc()
+	defer avutil.AvFrameFree(f)
+	dp := &FrameHandlerPayload{Descriptor: newTimeBaseDescriptor(avutil.NewRational(1, 90000)), Frame: f, Node: d}
+	rp := &FrameHandlerPayload{Descriptor: newTimeBaseDescriptor(avutil.NewRational(1, 25)), Frame: f, Node: r}
+	for _, v := range []struct {
+		p   *FrameHandlerPayload
+		pts int64
+		y   uint8
+	}{
+		{p: rp, pts: 0, y: 100},
+		{p: rp, pts: 1, y: 100},
+		{p: dp, pts: 0, y: 100},
+		{p: dp, pts: 3601, y: 101},
+		{p: rp, pts: 2, y: 100},
+		{p: dp, pts: 7199, y: 100},
+	} {
+		newTestGrayFrame(t, f, v.y, v.pts)
+		assert.NoError(t, m.measure(v.p))
+	}
+	s := m.Summary()
+	assert.Equal(t, 3, s.Frames)
+	assert.Equal(t, 0, s.Unmatched)
+	assert.InDelta(t, (2*maxPSNR+10*math.Log10(255*255))/3, s.PSNR.Average, 1e-9)
+	assert.InDelta(t, 10*math.Log10(255*255), s.PSNR.Min, 1e-9)
+	assert.InDelta(t, (maxPSNR+10*math.Log10(255*255))/2, m.statPSNR.v, 1e-9)
+
+	// Frames older than a matched frame are unmatched
+	for _, v := range []struct {
+		p   *FrameHandlerPayload
+		pts int64
+	}{
+		{p: rp, pts: 10},
+		{p: dp, pts: 72000},
+		{p: rp, pts: 20},
+	} {
+		newTestGrayFrame(t, f, 100, v.pts)
+		assert.NoError(t, m.measure(v.p))
+	}
+	s = m.Summary()
+	assert.Equal(t, 4, s.Frames)
+	assert.Equal(t, 1, s.Unmatched)
+	assert.Equal(t, 1.0, m.statUnmatched.v)
+
+	// VMAF
+	assert.Nil(t, s.VMAF)
+	m.addVMAF([]float64{80, 90, 70})
+	s = m.Summary()
+	assert.Equal(t, &QualityMetric{Average: 80, Min: 70}, s.VMAF)
+	assert.Equal(t, 80.0, m.statVMAF.v)
+	assert.Equal(t, &QualityMetric{Average: 80, Min: 70}, m.Summary().VMAF)
+}
@@ -13,19 +13,31 @@ import (
 	"github.com/asticode/go-astitools/sync"
 	"github.com/asticode/go-astitools/time"
 	"github.com/asticode/go-astitools/worker"
+	"github.com/asticode/goav/avcodec"
 	"github.com/asticode/goav/avutil"
+	"github.com/pkg/errors"
 )
 
 var countRateEnforcer uint64
 
 // RateEnforcer represents an object capable of enforcing rate based on PTS
+// In audio mode, it enforces a constant sample rate instead of a constant frame rate
 type RateEnforcer struct {
 	*astiencoder.BaseNode
+	audio            *RateEnforcerAudioOptions
+	audioDescriptor  Descriptor
 	buf              []*rateEnforcerItem
+	c                *astiencoder.Closer
 	d                *frameDispatcher
 	eh               *astiencoder.EventHandler
+	fallback         *RateEnforcerFallbackOptions
+	fallbackCtx      *Context // Format the fallback frame has been generated for
+	fallbackFrame    *avutil.Frame
+	inFallback       bool
+	lastLiveAt       time.Time
 	m                *sync.Mutex
 	n                astiencoder.Node
+	origins          map[rateEnforcerOrigin]bool
 	p                *framePool
 	period           time.Duration
 	previousItem     *rateEnforcerItem
@@ -51,24 +63,65 @@ type rateEnforcerItem struct {
 	n astiencoder.Node
 }
 
+type rateEnforcerOrigin struct {
+	n      astiencoder.Node
+	source astiencoder.Node
+}
+
 // RateEnforcerOptions represents rate enforcer options
 type RateEnforcerOptions struct {
-	Delay     time.Duration
+	// When provided, the rate enforcer works in audio mode and FrameRate is ignored
+	Audio *RateEnforcerAudioOptions
+	Delay time.Duration
+	// Only used in video mode
+	Fallback  *RateEnforcerFallbackOptions
 	FrameRate avutil.Rational
 	Node      astiencoder.NodeOptions
 	Restamper FrameRestamper
 }
 
+// RateEnforcerFallbackOptions represents rate enforcer fallback options
+// Once the rate enforcer has been repeating the previous frame for the timeout duration, it dispatches the slate
+// instead until frames come in again. The slate is generated with the format of incoming frames and is regenerated
+// whenever it changes
+type RateEnforcerFallbackOptions struct {
+	Slate   SlateOptions
+	Timeout time.Duration
+}
+
+// RateEnforcerAudioOptions represents rate enforcer audio options
+// Incoming frames must have the same channel layout, sample format and sample rate. Samples are sliced and
+// concatenated so that frames of FrameSize samples are dispatched, and gaps are filled with silence
+type RateEnforcerAudioOptions struct {
+	ChannelLayout uint64
+	// Defaults to 1024
+	FrameSize  int
+	SampleFmt  avcodec.AvSampleFormat
+	SampleRate int
+}
+
 // NewRateEnforcer creates a new rate enforcer
 func NewRateEnforcer(o RateEnforcerOptions, eh *astiencoder.EventHandler, c *astiencoder.Closer) (r *RateEnforcer) {
 	// Extend node metadata
 	count := atomic.AddUint64(&countRateEnforcer, uint64(1))
 	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("rate_enforcer_%d", count), fmt.Sprintf("Rate Enforcer #%d", count), "Enforces rate")
 
+	// In audio mode, a slot is a frame of samples
+	if o.Audio != nil {
+		if o.Audio.FrameSize <= 0 {
+			o.Audio.FrameSize = 1024
+		}
+		o.FrameRate = avutil.NewRational(o.Audio.SampleRate, o.Audio.FrameSize)
+	}
+
 	// Create rate enforcer
 	r = &RateEnforcer{
+		audio:            o.Audio,
+		c:                c,
 		eh:               eh,
+		fallback:         o.Fallback,
 		m:                &sync.Mutex{},
+		origins:          make(map[rateEnforcerOrigin]bool),
 		p:                newFramePool(c),
 		period:           time.Duration(float64(1e9) / o.FrameRate.ToDouble()),
 		q:                astisync.NewCtxQueue(),
@@ -82,6 +135,12 @@ func NewRateEnforcer(o RateEnforcerOptions, eh *astiencoder.EventHandler, c *ast
 	r.d = newFrameDispatcher(r, eh, c)
 	r.slotsCount = int(math.Max(math.Floor(float64(o.Delay)/float64(r.period)), 1))
 	r.addStats()
+
+	// Make sure the fallback frame is freed
+	c.Add(func() error {
+		r.freeFallbackFrame()
+		return nil
+	})
 	return
 }
 
@@ -108,10 +167,41 @@ func (r *RateEnforcer) addStats() {
 }
 
 // Switch switches the source
+// The source can be any ancestor of the rate enforcer's parents, which means an audio rate enforcer and a video
+// rate enforcer can stay in sync by switching both to the same demuxer
 func (r *RateEnforcer) Switch(n astiencoder.Node) {
 	r.m.Lock()
 	defer r.m.Unlock()
 	r.n = n
+	r.origins = make(map[rateEnforcerOrigin]bool)
+}
+
+// isFrom checks whether a node is the source or one of its descendants
+func (r *RateEnforcer) isFrom(n, source astiencoder.Node) (ok bool) {
+	// Quick checks
+	if n == nil || source == nil {
+		return false
+	} else if n == source {
+		return true
+	}
+
+	// Check cache
+	k := rateEnforcerOrigin{n: n, source: source}
+	var cached bool
+	if ok, cached = r.origins[k]; cached {
+		return
+	}
+
+	// Loop through parents
+	for _, p := range n.Parents() {
+		if ok = r.isFrom(p, source); ok {
+			break
+		}
+	}
+
+	// Store in cache
+	r.origins[k] = ok
+	return
 }
 
 // Connect implements the FrameHandlerConnector interface
@@ -167,7 +257,7 @@ func (r *RateEnforcer) Start(ctx context.Context, t astiencoder.CreateTaskFunc)
 			//   - the node of the last slot is different from the desired node AND the payload's node is the desired
 			//   node. That way, if the desired node doesn't dispatch frames for some time, we fallback to the previous
 			//   node instead of the previous item
-			if r.slots[len(r.slots)-1] == nil || (r.n != r.slots[len(r.slots)-1].n && r.n == p.Node) {
+			if r.slots[len(r.slots)-1] == nil || (r.n != r.slots[len(r.slots)-1].n && r.isFrom(p.Node, r.n)) {
 				r.slots[len(r.slots)-1] = r.newRateEnforcerSlot(p)
 				r.eh.Emit(astiencoder.Event{
 					Name:    EventNameRateEnforcerSwitched,
@@ -176,6 +266,11 @@ func (r *RateEnforcer) Start(ctx context.Context, t astiencoder.CreateTaskFunc)
 				})
 			}
 
+			// Make sure the fallback frame matches the frame format
+			if r.audio == nil && r.fallback != nil {
+				r.updateFallbackFrame(p.Frame)
+			}
+
 			// Create item
 			i := r.newRateEnforcerItem(p)
 
@@ -191,6 +286,34 @@ func (r *RateEnforcer) Start(ctx context.Context, t astiencoder.CreateTaskFunc)
 	})
 }
 
+// updateFallbackFrame generates the fallback frame if the frame format has changed since it was last generated
+func (r *RateEnforcer) updateFallbackFrame(f *avutil.Frame) {
+	// Nothing changed
+	// The format is stored even if generating the fallback frame fails so that it's not retried for every frame
+	ctx := newContextFromFrame(f, avcodec.AVMEDIA_TYPE_VIDEO)
+	if r.fallbackCtx != nil && r.fallbackCtx.sameFrameFormat(ctx) && avutil.AvCmpQ(r.fallbackCtx.SampleAspectRatio, ctx.SampleAspectRatio) == 0 {
+		return
+	}
+	r.fallbackCtx = &ctx
+
+	// Free previous fallback frame since it doesn't match the frame format anymore
+	r.freeFallbackFrame()
+
+	// Generate fallback frame
+	var err error
+	if r.fallbackFrame, err = newSlateFrame(r.fallback.Slate, ctx); err != nil {
+		r.eh.Emit(astiencoder.EventError(r, errors.Wrap(err, "astilibav: creating slate frame failed")))
+		return
+	}
+}
+
+func (r *RateEnforcer) freeFallbackFrame() {
+	if r.fallbackFrame != nil {
+		avutil.AvFrameFree(r.fallbackFrame)
+		r.fallbackFrame = nil
+	}
+}
+
 func (r *RateEnforcer) newRateEnforcerSlot(p *FrameHandlerPayload) *rateEnforcerSlot {
 	return &rateEnforcerSlot{
 		n:      r.n,
@@ -250,27 +373,68 @@ func (r *RateEnforcer) tickFunc(ctx context.Context, nextAt *time.Time) (stop bo
 		return
 	}
 
+	// Dispatch
+	if r.audio != nil {
+		r.dispatchAudio()
+	} else {
+		r.dispatchVideo()
+	}
+
+	// Remove first slot
+	r.slots = r.slots[1:]
+	return
+}
+
+func (r *RateEnforcer) dispatchVideo() {
 	// Distribute
 	r.distribute()
 
-	// Dispatch
+	// Get current item
 	i, previous := r.current()
-	if i != nil {
-		// Restamp frame
-		if r.restamper != nil {
-			r.restamper.Restamp(i.f, true)
+	if i == nil {
+		return
+	}
+
+	// Handle fallback
+	if !previous {
+		// Store last live time
+		r.lastLiveAt = time.Now()
+
+		// Leave fallback
+		if r.inFallback {
+			r.inFallback = false
+			r.eh.Emit(astiencoder.Event{
+				Name:   EventNameRateEnforcerFallbackLeft,
+				Target: r,
+			})
 		}
+	} else if r.fallbackFrame != nil && time.Since(r.lastLiveAt) >= r.fallback.Timeout {
+		// Enter fallback
+		if !r.inFallback {
+			r.inFallback = true
+			r.eh.Emit(astiencoder.Event{
+				Name:   EventNameRateEnforcerFallbackEntered,
+				Target: r,
+			})
+		}
+
+		// Dispatch slate
+		r.dispatchSlate(i)
+		return
+	}
 
-		// Dispatch frame
-		r.d.dispatch(i.f, i.d)
+	// Restamp frame
+	if r.restamper != nil {
+		r.restamper.Restamp(i.f, true)
 	}
 
-	// Remove first slot
+	// Dispatch frame
+	r.d.dispatch(i.f, i.d)
+
+	// Release frame
 	if !previous {
 		r.p.put(i.f)
 	}
-	r.slots = r.slots[1:]
-	return
 }
 
 func (s *rateEnforcerSlot) next() *rateEnforcerSlot {
@@ -282,12 +446,7 @@ func (s *rateEnforcerSlot) next() *rateEnforcerSlot {
 }
 func (r *RateEnforcer) distribute() {
 	// Get useful nodes
-	ns := make(map[astiencoder.Node]bool)
-	for _, s := range r.slots {
-		if s != nil && s.n != nil {
-			ns[s.n] = true
-		}
-	}
+	ns := r.usefulNodes()
 
 	// Loop through slots
 	for _, s := range r.slots {
@@ -299,9 +458,9 @@ func (r *RateEnforcer) distribute() {
 		// Loop through buffer
 		for idx := 0; idx < len(r.buf); idx++ {
 			// Not the same node
-			if r.buf[idx].n != s.n {
+			if !r.isFrom(r.buf[idx].n, s.n) {
 				// Node is useless
-				if _, ok := ns[r.buf[idx].n]; !ok {
+				if !r.isUseful(r.buf[idx].n, ns) {
 					r.p.put(r.buf[idx].f)
 					r.buf = append(r.buf[:idx], r.buf[idx+1:]...)
 					idx--
@@ -325,6 +484,168 @@ func (r *RateEnforcer) distribute() {
 	}
 }
 
+func (r *RateEnforcer) dispatchSlate(previous *rateEnforcerItem) {
+	// Get frame
+	f := r.p.get()
+	defer r.p.put(f)
+
+	// Copy slate
+	if ret := avutil.AvFrameRef(f, r.fallbackFrame); ret < 0 {
+		emitAvError(r, r.eh, ret, "avutil.AvFrameRef failed")
+		return
+	}
+
+	// Set pts
+	if r.slots[0] != nil {
+		f.SetPts(r.slots[0].ptsMin)
+	} else {
+		f.SetPts(previous.f.Pts())
+	}
+
+	// Restamp frame
+	if r.restamper != nil {
+		r.restamper.Restamp(f, true)
+	}
+
+	// Dispatch frame
+	r.d.dispatch(f, previous.d)
+}
+
+func (r *RateEnforcer) dispatchAudio() {
+	// Get slot
+	s := r.slots[0]
+
+	// Get useful nodes
+	ns := r.usefulNodes()
+
+	// Get frame
+	f := r.p.get()
+	defer r.p.put(f)
+
+	// Allocate buffer
+	f.SetChannelLayout(r.audio.ChannelLayout)
+	f.SetChannels(avutil.AvGetChannelLayoutNbChannels(r.audio.ChannelLayout))
+	f.SetFormat(int(r.audio.SampleFmt))
+	f.SetNbSamples(r.audio.FrameSize)
+	f.SetSampleRate(r.audio.SampleRate)
+	if ret := avutil.AvFrameGetBuffer(f, 0); ret < 0 {
+		emitAvError(r, r.eh, ret, "avutil.AvFrameGetBuffer failed")
+		return
+	}
+
+	// Fill with silence
+	// Gaps that are not filled with samples below stay silent
+	if ret := avutil.AvSamplesSetSilence(avutil.Data(f), 0, r.audio.FrameSize, f.Channels(), int(r.audio.SampleFmt)); ret < 0 {
+		emitAvError(r, r.eh, ret, "avutil.AvSamplesSetSilence failed")
+		return
+	}
+
+	// Loop through buffer
+	for idx := 0; idx < len(r.buf); idx++ {
+		// Get item
+		i := r.buf[idx]
+
+		// Item is useless or is older than the slot
+		end := r.audioItemEnd(i)
+		if !r.isUseful(i.n, ns) || (s != nil && end <= s.ptsMin) {
+			r.p.put(i.f)
+			r.buf = append(r.buf[:idx], r.buf[idx+1:]...)
+			idx--
+			continue
+		}
+
+		// Item is not part of the slot
+		if s == nil || !r.isFrom(i.n, s.n) || i.f.Pts() >= s.ptsMax {
+			continue
+		}
+
+		// Copy samples
+		r.copyAudioSamples(f, s, i)
+
+		// Store descriptor
+		r.audioDescriptor = i.d
+
+		// Item has been fully used
+		if end <= s.ptsMax {
+			r.p.put(i.f)
+			r.buf = append(r.buf[:idx], r.buf[idx+1:]...)
+			idx--
+		}
+	}
+
+	// We need a descriptor to dispatch the frame
+	if r.audioDescriptor == nil {
+		return
+	}
+
+	// Set pts
+	if s != nil {
+		f.SetPts(s.ptsMin)
+	}
+
+	// Restamp frame
+	if r.restamper != nil {
+		r.restamper.Restamp(f, true)
+	}
+
+	// Dispatch frame
+	r.d.dispatch(f, r.audioDescriptor)
+}
+
+// audioItemEnd returns the pts right after the last sample of the item
+func (r *RateEnforcer) audioItemEnd(i *rateEnforcerItem) int64 {
+	return i.f.Pts() + r.samplesToPts(i.f.NbSamples(), i.d.TimeBase())
+}
+
+func (r *RateEnforcer) samplesToPts(samples int, timeBase avutil.Rational) int64 {
+	return int64(math.Round(float64(samples) / float64(r.audio.SampleRate) / timeBase.ToDouble()))
+}
+
+func (r *RateEnforcer) ptsToSamples(pts int64, timeBase avutil.Rational) int {
+	return int(math.Round(float64(pts) * timeBase.ToDouble() * float64(r.audio.SampleRate)))
+}
+
+// copyAudioSamples copies the samples of the item that overlap the slot at the proper position in the frame
+func (r *RateEnforcer) copyAudioSamples(f *avutil.Frame, s *rateEnforcerSlot, i *rateEnforcerItem) {
+	// Get offsets
+	// dstOffset is the position of the item's first sample in the frame, srcOffset is the position of the frame's first
+	// sample in the item
+	dstOffset, srcOffset := r.ptsToSamples(i.f.Pts()-s.ptsMin, i.d.TimeBase()), 0
+	if dstOffset < 0 {
+		dstOffset, srcOffset = 0, -dstOffset
+	}
+
+	// Get number of samples
+	n := int(math.Min(float64(i.f.NbSamples()-srcOffset), float64(r.audio.FrameSize-dstOffset)))
+	if n <= 0 {
+		return
+	}
+
+	// Copy
+	if ret := avutil.AvSamplesCopy(avutil.Data(f), avutil.Data(i.f), dstOffset, srcOffset, n, f.Channels(), int(r.audio.SampleFmt)); ret < 0 {
+		emitAvError(r, r.eh, ret, "avutil.AvSamplesCopy failed")
+	}
+}
+
+func (r *RateEnforcer) usefulNodes() (ns map[astiencoder.Node]bool) {
+	ns = make(map[astiencoder.Node]bool)
+	for _, s := range r.slots {
+		if s != nil && s.n != nil {
+			ns[s.n] = true
+		}
+	}
+	return
+}
+
+func (r *RateEnforcer) isUseful(n astiencoder.Node, ns map[astiencoder.Node]bool) bool {
+	for source := range ns {
+		if r.isFrom(n, source) {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *RateEnforcer) current() (i *rateEnforcerItem, previous bool) {
 	if r.slots[0] != nil && r.slots[0].i != nil {
 		// Get item
@@ -0,0 +1,106 @@
+package astilibav
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astitools/worker"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// monoChannelLayout is libav's AV_CH_LAYOUT_MONO
+const monoChannelLayout = uint64(4)
+
+type testAudioFrameHandler struct {
+	*astiencoder.BaseNode
+	m       *sync.Mutex
+	pts     []int64
+	samples [][]int16
+}
+
+func newTestAudioFrameHandler(eh *astiencoder.EventHandler) (h *testAudioFrameHandler) {
+	h = &testAudioFrameHandler{m: &sync.Mutex{}}
+	h.BaseNode = astiencoder.NewBaseNode(astiencoder.NodeOptions{Metadata: astiencoder.NodeMetadata{Name: "test"}}, astiencoder.NewEventGeneratorNode(h), eh)
+	return
+}
+
+// Start implements the Starter interface
+func (h *testAudioFrameHandler) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	h.BaseNode.Start(ctx, t, func(t *astiworker.Task) {
+		<-h.Context().Done()
+	})
+}
+
+func (h *testAudioFrameHandler) HandleFrame(p *FrameHandlerPayload) {
+	s, _ := frameAudioSamples(p.Frame)
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.pts = append(h.pts, p.Frame.Pts())
+	h.samples = append(h.samples, s.Int16[0])
+}
+
+func newTestAudioFrame(f *avutil.Frame, pts int64, samples []int16) *avutil.Frame {
+	f.SetChannelLayout(monoChannelLayout)
+	f.SetChannels(1)
+	f.SetSampleRate(8)
+	writeAudioSamples(AudioSamples{Int16: [][]int16{samples}}, f)
+	f.SetPts(pts)
+	return f
+}
+
+func TestRateEnforcerAudio(t *testing.T) {
+	// Create rate enforcer
+	// One pts is one sample
+	c := astiencoder.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	r := NewRateEnforcer(RateEnforcerOptions{Audio: &RateEnforcerAudioOptions{
+		ChannelLayout: monoChannelLayout,
+		FrameSize:     4,
+		SampleFmt:     avcodec.AvSampleFormat(avutil.AvGetSampleFmt("s16p")),
+		SampleRate:    8,
+	}}, eh, c)
+	h := newTestAudioFrameHandler(eh)
+	r.Connect(h)
+
+	// Create items
+	n := newTestNode("source", eh)
+	d := newTimeBaseDescriptor(avutil.NewRational(1, 8))
+	r.buf = []*rateEnforcerItem{
+		{d: d, f: newTestAudioFrame(r.p.get(), 1, []int16{1, 2}), n: n},
+		{d: d, f: newTestAudioFrame(r.p.get(), 3, []int16{3, 4, 5}), n: n},
+	}
+
+	// Gaps are filled with silence and items are sliced
+	r.slots = []*rateEnforcerSlot{{n: n, ptsMin: 0, ptsMax: 4}}
+	r.dispatchAudio()
+	r.d.wait()
+	assert.Len(t, r.buf, 1)
+	r.slots = []*rateEnforcerSlot{{n: n, ptsMin: 4, ptsMax: 8}}
+	r.dispatchAudio()
+	r.d.wait()
+	assert.Len(t, r.buf, 0)
+
+	// Items of other sources are ignored
+	r.buf = []*rateEnforcerItem{{d: d, f: newTestAudioFrame(r.p.get(), 8, []int16{6, 7, 8, 9}), n: h}}
+	r.slots = []*rateEnforcerSlot{{n: n, ptsMin: 8, ptsMax: 12}}
+	r.dispatchAudio()
+	r.d.wait()
+	assert.Len(t, r.buf, 0)
+	assert.Equal(t, []int64{0, 4, 8}, h.pts)
+	assert.Equal(t, [][]int16{{0, 1, 2, 3}, {4, 5, 0, 0}, {0, 0, 0, 0}}, h.samples)
+}
+
+func TestRateEnforcerAudioPts(t *testing.T) {
+	c := astiencoder.NewCloser()
+	defer c.Close()
+	r := NewRateEnforcer(RateEnforcerOptions{Audio: &RateEnforcerAudioOptions{SampleRate: 48000}}, astiencoder.NewEventHandler(), c)
+	assert.Equal(t, 1024, r.audio.FrameSize)
+	tb := avutil.NewRational(1, 90000)
+	assert.Equal(t, int64(1920), r.samplesToPts(1024, tb))
+	assert.Equal(t, 1024, r.ptsToSamples(1920, tb))
+}
@@ -0,0 +1,294 @@
+package astilibav
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astitools/stat"
+	"github.com/asticode/go-astitools/sync"
+	"github.com/asticode/go-astitools/worker"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avutil"
+	"github.com/asticode/goav/swresample"
+	"github.com/pkg/errors"
+)
+
+var countResampler uint64
+
+// Resampler represents an object capable of resampling and converting the sample format of audio frames with
+// swresample
+// Contrary to the filterer, it reinitializes itself whenever the input format changes
+type Resampler struct {
+	*astiencoder.BaseNode
+	ctxSwr           *swresample.Context
+	d                *frameDispatcher
+	eh               *astiencoder.EventHandler
+	inCtx            *Context
+	nextPts          *int64 // In output sample rate units
+	o                ResamplerOptions
+	q                *astisync.CtxQueue
+	statIncomingRate *astistat.IncrementStat
+	statWorkRatio    *astistat.DurationRatioStat
+}
+
+// ResamplerOptions represents resampler options
+type ResamplerOptions struct {
+	// Since 0 is a valid sample format, the output context's sample format is ignored only when this is true
+	KeepSampleFmt bool
+	Node          astiencoder.NodeOptions
+	// Only channel layout, sample format and sample rate are used. If channel layout or sample rate is 0, the input
+	// one is used
+	OutputCtx Context
+}
+
+// NewResampler creates a new resampler
+func NewResampler(o ResamplerOptions, eh *astiencoder.EventHandler, c *astiencoder.Closer) (r *Resampler) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countResampler, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("resampler_%d", count), fmt.Sprintf("Resampler #%d", count), "Resamples")
+
+	// Create resampler
+	r = &Resampler{
+		eh:               eh,
+		o:                o,
+		q:                astisync.NewCtxQueue(),
+		statIncomingRate: astistat.NewIncrementStat(),
+		statWorkRatio:    astistat.NewDurationRatioStat(),
+	}
+	r.BaseNode = astiencoder.NewBaseNode(o.Node, astiencoder.NewEventGeneratorNode(r), eh)
+	r.d = newFrameDispatcher(r, eh, c)
+	r.addStats()
+
+	// Make sure the swresample context is freed
+	c.Add(func() error {
+		r.freeCtx()
+		return nil
+	})
+	return
+}
+
+func (r *Resampler) addStats() {
+	// Add incoming rate
+	r.Stater().AddStat(astistat.StatMetadata{
+		Description: "Number of frames coming in per second",
+		Label:       "Incoming rate",
+		Unit:        "fps",
+	}, r.statIncomingRate)
+
+	// Add work ratio
+	r.Stater().AddStat(astistat.StatMetadata{
+		Description: "Percentage of time spent doing some actual work",
+		Label:       "Work ratio",
+		Unit:        "%",
+	}, r.statWorkRatio)
+
+	// Add dispatcher stats
+	r.d.addStats(r.Stater())
+
+	// Add queue stats
+	r.q.AddStats(r.Stater())
+}
+
+// Connect implements the FrameHandlerConnector interface
+func (r *Resampler) Connect(h FrameHandler) {
+	// Add handler
+	r.d.addHandler(h)
+
+	// Connect nodes
+	astiencoder.ConnectNodes(r, h)
+}
+
+// Disconnect implements the FrameHandlerConnector interface
+func (r *Resampler) Disconnect(h FrameHandler) {
+	// Delete handler
+	r.d.delHandler(h)
+
+	// Disconnect nodes
+	astiencoder.DisconnectNodes(r, h)
+}
+
+// Start starts the resampler
+func (r *Resampler) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	r.BaseNode.Start(ctx, t, func(t *astiworker.Task) {
+		// Handle context
+		go r.q.HandleCtx(r.Context())
+
+		// Make sure to wait for all dispatcher subprocesses to be done so that they are properly closed
+		defer r.d.wait()
+
+		// Make sure to stop the queue properly
+		defer r.q.Stop()
+
+		// Start queue
+		r.q.Start(func(dp interface{}) {
+			// Handle pause
+			defer r.HandlePause()
+
+			// Assert payload
+			p := dp.(*FrameHandlerPayload)
+
+			// Increment incoming rate
+			r.statIncomingRate.Add(1)
+
+			// Resample
+			r.statWorkRatio.Add(true)
+			if err := r.resample(p); err != nil {
+				r.statWorkRatio.Done(true)
+				r.eh.Emit(astiencoder.EventError(r, errors.Wrap(err, "astilibav: resampling failed")))
+				return
+			}
+			r.statWorkRatio.Done(true)
+		})
+	})
+}
+
+func (r *Resampler) resample(p *FrameHandlerPayload) (err error) {
+	// Make sure the swresample context matches the input format
+	if err = r.checkCtx(newContextFromFrame(p.Frame, avcodec.AVMEDIA_TYPE_AUDIO), p.Descriptor); err != nil {
+		err = errors.Wrap(err, "astilibav: checking swresample context failed")
+		return
+	}
+
+	// Convert
+	if err = r.convert(p.Frame, p.Descriptor); err != nil {
+		err = errors.Wrap(err, "astilibav: converting failed")
+		return
+	}
+	return
+}
+
+// noPtsValue is libav's AV_NOPTS_VALUE
+const noPtsValue = int64(math.MinInt64)
+
+// convert converts the input frame and dispatches the output frame if any
+// If the input frame is nil, samples buffered by swresample are flushed
+func (r *Resampler) convert(in *avutil.Frame, d Descriptor) (err error) {
+	// Get frame
+	f := r.d.p.get()
+	defer r.d.p.put(f)
+
+	// Copy frame properties
+	if in != nil {
+		if ret := avutil.AvFrameCopyProps(f, in); ret < 0 {
+			err = errors.Wrap(NewAvError(ret), "astilibav: avutil.AvFrameCopyProps failed")
+			return
+		}
+	}
+
+	// Set output format
+	// The buffer is allocated by swresample since the number of output samples depends on its internal delay
+	outCtx := r.outputCtx(*r.inCtx)
+	f.SetChannelLayout(outCtx.ChannelLayout)
+	f.SetFormat(int(outCtx.SampleFmt))
+	f.SetSampleRate(outCtx.SampleRate)
+
+	// Get pts of the first output sample, in output sample rate units
+	// Samples buffered by swresample come out before the input samples
+	outTimeBase := avutil.NewRational(1, outCtx.SampleRate)
+	pts := r.nextPts
+	if in != nil && in.Pts() != noPtsValue {
+		v := avutil.AvRescaleQ(in.Pts(), d.TimeBase(), outTimeBase) - r.ctxSwr.SwrGetDelay(int64(outCtx.SampleRate))
+		pts = &v
+	}
+
+	// Convert
+	if ret := r.ctxSwr.SwrConvertFrame((*swresample.Frame)(unsafe.Pointer(f)), (*swresample.Frame)(unsafe.Pointer(in))); ret < 0 {
+		err = errors.Wrap(NewAvError(ret), "astilibav: r.ctxSwr.SwrConvertFrame failed")
+		return
+	}
+
+	// Samples are still buffered
+	if f.NbSamples() == 0 {
+		return
+	}
+
+	// Set pts
+	if pts != nil {
+		f.SetPts(avutil.AvRescaleQ(*pts, outTimeBase, d.TimeBase()))
+		nextPts := *pts + int64(f.NbSamples())
+		r.nextPts = &nextPts
+	}
+
+	// Dispatch frame
+	r.d.dispatch(f, d)
+	return
+}
+
+func (r *Resampler) outputCtx(inCtx Context) (outCtx Context) {
+	outCtx = inCtx
+	if r.o.OutputCtx.ChannelLayout > 0 {
+		outCtx.ChannelLayout = r.o.OutputCtx.ChannelLayout
+		outCtx.Channels = avutil.AvGetChannelLayoutNbChannels(outCtx.ChannelLayout)
+	}
+	if !r.o.KeepSampleFmt {
+		outCtx.SampleFmt = r.o.OutputCtx.SampleFmt
+	}
+	if r.o.OutputCtx.SampleRate > 0 {
+		outCtx.SampleRate = r.o.OutputCtx.SampleRate
+	}
+	return
+}
+
+func (r *Resampler) checkCtx(inCtx Context, d Descriptor) (err error) {
+	// Nothing changed
+	if r.inCtx != nil && r.inCtx.sameFrameFormat(inCtx) {
+		return
+	}
+
+	// Flush previous context so that the samples it has buffered are not lost
+	if r.ctxSwr != nil {
+		if err = r.convert(nil, d); err != nil {
+			r.eh.Emit(astiencoder.EventError(r, errors.Wrap(err, "astilibav: flushing swresample context failed")))
+		}
+	}
+
+	// Free previous context
+	r.freeCtx()
+	r.nextPts = nil
+
+	// Create context
+	outCtx := r.outputCtx(inCtx)
+	if r.ctxSwr = swresample.SwrAllocSetOpts(nil, int64(outCtx.ChannelLayout), swresample.AvSampleFormat(outCtx.SampleFmt), outCtx.SampleRate, int64(inCtx.ChannelLayout), swresample.AvSampleFormat(inCtx.SampleFmt), inCtx.SampleRate, 0, 0); r.ctxSwr == nil {
+		err = fmt.Errorf("astilibav: no swresample context allocated for %s %s %d to %s %s %d", avutil.AvGetChannelLayoutString(inCtx.ChannelLayout), avutil.AvGetSampleFmtName(int(inCtx.SampleFmt)), inCtx.SampleRate, avutil.AvGetChannelLayoutString(outCtx.ChannelLayout), avutil.AvGetSampleFmtName(int(outCtx.SampleFmt)), outCtx.SampleRate)
+		return
+	}
+
+	// Init context
+	if ret := r.ctxSwr.SwrInit(); ret < 0 {
+		r.freeCtx()
+		err = errors.Wrap(NewAvError(ret), "astilibav: r.ctxSwr.SwrInit failed")
+		return
+	}
+
+	// Format changed
+	if r.inCtx != nil {
+		r.eh.Emit(astiencoder.Event{
+			Name: EventNameFormatChanged,
+			Payload: EventFormatChanged{
+				From: *r.inCtx,
+				To:   inCtx,
+			},
+			Target: r,
+		})
+	}
+
+	// Store input ctx
+	r.inCtx = &inCtx
+	return
+}
+
+func (r *Resampler) freeCtx() {
+	if r.ctxSwr != nil {
+		r.ctxSwr.SwrFree()
+		r.ctxSwr = nil
+	}
+}
+
+// HandleFrame implements the FrameHandler interface
+func (r *Resampler) HandleFrame(p *FrameHandlerPayload) {
+	r.q.Send(p)
+}
@@ -0,0 +1,63 @@
+package astilibav
+
+import (
+	"testing"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// stereoChannelLayout is libav's AV_CH_LAYOUT_STEREO
+const stereoChannelLayout = uint64(3)
+
+func TestResamplerOutputCtx(t *testing.T) {
+	c := astiencoder.NewCloser()
+	defer c.Close()
+	inCtx := Context{ChannelLayout: stereoChannelLayout, SampleFmt: avcodec.AvSampleFormat(avutil.AvGetSampleFmt("s16")), SampleRate: 48000}
+
+	// Sample format 0 is a valid sample format
+	r := NewResampler(ResamplerOptions{}, astiencoder.NewEventHandler(), c)
+	assert.Equal(t, Context{ChannelLayout: stereoChannelLayout, SampleRate: 48000}, r.outputCtx(inCtx))
+
+	// Keep sample format
+	r = NewResampler(ResamplerOptions{KeepSampleFmt: true, OutputCtx: Context{SampleRate: 44100}}, astiencoder.NewEventHandler(), c)
+	assert.Equal(t, Context{ChannelLayout: stereoChannelLayout, SampleFmt: inCtx.SampleFmt, SampleRate: 44100}, r.outputCtx(inCtx))
+}
+
+func TestResamplerPts(t *testing.T) {
+	c := astiencoder.NewCloser()
+	defer c.Close()
+	r := NewResampler(ResamplerOptions{KeepSampleFmt: true, OutputCtx: Context{SampleRate: 44100}}, astiencoder.NewEventHandler(), c)
+	d := newTimeBaseDescriptor(avutil.NewRational(1, 48000))
+
+	// Create frame
+	f := avutil.AvFrameAlloc()
+	defer avutil.AvFrameFree(f)
+	f.SetChannelLayout(stereoChannelLayout)
+	f.SetChannels(2)
+	f.SetFormat(int(avutil.AvGetSampleFmt("s16")))
+	f.SetNbSamples(1024)
+	f.SetSampleRate(48000)
+	if ret := avutil.AvFrameGetBuffer(f, 0); ret < 0 {
+		t.Fatal(NewAvError(ret))
+	}
+
+	// Resample
+	for idx := 0; idx < 10; idx++ {
+		f.SetPts(int64(idx * 1024))
+		assert.NoError(t, r.resample(&FrameHandlerPayload{Descriptor: d, Frame: f}))
+	}
+
+	// Next pts matches the input pts minus the samples buffered by swresample
+	assert.NotNil(t, r.nextPts)
+	expected := avutil.AvRescaleQ(10*1024, d.TimeBase(), avutil.NewRational(1, 44100)) - r.ctxSwr.SwrGetDelay(44100)
+	assert.InDelta(t, expected, *r.nextPts, 1)
+
+	// Format change resets the pts
+	f.SetSampleRate(44100)
+	f.SetPts(0)
+	assert.NoError(t, r.resample(&FrameHandlerPayload{Descriptor: d, Frame: f}))
+	assert.Equal(t, int64(1024), *r.nextPts)
+}
@@ -0,0 +1,268 @@
+package astilibav
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astitools/stat"
+	"github.com/asticode/go-astitools/sync"
+	"github.com/asticode/go-astitools/worker"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avutil"
+	"github.com/asticode/goav/swscale"
+	"github.com/pkg/errors"
+)
+
+var countScaler uint64
+
+// Scaler represents an object capable of scaling and converting the pixel format of video frames with swscale
+// Contrary to the filterer, it reinitializes itself whenever the input format changes
+type Scaler struct {
+	*astiencoder.BaseNode
+	ctxSws           *swscale.Context
+	d                *frameDispatcher
+	eh               *astiencoder.EventHandler
+	inCtx            *Context
+	o                ScalerOptions
+	q                *astisync.CtxQueue
+	statIncomingRate *astistat.IncrementStat
+	statWorkRatio    *astistat.DurationRatioStat
+}
+
+// ScalerOptions represents scaler options
+type ScalerOptions struct {
+	// Defaults to swscale.SWS_BICUBIC
+	Flags int
+	Node  astiencoder.NodeOptions
+	// Only height and width are used. If height or width is 0, the input one is used
+	OutputCtx Context
+	// If nil, the input pixel format is used. It's a pointer since 0 is a valid pixel format
+	PixelFormat *avutil.PixelFormat
+}
+
+// NewScaler creates a new scaler
+func NewScaler(o ScalerOptions, eh *astiencoder.EventHandler, c *astiencoder.Closer) (s *Scaler) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countScaler, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("scaler_%d", count), fmt.Sprintf("Scaler #%d", count), "Scales")
+
+	// Default flags
+	if o.Flags == 0 {
+		o.Flags = swscale.SWS_BICUBIC
+	}
+
+	// Create scaler
+	s = &Scaler{
+		eh:               eh,
+		o:                o,
+		q:                astisync.NewCtxQueue(),
+		statIncomingRate: astistat.NewIncrementStat(),
+		statWorkRatio:    astistat.NewDurationRatioStat(),
+	}
+	s.BaseNode = astiencoder.NewBaseNode(o.Node, astiencoder.NewEventGeneratorNode(s), eh)
+	s.d = newFrameDispatcher(s, eh, c)
+	s.addStats()
+
+	// Make sure the swscale context is freed
+	c.Add(func() error {
+		s.freeCtx()
+		return nil
+	})
+	return
+}
+
+func (s *Scaler) addStats() {
+	// Add incoming rate
+	s.Stater().AddStat(astistat.StatMetadata{
+		Description: "Number of frames coming in per second",
+		Label:       "Incoming rate",
+		Unit:        "fps",
+	}, s.statIncomingRate)
+
+	// Add work ratio
+	s.Stater().AddStat(astistat.StatMetadata{
+		Description: "Percentage of time spent doing some actual work",
+		Label:       "Work ratio",
+		Unit:        "%",
+	}, s.statWorkRatio)
+
+	// Add dispatcher stats
+	s.d.addStats(s.Stater())
+
+	// Add queue stats
+	s.q.AddStats(s.Stater())
+}
+
+// Connect implements the FrameHandlerConnector interface
+func (s *Scaler) Connect(h FrameHandler) {
+	// Add handler
+	s.d.addHandler(h)
+
+	// Connect nodes
+	astiencoder.ConnectNodes(s, h)
+}
+
+// Disconnect implements the FrameHandlerConnector interface
+func (s *Scaler) Disconnect(h FrameHandler) {
+	// Delete handler
+	s.d.delHandler(h)
+
+	// Disconnect nodes
+	astiencoder.DisconnectNodes(s, h)
+}
+
+// Start starts the scaler
+func (s *Scaler) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	s.BaseNode.Start(ctx, t, func(t *astiworker.Task) {
+		// Handle context
+		go s.q.HandleCtx(s.Context())
+
+		// Make sure to wait for all dispatcher subprocesses to be done so that they are properly closed
+		defer s.d.wait()
+
+		// Make sure to stop the queue properly
+		defer s.q.Stop()
+
+		// Start queue
+		s.q.Start(func(dp interface{}) {
+			// Handle pause
+			defer s.HandlePause()
+
+			// Assert payload
+			p := dp.(*FrameHandlerPayload)
+
+			// Increment incoming rate
+			s.statIncomingRate.Add(1)
+
+			// Scale
+			s.statWorkRatio.Add(true)
+			if err := s.scale(p); err != nil {
+				s.statWorkRatio.Done(true)
+				s.eh.Emit(astiencoder.EventError(s, errors.Wrap(err, "astilibav: scaling failed")))
+				return
+			}
+			s.statWorkRatio.Done(true)
+		})
+	})
+}
+
+func (s *Scaler) scale(p *FrameHandlerPayload) (err error) {
+	// Make sure the swscale context matches the input format
+	if err = s.checkCtx(newContextFromFrame(p.Frame, avcodec.AVMEDIA_TYPE_VIDEO)); err != nil {
+		err = errors.Wrap(err, "astilibav: checking swscale context failed")
+		return
+	}
+
+	// Get frame
+	f := s.d.p.get()
+	defer s.d.p.put(f)
+
+	// Copy frame properties
+	if ret := avutil.AvFrameCopyProps(f, p.Frame); ret < 0 {
+		err = errors.Wrap(NewAvError(ret), "astilibav: avutil.AvFrameCopyProps failed")
+		return
+	}
+
+	// Allocate buffer
+	outCtx := s.outputCtx(*s.inCtx)
+	f.SetFormat(int(outCtx.PixelFormat))
+	f.SetHeight(outCtx.Height)
+	f.SetSampleAspectRatio(outCtx.SampleAspectRatio)
+	f.SetWidth(outCtx.Width)
+	if ret := avutil.AvFrameGetBuffer(f, 0); ret < 0 {
+		err = errors.Wrap(NewAvError(ret), "astilibav: avutil.AvFrameGetBuffer failed")
+		return
+	}
+
+	// Scale
+	if ret := swscale.SwsScale2(s.ctxSws, avutil.Data(p.Frame), avutil.Linesize(p.Frame), 0, p.Frame.Height(), avutil.Data(f), avutil.Linesize(f)); ret < 0 {
+		err = errors.Wrap(NewAvError(ret), "astilibav: swscale.SwsScale2 failed")
+		return
+	}
+
+	// Dispatch frame
+	s.d.dispatch(f, p.Descriptor)
+	return
+}
+
+func (s *Scaler) outputCtx(inCtx Context) (outCtx Context) {
+	outCtx = inCtx
+	if s.o.OutputCtx.Height > 0 {
+		outCtx.Height = s.o.OutputCtx.Height
+	}
+	if s.o.PixelFormat != nil {
+		outCtx.PixelFormat = *s.o.PixelFormat
+	}
+	if s.o.OutputCtx.Width > 0 {
+		outCtx.Width = s.o.OutputCtx.Width
+	}
+	outCtx.SampleAspectRatio = scaledSampleAspectRatio(inCtx, outCtx.Width, outCtx.Height)
+	return
+}
+
+// scaledSampleAspectRatio returns the sample aspect ratio that keeps the display aspect ratio once scaled, like
+// vf_scale does: out_sar = in_sar * (in_w * out_h) / (in_h * out_w)
+// An unknown sample aspect ratio is left untouched
+func scaledSampleAspectRatio(inCtx Context, width, height int) avutil.Rational {
+	if inCtx.SampleAspectRatio.Num() == 0 || inCtx.SampleAspectRatio.Den() == 0 || inCtx.Height == 0 || width == 0 {
+		return inCtx.SampleAspectRatio
+	}
+	num := int64(inCtx.SampleAspectRatio.Num()) * int64(inCtx.Width) * int64(height)
+	den := int64(inCtx.SampleAspectRatio.Den()) * int64(inCtx.Height) * int64(width)
+	g := gcd(num, den)
+	return avutil.NewRational(int(num/g), int(den/g))
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+func (s *Scaler) checkCtx(inCtx Context) (err error) {
+	// Nothing changed
+	if s.inCtx != nil && s.inCtx.sameFrameFormat(inCtx) {
+		return
+	}
+
+	// Free previous context
+	s.freeCtx()
+
+	// Create context
+	outCtx := s.outputCtx(inCtx)
+	if s.ctxSws = swscale.SwsGetcontext(inCtx.Width, inCtx.Height, (swscale.PixelFormat)(inCtx.PixelFormat), outCtx.Width, outCtx.Height, (swscale.PixelFormat)(outCtx.PixelFormat), s.o.Flags, nil, nil, nil); s.ctxSws == nil {
+		err = fmt.Errorf("astilibav: no swscale context created for %dx%d %s to %dx%d %s", inCtx.Width, inCtx.Height, avutil.AvGetPixFmtName(int(inCtx.PixelFormat)), outCtx.Width, outCtx.Height, avutil.AvGetPixFmtName(int(outCtx.PixelFormat)))
+		return
+	}
+
+	// Format changed
+	if s.inCtx != nil {
+		s.eh.Emit(astiencoder.Event{
+			Name: EventNameFormatChanged,
+			Payload: EventFormatChanged{
+				From: *s.inCtx,
+				To:   inCtx,
+			},
+			Target: s,
+		})
+	}
+
+	// Store input ctx
+	s.inCtx = &inCtx
+	return
+}
+
+func (s *Scaler) freeCtx() {
+	if s.ctxSws != nil {
+		swscale.SwsFreecontext(s.ctxSws)
+		s.ctxSws = nil
+	}
+}
+
+// HandleFrame implements the FrameHandler interface
+func (s *Scaler) HandleFrame(p *FrameHandlerPayload) {
+	s.q.Send(p)
+}
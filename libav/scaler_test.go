@@ -0,0 +1,78 @@
+package astilibav
+
+import (
+	"testing"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestScalerFrame(t *testing.T, f *avutil.Frame, width, height int) {
+	avutil.AvFrameUnref(f)
+	f.SetFormat(int(avutil.AV_PIX_FMT_YUV420P))
+	f.SetHeight(height)
+	f.SetSampleAspectRatio(avutil.NewRational(1, 1))
+	f.SetWidth(width)
+	if ret := avutil.AvFrameGetBuffer(f, 0); ret < 0 {
+		t.Fatal(NewAvError(ret))
+	}
+}
+
+func TestScalerOutputCtx(t *testing.T) {
+	c := astiencoder.NewCloser()
+	defer c.Close()
+	inCtx := Context{CodecType: avcodec.AVMEDIA_TYPE_VIDEO, Height: 1080, PixelFormat: avutil.AV_PIX_FMT_YUV420P, SampleAspectRatio: avutil.NewRational(1, 1), Width: 1920}
+
+	// Pixel format is kept by default
+	s := NewScaler(ScalerOptions{}, astiencoder.NewEventHandler(), c)
+	assert.Equal(t, inCtx, s.outputCtx(inCtx))
+
+	// Sample aspect ratio keeps the display aspect ratio
+	p := avutil.PixelFormat(avutil.AV_PIX_FMT_RGB24)
+	s = NewScaler(ScalerOptions{OutputCtx: Context{Height: 720, Width: 720}, PixelFormat: &p}, astiencoder.NewEventHandler(), c)
+	outCtx := s.outputCtx(inCtx)
+	assert.Equal(t, p, outCtx.PixelFormat)
+	assert.Equal(t, 720, outCtx.Height)
+	assert.Equal(t, 720, outCtx.Width)
+	assert.Equal(t, 16, outCtx.SampleAspectRatio.Num())
+	assert.Equal(t, 9, outCtx.SampleAspectRatio.Den())
+
+	// Unknown sample aspect ratio is left untouched
+	inCtx.SampleAspectRatio = avutil.NewRational(0, 1)
+	assert.Equal(t, 0, s.outputCtx(inCtx).SampleAspectRatio.Num())
+}
+
+func TestScalerScale(t *testing.T) {
+	// Create scaler
+	c := astiencoder.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	s := NewScaler(ScalerOptions{OutputCtx: Context{Height: 4, Width: 4}}, eh, c)
+	var es []EventFormatChanged
+	eh.AddForEventName(EventNameFormatChanged, func(e astiencoder.Event) bool {
+		es = append(es, e.Payload.(EventFormatChanged))
+		return false
+	})
+
+	// Scale
+	// The swscale context is only reinitialized when the input format changes
+	f := avutil.AvFrameAlloc()
+	defer avutil.AvFrameFree(f)
+	p := &FrameHandlerPayload{Descriptor: newTimeBaseDescriptor(avutil.NewRational(1, 25)), Frame: f}
+	newTestScalerFrame(t, f, 8, 8)
+	assert.NoError(t, s.scale(p))
+	ctxSws := s.ctxSws
+	assert.NotNil(t, ctxSws)
+	assert.NoError(t, s.scale(p))
+	assert.True(t, ctxSws == s.ctxSws)
+	assert.Len(t, es, 0)
+	newTestScalerFrame(t, f, 16, 8)
+	assert.NoError(t, s.scale(p))
+	assert.NotNil(t, s.ctxSws)
+	assert.Equal(t, 16, s.inCtx.Width)
+	assert.Len(t, es, 1)
+	assert.Equal(t, 8, es[0].From.Width)
+	assert.Equal(t, 16, es[0].To.Width)
+}
@@ -0,0 +1,270 @@
+package astilibav
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astitools/stat"
+	"github.com/asticode/go-astitools/sync"
+	"github.com/asticode/go-astitools/worker"
+	"github.com/asticode/goav/avutil"
+)
+
+var countSceneDetector uint64
+
+// SceneDetector represents an object capable of computing a scene score for every frame and of emitting an event
+// when a scene changes
+// Frames are dispatched untouched so that the detector can be placed before encoders
+type SceneDetector struct {
+	*astiencoder.BaseNode
+	aligned          bool
+	alignerPts       map[interface{}]int64
+	d                *frameDispatcher
+	eh               *astiencoder.EventHandler
+	lastChangePts    *time.Duration
+	m                *sync.Mutex
+	o                SceneDetectorOptions
+	previous         *lumaThumbnail
+	q                *astisync.CtxQueue
+	sceneChanges     map[int64]bool
+	statIncomingRate *astistat.IncrementStat
+	statScore        *valueStat
+	statWorkRatio    *astistat.DurationRatioStat
+	tolerances       keyFrameTolerances
+}
+
+// SceneDetectorOptions represents scene detector options
+type SceneDetectorOptions struct {
+	// Scene changes occurring less than MinInterval after the previous scene change are ignored
+	MinInterval time.Duration
+	Node        astiencoder.NodeOptions
+	// Scene score (between 0 and 1) above which a frame is considered a scene change. Defaults to 0.4
+	Threshold float64
+}
+
+// EventSceneChanged represents the payload of a scene changed event
+type EventSceneChanged struct {
+	// Pts in the time base of the descriptor
+	Pts   int64
+	Score float64
+	Time  time.Duration
+}
+
+// NewSceneDetector creates a new scene detector
+func NewSceneDetector(o SceneDetectorOptions, eh *astiencoder.EventHandler, c *astiencoder.Closer) (d *SceneDetector) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countSceneDetector, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("scene_detector_%d", count), fmt.Sprintf("Scene Detector #%d", count), "Detects scenes")
+
+	// Default options
+	if o.Threshold <= 0 {
+		o.Threshold = 0.4
+	}
+
+	// Create detector
+	d = &SceneDetector{
+		alignerPts:       make(map[interface{}]int64),
+		eh:               eh,
+		m:                &sync.Mutex{},
+		o:                o,
+		q:                astisync.NewCtxQueue(),
+		sceneChanges:     make(map[int64]bool),
+		statIncomingRate: astistat.NewIncrementStat(),
+		statScore:        newMaxValueStat(),
+		statWorkRatio:    astistat.NewDurationRatioStat(),
+		tolerances:       make(keyFrameTolerances),
+	}
+	d.BaseNode = astiencoder.NewBaseNode(o.Node, astiencoder.NewEventGeneratorNode(d), eh)
+	d.d = newFrameDispatcher(d, eh, c)
+	d.addStats()
+	return
+}
+
+func (d *SceneDetector) addStats() {
+	// Add incoming rate
+	d.Stater().AddStat(astistat.StatMetadata{
+		Description: "Number of frames coming in per second",
+		Label:       "Incoming rate",
+		Unit:        "fps",
+	}, d.statIncomingRate)
+
+	// Add score
+	d.Stater().AddStat(astistat.StatMetadata{
+		Description: "Max scene score since the previous stat",
+		Label:       "Scene score",
+	}, d.statScore)
+
+	// Add work ratio
+	d.Stater().AddStat(astistat.StatMetadata{
+		Description: "Percentage of time spent doing some actual work",
+		Label:       "Work ratio",
+		Unit:        "%",
+	}, d.statWorkRatio)
+
+	// Add dispatcher stats
+	d.d.addStats(d.Stater())
+
+	// Add queue stats
+	d.q.AddStats(d.Stater())
+}
+
+// Connect implements the FrameHandlerConnector interface
+func (d *SceneDetector) Connect(h FrameHandler) {
+	// Add handler
+	d.d.addHandler(h)
+
+	// Connect nodes
+	astiencoder.ConnectNodes(d, h)
+}
+
+// Disconnect implements the FrameHandlerConnector interface
+func (d *SceneDetector) Disconnect(h FrameHandler) {
+	// Delete handler
+	d.d.delHandler(h)
+
+	// Disconnect nodes
+	astiencoder.DisconnectNodes(d, h)
+}
+
+// Start starts the scene detector
+func (d *SceneDetector) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	d.BaseNode.Start(ctx, t, func(t *astiworker.Task) {
+		// Handle context
+		go d.q.HandleCtx(d.Context())
+
+		// Make sure to wait for all dispatcher subprocesses to be done so that they are properly closed
+		defer d.d.wait()
+
+		// Make sure to stop the queue properly
+		defer d.q.Stop()
+
+		// Start queue
+		d.q.Start(func(dp interface{}) {
+			// Handle pause
+			defer d.HandlePause()
+
+			// Assert payload
+			p := dp.(*FrameHandlerPayload)
+
+			// Increment incoming rate
+			d.statIncomingRate.Add(1)
+
+			// Detect
+			d.statWorkRatio.Add(true)
+			d.detect(p)
+			d.statWorkRatio.Done(true)
+
+			// Dispatch frame
+			d.d.dispatch(p.Frame, p.Descriptor)
+		})
+	})
+}
+
+func (d *SceneDetector) detect(p *FrameHandlerPayload) {
+	// Create thumbnail
+	t := newLumaThumbnail(p.Frame)
+
+	// Make sure to store the thumbnail
+	defer func() { d.previous = t }()
+
+	// No previous thumbnail
+	if t == nil || d.previous == nil {
+		return
+	}
+
+	// Get score
+	s := t.score(d.previous)
+	d.statScore.set(s)
+
+	// Score is too low
+	if s < d.o.Threshold {
+		return
+	}
+
+	// Scene changed too recently
+	pts := time.Duration(avutil.AvRescaleQ(p.Frame.Pts(), p.Descriptor.TimeBase(), nanosecondRational))
+	if d.lastChangePts != nil && pts-*d.lastChangePts < d.o.MinInterval {
+		return
+	}
+	d.lastChangePts = &pts
+
+	// Store scene change
+	d.m.Lock()
+	if d.aligned {
+		d.sceneChanges[int64(pts)] = true
+	}
+	d.m.Unlock()
+
+	// Emit event
+	d.eh.Emit(astiencoder.Event{
+		Name: EventNameSceneChanged,
+		Payload: EventSceneChanged{
+			Pts:   p.Frame.Pts(),
+			Score: s,
+			Time:  pts,
+		},
+		Target: d,
+	})
+}
+
+// KeyFrameAligner returns a key frame aligner that forces key frames on scene changes
+// Encoders using it must be fed by the scene detector, directly or not, so that frames are analyzed before reaching
+// them
+func (d *SceneDetector) KeyFrameAligner() KeyFrameAligner {
+	d.m.Lock()
+	defer d.m.Unlock()
+	d.aligned = true
+	return (*keyFrameAlignerWithSceneDetector)(d)
+}
+
+type keyFrameAlignerWithSceneDetector SceneDetector
+
+// ShouldForce implements the KeyFrameAligner interface
+func (a *keyFrameAlignerWithSceneDetector) ShouldForce(f *avutil.Frame, d Descriptor, id interface{}) (force bool) {
+	// Get pts
+	pts := avutil.AvRescaleQ(f.Pts(), d.TimeBase(), nanosecondRational)
+
+	// Lock
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	// Check whether a scene changed in [pts - tolerance, pts + tolerance[
+	// Encoders may have been fed with frames rescaled differently or with a different frame rate than the ones the
+	// scene detector analyzed, therefore a frame matches scene changes occurring at any pts it covers
+	t := a.tolerances.get(id, pts)
+	for k := range a.sceneChanges {
+		if k >= pts-t && k < pts+t {
+			force = true
+			break
+		}
+	}
+
+	// Store pts
+	a.alignerPts[id] = pts
+
+	// Remove scene changes that can't be used anymore
+	// Next frames of every encoder start after their current pts and can't cover scene changes before it
+	var min int64
+	var ok bool
+	for _, v := range a.alignerPts {
+		if !ok || v < min {
+			min = v
+			ok = true
+		}
+	}
+	for k := range a.sceneChanges {
+		if k < min {
+			delete(a.sceneChanges, k)
+		}
+	}
+	return
+}
+
+// HandleFrame implements the FrameHandler interface
+func (d *SceneDetector) HandleFrame(p *FrameHandlerPayload) {
+	d.q.Send(p)
+}
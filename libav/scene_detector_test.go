@@ -0,0 +1,58 @@
+package astilibav
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestGrayFrame(t *testing.T, f *avutil.Frame, y uint8, pts int64) {
+	avutil.AvFrameUnref(f)
+	i := image.NewGray(image.Rect(0, 0, 2*lumaThumbnailWidth, 2*lumaThumbnailHeight))
+	draw.Draw(i, i.Bounds(), &image.Uniform{C: color.Gray{Y: y}}, image.ZP, draw.Src)
+	if err := writeImage(i, f); err != nil {
+		t.Fatal(err)
+	}
+	f.SetPts(pts)
+}
+
+func TestSceneDetector(t *testing.T) {
+	// Create detector
+	c := astiencoder.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	d := NewSceneDetector(SceneDetectorOptions{MinInterval: time.Second}, eh, c)
+	a := d.KeyFrameAligner()
+	var es []EventSceneChanged
+	eh.AddForEventName(EventNameSceneChanged, func(e astiencoder.Event) bool {
+		es = append(es, e.Payload.(EventSceneChanged))
+		return false
+	})
+
+	// Detect
+	// The last scene change is too close to the previous one
+	f := avutil.AvFrameAlloc()
+	defer avutil.AvFrameFree(f)
+	p := &FrameHandlerPayload{Descriptor: newTimeBaseDescriptor(avutil.NewRational(1, 25)), Frame: f}
+	for idx, y := range []uint8{0, 0, 255, 0} {
+		newTestGrayFrame(t, f, y, int64(idx))
+		d.detect(p)
+	}
+	assert.Equal(t, []EventSceneChanged{{Pts: 2, Score: 1, Time: 80 * time.Millisecond}}, es)
+
+	// Encoder pts are 1 tick early because of rescaling
+	dd := newTimeBaseDescriptor(avutil.NewRational(1, 90000))
+	var forced []bool
+	for _, pts := range []int64{0, 3599, 7199, 10799} {
+		f.SetPts(pts)
+		forced = append(forced, a.ShouldForce(f, dd, "1"))
+	}
+	assert.Equal(t, []bool{false, false, true, false}, forced)
+	assert.Len(t, d.sceneChanges, 0)
+}
@@ -0,0 +1,222 @@
+package astilibav
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astitools/stat"
+	"github.com/asticode/go-astitools/sync"
+	"github.com/asticode/go-astitools/worker"
+	"github.com/asticode/goav/avutil"
+	"github.com/pkg/errors"
+)
+
+var countSilenceDetector uint64
+
+const minDB = -144
+
+// SilenceDetector represents an object capable of detecting silent and clipped audio intervals
+type SilenceDetector struct {
+	*astiencoder.BaseNode
+	clipping         *intervalDetector
+	eh               *astiencoder.EventHandler
+	o                SilenceDetectorOptions
+	q                *astisync.CtxQueue
+	silence          *intervalDetector
+	statIncomingRate *astistat.IncrementStat
+	statPeak         *valueStat
+	statWorkRatio    *astistat.DurationRatioStat
+}
+
+// SilenceDetectorOptions represents silence detector options
+type SilenceDetectorOptions struct {
+	// Number of consecutive samples above the clipping threshold after which a frame is considered clipped.
+	// Defaults to 3
+	ClippingMinSamples int
+	// Min duration of a clipping interval. Defaults to 0 so that every clipped frame is reported
+	ClippingMinDuration time.Duration
+	// Level in dBFS above which a sample is considered clipped. Defaults to -0.01
+	ClippingThreshold float64
+	Node              astiencoder.NodeOptions
+	// Min duration of a silence interval. Defaults to 2s
+	SilenceMinDuration time.Duration
+	// Level in dBFS under which all samples of a frame must be for it to be considered silent. Defaults to -60
+	SilenceThreshold float64
+}
+
+// SilenceDetectorExposedData represents silence detector exposed data
+type SilenceDetectorExposedData struct {
+	Clipping []DetectionInterval `json:"clipping"`
+	Silence  []DetectionInterval `json:"silence"`
+}
+
+// NewSilenceDetector creates a new silence detector
+func NewSilenceDetector(o SilenceDetectorOptions, eh *astiencoder.EventHandler) (d *SilenceDetector) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countSilenceDetector, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("silence_detector_%d", count), fmt.Sprintf("Silence Detector #%d", count), "Detects silence and clipping")
+
+	// Default options
+	if o.ClippingMinSamples <= 0 {
+		o.ClippingMinSamples = 3
+	}
+	if o.ClippingThreshold == 0 {
+		o.ClippingThreshold = -0.01
+	}
+	if o.SilenceMinDuration <= 0 {
+		o.SilenceMinDuration = 2 * time.Second
+	}
+	if o.SilenceThreshold == 0 {
+		o.SilenceThreshold = -60
+	}
+
+	// Create detector
+	d = &SilenceDetector{
+		eh:               eh,
+		o:                o,
+		q:                astisync.NewCtxQueue(),
+		statIncomingRate: astistat.NewIncrementStat(),
+		statPeak:         newMaxValueStat(),
+		statWorkRatio:    astistat.NewDurationRatioStat(),
+	}
+	d.BaseNode = astiencoder.NewBaseNode(o.Node, astiencoder.NewEventGeneratorNode(d), eh)
+	d.clipping = newIntervalDetector(o.ClippingMinDuration, EventNameClippingStarted, EventNameClippingEnded, d, eh)
+	d.silence = newIntervalDetector(o.SilenceMinDuration, EventNameSilenceStarted, EventNameSilenceEnded, d, eh)
+	d.addStats()
+	return
+}
+
+func (d *SilenceDetector) addStats() {
+	// Add incoming rate
+	d.Stater().AddStat(astistat.StatMetadata{
+		Description: "Number of frames coming in per second",
+		Label:       "Incoming rate",
+		Unit:        "fps",
+	}, d.statIncomingRate)
+
+	// Add peak
+	d.Stater().AddStat(astistat.StatMetadata{
+		Description: "Max sample peak since the previous stat",
+		Label:       "Peak",
+		Unit:        "dBFS",
+	}, d.statPeak)
+
+	// Add work ratio
+	d.Stater().AddStat(astistat.StatMetadata{
+		Description: "Percentage of time spent doing some actual work",
+		Label:       "Work ratio",
+		Unit:        "%",
+	}, d.statWorkRatio)
+
+	// Add queue stats
+	d.q.AddStats(d.Stater())
+}
+
+// ExposedData implements the astiencoder.NodeExposer interface
+func (d *SilenceDetector) ExposedData() interface{} {
+	return d.Report()
+}
+
+// Report returns the silence and clipping intervals detected so far
+func (d *SilenceDetector) Report() SilenceDetectorExposedData {
+	return SilenceDetectorExposedData{
+		Clipping: d.clipping.report(),
+		Silence:  d.silence.report(),
+	}
+}
+
+// Start starts the silence detector
+func (d *SilenceDetector) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	d.BaseNode.Start(ctx, t, func(t *astiworker.Task) {
+		// Handle context
+		go d.q.HandleCtx(d.Context())
+
+		// Make sure to stop the queue properly
+		defer d.q.Stop()
+
+		// Start queue
+		d.q.Start(func(dp interface{}) {
+			// Handle pause
+			defer d.HandlePause()
+
+			// Assert payload
+			p := dp.(*FrameHandlerPayload)
+
+			// Increment incoming rate
+			d.statIncomingRate.Add(1)
+
+			// Detect
+			d.statWorkRatio.Add(true)
+			if err := d.detect(p); err != nil {
+				d.statWorkRatio.Done(true)
+				d.eh.Emit(astiencoder.EventError(d, errors.Wrap(err, "astilibav: detecting silence failed")))
+				return
+			}
+			d.statWorkRatio.Done(true)
+		})
+
+		// Close ongoing intervals
+		d.clipping.close()
+		d.silence.close()
+	})
+}
+
+func (d *SilenceDetector) detect(p *FrameHandlerPayload) (err error) {
+	// Get samples
+	var s AudioSamples
+	if s, err = frameAudioSamples(p.Frame); err != nil {
+		err = errors.Wrap(err, "astilibav: getting audio samples failed")
+		return
+	}
+
+	// Loop through samples
+	clippingThreshold := math.Pow(10, d.o.ClippingThreshold/20)
+	var clipped bool
+	var peak float64
+	for _, c := range s.normalized() {
+		var consecutive int
+		for _, v := range c {
+			v = math.Abs(v)
+			if v > peak {
+				peak = v
+			}
+			if v >= clippingThreshold {
+				if consecutive++; consecutive >= d.o.ClippingMinSamples {
+					clipped = true
+				}
+			} else {
+				consecutive = 0
+			}
+		}
+	}
+	d.statPeak.set(amplitudeToDB(peak))
+
+	// Get frame times
+	start := detectionTime{pts: p.Frame.Pts()}
+	end := detectionTime{pts: start.pts + avutil.AvRescaleQ(int64(p.Frame.NbSamples()), avutil.NewRational(1, p.Frame.SampleRate()), p.Descriptor.TimeBase())}
+	start.t = time.Duration(avutil.AvRescaleQ(start.pts, p.Descriptor.TimeBase(), nanosecondRational))
+	end.t = time.Duration(avutil.AvRescaleQ(end.pts, p.Descriptor.TimeBase(), nanosecondRational))
+
+	// Handle decisions
+	d.clipping.handle(clipped, start, end)
+	d.silence.handle(amplitudeToDB(peak) < d.o.SilenceThreshold, start, end)
+	return
+}
+
+// amplitudeToDB converts an amplitude between 0 and 1 to dBFS
+// Silence is floored to minDB so that values can be JSON encoded
+func amplitudeToDB(v float64) float64 {
+	if v <= 0 {
+		return minDB
+	}
+	return math.Max(20*math.Log10(v), minDB)
+}
+
+// HandleFrame implements the FrameHandler interface
+func (d *SilenceDetector) HandleFrame(p *FrameHandlerPayload) {
+	d.q.Send(p)
+}
@@ -0,0 +1,42 @@
+package astilibav
+
+import (
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAmplitudeToDB(t *testing.T) {
+	assert.Equal(t, float64(minDB), amplitudeToDB(0))
+	assert.Equal(t, float64(0), amplitudeToDB(1))
+	assert.InDelta(t, -6.02, amplitudeToDB(0.5), 0.01)
+}
+
+func TestSilenceDetector(t *testing.T) {
+	d := NewSilenceDetector(SilenceDetectorOptions{ClippingMinSamples: 2, SilenceMinDuration: time.Second}, astiencoder.NewEventHandler())
+	f := avutil.AvFrameAlloc()
+	defer avutil.AvFrameFree(f)
+	p := &FrameHandlerPayload{Descriptor: newTimeBaseDescriptor(avutil.NewRational(1, 8)), Frame: f}
+
+	// One frame lasts 0.5s
+	for idx, samples := range [][]int16{
+		{0, 0, 0, 0},
+		{0, 0, 0, 0},
+		{1 << 14, 0, 0, 0},
+		{1<<15 - 1, 1<<15 - 1, 0, 0},
+		{1<<15 - 1, 0, 1<<15 - 1, 0},
+	} {
+		newTestAudioFrame(f, int64(idx*4), samples)
+		assert.NoError(t, d.detect(p))
+		avutil.AvFrameUnref(f)
+	}
+	d.clipping.close()
+	d.silence.close()
+	assert.Equal(t, SilenceDetectorExposedData{
+		Clipping: []DetectionInterval{{End: 2 * time.Second, Start: 1500 * time.Millisecond}},
+		Silence:  []DetectionInterval{{End: time.Second, Start: 0}},
+	}, d.Report())
+}
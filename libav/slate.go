@@ -0,0 +1,121 @@
+package astilibav
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/goav/avfilter"
+	"github.com/asticode/goav/avutil"
+	"github.com/pkg/errors"
+)
+
+// SlateOptions represents slate options
+// Sources are checked in this order: image path, pattern and color
+// The slate has the dimensions, pixel format and sample aspect ratio of the frames it replaces
+type SlateOptions struct {
+	// Color name or hexadecimal value as libavfilter expects it (e.g. "black" or "0x1E1E1E"). Defaults to "black"
+	Color string
+	// Image file decoded with the "movie" filter and scaled to the frames' dimensions
+	ImagePath string
+	// libavfilter video source name such as "testsrc2", "smptebars" or "smptehdbars"
+	Pattern string
+}
+
+func (o SlateOptions) content(ctx Context) (c string) {
+	// Source
+	switch {
+	case len(o.ImagePath) > 0:
+		c = fmt.Sprintf("movie=filename=%s,scale='w=%d:h=%d'", escapeFilterGraphOption(o.ImagePath), ctx.Width, ctx.Height)
+	case len(o.Pattern) > 0:
+		c = fmt.Sprintf("%s=size=%dx%d", o.Pattern, ctx.Width, ctx.Height)
+	default:
+		color := o.Color
+		if len(color) == 0 {
+			color = "black"
+		}
+		c = fmt.Sprintf("color=c=%s:size=%dx%d", color, ctx.Width, ctx.Height)
+	}
+
+	// Pixel format
+	c += fmt.Sprintf(",format=pix_fmts=%s", avutil.AvGetPixFmtName(int(ctx.PixelFormat)))
+
+	// Sample aspect ratio
+	if ctx.SampleAspectRatio.Num() > 0 && ctx.SampleAspectRatio.Den() > 0 {
+		c += fmt.Sprintf(",setsar=%d/%d", ctx.SampleAspectRatio.Num(), ctx.SampleAspectRatio.Den())
+	}
+	return
+}
+
+// escapeFilterGraphOption escapes an option value for both levels of parsing it goes through when it's part of a
+// filter graph description: first the option parser, then the graph parser
+func escapeFilterGraphOption(v string) string {
+	// Option parser
+	v = "'" + strings.Replace(v, "'", `'\''`, -1) + "'"
+
+	// Graph parser
+	return strings.NewReplacer(`\`, `\\`, "'", `\'`, "[", `\[`, "]", `\]`, ",", `\,`, ";", `\;`).Replace(v)
+}
+
+// newSlateFrame generates a single video frame with a source-only filter graph
+// Only height, pixel format, sample aspect ratio and width of the context are used. The frame must be freed by the
+// caller
+func newSlateFrame(o SlateOptions, ctx Context) (f *avutil.Frame, err error) {
+	// Check dimensions
+	if ctx.Width <= 0 || ctx.Height <= 0 {
+		err = fmt.Errorf("astilibav: invalid slate dimensions %dx%d", ctx.Width, ctx.Height)
+		return
+	}
+
+	// Make sure the graph is freed once the frame has been generated
+	gc := astiencoder.NewCloser()
+	defer gc.Close()
+
+	// Create graph
+	g := avfilter.AvfilterGraphAlloc()
+	gc.Add(func() error {
+		g.AvfilterGraphFree()
+		return nil
+	})
+
+	// Create buffer sink ctx
+	// We need to create an intermediate variable to avoid "cgo argument has Go pointer to Go pointer" errors
+	var bufferSinkCtx *avfilter.Context
+	if ret := avfilter.AvfilterGraphCreateFilter(&bufferSinkCtx, avfilter.AvfilterGetByName("buffersink"), "out", "", nil, g); ret < 0 {
+		err = errors.Wrap(NewAvError(ret), "astilibav: avfilter.AvfilterGraphCreateFilter on empty args failed")
+		return
+	}
+
+	// Create inputs
+	inputs := avfilter.AvfilterInoutAlloc()
+	inputs.SetName("out")
+	inputs.SetFilterCtx(bufferSinkCtx)
+	inputs.SetPadIdx(0)
+	inputs.SetNext(nil)
+
+	// Parse content
+	var outputs *avfilter.Input
+	content := o.content(ctx)
+	if ret := g.AvfilterGraphParsePtr(content, &inputs, &outputs, nil); ret < 0 {
+		err = errors.Wrapf(NewAvError(ret), "astilibav: g.AvfilterGraphParsePtr on content %s failed", content)
+		return
+	}
+
+	// Configure
+	if ret := g.AvfilterGraphConfig(nil); ret < 0 {
+		err = errors.Wrap(NewAvError(ret), "astilibav: g.AvfilterGraphConfig failed")
+		return
+	}
+
+	// Alloc frame
+	f = avutil.AvFrameAlloc()
+
+	// Pull frame
+	if ret := g.AvBuffersinkGetFrame(bufferSinkCtx, f); ret < 0 {
+		avutil.AvFrameFree(f)
+		f = nil
+		err = errors.Wrap(NewAvError(ret), "astilibav: g.AvBuffersinkGetFrame failed")
+		return
+	}
+	return
+}
@@ -0,0 +1,29 @@
+package astilibav
+
+import (
+	"testing"
+
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlateOptionsContent(t *testing.T) {
+	ctx := Context{Height: 720, PixelFormat: avutil.AV_PIX_FMT_YUV420P, SampleAspectRatio: avutil.NewRational(1, 1), Width: 1280}
+	assert.Equal(t, "color=c=black:size=1280x720,format=pix_fmts=yuv420p,setsar=1/1", SlateOptions{}.content(ctx))
+	assert.Equal(t, "smptebars=size=1280x720,format=pix_fmts=yuv420p,setsar=1/1", SlateOptions{Color: "red", Pattern: "smptebars"}.content(ctx))
+	assert.Equal(t, `movie=filename=\'a\'\\\'\'b\,c.png\',scale='w=1280:h=720',format=pix_fmts=yuv420p,setsar=1/1`, SlateOptions{ImagePath: "a'b,c.png", Pattern: "smptebars"}.content(ctx))
+}
+
+func TestNewSlateFrame(t *testing.T) {
+	// Invalid dimensions
+	_, err := newSlateFrame(SlateOptions{}, Context{})
+	assert.Error(t, err)
+
+	// Frame has the context's format
+	f, err := newSlateFrame(SlateOptions{Color: "red"}, Context{Height: 72, PixelFormat: avutil.AV_PIX_FMT_YUV420P, Width: 128})
+	assert.NoError(t, err)
+	defer avutil.AvFrameFree(f)
+	assert.Equal(t, 72, f.Height())
+	assert.Equal(t, 128, f.Width())
+	assert.Equal(t, int(avutil.AV_PIX_FMT_YUV420P), f.Format())
+}
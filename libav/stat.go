@@ -0,0 +1,53 @@
+package astilibav
+
+import (
+	"sync"
+	"time"
+)
+
+// valueStat represents a stat whose value is set by the node rather than computed out of increments
+// In max mode, the value is the max value set since the stat was last read
+type valueStat struct {
+	m   *sync.Mutex
+	max bool
+	ok  bool
+	v   float64
+}
+
+func newValueStat() *valueStat {
+	return &valueStat{m: &sync.Mutex{}}
+}
+
+func newMaxValueStat() *valueStat {
+	return &valueStat{
+		m:   &sync.Mutex{},
+		max: true,
+	}
+}
+
+func (s *valueStat) set(v float64) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if !s.max || !s.ok || v > s.v {
+		s.v = v
+	}
+	s.ok = true
+}
+
+// Start implements the astistat.StatHandler interface
+func (s *valueStat) Start() {}
+
+// Stop implements the astistat.StatHandler interface
+func (s *valueStat) Stop() {}
+
+// Value implements the astistat.StatHandler interface
+func (s *valueStat) Value(delta time.Duration) interface{} {
+	s.m.Lock()
+	defer s.m.Unlock()
+	v := s.v
+	if s.max {
+		s.ok = false
+		s.v = 0
+	}
+	return v
+}
@@ -0,0 +1,384 @@
+package astilibav
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astitools/stat"
+	"github.com/asticode/go-astitools/sync"
+	"github.com/asticode/go-astitools/worker"
+	"github.com/asticode/goav/avutil"
+	"github.com/pkg/errors"
+)
+
+var countStoryboardGenerator uint64
+
+// Storyboard generator formats
+const (
+	StoryboardGeneratorFormatJPEG = "jpeg"
+	StoryboardGeneratorFormatPNG  = "png"
+)
+
+// StoryboardGenerator represents an object capable of tiling frames into sprite sheets and describing them in a
+// WebVTT thumbnails track
+type StoryboardGenerator struct {
+	*astiencoder.BaseNode
+	count            uint32
+	cues             []storyboardCue
+	eh               *astiencoder.EventHandler
+	firstPts         *time.Duration
+	lastPts          *time.Duration
+	o                StoryboardGeneratorOptions
+	q                *astisync.CtxQueue
+	sheet            *image.RGBA
+	statIncomingRate *astistat.IncrementStat
+	statSheetRate    *astistat.IncrementStat
+	statWorkRatio    *astistat.DurationRatioStat
+	t                *template.Template
+	thumbnails       int
+}
+
+// StoryboardGeneratorOptions represents storyboard generator options
+// Thumbnails have the size of the frames they're created from, frames should therefore be scaled beforehand. When the
+// frame size changes, the current sprite sheet is written and a new one is started with the new size
+type StoryboardGeneratorOptions struct {
+	// Number of thumbnails per row. Defaults to 5
+	Columns int
+	// Available in the pattern along with "count" (number of written sprite sheets, starting at 1). It's copied and can
+	// therefore be modified once the storyboard generator is created
+	Data map[string]interface{}
+	// Possible values are "jpeg" (default) and "png"
+	Format string
+	// Duration between 2 thumbnails. Defaults to 10s
+	Interval time.Duration
+	// Between 1 and 100. Defaults to jpeg.DefaultQuality
+	JPEGQuality int
+	Node        astiencoder.NodeOptions
+	// Template of the path sprite sheets are written to
+	Pattern string
+	// Number of thumbnails per column. Defaults to 5
+	Rows int
+	// Path the WebVTT thumbnails track is written to. Sprite sheets are referenced relatively to it
+	VTTPath string
+}
+
+type storyboardCue struct {
+	end   time.Duration
+	path  string
+	rect  image.Rectangle
+	start time.Duration
+}
+
+// NewStoryboardGenerator creates a new storyboard generator
+func NewStoryboardGenerator(o StoryboardGeneratorOptions, eh *astiencoder.EventHandler) (g *StoryboardGenerator, err error) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countStoryboardGenerator, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("storyboard_generator_%d", count), fmt.Sprintf("Storyboard Generator #%d", count), "Generates storyboards")
+
+	// Copy data since "count" is updated for every written sprite sheet
+	data := make(map[string]interface{})
+	for k, v := range o.Data {
+		data[k] = v
+	}
+	o.Data = data
+
+	// Default options
+	if o.Columns <= 0 {
+		o.Columns = 5
+	}
+	if len(o.Format) == 0 {
+		o.Format = StoryboardGeneratorFormatJPEG
+	}
+	if o.Interval <= 0 {
+		o.Interval = 10 * time.Second
+	}
+	if o.JPEGQuality <= 0 {
+		o.JPEGQuality = jpeg.DefaultQuality
+	}
+	if o.Rows <= 0 {
+		o.Rows = 5
+	}
+
+	// Create storyboard generator
+	g = &StoryboardGenerator{
+		eh:               eh,
+		o:                o,
+		q:                astisync.NewCtxQueue(),
+		statIncomingRate: astistat.NewIncrementStat(),
+		statSheetRate:    astistat.NewIncrementStat(),
+		statWorkRatio:    astistat.NewDurationRatioStat(),
+	}
+	g.BaseNode = astiencoder.NewBaseNode(o.Node, astiencoder.NewEventGeneratorNode(g), eh)
+	g.addStats()
+
+	// Check format
+	switch o.Format {
+	case StoryboardGeneratorFormatJPEG, StoryboardGeneratorFormatPNG:
+	default:
+		err = fmt.Errorf("astilibav: invalid format %s", o.Format)
+		return
+	}
+
+	// No vtt path
+	if len(o.VTTPath) == 0 {
+		err = errors.New("astilibav: no vtt path provided")
+		return
+	}
+
+	// Parse pattern
+	if g.t, err = template.New("").Parse(o.Pattern); err != nil {
+		err = errors.Wrapf(err, "astilibav: parsing pattern %s as template failed", o.Pattern)
+		return
+	}
+	return
+}
+
+func (g *StoryboardGenerator) addStats() {
+	// Add incoming rate
+	g.Stater().AddStat(astistat.StatMetadata{
+		Description: "Number of frames coming in per second",
+		Label:       "Incoming rate",
+		Unit:        "fps",
+	}, g.statIncomingRate)
+
+	// Add sheet rate
+	g.Stater().AddStat(astistat.StatMetadata{
+		Description: "Number of sprite sheets written per second",
+		Label:       "Sheet rate",
+		Unit:        "sps",
+	}, g.statSheetRate)
+
+	// Add work ratio
+	g.Stater().AddStat(astistat.StatMetadata{
+		Description: "Percentage of time spent doing some actual work",
+		Label:       "Work ratio",
+		Unit:        "%",
+	}, g.statWorkRatio)
+
+	// Add queue stats
+	g.q.AddStats(g.Stater())
+}
+
+// Start starts the storyboard generator
+func (g *StoryboardGenerator) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	g.BaseNode.Start(ctx, t, func(t *astiworker.Task) {
+		// Handle context
+		go g.q.HandleCtx(g.Context())
+
+		// Make sure to stop the queue properly
+		defer g.q.Stop()
+
+		// Start queue
+		g.q.Start(func(dp interface{}) {
+			// Handle pause
+			defer g.HandlePause()
+
+			// Assert payload
+			p := dp.(*FrameHandlerPayload)
+
+			// Increment incoming rate
+			g.statIncomingRate.Add(1)
+
+			// Add thumbnail
+			g.statWorkRatio.Add(true)
+			if err := g.addThumbnail(p); err != nil {
+				g.statWorkRatio.Done(true)
+				g.eh.Emit(astiencoder.EventError(g, errors.Wrap(err, "astilibav: adding thumbnail failed")))
+				return
+			}
+			g.statWorkRatio.Done(true)
+		})
+
+		// Write remaining thumbnails
+		if err := g.writeSheet(); err != nil {
+			g.eh.Emit(astiencoder.EventError(g, errors.Wrap(err, "astilibav: writing sheet failed")))
+		}
+	})
+}
+
+func (g *StoryboardGenerator) addThumbnail(p *FrameHandlerPayload) (err error) {
+	// Get pts
+	pts := time.Duration(avutil.AvRescaleQ(p.Frame.Pts(), p.Descriptor.TimeBase(), nanosecondRational))
+
+	// Frame is too close to the previous thumbnail
+	if g.lastPts != nil && pts-*g.lastPts < g.o.Interval {
+		return
+	}
+	g.lastPts = &pts
+
+	// Times are relative to the first thumbnail
+	if g.firstPts == nil {
+		g.firstPts = &pts
+	}
+
+	// Get image
+	var i image.Image
+	if i, err = frameImage(p.Frame); err != nil {
+		err = errors.Wrap(err, "astilibav: getting image failed")
+		return
+	}
+
+	// Thumbnail size has changed
+	w, h := i.Bounds().Dx(), i.Bounds().Dy()
+	if g.sheet != nil && (g.sheet.Bounds().Dx() != w*g.o.Columns || g.sheet.Bounds().Dy() != h*g.o.Rows) {
+		// Write the current sheet since a sheet can only contain thumbnails of the same size
+		if err = g.writeSheet(); err != nil {
+			err = errors.Wrap(err, "astilibav: writing sheet failed")
+			return
+		}
+
+		// Reset sheet
+		g.sheet = nil
+	}
+
+	// Create sheet
+	if g.sheet == nil {
+		g.sheet = image.NewRGBA(image.Rect(0, 0, w*g.o.Columns, h*g.o.Rows))
+	}
+
+	// Draw thumbnail
+	r := image.Rect(0, 0, w, h).Add(image.Pt((g.thumbnails%g.o.Columns)*w, (g.thumbnails/g.o.Columns)*h))
+	draw.Draw(g.sheet, r, i, i.Bounds().Min, draw.Src)
+	g.thumbnails++
+
+	// Add cue
+	// Its path is set once the sheet is written
+	g.cues = append(g.cues, storyboardCue{
+		end:   pts - *g.firstPts + g.o.Interval,
+		rect:  r,
+		start: pts - *g.firstPts,
+	})
+
+	// Sheet is full
+	if g.thumbnails == g.o.Columns*g.o.Rows {
+		if err = g.writeSheet(); err != nil {
+			err = errors.Wrap(err, "astilibav: writing sheet failed")
+			return
+		}
+	}
+	return
+}
+
+func (g *StoryboardGenerator) writeSheet() (err error) {
+	// Nothing to write
+	if g.thumbnails == 0 {
+		return
+	}
+
+	// Only keep the rows that have been used
+	w, h := g.sheet.Bounds().Dx()/g.o.Columns, g.sheet.Bounds().Dy()/g.o.Rows
+	cs, rs := g.o.Columns, (g.thumbnails+g.o.Columns-1)/g.o.Columns
+	if g.thumbnails < cs {
+		cs = g.thumbnails
+	}
+	i := g.sheet.SubImage(image.Rect(0, 0, w*cs, h*rs))
+
+	// Increment count
+	g.count++
+
+	// Update data
+	g.o.Data["count"] = g.count
+
+	// Execute template
+	buf := &bytes.Buffer{}
+	if err = g.t.Execute(buf, g.o.Data); err != nil {
+		err = errors.Wrapf(err, "astilibav: executing template %s with data %+v failed", g.o.Pattern, g.o.Data)
+		return
+	}
+	path := buf.String()
+
+	// Encode image
+	buf = &bytes.Buffer{}
+	switch g.o.Format {
+	case StoryboardGeneratorFormatJPEG:
+		err = jpeg.Encode(buf, i, &jpeg.Options{Quality: g.o.JPEGQuality})
+	case StoryboardGeneratorFormatPNG:
+		err = png.Encode(buf, i)
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "astilibav: encoding %s failed", g.o.Format)
+		return
+	}
+
+	// Write image
+	if err = ioutil.WriteFile(path, buf.Bytes(), 0666); err != nil {
+		err = errors.Wrapf(err, "astilibav: writing file %s failed", path)
+		return
+	}
+
+	// Sprite sheets are referenced relatively to the vtt
+	rel := path
+	if v, errRel := filepath.Rel(filepath.Dir(g.o.VTTPath), path); errRel == nil {
+		rel = filepath.ToSlash(v)
+	}
+
+	// Update cues
+	for idx := len(g.cues) - g.thumbnails; idx < len(g.cues); idx++ {
+		g.cues[idx].path = rel
+	}
+
+	// Reset sheet
+	draw.Draw(g.sheet, g.sheet.Bounds(), image.Transparent, image.ZP, draw.Src)
+	g.thumbnails = 0
+
+	// Write vtt
+	if err = g.writeVTT(); err != nil {
+		err = errors.Wrap(err, "astilibav: writing vtt failed")
+		return
+	}
+
+	// Increment sheet rate
+	g.statSheetRate.Add(1)
+	return
+}
+
+// writeVTT rewrites the whole track so that it's always consistent with the sprite sheets written so far
+func (g *StoryboardGenerator) writeVTT() (err error) {
+	// Write cues
+	buf := bytes.NewBufferString("WEBVTT\n")
+	for _, c := range g.cues {
+		// Sheet has not been written yet
+		if len(c.path) == 0 {
+			continue
+		}
+		fmt.Fprintf(buf, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n", vttTimestamp(c.start), vttTimestamp(c.end), c.path, c.rect.Min.X, c.rect.Min.Y, c.rect.Dx(), c.rect.Dy())
+	}
+
+	// Write to a temporary file first so that readers never get a partial track
+	tmp := g.o.VTTPath + ".tmp"
+	if err = ioutil.WriteFile(tmp, buf.Bytes(), 0666); err != nil {
+		err = errors.Wrapf(err, "astilibav: writing file %s failed", tmp)
+		return
+	}
+
+	// Rename
+	if err = os.Rename(tmp, g.o.VTTPath); err != nil {
+		err = errors.Wrapf(err, "astilibav: renaming %s to %s failed", tmp, g.o.VTTPath)
+		return
+	}
+	return
+}
+
+func vttTimestamp(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	return fmt.Sprintf("%.2d:%.2d:%.2d.%.3d", d/time.Hour, d%time.Hour/time.Minute, d%time.Minute/time.Second, d%time.Second/time.Millisecond)
+}
+
+// HandleFrame implements the FrameHandler interface
+func (g *StoryboardGenerator) HandleFrame(p *FrameHandlerPayload) {
+	g.q.Send(p)
+}
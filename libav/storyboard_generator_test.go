@@ -0,0 +1,115 @@
+package astilibav
+
+import (
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVTTTimestamp(t *testing.T) {
+	assert.Equal(t, "00:00:00.000", vttTimestamp(-time.Second))
+	assert.Equal(t, "00:00:01.500", vttTimestamp(1500*time.Millisecond))
+	assert.Equal(t, "01:02:03.004", vttTimestamp(time.Hour+2*time.Minute+3*time.Second+4*time.Millisecond))
+}
+
+func TestStoryboardGenerator(t *testing.T) {
+	// Create temp dir
+	dir, err := ioutil.TempDir("", "astilibav_storyboard_generator_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Create storyboard generator
+	data := map[string]interface{}{"input": "default"}
+	g, err := NewStoryboardGenerator(StoryboardGeneratorOptions{
+		Columns:  2,
+		Data:     data,
+		Format:   StoryboardGeneratorFormatPNG,
+		Interval: time.Second,
+		Pattern:  filepath.Join(dir, "{{.input}}-{{.count}}.png"),
+		Rows:     2,
+		VTTPath:  filepath.Join(dir, "storyboard.vtt"),
+	}, astiencoder.NewEventHandler())
+	assert.NoError(t, err)
+
+	// Data is copied
+	data["input"] = "updated"
+	assert.Equal(t, "default", g.o.Data["input"])
+
+	// Add thumbnails
+	f := avutil.AvFrameAlloc()
+	defer avutil.AvFrameFree(f)
+	p := &FrameHandlerPayload{Descriptor: newTimeBaseDescriptor(avutil.NewRational(1, 10)), Frame: f}
+	add := func(pts int64, w, h int) {
+		avutil.AvFrameUnref(f)
+		assert.NoError(t, writeImage(image.NewGray(image.Rect(0, 0, w, h)), f))
+		f.SetPts(pts)
+		assert.NoError(t, g.addThumbnail(p))
+	}
+	for _, pts := range []int64{0, 5, 10, 20, 30, 40} {
+		add(pts, 4, 2)
+	}
+
+	// Frames too close to the previous thumbnail are ignored and the sheet is written once full
+	assert.Equal(t, uint32(1), g.count)
+	assert.Equal(t, 1, g.thumbnails)
+	i := decodeTestPNG(t, filepath.Join(dir, "default-1.png"))
+	assert.Equal(t, image.Rect(0, 0, 8, 4), i.Bounds())
+
+	// Size change writes the current sheet and starts a new one
+	add(50, 2, 2)
+	assert.Equal(t, uint32(2), g.count)
+	assert.Equal(t, 1, g.thumbnails)
+	assert.Equal(t, image.Rect(0, 0, 4, 4), g.sheet.Bounds())
+	i = decodeTestPNG(t, filepath.Join(dir, "default-2.png"))
+	assert.Equal(t, image.Rect(0, 0, 4, 2), i.Bounds())
+
+	// Remaining thumbnails
+	assert.NoError(t, g.writeSheet())
+	i = decodeTestPNG(t, filepath.Join(dir, "default-3.png"))
+	assert.Equal(t, image.Rect(0, 0, 2, 2), i.Bounds())
+	b, err := ioutil.ReadFile(filepath.Join(dir, "storyboard.vtt"))
+	assert.NoError(t, err)
+	assert.Equal(t, `WEBVTT
+
+00:00:00.000 --> 00:00:01.000
+default-1.png#xywh=0,0,4,2
+
+00:00:01.000 --> 00:00:02.000
+default-1.png#xywh=4,0,4,2
+
+00:00:02.000 --> 00:00:03.000
+default-1.png#xywh=0,2,4,2
+
+00:00:03.000 --> 00:00:04.000
+default-1.png#xywh=4,2,4,2
+
+00:00:04.000 --> 00:00:05.000
+default-2.png#xywh=0,0,4,2
+
+00:00:05.000 --> 00:00:06.000
+default-3.png#xywh=0,0,2,2
+`, string(b))
+}
+
+func decodeTestPNG(t *testing.T, path string) image.Image {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	i, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
@@ -1,6 +1,10 @@
 package astilibav
 
 import (
+	"fmt"
+	"math"
+	"unsafe"
+
 	"github.com/asticode/goav/avcodec"
 	"github.com/asticode/goav/avformat"
 	"github.com/pkg/errors"
@@ -24,5 +28,71 @@ func CloneStream(i *avformat.Stream, ctxFormat *avformat.Context) (o *avformat.S
 
 	// Reset codec tag as shown in https://github.com/FFmpeg/FFmpeg/blob/n4.0.2/doc/examples/remuxing.c#L122
 	o.CodecParameters().SetCodecTag(0)
+
+	// Copy HDR side data
+	for _, t := range []avcodec.AvPacketSideDataType{avcodec.AV_PKT_DATA_CONTENT_LIGHT_LEVEL, avcodec.AV_PKT_DATA_MASTERING_DISPLAY_METADATA} {
+		if err = addStreamSideData(o, t, streamSideData(i, t)); err != nil {
+			err = errors.Wrapf(err, "astilibav: adding stream side data %v failed", t)
+			return
+		}
+	}
+	return
+}
+
+func streamSideData(s *avformat.Stream, t avcodec.AvPacketSideDataType) (b []byte) {
+	// Get side data
+	var size int
+	sd := s.AvStreamGetSideData(t, &size)
+	if sd == nil || size <= 0 {
+		return
+	}
+
+	// Copy side data
+	b = make([]byte, size)
+	copy(b, (*[1 << 30]byte)(unsafe.Pointer(sd))[:size:size])
+	return
+}
+
+// streamRotation returns the clockwise rotation in degrees, rounded to the nearest multiple of 90, that frames need
+// for them to be displayed properly
+func streamRotation(s *avformat.Stream) int {
+	// Get display matrix
+	b := streamSideData(s, avcodec.AV_PKT_DATA_DISPLAYMATRIX)
+	if len(b) < 36 {
+		return 0
+	}
+	m := (*[9]int32)(unsafe.Pointer(&b[0]))
+
+	// Compute rotation the same way av_display_rotation_get does
+	// Values are 16.16 fixed point numbers
+	s0, s1 := math.Hypot(float64(m[0]), float64(m[3])), math.Hypot(float64(m[1]), float64(m[4]))
+	if s0 == 0 || s1 == 0 {
+		return 0
+	}
+	r := -math.Atan2(float64(m[1])/s1, float64(m[0])/s0) * 180 / math.Pi
+
+	// av_display_rotation_get returns a counterclockwise rotation
+	d := int(math.Round(-r/90)) * 90 % 360
+	if d < 0 {
+		d += 360
+	}
+	return d
+}
+
+func addStreamSideData(s *avformat.Stream, t avcodec.AvPacketSideDataType, b []byte) (err error) {
+	// No side data
+	if len(b) == 0 {
+		return
+	}
+
+	// Allocate side data
+	sd := s.AvStreamNewSideData(t, len(b))
+	if sd == nil {
+		err = fmt.Errorf("astilibav: allocating side data of size %d failed", len(b))
+		return
+	}
+
+	// Copy side data
+	copy((*[1 << 30]byte)(unsafe.Pointer(sd))[:len(b):len(b)], b)
 	return
 }
@@ -0,0 +1,63 @@
+package astilibav
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avformat"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamSideData(t *testing.T) {
+	ctxFormat := avformat.AvformatAllocContext()
+	defer ctxFormat.AvformatFreeContext()
+	s := AddStream(ctxFormat)
+
+	// No side data
+	assert.Nil(t, streamSideData(s, avcodec.AV_PKT_DATA_CONTENT_LIGHT_LEVEL))
+	assert.NoError(t, addStreamSideData(s, avcodec.AV_PKT_DATA_CONTENT_LIGHT_LEVEL, nil))
+	assert.Nil(t, streamSideData(s, avcodec.AV_PKT_DATA_CONTENT_LIGHT_LEVEL))
+
+	// Side data
+	assert.NoError(t, addStreamSideData(s, avcodec.AV_PKT_DATA_CONTENT_LIGHT_LEVEL, []byte{1, 2, 3, 4}))
+	assert.Equal(t, []byte{1, 2, 3, 4}, streamSideData(s, avcodec.AV_PKT_DATA_CONTENT_LIGHT_LEVEL))
+	assert.Nil(t, streamSideData(s, avcodec.AV_PKT_DATA_MASTERING_DISPLAY_METADATA))
+}
+
+// displayMatrix builds a display matrix the way the mov demuxer does for a clockwise rotation
+func displayMatrix(rotation float64) []byte {
+	r := rotation * math.Pi / 180
+	c, s := int32(math.Round(math.Cos(r)*(1<<16))), int32(math.Round(math.Sin(r)*(1<<16)))
+	b := make([]byte, 36)
+	for idx, v := range []int32{c, s, 0, -s, c, 0, 0, 0, 1 << 30} {
+		binary.LittleEndian.PutUint32(b[idx*4:], uint32(v))
+	}
+	return b
+}
+
+func TestStreamRotation(t *testing.T) {
+	ctxFormat := avformat.AvformatAllocContext()
+	defer ctxFormat.AvformatFreeContext()
+
+	// No display matrix
+	assert.Equal(t, 0, streamRotation(AddStream(ctxFormat)))
+
+	// Display matrix
+	for _, v := range []struct {
+		expected int
+		rotation float64
+	}{
+		{expected: 0, rotation: 0},
+		{expected: 90, rotation: 90},
+		{expected: 90, rotation: 89.9},
+		{expected: 180, rotation: 180},
+		{expected: 270, rotation: 270},
+		{expected: 270, rotation: -90},
+	} {
+		s := AddStream(ctxFormat)
+		assert.NoError(t, addStreamSideData(s, avcodec.AV_PKT_DATA_DISPLAYMATRIX, displayMatrix(v.rotation)))
+		assert.Equal(t, v.expected, streamRotation(s), "rotation %v", v.rotation)
+	}
+}
@@ -73,6 +73,23 @@ type NodeParentMapper interface {
 	DelChild(n Node)
 }
 
+// NodeCommander represents a node that can receive commands while running
+type NodeCommander interface {
+	SendCommandWithResponse(target, cmd, arg string, flags int) (res string, err error)
+}
+
+// NodeSwitcher represents a node that can be replaced by a node with different content while running
+// It should block until the switch is done or the context is done, and return the events emitted while switching
+type NodeSwitcher interface {
+	SwitchContent(ctx context.Context, w *Workflow, content string) (n Node, es []Event, err error)
+}
+
+// NodeExposer represents a node that exposes additional data through the API
+// Nodes should emit an EventNameNodeDataChanged event whenever their data changes
+type NodeExposer interface {
+	ExposedData() interface{}
+}
+
 // Statuses
 const (
 	StatusPaused  = "paused"
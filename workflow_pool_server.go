@@ -1,6 +1,7 @@
 package astiencoder
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -96,6 +97,7 @@ func newExposedWorkflowEdge(parent, child Node) ExposedWorkflowEdge {
 
 // ExposedWorkflowNode represents an exposed workflow node
 type ExposedWorkflowNode struct {
+	Data        interface{}           `json:"data,omitempty"`
 	Description string                `json:"description"`
 	Label       string                `json:"label"`
 	Name        string                `json:"name"`
@@ -118,6 +120,9 @@ func newExposedWorkflowNode(n Node) (w ExposedWorkflowNode) {
 		Stats:       []ExposedStatMetadata{},
 		Status:      n.Status(),
 	}
+	if e, ok := n.(NodeExposer); ok {
+		w.Data = e.ExposedData()
+	}
 	if s := n.Stater(); s != nil {
 		for _, v := range s.StatsMetadata() {
 			w.Stats = append(w.Stats, ExposedStatMetadata{
@@ -170,9 +175,11 @@ func (s *workflowPoolServer) handler() http.Handler {
 	r.GET("/api/references", s.handleReferences())
 	r.GET("/api/workflows", s.handleWorkflows())
 	r.GET("/api/workflows/:workflow", s.handleWorkflow())
+	r.POST("/api/workflows/:workflow/nodes/:node/command", s.handleNodeCommand())
 	r.GET("/api/workflows/:workflow/nodes/:node/continue", s.handleNodeContinue())
 	r.GET("/api/workflows/:workflow/nodes/:node/pause", s.handleNodePause())
 	r.GET("/api/workflows/:workflow/nodes/:node/start", s.handleNodeStart())
+	r.POST("/api/workflows/:workflow/nodes/:node/switch", s.handleNodeSwitch())
 	r.GET("/api/workflows/:workflow/continue", s.handleWorkflowContinue())
 	r.GET("/api/workflows/:workflow/pause", s.handleWorkflowPause())
 	r.GET("/api/workflows/:workflow/start", s.handleWorkflowStart())
@@ -342,6 +349,112 @@ func (s *workflowPoolServer) handleNodeAction(fn func(w *Workflow, n Node)) http
 	})
 }
 
+func (s *workflowPoolServer) handleNodeRequest(fn func(w *Workflow, n Node, rw http.ResponseWriter, r *http.Request)) httprouter.Handle {
+	return func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		s.handleNodeAction(func(w *Workflow, n Node) { fn(w, n, rw, r) })(rw, r, p)
+	}
+}
+
+// ExposedNodeCommand represents an exposed node command
+type ExposedNodeCommand struct {
+	Arg    string `json:"arg"`
+	Cmd    string `json:"cmd"`
+	Flags  int    `json:"flags"`
+	Target string `json:"target"`
+}
+
+// ExposedNodeCommandResponse represents an exposed node command response
+type ExposedNodeCommandResponse struct {
+	Response string `json:"response"`
+}
+
+func (s *workflowPoolServer) handleNodeCommand() httprouter.Handle {
+	return s.handleNodeRequest(func(w *Workflow, n Node, rw http.ResponseWriter, r *http.Request) {
+		// Node is not a commander
+		c, ok := n.(NodeCommander)
+		if !ok {
+			WriteJSONError(rw, http.StatusBadRequest, fmt.Errorf("astiencoder: node %s doesn't accept commands", n.Metadata().Name))
+			return
+		}
+
+		// Unmarshal body
+		var b ExposedNodeCommand
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			WriteJSONError(rw, http.StatusBadRequest, errors.Wrap(err, "astiencoder: unmarshaling body failed"))
+			return
+		}
+
+		// Send command
+		res, err := c.SendCommandWithResponse(b.Target, b.Cmd, b.Arg, b.Flags)
+		if err != nil {
+			WriteJSONError(rw, http.StatusInternalServerError, errors.Wrapf(err, "astiencoder: sending command %+v to node %s failed", b, n.Metadata().Name))
+			return
+		}
+
+		// Write
+		s.writeJSONData(rw, ExposedNodeCommandResponse{Response: res})
+	})
+}
+
+// ExposedNodeSwitch represents an exposed node switch
+type ExposedNodeSwitch struct {
+	Content string `json:"content"`
+}
+
+// ExposedNodeSwitchResponse represents an exposed node switch response
+type ExposedNodeSwitchResponse struct {
+	Events []string            `json:"events"`
+	Node   ExposedWorkflowNode `json:"node"`
+}
+
+// nodeSwitchTimeout is the max duration the server waits for a node switch to be done
+const nodeSwitchTimeout = 30 * time.Second
+
+func (s *workflowPoolServer) handleNodeSwitch() httprouter.Handle {
+	return s.handleNodeRequest(func(w *Workflow, n Node, rw http.ResponseWriter, r *http.Request) {
+		// Node is not a switcher
+		sw, ok := n.(NodeSwitcher)
+		if !ok {
+			WriteJSONError(rw, http.StatusBadRequest, fmt.Errorf("astiencoder: node %s can't be switched", n.Metadata().Name))
+			return
+		}
+
+		// Workflow is not running
+		if w.Status() != StatusRunning {
+			WriteJSONError(rw, http.StatusBadRequest, fmt.Errorf("astiencoder: workflow %s is not running", w.Name()))
+			return
+		}
+
+		// Unmarshal body
+		var b ExposedNodeSwitch
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			WriteJSONError(rw, http.StatusBadRequest, errors.Wrap(err, "astiencoder: unmarshaling body failed"))
+			return
+		}
+
+		// Create context
+		ctx, cancel := context.WithTimeout(r.Context(), nodeSwitchTimeout)
+		defer cancel()
+
+		// Switch
+		nn, es, err := sw.SwitchContent(ctx, w, b.Content)
+		if err != nil {
+			WriteJSONError(rw, http.StatusInternalServerError, errors.Wrapf(err, "astiencoder: switching node %s failed", n.Metadata().Name))
+			return
+		}
+
+		// Write
+		o := ExposedNodeSwitchResponse{
+			Events: []string{},
+			Node:   newExposedWorkflowNode(nn),
+		}
+		for _, e := range es {
+			o.Events = append(o.Events, e.Name)
+		}
+		s.writeJSONData(rw, o)
+	})
+}
+
 func (s *workflowPoolServer) handleNodeContinue() httprouter.Handle {
 	return s.handleNodeAction(func(w *Workflow, n Node) { n.Continue() })
 }
@@ -410,6 +523,12 @@ type ExposedStat struct {
 	Value       interface{} `json:"value"`
 }
 
+// ExposedNodeData represents exposed node data
+type ExposedNodeData struct {
+	Data interface{} `json:"data"`
+	Name string      `json:"name"`
+}
+
 // HandleEvent implements the EventHandler interface
 func (s *workflowPoolServer) adaptEventHandler(eh *EventHandler) {
 	eh.AddForAll(func(e Event) bool {
@@ -439,6 +558,12 @@ func (s *workflowPoolServer) adaptEventHandler(eh *EventHandler) {
 			p = np
 		case EventNameNodeContinued, EventNameNodePaused, EventNameNodeStarted, EventNameNodeStopped:
 			p = e.Target.(Node).Metadata().Name
+		case EventNameNodeDataChanged:
+			np := ExposedNodeData{Name: e.Target.(Node).Metadata().Name}
+			if d, ok := e.Target.(NodeExposer); ok {
+				np.Data = d.ExposedData()
+			}
+			p = np
 		}
 		s.sendEventToWebsocket(n, p)
 		return false